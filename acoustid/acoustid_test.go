@@ -0,0 +1,408 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package acoustid_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/sporeprint/acoustid"
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+// standIn is a local stand-in for the AcoustID web service.
+type standIn struct {
+	// failures is the number of requests answered with a 503 first.
+	failures atomic.Int32
+	requests atomic.Int32
+
+	mu     sync.Mutex
+	polls  int
+	params []map[string][]string
+}
+
+func (s *standIn) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	s.requests.Add(1)
+
+	if err := request.ParseForm(); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	s.mu.Lock()
+	s.params = append(s.params, request.Form)
+	s.mu.Unlock()
+
+	reply := func(status int, body string) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(status)
+		_, _ = writer.Write([]byte(body))
+	}
+
+	if s.failures.Add(-1) >= 0 {
+		reply(http.StatusServiceUnavailable, `{"status": "error", "error": {"code": 13, "message": "service unavailable"}}`)
+
+		return
+	}
+
+	if request.Form.Get("client") != "app-key" {
+		reply(http.StatusBadRequest, `{"status": "error", "error": {"code": 4, "message": "invalid API key"}}`)
+
+		return
+	}
+
+	switch request.URL.Path {
+	case "/lookup":
+		reply(http.StatusOK, `{"status": "ok", "results": [{"id": "9ff43b6a-4f16-427c-93c2-92307ca505e0", "score": 0.97,
+			"recordings": [{"id": "cd2e7c47-16f5-46c6-a37c-a1eb7bf599ff", "title": "Song", "duration": 215,
+			"artists": [{"id": "a74b1b7f-71a5-4011-9441-d0b5e4122711", "name": "Band"}]}]}]}`)
+	case "/submit":
+		reply(http.StatusOK, `{"status": "ok", "submissions": [{"index": "1", "id": 2, "status": "pending"},
+			{"index": "0", "id": 1, "status": "pending"}]}`)
+	case "/submission_status":
+		s.mu.Lock()
+		s.polls++
+		status := "pending"
+
+		if s.polls > 1 {
+			status = "imported"
+		}
+		s.mu.Unlock()
+
+		reply(http.StatusOK, `{"status": "ok", "submissions": [{"id": 1, "status": "`+status+`", "result": {"id": "9ff43b6a"}}]}`)
+	default:
+		reply(http.StatusNotFound, `not found`)
+	}
+}
+
+func newClient(t *testing.T, handler http.Handler, options acoustid.Options) *acoustid.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	options.BaseURL = srv.URL
+	options.Backoff = time.Millisecond
+
+	return acoustid.New("app-key", options)
+}
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	stand := &standIn{}
+	client := newClient(t, stand, acoustid.Options{Rate: -1})
+
+	results, err := client.Lookup(context.Background(), "AQAAA", 215, acoustid.MetaRecordings, acoustid.MetaSources)
+	if err != nil {
+		t.Fatalf("Lookup() failed: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Score != 0.97 || len(results[0].Recordings) != 1 ||
+		results[0].Recordings[0].Artists[0].Name != "Band" {
+		t.Errorf("Lookup() = %+v", results)
+	}
+
+	params := stand.params[0]
+	if params["meta"][0] != "recordings sources" || params["duration"][0] != "215" || params["format"][0] != "json" {
+		t.Errorf("Lookup() sent %v", params)
+	}
+}
+
+func TestSubmitAndWait(t *testing.T) {
+	t.Parallel()
+
+	stand := &standIn{}
+	client := newClient(t, stand, acoustid.Options{Rate: -1})
+
+	statuses, err := client.Submit(context.Background(), "user-key", []acoustid.Submission{
+		{Fingerprint: "AQAAA", Duration: 215, MBID: "cd2e7c47-16f5-46c6-a37c-a1eb7bf599ff"},
+		{Fingerprint: "AQAAB", Duration: 100, Track: "Song", Artist: "Band", Year: 1999},
+	})
+	if err != nil {
+		t.Fatalf("Submit() failed: %v", err)
+	}
+
+	// Statuses are returned in submission order, whatever the response order.
+	if len(statuses) != 2 || statuses[0].ID != 1 || statuses[1].ID != 2 || statuses[0].Status != acoustid.StatusPending {
+		t.Errorf("Submit() = %+v", statuses)
+	}
+
+	params := stand.params[0]
+	if params["user"][0] != "user-key" || params["mbid.0"][0] == "" || params["artist.1"][0] != "Band" ||
+		params["year.1"][0] != "1999" || params["mbid.1"] != nil {
+		t.Errorf("Submit() sent %v", params)
+	}
+
+	final, err := client.Wait(context.Background(), time.Millisecond, 1)
+	if err != nil {
+		t.Fatalf("Wait() failed: %v", err)
+	}
+
+	if len(final) != 1 || final[0].Status != acoustid.StatusImported || final[0].Result.ID != "9ff43b6a" {
+		t.Errorf("Wait() = %+v", final)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	stand := &standIn{}
+	stand.failures.Store(2)
+	client := newClient(t, stand, acoustid.Options{Rate: -1})
+
+	if _, err := client.Lookup(context.Background(), "AQAAA", 215); err != nil {
+		t.Fatalf("Lookup() failed after retries: %v", err)
+	}
+
+	if got := stand.requests.Load(); got != 3 {
+		t.Errorf("sent %d requests, want 3", got)
+	}
+
+	stand.failures.Store(10)
+
+	_, err := client.Lookup(context.Background(), "AQAAA", 215)
+
+	var apiErr *acoustid.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != acoustid.CodeServiceUnavailable || apiErr.Status != http.StatusServiceUnavailable {
+		t.Errorf("Lookup() = %v, want service unavailable", err)
+	}
+
+	if got := stand.requests.Load(); got != 3+1+acoustid.DefaultRetries {
+		t.Errorf("sent %d requests, want %d", got, 3+1+acoustid.DefaultRetries)
+	}
+}
+
+// TestSubmitNotResent checks that a submission whose response is lost is not
+// sent again, while a lookup is.
+func TestSubmitNotResent(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	// Reads the request, then drops the connection without answering.
+	client := newClient(t, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests.Add(1)
+
+		_ = request.ParseForm()
+
+		if conn, _, err := writer.(http.Hijacker).Hijack(); err == nil {
+			_ = conn.Close()
+		}
+	}), acoustid.Options{Rate: -1})
+
+	if _, err := client.Submit(context.Background(), "user-key", []acoustid.Submission{{Fingerprint: "AQAAA", Duration: 215}}); err == nil {
+		t.Fatal("Submit() should fail")
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Submit() sent %d requests, want 1", got)
+	}
+
+	if _, err := client.Lookup(context.Background(), "AQAAA", 215); err == nil {
+		t.Fatal("Lookup() should fail")
+	}
+
+	if got := requests.Load(); got != 2+acoustid.DefaultRetries {
+		t.Errorf("Lookup() sent %d requests, want %d", got-1, 1+acoustid.DefaultRetries)
+	}
+}
+
+// TestSubmitNotResentOnProxyError checks that a submission answered with an
+// error page, as a proxy would, is not sent again.
+func TestSubmitNotResentOnProxyError(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	client := newClient(t, http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		writer.Header().Set("Content-Type", "text/html")
+		writer.WriteHeader(http.StatusBadGateway)
+		_, _ = writer.Write([]byte("<html><body>502 Bad Gateway</body></html>"))
+	}), acoustid.Options{Rate: -1})
+
+	if _, err := client.Submit(context.Background(), "user-key", []acoustid.Submission{{Fingerprint: "AQAAA", Duration: 215}}); !errors.Is(err, acoustid.ErrResponse) {
+		t.Errorf("Submit() = %v, want ErrResponse", err)
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Submit() sent %d requests, want 1", got)
+	}
+}
+
+func TestResponseTooLarge(t *testing.T) {
+	t.Parallel()
+
+	// Valid JSON, padded with whitespace beyond the response size limit.
+	client := newClient(t, http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		_, _ = writer.Write([]byte(`{"status": "ok", "results": []}`))
+		_, _ = writer.Write(bytes.Repeat([]byte(" "), 33<<20))
+	}), acoustid.Options{Rate: -1})
+
+	if _, err := client.Lookup(context.Background(), "AQAAA", 215); !errors.Is(err, acoustid.ErrResponse) {
+		t.Errorf("Lookup() = %v, want ErrResponse", err)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	t.Parallel()
+
+	stand := &standIn{}
+
+	srv := httptest.NewServer(stand)
+	t.Cleanup(srv.Close)
+
+	client := acoustid.New("wrong-key", acoustid.Options{BaseURL: srv.URL, Rate: -1})
+
+	_, err := client.Lookup(context.Background(), "AQAAA", 215)
+
+	var apiErr *acoustid.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != acoustid.CodeInvalidAPIKey || apiErr.Temporary() {
+		t.Errorf("Lookup() = %v, want invalid API key", err)
+	}
+
+	// Client errors are not retried.
+	if got := stand.requests.Load(); got != 1 {
+		t.Errorf("sent %d requests, want 1", got)
+	}
+
+	_, err = acoustid.New("app-key", acoustid.Options{BaseURL: srv.URL + "/missing", Rate: -1, Retries: -1}).
+		Lookup(context.Background(), "AQAAA", 215)
+	if !errors.Is(err, acoustid.ErrResponse) {
+		t.Errorf("Lookup() = %v, want ErrResponse", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	client := newClient(t, &standIn{}, acoustid.Options{Rate: 20})
+	start := time.Now()
+
+	var workers sync.WaitGroup
+
+	for range 4 {
+		workers.Go(func() {
+			if _, err := client.Lookup(context.Background(), "AQAAA", 215); err != nil {
+				t.Errorf("Lookup() failed: %v", err)
+			}
+		})
+	}
+
+	workers.Wait()
+
+	// 4 requests at 20 per second: the last one waits for 3 intervals.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("4 requests took %v, want at least 150ms", elapsed)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	t.Parallel()
+
+	stand := &standIn{}
+	stand.failures.Store(100)
+	client := newClient(t, stand, acoustid.Options{Backoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Lookup(ctx, "AQAAA", 215); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lookup() = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestErrorJSON(t *testing.T) {
+	t.Parallel()
+
+	data, err := json.Marshal(&acoustid.Error{Status: 400, Code: acoustid.CodeInvalidDuration, Message: "invalid duration"})
+	if err != nil || string(data) != `{"code":8,"message":"invalid duration"}` {
+		t.Errorf("json.Marshal(Error) = %s, %v", data, err)
+	}
+}
+
+func TestLookupSporeprintServer(t *testing.T) {
+	t.Parallel()
+
+	ctx := chromaprint.New()
+	defer ctx.Free()
+
+	samples := make([]int16, 11025*20)
+	for i := range samples {
+		samples[i] = int16(((i + 7) * 17) % 65536)
+	}
+
+	if err := ctx.Start(11025, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ctx.Feed(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ctx.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := ctx.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := chromaprint.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := db.Open(t.TempDir(), db.Options{Create: true, Index: index.Options{Threshold: 0.4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = database.Close() })
+
+	if err = database.Add([]index.Track{{ID: "local", Duration: 20, Raw: raw}}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(server.New(server.Options{DB: database}))
+	t.Cleanup(srv.Close)
+
+	results, err := acoustid.New("any", acoustid.Options{BaseURL: srv.URL + "/v2", Rate: -1}).
+		Lookup(context.Background(), encoded, 20, acoustid.MetaRecordings)
+	if err != nil {
+		t.Fatalf("Lookup() failed: %v", err)
+	}
+
+	if len(results) != 1 || results[0].ID != "local" || results[0].Score != 1 {
+		t.Errorf("Lookup() = %+v, want the local track", results)
+	}
+}
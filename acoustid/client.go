@@ -0,0 +1,244 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package acoustid
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBaseURL is the AcoustID web service.
+	DefaultBaseURL = "https://api.acoustid.org/v2"
+	// DefaultRate is the request rate allowed by the service, per second.
+	DefaultRate = 3
+	// DefaultRetries is the default number of retries of a failed request.
+	DefaultRetries = 3
+	// DefaultBackoff is the default delay before the first retry. It doubles
+	// at every retry.
+	DefaultBackoff = 500 * time.Millisecond
+
+	// maxErrorBody is how much of an unexpected response is reported.
+	maxErrorBody = 512
+	// maxResponseBody bounds the responses read. Batch lookups with
+	// metadata are the largest, far below it.
+	maxResponseBody = 32 << 20
+	statusOK        = "ok"
+)
+
+// Options configures a [Client].
+type Options struct {
+	// BaseURL is the service URL, without trailing slash. Empty means
+	// DefaultBaseURL.
+	BaseURL string
+	// HTTPClient sends requests. Nil means [http.DefaultClient].
+	HTTPClient *http.Client
+	// Rate is the maximum number of requests per second. Zero means
+	// DefaultRate, negative means unlimited.
+	Rate float64
+	// Retries is the number of retries of a failed request. Zero means
+	// DefaultRetries, negative means none.
+	Retries int
+	// Backoff is the delay before the first retry. Zero means
+	// DefaultBackoff.
+	Backoff time.Duration
+}
+
+// Client calls the AcoustID web service. It is safe for concurrent use, and
+// its rate limit applies across goroutines.
+type Client struct {
+	key     string
+	options Options
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// New returns a client identified by an application API key.
+func New(key string, options Options) *Client {
+	if options.BaseURL == "" {
+		options.BaseURL = DefaultBaseURL
+	}
+
+	if options.HTTPClient == nil {
+		options.HTTPClient = http.DefaultClient
+	}
+
+	if options.Rate == 0 {
+		options.Rate = DefaultRate
+	}
+
+	if options.Retries == 0 {
+		options.Retries = DefaultRetries
+	}
+
+	if options.Backoff == 0 {
+		options.Backoff = DefaultBackoff
+	}
+
+	return &Client{key: key, options: options}
+}
+
+// call posts params to an endpoint, retrying temporary failures, and decodes
+// the response into result, which must embed a status field. Requests that
+// are not idempotent (submissions) are only retried when they were not sent,
+// or were rejected by the service: retrying after a lost response could
+// submit twice.
+func (c *Client) call(ctx context.Context, endpoint string, params url.Values, idempotent bool, result any) error {
+	params.Set("client", c.key)
+	params.Set("format", "json")
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		if err = c.wait(ctx); err != nil {
+			return err
+		}
+
+		var retry bool
+		if retry, err = c.post(ctx, endpoint, params, idempotent, result); err == nil || !retry || attempt >= c.options.Retries {
+			return err
+		}
+
+		timer := time.NewTimer(c.options.Backoff << attempt)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("acoustid: %w (after %w)", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// post sends one request, and reports whether a failure is worth retrying.
+func (c *Client) post(ctx context.Context, endpoint string, params url.Values, idempotent bool, result any) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.options.BaseURL+"/"+endpoint,
+		strings.NewReader(params.Encode()))
+	if err != nil {
+		return false, fmt.Errorf("acoustid: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := c.options.HTTPClient.Do(request)
+	if err != nil {
+		// Network errors are retried, unless the context is done.
+		return ctx.Err() == nil && (idempotent || unsent(err)), fmt.Errorf("acoustid: %w", err)
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseBody+1))
+	if err != nil {
+		return idempotent, fmt.Errorf("acoustid: reading response: %w", err)
+	}
+
+	if len(body) > maxResponseBody {
+		return false, fmt.Errorf("%w: HTTP %d: response exceeds %d bytes", ErrResponse, response.StatusCode, maxResponseBody)
+	}
+
+	var envelope struct {
+		Status string `json:"status"`
+		Error  *Error `json:"error"`
+	}
+
+	// A submission the service answered may have been accepted: never retried.
+	temporaryStatus := idempotent && (response.StatusCode >= http.StatusInternalServerError ||
+		response.StatusCode == http.StatusTooManyRequests)
+
+	if err = json.Unmarshal(body, &envelope); err != nil {
+		return temporaryStatus, fmt.Errorf("%w: HTTP %d: %s", ErrResponse, response.StatusCode, truncate(body))
+	}
+
+	if envelope.Status != statusOK {
+		if envelope.Error == nil {
+			return temporaryStatus, fmt.Errorf("%w: HTTP %d: %s", ErrResponse, response.StatusCode, truncate(body))
+		}
+
+		envelope.Error.Status = response.StatusCode
+
+		return temporaryStatus || (idempotent && envelope.Error.Temporary()), envelope.Error
+	}
+
+	if err = json.Unmarshal(body, result); err != nil {
+		return false, fmt.Errorf("%w: %w", ErrResponse, err)
+	}
+
+	return false, nil
+}
+
+// wait blocks until the rate limit allows a request.
+func (c *Client) wait(ctx context.Context) error {
+	if c.options.Rate < 0 {
+		return nil
+	}
+
+	interval := time.Duration(float64(time.Second) / c.options.Rate)
+
+	c.mu.Lock()
+	now := time.Now()
+	slot := c.next
+
+	if slot.Before(now) {
+		slot = now
+	}
+
+	c.next = slot.Add(interval)
+	c.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("acoustid: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+// unsent reports whether a request failed before it was sent: the connection
+// could not be established.
+func unsent(err error) bool {
+	opErr := &net.OpError{}
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func truncate(body []byte) string {
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,28 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package acoustid is a client of the AcoustID web service: fingerprint
+// lookups, submissions and submission status.
+//
+// Requests are rate limited to 3 per second, as the service requires, and
+// retried with exponential backoff on network errors, HTTP 5xx and 429, and
+// AcoustID errors that are temporary. Submissions are only retried after
+// network errors when the connection could not be established, so that they
+// are never sent twice. Service errors are returned as
+// [*Error], with the AcoustID error [Code].
+//
+// Reference: https://acoustid.org/webservice
+package acoustid
@@ -0,0 +1,76 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package acoustid
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code is an AcoustID error code.
+type Code int
+
+// AcoustID error codes.
+const (
+	CodeUnknownFormat           Code = 1
+	CodeMissingParameter        Code = 2
+	CodeInvalidFingerprint      Code = 3
+	CodeInvalidAPIKey           Code = 4
+	CodeInternalError           Code = 5
+	CodeInvalidUserAPIKey       Code = 6
+	CodeInvalidUUID             Code = 7
+	CodeInvalidDuration         Code = 8
+	CodeInvalidBitrate          Code = 9
+	CodeInvalidForeignID        Code = 10
+	CodeInvalidMaxDurationDiff  Code = 11
+	CodeNotAllowed              Code = 12
+	CodeServiceUnavailable      Code = 13
+	CodeTooManyRequests         Code = 14
+	CodeInvalidMusicBrainzToken Code = 15
+	CodeInsecureRequest         Code = 16
+	CodeUnknownApplication      Code = 17
+	CodeFingerprintNotFound     Code = 18
+)
+
+// ErrResponse happens when the service answers something that is not an
+// AcoustID response.
+var ErrResponse = errors.New("acoustid: invalid response")
+
+// Error is an error returned by the service.
+type Error struct {
+	// Status is the HTTP status code.
+	Status int `json:"-"`
+	// Code is the AcoustID error code.
+	Code Code `json:"code"`
+	// Message is the AcoustID error message.
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("acoustid: error %d (HTTP %d): %s", e.Code, e.Status, e.Message)
+}
+
+// Temporary reports whether retrying the request may succeed.
+func (e *Error) Temporary() bool {
+	switch e.Code {
+	case CodeInternalError, CodeServiceUnavailable, CodeTooManyRequests:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package acoustid
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Lookup meta parameters, selecting what results include.
+const (
+	MetaRecordings      = "recordings"
+	MetaRecordingIDs    = "recordingids"
+	MetaReleases        = "releases"
+	MetaReleaseIDs      = "releaseids"
+	MetaReleaseGroups   = "releasegroups"
+	MetaReleaseGroupIDs = "releasegroupids"
+	MetaTracks          = "tracks"
+	MetaUserMeta        = "usermeta"
+	MetaSources         = "sources"
+	MetaCompress        = "compress"
+)
+
+// Artist is a MusicBrainz artist.
+type Artist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ReleaseGroup is a MusicBrainz release group.
+type ReleaseGroup struct {
+	ID             string   `json:"id"`
+	Title          string   `json:"title,omitempty"`
+	Type           string   `json:"type,omitempty"`
+	SecondaryTypes []string `json:"secondarytypes,omitempty"`
+	Artists        []Artist `json:"artists,omitempty"`
+}
+
+// Release is a MusicBrainz release.
+type Release struct {
+	ID      string `json:"id"`
+	Title   string `json:"title,omitempty"`
+	Country string `json:"country,omitempty"`
+}
+
+// Recording is a MusicBrainz recording linked to an AcoustID track. Fields
+// other than ID depend on the lookup meta.
+type Recording struct {
+	ID            string         `json:"id"`
+	Title         string         `json:"title,omitempty"`
+	Duration      float64        `json:"duration,omitempty"`
+	Artists       []Artist       `json:"artists,omitempty"`
+	Releases      []Release      `json:"releases,omitempty"`
+	ReleaseGroups []ReleaseGroup `json:"releasegroups,omitempty"`
+	// Sources is the number of submissions linking the recording, with
+	// [MetaSources].
+	Sources int `json:"sources,omitempty"`
+}
+
+// Result is an AcoustID track matching a lookup.
+type Result struct {
+	// ID is the AcoustID track ID.
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+	// Recordings are included with the recording meta parameters.
+	Recordings []Recording `json:"recordings,omitempty"`
+}
+
+// Lookup returns the AcoustID tracks matching an encoded fingerprint of a
+// recording lasting duration seconds, best first. Meta selects what results
+// include, as Meta* constants.
+func (c *Client) Lookup(ctx context.Context, fingerprint string, duration int, meta ...string) ([]Result, error) {
+	params := url.Values{
+		"fingerprint": {fingerprint},
+		"duration":    {strconv.Itoa(duration)},
+	}
+
+	if len(meta) > 0 {
+		params.Set("meta", strings.Join(meta, " "))
+	}
+
+	var response struct {
+		Results []Result `json:"results"`
+	}
+
+	if err := c.call(ctx, "lookup", params, true, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Results, nil
+}
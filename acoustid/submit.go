@@ -0,0 +1,180 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package acoustid
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Submission states.
+const (
+	StatusPending  = "pending"
+	StatusImported = "imported"
+)
+
+// DefaultPollInterval is the default interval of [Client.Wait].
+const DefaultPollInterval = 5 * time.Second
+
+// Submission is a fingerprint submitted to AcoustID, with optional metadata.
+type Submission struct {
+	Fingerprint string
+	// Duration is in seconds.
+	Duration int
+	// MBID is the MusicBrainz recording ID, if known.
+	MBID string
+	// Track, Artist, Album, AlbumArtist, Year, TrackNumber and DiscNumber
+	// describe the recording when there is no MBID.
+	Track       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Year        int
+	TrackNumber int
+	DiscNumber  int
+	// FileFormat and Bitrate describe the fingerprinted file.
+	FileFormat string
+	Bitrate    int
+}
+
+// SubmissionStatus is the state of a submission.
+type SubmissionStatus struct {
+	// ID identifies the submission for [Client.Status].
+	ID int `json:"id"`
+	// Status is StatusPending, then StatusImported.
+	Status string `json:"status"`
+	// Result holds the AcoustID track ID once imported.
+	Result *struct {
+		ID string `json:"id"`
+	} `json:"result,omitempty"`
+}
+
+// Submit submits fingerprints in one batch on behalf of the user identified
+// by their API key, and returns their statuses in order.
+func (c *Client) Submit(ctx context.Context, user string, submissions []Submission) ([]SubmissionStatus, error) {
+	params := url.Values{"user": {user}}
+
+	for num, submission := range submissions {
+		set := func(name, value string) {
+			if value != "" {
+				params.Set(name+"."+strconv.Itoa(num), value)
+			}
+		}
+
+		setInt := func(name string, value int) {
+			if value != 0 {
+				set(name, strconv.Itoa(value))
+			}
+		}
+
+		set("fingerprint", submission.Fingerprint)
+		setInt("duration", submission.Duration)
+		set("mbid", submission.MBID)
+		set("track", submission.Track)
+		set("artist", submission.Artist)
+		set("album", submission.Album)
+		set("albumartist", submission.AlbumArtist)
+		setInt("year", submission.Year)
+		setInt("trackno", submission.TrackNumber)
+		setInt("discno", submission.DiscNumber)
+		set("fileformat", submission.FileFormat)
+		setInt("bitrate", submission.Bitrate)
+	}
+
+	var response struct {
+		Submissions []struct {
+			Index string `json:"index"`
+			SubmissionStatus
+		} `json:"submissions"`
+	}
+
+	if err := c.call(ctx, "submit", params, false, &response); err != nil {
+		return nil, err
+	}
+
+	if len(response.Submissions) != len(submissions) {
+		return nil, fmt.Errorf("%w: %d statuses for %d submissions", ErrResponse, len(response.Submissions), len(submissions))
+	}
+
+	statuses := make([]SubmissionStatus, len(submissions))
+
+	for num, status := range response.Submissions {
+		index, err := strconv.Atoi(status.Index)
+		if err != nil || index < 0 || index >= len(statuses) {
+			// Single submissions have no index.
+			index = num
+		}
+
+		statuses[index] = status.SubmissionStatus
+	}
+
+	return statuses, nil
+}
+
+// Status returns the current statuses of submissions.
+func (c *Client) Status(ctx context.Context, ids ...int) ([]SubmissionStatus, error) {
+	params := url.Values{}
+	for _, id := range ids {
+		params.Add("id", strconv.Itoa(id))
+	}
+
+	var response struct {
+		Submissions []SubmissionStatus `json:"submissions"`
+	}
+
+	if err := c.call(ctx, "submission_status", params, true, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Submissions, nil
+}
+
+// Wait polls the statuses of submissions every interval (zero means
+// DefaultPollInterval) until none is pending, and returns them.
+func (c *Client) Wait(ctx context.Context, interval time.Duration, ids ...int) ([]SubmissionStatus, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		statuses, err := c.Status(ctx, ids...)
+		if err != nil {
+			return nil, err
+		}
+
+		pending := false
+		for _, status := range statuses {
+			pending = pending || status.Status == StatusPending
+		}
+
+		if !pending {
+			return statuses, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return statuses, fmt.Errorf("acoustid: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,247 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/version"
+)
+
+const (
+	// dirName is the directory of [DefaultDir] under the user cache directory.
+	dirName = "sporeprint"
+
+	entrySuffix = ".json"
+	tempSuffix  = ".tmp"
+	dirPerm     = 0o755
+)
+
+// ErrInvalid happens when a cache directory cannot be used.
+var ErrInvalid = errors.New("cache: invalid directory")
+
+// Options controls [Open].
+type Options struct {
+	// Hash also identifies files by the SHA-256 of their content, to detect
+	// changes that preserve their size and modification time. Every file is
+	// then read in full.
+	Hash bool
+}
+
+// Cache is a fingerprint cache directory. It is safe for concurrent use.
+type Cache struct {
+	dir         string
+	hash        bool
+	version     string
+	chromaprint string
+}
+
+// Stamp identifies the content of a file, as of [Cache.Stamp].
+type Stamp struct {
+	Size    int64
+	ModTime int64
+	Hash    string
+}
+
+// identity is what an entry must match to be a hit.
+type identity struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mtime"`
+	Hash        string `json:"sha256,omitempty"`
+	Length      int    `json:"length"`
+	Algorithm   int    `json:"algorithm"`
+	Version     string `json:"version"`
+	Chromaprint string `json:"chromaprint"`
+}
+
+// entry is the JSON format of a cached fingerprint.
+type entry struct {
+	identity
+
+	Duration float64 `json:"duration"`
+	// Fingerprint is the raw fingerprint, in little-endian.
+	Fingerprint []byte `json:"fingerprint"`
+}
+
+// DefaultDir returns the sporeprint directory under the user cache directory.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	return filepath.Join(dir, dirName), nil
+}
+
+// Open opens the cache in dir, creating it if needed.
+func Open(dir string, options Options) (*Cache, error) {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	return &Cache{
+		dir:         dir,
+		hash:        options.Hash,
+		version:     version.Version(),
+		chromaprint: chromaprint.Version(),
+	}, nil
+}
+
+// Stamp identifies the current content of the file at path. Take it before
+// fingerprinting the file, so that changes made meanwhile are misses.
+func (c *Cache) Stamp(path string) (Stamp, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Stamp{}, fmt.Errorf("stamping %s: %w", path, err)
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return Stamp{}, fmt.Errorf("stamping %s: %w", path, err)
+	}
+
+	stamp := Stamp{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+
+	if c.hash {
+		digest := sha256.New()
+		if _, err = io.Copy(digest, file); err != nil {
+			return Stamp{}, fmt.Errorf("stamping %s: %w", path, err)
+		}
+
+		stamp.Hash = hex.EncodeToString(digest.Sum(nil))
+	}
+
+	return stamp, nil
+}
+
+// Get returns the track of the file at path, with path as ID, if it was
+// cached with the same stamp and fingerprinting length by the same versions.
+// Unreadable entries are misses.
+func (c *Cache) Get(path string, stamp Stamp, length int) (index.Track, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return index.Track{}, false
+	}
+
+	data, err := os.ReadFile(c.entryPath(abs))
+	if err != nil {
+		return index.Track{}, false
+	}
+
+	var cached entry
+	if err = json.Unmarshal(data, &cached); err != nil || len(cached.Fingerprint)%4 != 0 {
+		return index.Track{}, false
+	}
+
+	if cached.identity != c.identity(abs, stamp, length) {
+		return index.Track{}, false
+	}
+
+	raw := make([]uint32, len(cached.Fingerprint)/4)
+	for i := range raw {
+		raw[i] = binary.LittleEndian.Uint32(cached.Fingerprint[4*i:])
+	}
+
+	return index.Track{ID: path, Duration: cached.Duration, Raw: raw}, true
+}
+
+// Put caches the track of the file at path, fingerprinted up to length
+// seconds from the content identified by stamp.
+func (c *Cache) Put(path string, stamp Stamp, length int, track index.Track) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("caching %s: %w", path, err)
+	}
+
+	fingerprint := make([]byte, 4*len(track.Raw))
+	for i, hash := range track.Raw {
+		binary.LittleEndian.PutUint32(fingerprint[4*i:], hash)
+	}
+
+	data, err := json.Marshal(entry{
+		identity:    c.identity(abs, stamp, length),
+		Duration:    track.Duration,
+		Fingerprint: fingerprint,
+	})
+	if err != nil {
+		return fmt.Errorf("caching %s: %w", path, err)
+	}
+
+	target := c.entryPath(abs)
+	if err = os.MkdirAll(filepath.Dir(target), dirPerm); err != nil {
+		return fmt.Errorf("caching %s: %w", path, err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*"+tempSuffix)
+	if err != nil {
+		return fmt.Errorf("caching %s: %w", path, err)
+	}
+
+	_, err = file.Write(data)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), target)
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+
+		return fmt.Errorf("caching %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// identity returns the identity of a fingerprint computed by this program.
+func (c *Cache) identity(abs string, stamp Stamp, length int) identity {
+	return identity{
+		Path:        abs,
+		Size:        stamp.Size,
+		ModTime:     stamp.ModTime,
+		Hash:        stamp.Hash,
+		Length:      length,
+		Algorithm:   chromaprint.Algorithm,
+		Version:     c.version,
+		Chromaprint: c.chromaprint,
+	}
+}
+
+// entryPath returns the path of the entry of a file, under a subdirectory per
+// first byte of its name to keep directories small.
+func (c *Cache) entryPath(abs string) string {
+	name := sha256.Sum256([]byte(abs))
+	encoded := hex.EncodeToString(name[:])
+
+	return filepath.Join(c.dir, encoded[:2], encoded+entrySuffix)
+}
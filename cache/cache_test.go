@@ -0,0 +1,164 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cache_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/sporeprint/cache"
+	"github.com/mycophonic/sporeprint/index"
+)
+
+// put writes content to path, and caches track for it with length.
+func put(t *testing.T, fingerprints *cache.Cache, path, content string, length int, track index.Track) cache.Stamp {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stamp, err := fingerprints.Stamp(path)
+	if err != nil {
+		t.Fatalf("Stamp() failed: %v", err)
+	}
+
+	if err = fingerprints.Put(path, stamp, length, track); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	return stamp
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	fingerprints, err := cache.Open(filepath.Join(t.TempDir(), "cache"), cache.Options{})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "track.flac")
+	track := index.Track{ID: path, Duration: 12.5, Raw: []uint32{1, 0xdeadbeef, 3}}
+	stamp := put(t, fingerprints, path, "audio", 120, track)
+
+	got, ok := fingerprints.Get(path, stamp, 120)
+	if !ok || got.ID != path || got.Duration != track.Duration || !slices.Equal(got.Raw, track.Raw) {
+		t.Fatalf("Get() = %+v, %v; want %+v", got, ok, track)
+	}
+
+	if _, ok = fingerprints.Get(path, stamp, 0); ok {
+		t.Error("Get() with another length should miss")
+	}
+
+	// The entry is per absolute path, the track ID is the path asked for.
+	relative, err := filepath.Rel(".", path)
+	if err == nil {
+		if got, ok = fingerprints.Get(relative, stamp, 120); !ok || got.ID != relative {
+			t.Errorf("Get(%s) = %s, %v; want a hit", relative, got.ID, ok)
+		}
+	}
+
+	// Changes of size or modification time are misses.
+	if err = os.WriteFile(path, []byte("other audio"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if stamp, err = fingerprints.Stamp(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok = fingerprints.Get(path, stamp, 120); ok {
+		t.Error("Get() after a size change should miss")
+	}
+
+	put(t, fingerprints, path, "audio", 120, track)
+
+	if err = os.Chtimes(path, time.Time{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if stamp, err = fingerprints.Stamp(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok = fingerprints.Get(path, stamp, 120); ok {
+		t.Error("Get() after a modification time change should miss")
+	}
+
+	if _, err = fingerprints.Stamp(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Stamp(missing) should fail")
+	}
+}
+
+func TestCacheHash(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	path := filepath.Join(t.TempDir(), "track.flac")
+	track := index.Track{ID: path, Duration: 1, Raw: []uint32{1, 2}}
+
+	hashed, err := cache.Open(dir, cache.Options{Hash: true})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	stamp := put(t, hashed, path, "audio", 120, track)
+	if stamp.Hash == "" {
+		t.Fatal("Stamp() with Hash returned no hash")
+	}
+
+	// Same size and modification time, different content.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(path, []byte("AUDIO"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Chtimes(path, time.Time{}, info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	if stamp, err = hashed.Stamp(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := hashed.Get(path, stamp, 120); ok {
+		t.Error("Get() after a content change should miss with Hash")
+	}
+
+	// Without Hash, the change goes unnoticed, but entries with a hash are
+	// misses.
+	plain, err := cache.Open(dir, cache.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stamp, err = plain.Stamp(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := plain.Get(path, stamp, 120); ok {
+		t.Error("Get() without Hash should miss entries with a hash")
+	}
+}
@@ -0,0 +1,30 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package cache stores the fingerprints of audio files, so that library scans
+// only decode the files that are new or changed since the previous scan.
+//
+// A cache is a directory holding an entry per file, named after the SHA-256
+// of its absolute path and written atomically, so that several processes may
+// share it. An entry records the size and modification time of the file, and
+// optionally the SHA-256 of its content, along with the fingerprinting length,
+// the Chromaprint algorithm and the sporeprint and Chromaprint versions. Any
+// difference with the file or the running program is a miss: the file is
+// fingerprinted again and the entry replaced.
+//
+// Entries of deleted files are never removed: delete the directory to reclaim
+// their space.
+package cache
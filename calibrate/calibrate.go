@@ -0,0 +1,307 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package calibrate
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+const (
+	// DefaultTargetFPR is the default target false-positive rate.
+	DefaultTargetFPR = 0.01
+
+	// histogramBins is the number of histogram bins over [0, 1].
+	histogramBins = 20
+)
+
+// ErrUnlabeled happens when the pairs are not both same and different.
+var ErrUnlabeled = errors.New("calibrate: need both same and different pairs")
+
+// Pair is two encoded fingerprints labeled as the same recording or not.
+type Pair struct {
+	FP1  string
+	FP2  string
+	Same bool
+}
+
+// Sample is the measured similarity of a labeled pair.
+type Sample struct {
+	Score float64 `json:"score"`
+	// BitErrorRate is measured at the best offset, as
+	// [compare.ResultBitErrorRate].
+	BitErrorRate float64 `json:"ber"`
+	Same         bool    `json:"same"`
+}
+
+// Distribution summarizes values in [0, 1].
+type Distribution struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	P5     float64 `json:"p5"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	P95    float64 `json:"p95"`
+	// Histogram counts values in 20 bins of width 0.05, the last one
+	// including 1.
+	Histogram []int `json:"histogram"`
+}
+
+// Point is the outcome of one threshold.
+type Point struct {
+	Threshold float64 `json:"threshold"`
+	// TPR is the true-positive rate, or recall.
+	TPR float64 `json:"tpr"`
+	// FPR is the false-positive rate.
+	FPR float64 `json:"fpr"`
+	// Precision is the fraction of predicted same pairs that are.
+	Precision float64 `json:"precision"`
+}
+
+// Report is the outcome of [Evaluate].
+type Report struct {
+	Same      int `json:"same"`
+	Different int `json:"different"`
+
+	ScoreSame      Distribution `json:"score_same"`
+	ScoreDifferent Distribution `json:"score_different"`
+	BERSame        Distribution `json:"ber_same"`
+	BERDifferent   Distribution `json:"ber_different"`
+
+	// Curve holds a point per distinct score, by decreasing threshold. It is
+	// both the ROC curve (FPR, TPR) and the precision-recall curve (TPR,
+	// Precision).
+	Curve []Point `json:"curve"`
+	// AUC is the area under the ROC curve.
+	AUC float64 `json:"auc"`
+	// AveragePrecision is the area under the precision-recall curve.
+	AveragePrecision float64 `json:"average_precision"`
+
+	// EER is the equal error rate, where the false-positive rate equals the
+	// false-negative rate, reached at EERThreshold.
+	EER          float64 `json:"eer"`
+	EERThreshold float64 `json:"eer_threshold"`
+
+	// TargetFPR is the false-positive rate Recommended was chosen for: the
+	// lowest threshold whose false-positive rate does not exceed it.
+	TargetFPR   float64 `json:"target_fpr"`
+	Recommended Point   `json:"recommended"`
+}
+
+// Measure scores pairs with matcher (nil means [compare.Bounded]).
+func Measure(pairs []Pair, matcher compare.Matcher) ([]Sample, error) {
+	if matcher == nil {
+		matcher = compare.Bounded{}
+	}
+
+	samples := make([]Sample, len(pairs))
+
+	for num, pair := range pairs {
+		result, err := compare.Match(pair.FP1, pair.FP2, matcher)
+		if err != nil {
+			return nil, fmt.Errorf("pair %d: %w", num+1, err)
+		}
+
+		ber, err := compare.ResultBitErrorRate(pair.FP1, pair.FP2, result)
+		if err != nil {
+			return nil, fmt.Errorf("pair %d: %w", num+1, err)
+		}
+
+		samples[num] = Sample{Score: result.Score, BitErrorRate: ber, Same: pair.Same}
+	}
+
+	return samples, nil
+}
+
+// Evaluate computes the report of samples for a target false-positive rate.
+// Zero means [DefaultTargetFPR].
+func Evaluate(samples []Sample, targetFPR float64) (Report, error) {
+	if targetFPR <= 0 {
+		targetFPR = DefaultTargetFPR
+	}
+
+	var scoreSame, scoreDifferent, berSame, berDifferent []float64
+
+	for _, sample := range samples {
+		if sample.Same {
+			scoreSame = append(scoreSame, sample.Score)
+			berSame = append(berSame, sample.BitErrorRate)
+		} else {
+			scoreDifferent = append(scoreDifferent, sample.Score)
+			berDifferent = append(berDifferent, sample.BitErrorRate)
+		}
+	}
+
+	if len(scoreSame) == 0 || len(scoreDifferent) == 0 {
+		return Report{}, ErrUnlabeled
+	}
+
+	report := Report{
+		Same:           len(scoreSame),
+		Different:      len(scoreDifferent),
+		ScoreSame:      distribution(scoreSame),
+		ScoreDifferent: distribution(scoreDifferent),
+		BERSame:        distribution(berSame),
+		BERDifferent:   distribution(berDifferent),
+		Curve:          curve(samples, len(scoreSame), len(scoreDifferent)),
+		TargetFPR:      targetFPR,
+	}
+
+	report.AUC, report.AveragePrecision = areas(report.Curve)
+	report.EER, report.EERThreshold = equalErrorRate(report.Curve)
+
+	// The curve is by decreasing threshold, so increasing FPR: the lowest
+	// threshold within the target is the last point within it.
+	report.Recommended = Point{Threshold: math.Nextafter(report.ScoreDifferent.Max, math.Inf(1)), Precision: 1}
+
+	for _, point := range report.Curve {
+		if point.FPR > targetFPR {
+			break
+		}
+
+		report.Recommended = point
+	}
+
+	return report, nil
+}
+
+// curve returns a point per distinct score, by decreasing threshold.
+func curve(samples []Sample, same, different int) []Point {
+	sorted := slices.SortedFunc(slices.Values(samples), func(a, b Sample) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	var (
+		points   []Point
+		truePos  int
+		falsePos int
+	)
+
+	for num, sample := range sorted {
+		if sample.Same {
+			truePos++
+		} else {
+			falsePos++
+		}
+
+		// Wait for the last sample of equal scores: they all pass together.
+		if num+1 < len(sorted) && sorted[num+1].Score == sample.Score {
+			continue
+		}
+
+		points = append(points, Point{
+			Threshold: sample.Score,
+			TPR:       float64(truePos) / float64(same),
+			FPR:       float64(falsePos) / float64(different),
+			Precision: float64(truePos) / float64(truePos+falsePos),
+		})
+	}
+
+	return points
+}
+
+// areas integrates the ROC curve with the trapezoidal rule, and the
+// precision-recall curve as the average precision.
+func areas(points []Point) (auc, averagePrecision float64) {
+	var prevTPR, prevFPR float64
+
+	for _, point := range points {
+		auc += (point.FPR - prevFPR) * (point.TPR + prevTPR) / 2 //nolint:mnd
+		averagePrecision += (point.TPR - prevTPR) * point.Precision
+		prevTPR, prevFPR = point.TPR, point.FPR
+	}
+
+	return auc, averagePrecision
+}
+
+// equalErrorRate finds where the false-negative rate, decreasing along the
+// curve, crosses the increasing false-positive rate.
+func equalErrorRate(points []Point) (eer, threshold float64) {
+	prevFNR, prevFPR, prevThreshold := 1.0, 0.0, math.Inf(1)
+
+	for _, point := range points {
+		fnr := 1 - point.TPR
+		if fnr > point.FPR {
+			prevFNR, prevFPR, prevThreshold = fnr, point.FPR, point.Threshold
+
+			continue
+		}
+
+		// Interpolate between the points on both sides of the crossing.
+		gap := (prevFNR - prevFPR) - (fnr - point.FPR)
+		if gap == 0 || math.IsInf(prevThreshold, 1) {
+			return (fnr + point.FPR) / 2, point.Threshold //nolint:mnd
+		}
+
+		ratio := (prevFNR - prevFPR) / gap
+
+		return prevFPR + ratio*(point.FPR-prevFPR), prevThreshold + ratio*(point.Threshold-prevThreshold)
+	}
+
+	return 1, 0
+}
+
+func distribution(values []float64) Distribution {
+	sorted := slices.Sorted(slices.Values(values))
+	dist := Distribution{
+		Count:     len(sorted),
+		Min:       sorted[0],
+		Max:       sorted[len(sorted)-1],
+		P5:        percentile(sorted, 0.05), //nolint:mnd
+		P25:       percentile(sorted, 0.25), //nolint:mnd
+		Median:    percentile(sorted, 0.5),  //nolint:mnd
+		P75:       percentile(sorted, 0.75), //nolint:mnd
+		P95:       percentile(sorted, 0.95), //nolint:mnd
+		Histogram: make([]int, histogramBins),
+	}
+
+	for _, value := range sorted {
+		dist.Mean += value
+		dist.Histogram[min(max(int(value*histogramBins), 0), histogramBins-1)]++
+	}
+
+	dist.Mean /= float64(len(sorted))
+
+	for _, value := range sorted {
+		dist.StdDev += (value - dist.Mean) * (value - dist.Mean)
+	}
+
+	dist.StdDev = math.Sqrt(dist.StdDev / float64(len(sorted)))
+
+	return dist
+}
+
+// percentile interpolates linearly between the closest ranks of sorted.
+func percentile(sorted []float64, fraction float64) float64 {
+	rank := fraction * float64(len(sorted)-1)
+	low := int(rank)
+
+	if low+1 >= len(sorted) {
+		return sorted[low]
+	}
+
+	return sorted[low] + (rank-float64(low))*(sorted[low+1]-sorted[low])
+}
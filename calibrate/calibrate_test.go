@@ -0,0 +1,156 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package calibrate_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/calibrate"
+	"github.com/mycophonic/sporeprint/chromaprint"
+)
+
+func fingerprint(t *testing.T, seed int) string {
+	t.Helper()
+
+	ctx := chromaprint.New()
+	defer ctx.Free()
+
+	if err := ctx.Start(11025, 1); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	samples := make([]int16, 11025*5)
+	for i := range samples {
+		samples[i] = int16(((i + seed) * 17) % 65536)
+	}
+
+	if err := ctx.Feed(samples); err != nil {
+		t.Fatalf("Feed() failed: %v", err)
+	}
+
+	if err := ctx.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+
+	fp, err := ctx.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() failed: %v", err)
+	}
+
+	return fp
+}
+
+func TestMeasure(t *testing.T) {
+	t.Parallel()
+
+	fp := fingerprint(t, 0)
+
+	samples, err := calibrate.Measure([]calibrate.Pair{{FP1: fp, FP2: fp, Same: true}}, nil)
+	if err != nil {
+		t.Fatalf("Measure() failed: %v", err)
+	}
+
+	if want := (calibrate.Sample{Score: 1, BitErrorRate: 0, Same: true}); samples[0] != want {
+		t.Errorf("Measure() = %+v, want %+v", samples[0], want)
+	}
+
+	if _, err = calibrate.Measure([]calibrate.Pair{{FP1: fp, FP2: "invalid!!!"}}, nil); err == nil {
+		t.Error("Measure() with an invalid fingerprint should fail")
+	}
+}
+
+func TestEvaluateSeparable(t *testing.T) {
+	t.Parallel()
+
+	samples := []calibrate.Sample{
+		{Score: 0.9, Same: true},
+		{Score: 0.8, Same: true},
+		{Score: 0.6, Same: true},
+		{Score: 0.3},
+		{Score: 0.2},
+		{Score: 0.1},
+	}
+
+	report, err := calibrate.Evaluate(samples, 0)
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+
+	if report.AUC != 1 || report.AveragePrecision != 1 || report.EER != 0 {
+		t.Errorf("AUC = %f, AP = %f, EER = %f, want 1, 1, 0", report.AUC, report.AveragePrecision, report.EER)
+	}
+
+	if report.EERThreshold > 0.6 || report.EERThreshold <= 0.3 {
+		t.Errorf("EERThreshold = %f, want in (0.3, 0.6]", report.EERThreshold)
+	}
+
+	want := calibrate.Point{Threshold: 0.6, TPR: 1, FPR: 0, Precision: 1}
+	if report.Recommended != want || report.TargetFPR != calibrate.DefaultTargetFPR {
+		t.Errorf("Recommended = %+v for %f, want %+v", report.Recommended, report.TargetFPR, want)
+	}
+
+	if report.ScoreSame.Count != 3 || math.Abs(report.ScoreSame.Mean-2.3/3) > 1e-9 || report.ScoreSame.Median != 0.8 {
+		t.Errorf("ScoreSame = %+v", report.ScoreSame)
+	}
+
+	if report.ScoreDifferent.Histogram[6] != 1 || report.ScoreDifferent.Histogram[2] != 1 {
+		t.Errorf("ScoreDifferent.Histogram = %v", report.ScoreDifferent.Histogram)
+	}
+}
+
+func TestEvaluateOverlap(t *testing.T) {
+	t.Parallel()
+
+	// Scores interleave: same, different, same, different...
+	var samples []calibrate.Sample
+	for i := range 10 {
+		samples = append(samples, calibrate.Sample{Score: 1 - float64(i)/10, Same: i%2 == 0})
+	}
+
+	report, err := calibrate.Evaluate(samples, 0.2)
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+
+	if len(report.Curve) != 10 {
+		t.Fatalf("Curve has %d points, want 10", len(report.Curve))
+	}
+
+	// Pairs of ranks: (0.2, 0), (0.2, 0.2), (0.4, 0.2), ... : AUC = 0.6.
+	if math.Abs(report.AUC-0.6) > 1e-9 {
+		t.Errorf("AUC = %f, want 0.6", report.AUC)
+	}
+
+	if math.Abs(report.EER-0.4) > 1e-9 {
+		t.Errorf("EER = %f, want 0.4", report.EER)
+	}
+
+	// One different pair out of 5 is let through down to 0.8, two at 0.7.
+	if report.Recommended.Threshold != 0.8 || report.Recommended.TPR != 0.4 {
+		t.Errorf("Recommended = %+v, want threshold 0.8 with TPR 0.4", report.Recommended)
+	}
+}
+
+func TestEvaluateUnlabeled(t *testing.T) {
+	t.Parallel()
+
+	if _, err := calibrate.Evaluate([]calibrate.Sample{{Score: 1, Same: true}}, 0); !errors.Is(err, calibrate.ErrUnlabeled) {
+		t.Errorf("Evaluate() = %v, want ErrUnlabeled", err)
+	}
+}
@@ -0,0 +1,26 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package calibrate measures how well a similarity threshold separates
+// labeled pairs of fingerprints, to pick one suited to a given material
+// instead of AcoustID's default.
+//
+// [Measure] scores the pairs, and [Evaluate] derives score and bit error rate
+// distributions, the ROC and precision-recall curves, the equal error rate
+// and the lowest threshold meeting a target false-positive rate. A pair is
+// predicted to be the same recording when its score is at or above the
+// threshold.
+package calibrate
@@ -125,10 +125,22 @@ func (c *Context) Fingerprint() (string, error) {
 	return fp, nil
 }
 
+// Algorithm is the Chromaprint algorithm used by [New], as reported by
+// [DecodeAlgorithm]. Fingerprints from other algorithms are not comparable.
+const Algorithm = C.CHROMAPRINT_ALGORITHM_DEFAULT
+
 // Decode converts a base64-encoded Chromaprint fingerprint (as returned by
 // [Context.Fingerprint]) into a raw uint32 subfingerprint array suitable for
 // comparison operations.
 func Decode(encoded string) ([]uint32, error) {
+	raw, _, err := DecodeAlgorithm(encoded)
+
+	return raw, err
+}
+
+// DecodeAlgorithm is [Decode], also returning the algorithm the fingerprint
+// was computed with.
+func DecodeAlgorithm(encoded string) ([]uint32, int, error) {
 	cEncoded := C.CString(encoded)
 	defer C.free(unsafe.Pointer(cEncoded))
 
@@ -137,7 +149,7 @@ func Decode(encoded string) ([]uint32, error) {
 	var algorithm C.int
 
 	if C.chromaprint_decode_fingerprint(cEncoded, C.int(len(encoded)), &rawPtr, &rawSize, &algorithm, 1) != 1 {
-		return nil, ErrDecode
+		return nil, 0, ErrDecode
 	}
 
 	defer C.chromaprint_dealloc(unsafe.Pointer(rawPtr))
@@ -145,7 +157,7 @@ func Decode(encoded string) ([]uint32, error) {
 	raw := make([]uint32, int(rawSize))
 	copy(raw, unsafe.Slice((*uint32)(unsafe.Pointer(rawPtr)), int(rawSize)))
 
-	return raw, nil
+	return raw, int(algorithm), nil
 }
 
 // Version returns the Chromaprint library version string.
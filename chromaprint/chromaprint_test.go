@@ -233,3 +233,46 @@ func TestMultipleFeeds(t *testing.T) {
 		t.Error("Fingerprint() returned empty string after multiple feeds")
 	}
 }
+
+func TestDecodeAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	ctx := chromaprint.New()
+	defer ctx.Free()
+
+	if err := ctx.Start(11025, 1); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	samples := make([]int16, 11025*3)
+	for i := range samples {
+		samples[i] = int16(((i * 17) % 65536) - 32768)
+	}
+
+	if err := ctx.Feed(samples); err != nil {
+		t.Fatalf("Feed() failed: %v", err)
+	}
+
+	if err := ctx.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+
+	fingerprint, err := ctx.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() failed: %v", err)
+	}
+
+	raw, algorithm, err := chromaprint.DecodeAlgorithm(fingerprint)
+	if err != nil {
+		t.Fatalf("DecodeAlgorithm() failed: %v", err)
+	}
+
+	if algorithm != chromaprint.Algorithm {
+		t.Errorf("DecodeAlgorithm() algorithm = %d, want %d", algorithm, chromaprint.Algorithm)
+	}
+
+	decoded, err := chromaprint.Decode(fingerprint)
+	if err != nil || len(decoded) != len(raw) || len(raw) == 0 {
+		t.Errorf("Decode() = %d hashes, %v; DecodeAlgorithm() = %d hashes", len(decoded), err, len(raw))
+	}
+}
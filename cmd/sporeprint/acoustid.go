@@ -0,0 +1,286 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/acoustid"
+	"github.com/mycophonic/sporeprint/fingerprint"
+)
+
+// submitBatch is the number of fingerprints sent per submit request.
+const submitBatch = 50
+
+func acoustIDFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "client",
+			Usage:    "AcoustID application API key",
+			Sources:  cli.EnvVars("ACOUSTID_CLIENT"),
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "url",
+			Value: acoustid.DefaultBaseURL,
+			Usage: "AcoustID web service URL (a \"sporeprint serve\" /v2 works for lookups)",
+		},
+	}
+}
+
+func lookupCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "lookup",
+		Usage:     "Look up a fingerprint on AcoustID",
+		ArgsUsage: "[FINGERPRINT]",
+		Description: `Looks up FINGERPRINT, whose audio lasts --duration seconds, or fingerprints PCM
+from stdin (as "fingerprint") and looks it up with its duration.
+
+Prints the AcoustID tracks found, best first, with their MusicBrainz
+recordings.`,
+		Flags: append(acoustIDFlags(),
+			&cli.IntFlag{
+				Name:  "duration",
+				Usage: "duration of the fingerprinted audio in seconds (required with FINGERPRINT)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "meta",
+				Value: []string{acoustid.MetaRecordings, acoustid.MetaReleaseGroups, acoustid.MetaCompress},
+				Usage: "metadata to include (recordings, recordingids, releasegroups, sources...)",
+			},
+			&cli.IntFlag{
+				Name:    "length",
+				Aliases: []string{"l"},
+				Value:   fingerprint.DefaultLength,
+				Usage:   "max audio length in seconds when reading stdin (0 = unlimited)",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: formatText,
+				Usage: "output format (text, json)",
+			},
+		),
+		Action: runLookup,
+	}
+}
+
+func submitCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "submit",
+		Usage:     "Submit fingerprints to AcoustID",
+		ArgsUsage: "[LIST]",
+		Description: `Reads fingerprints from LIST (or stdin), one per line:
+
+  MBID<TAB>FINGERPRINT<TAB>DURATION
+
+where MBID is the MusicBrainz recording ID (may be empty) and DURATION the
+duration of the fingerprinted audio in seconds, and submits them on behalf of
+--user. With --wait, polls until AcoustID has imported them.`,
+		Flags: append(acoustIDFlags(),
+			&cli.StringFlag{
+				Name:     "user",
+				Usage:    "AcoustID user API key",
+				Sources:  cli.EnvVars("ACOUSTID_USER"),
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "wait",
+				Usage: "wait for the submissions to be imported",
+			},
+			&cli.DurationFlag{
+				Name:  "poll-interval",
+				Value: acoustid.DefaultPollInterval,
+				Usage: "interval between status polls with --wait",
+			},
+		),
+		Action: runSubmit,
+	}
+}
+
+func runLookup(ctx context.Context, cliCom *cli.Command) error {
+	format := cliCom.String("format")
+	if format != formatText && format != formatJSON {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidArgs, format)
+	}
+
+	encoded, duration := cliCom.Args().First(), cliCom.Int("duration")
+
+	switch {
+	case cliCom.Args().Len() > 1:
+		return fmt.Errorf("%w: expected at most one fingerprint", ErrInvalidArgs)
+	case encoded != "" && duration <= 0:
+		return fmt.Errorf("%w: --duration is required with a fingerprint", ErrInvalidArgs)
+	case encoded == "":
+		result, err := fingerprintStdin(ctx, fingerprint.Options{Length: cliCom.Int("length")})
+		if err != nil {
+			return err
+		}
+
+		encoded, duration = result.Fingerprint, int(math.Round(result.Duration))
+	}
+
+	client := acoustid.New(cliCom.String("client"), acoustid.Options{BaseURL: cliCom.String("url")})
+
+	results, err := client.Lookup(ctx, encoded, duration, cliCom.StringSlice("meta")...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrServiceFailure, err)
+	}
+
+	if format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err = encoder.Encode(results); err != nil {
+			return fmt.Errorf("%w: %w", ErrServiceFailure, err)
+		}
+	} else {
+		for _, result := range results {
+			_, _ = fmt.Fprintf(os.Stdout, "%s score=%.3f\n", result.ID, result.Score)
+
+			for _, recording := range result.Recordings {
+				artists := make([]string, len(recording.Artists))
+				for i, artist := range recording.Artists {
+					artists[i] = artist.Name
+				}
+
+				_, _ = fmt.Fprintf(os.Stdout, "  %s %s - %s\n", recording.ID, strings.Join(artists, ", "), recording.Title)
+			}
+		}
+	}
+
+	if len(results) == 0 {
+		return ErrNoMatch
+	}
+
+	return nil
+}
+
+func runSubmit(ctx context.Context, cliCom *cli.Command) error {
+	if cliCom.Args().Len() > 1 {
+		return fmt.Errorf("%w: expected at most one list", ErrInvalidArgs)
+	}
+
+	list := cliCom.Args().First()
+	if list == "" {
+		list = "-"
+	}
+
+	submissions, err := readSubmissions(list)
+	if err != nil {
+		return err
+	}
+
+	client := acoustid.New(cliCom.String("client"), acoustid.Options{BaseURL: cliCom.String("url")})
+
+	var ids []int
+
+	for start := 0; start < len(submissions); start += submitBatch {
+		batch := submissions[start:min(start+submitBatch, len(submissions))]
+
+		statuses, err := client.Submit(ctx, cliCom.String("user"), batch)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrServiceFailure, err)
+		}
+
+		for num, status := range statuses {
+			_, _ = fmt.Fprintf(os.Stdout, "%d submission=%d status=%s\n", start+num+1, status.ID, status.Status)
+			ids = append(ids, status.ID)
+		}
+	}
+
+	if !cliCom.Bool("wait") || len(ids) == 0 {
+		return nil
+	}
+
+	statuses, err := client.Wait(ctx, cliCom.Duration("poll-interval"), ids...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrServiceFailure, err)
+	}
+
+	for _, status := range statuses {
+		track := ""
+		if status.Result != nil {
+			track = " track=" + status.Result.ID
+		}
+
+		_, _ = fmt.Fprintf(os.Stdout, "submission=%d status=%s%s\n", status.ID, status.Status, track)
+	}
+
+	return nil
+}
+
+// readSubmissions parses "MBID<TAB>FINGERPRINT<TAB>DURATION" lines. Empty lines
+// and lines starting with # are skipped.
+func readSubmissions(path string) ([]acoustid.Submission, error) {
+	var reader io.Reader = os.Stdin
+
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailure, err)
+		}
+
+		defer file.Close()
+
+		reader = file
+	}
+
+	var submissions []acoustid.Submission
+
+	scanner := bufio.NewScanner(reader)
+	// Fingerprints of long recordings make long lines.
+	scanner.Buffer(nil, 1<<24) //nolint:mnd
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 { //nolint:mnd
+			return nil, fmt.Errorf("%w: %s:%d: expected 3 tab-separated fields", ErrInvalidArgs, path, lineNum)
+		}
+
+		duration, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("%w: %s:%d: invalid duration %q", ErrInvalidArgs, path, lineNum, fields[2])
+		}
+
+		submissions = append(submissions, acoustid.Submission{
+			MBID:        fields[0],
+			Fingerprint: fields[1],
+			Duration:    int(math.Round(duration)),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailure, err)
+	}
+
+	return submissions, nil
+}
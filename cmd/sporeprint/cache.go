@@ -0,0 +1,66 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/cache"
+)
+
+// defaultCache is the --cache value selecting [cache.DefaultDir].
+const defaultCache = "default"
+
+// cacheFlags are the flags of [openCache].
+func cacheFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "cache",
+			Usage:   `fingerprint cache directory ("` + defaultCache + `" for the user cache directory)`,
+			Sources: cli.EnvVars("SPOREPRINT_CACHE"),
+		},
+		&cli.BoolFlag{
+			Name:  "cache-hash",
+			Usage: "also identify cached files by the SHA-256 of their content (reads every file)",
+		},
+	}
+}
+
+// openCache opens the fingerprint cache selected by --cache, or returns nil
+// without one.
+func openCache(cliCom *cli.Command) (*cache.Cache, error) {
+	dir := cliCom.String("cache")
+	if dir == "" {
+		return nil, nil //nolint:nilnil // No cache is not an error.
+	}
+
+	if dir == defaultCache {
+		var err error
+		if dir, err = cache.DefaultDir(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCacheFailure, err)
+		}
+	}
+
+	fingerprints, err := cache.Open(dir, cache.Options{Hash: cliCom.Bool("cache-hash")})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCacheFailure, err)
+	}
+
+	return fingerprints, nil
+}
@@ -0,0 +1,205 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/calibrate"
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+const formatText = "text"
+
+func calibrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "calibrate",
+		Usage:     "Evaluate thresholds on labeled fingerprint pairs",
+		ArgsUsage: "[PAIRS]",
+		Description: `Reads labeled pairs from PAIRS (or stdin), one per line:
+
+  LABEL<TAB>FINGERPRINT1<TAB>FINGERPRINT2
+
+where LABEL is "same" (or 1) for two encodings of the same recording, and
+"different" (or 0) otherwise. Empty lines and lines starting with # are
+skipped.
+
+Reports the score and bit error rate distributions of both classes, the ROC
+and precision-recall curves (JSON only), the equal error rate, and the lowest
+threshold whose false-positive rate does not exceed --target-fpr.`,
+		Flags: []cli.Flag{
+			&cli.FloatFlag{
+				Name:  "target-fpr",
+				Value: calibrate.DefaultTargetFPR,
+				Usage: "maximum false-positive rate of the recommended threshold",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: formatText,
+				Usage: "output format (text, json)",
+			},
+			&cli.BoolFlag{
+				Name:  "global",
+				Usage: "search all alignment offsets instead of ±15 seconds (edits, excerpts)",
+			},
+			&cli.BoolFlag{
+				Name:  "tempo",
+				Usage: "also search time-scale factors (speed changes)",
+			},
+		},
+		Action: runCalibrate,
+	}
+}
+
+func runCalibrate(_ context.Context, cliCom *cli.Command) error {
+	format := cliCom.String("format")
+	if format != formatText && format != formatJSON {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidArgs, format)
+	}
+
+	if cliCom.Args().Len() > 1 {
+		return fmt.Errorf("%w: expected at most one pairs file", ErrInvalidArgs)
+	}
+
+	path := cliCom.Args().First()
+	if path == "" {
+		path = "-"
+	}
+
+	pairs, err := readPairs(path)
+	if err != nil {
+		return err
+	}
+
+	var matcher compare.Matcher = compare.Bounded{}
+	if cliCom.Bool("global") {
+		matcher = compare.Global{}
+	}
+
+	if cliCom.Bool("tempo") {
+		matcher = compare.Tempo{Base: matcher}
+	}
+
+	samples, err := calibrate.Measure(pairs, matcher)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	report, err := calibrate.Evaluate(samples, cliCom.Float("target-fpr"))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArgs, err)
+	}
+
+	if format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err = encoder.Encode(report); err != nil {
+			return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+		}
+
+		return nil
+	}
+
+	printCalibration(report)
+
+	return nil
+}
+
+func printCalibration(report calibrate.Report) {
+	_, _ = fmt.Fprintf(os.Stdout, "pairs: %d same, %d different\n", report.Same, report.Different)
+
+	for _, dist := range []struct {
+		name string
+		calibrate.Distribution
+	}{
+		{"score same     ", report.ScoreSame},
+		{"score different", report.ScoreDifferent},
+		{"ber   same     ", report.BERSame},
+		{"ber   different", report.BERDifferent},
+	} {
+		_, _ = fmt.Fprintf(os.Stdout, "%s  mean=%.3f sd=%.3f min=%.3f p5=%.3f median=%.3f p95=%.3f max=%.3f\n",
+			dist.name, dist.Mean, dist.StdDev, dist.Min, dist.P5, dist.Median, dist.P95, dist.Max)
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "auc=%.4f average_precision=%.4f\n", report.AUC, report.AveragePrecision)
+	_, _ = fmt.Fprintf(os.Stdout, "eer=%.4f at threshold=%.3f\n", report.EER, report.EERThreshold)
+	_, _ = fmt.Fprintf(os.Stdout, "recommended threshold=%.3f for fpr<=%.4f: tpr=%.4f fpr=%.4f precision=%.4f (default %.2f)\n",
+		report.Recommended.Threshold, report.TargetFPR, report.Recommended.TPR, report.Recommended.FPR,
+		report.Recommended.Precision, defaultThreshold)
+}
+
+// readPairs parses "LABEL<TAB>FINGERPRINT1<TAB>FINGERPRINT2" lines. Empty lines
+// and lines starting with # are skipped.
+func readPairs(path string) ([]calibrate.Pair, error) {
+	var reader io.Reader = os.Stdin
+
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailure, err)
+		}
+
+		defer file.Close()
+
+		reader = file
+	}
+
+	var pairs []calibrate.Pair
+
+	scanner := bufio.NewScanner(reader)
+	// Fingerprints of long recordings make long lines.
+	scanner.Buffer(nil, 1<<25) //nolint:mnd
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 { //nolint:mnd
+			return nil, fmt.Errorf("%w: %s:%d: expected 3 tab-separated fields", ErrInvalidArgs, path, lineNum)
+		}
+
+		pair := calibrate.Pair{FP1: fields[1], FP2: fields[2]}
+
+		switch strings.ToLower(fields[0]) {
+		case "same", "1":
+			pair.Same = true
+		case "different", "0":
+		default:
+			return nil, fmt.Errorf("%w: %s:%d: unknown label %q", ErrInvalidArgs, path, lineNum, fields[0])
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailure, err)
+	}
+
+	return pairs, nil
+}
@@ -0,0 +1,245 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+const (
+	// socketEnv names the daemon socket used by other commands.
+	socketEnv = "SPOREPRINT_SOCKET"
+	// daemonTimeout bounds the check that the daemon answers.
+	daemonTimeout = time.Second
+	socketMode    = 0o600
+	// wavAllowance is uploaded to the daemon on top of the PCM needed, for
+	// WAV headers.
+	wavAllowance   = 1 << 20
+	bytesPerSample = 2
+)
+
+func daemonCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "daemon",
+		Usage: "Serve the API on a Unix socket, keeping a database loaded",
+		Description: `Serves the "serve" endpoints on a Unix socket readable by the current user
+only, so that a database index is loaded once instead of by every command.
+
+With SPOREPRINT_SOCKET set to the socket, "fingerprint" and "compare" (and
+every command reading PCM from stdin) work through the daemon. They fall back
+to working in-process, with a warning, when it does not answer. Only the PCM
+needed by --length is uploaded: with --length 0, or lengths above the daemon
+upload limit (about 50 minutes), stdin is fingerprinted in-process.
+
+"db search" also searches through the daemon when it serves the same
+database, with the --threshold, --global and --lsh of the daemon: setting them
+searches in-process instead.
+
+  sporeprint daemon --socket /tmp/sporeprint.sock --db library.db &
+  export SPOREPRINT_SOCKET=/tmp/sporeprint.sock
+  sporeprint db search library.db < excerpt.pcm`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "socket",
+				Usage:    "Unix socket to listen on",
+				Sources:  cli.EnvVars(socketEnv),
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "db",
+				Usage: "database searched by /v1/search",
+			},
+			&cli.FloatFlag{
+				Name:    "threshold",
+				Aliases: []string{"t"},
+				Value:   defaultThreshold,
+				Usage:   "minimum similarity score for a match (0.0-1.0)",
+			},
+			&cli.BoolFlag{
+				Name:  "global",
+				Usage: "search the database at all alignment offsets instead of ±15 seconds",
+			},
+			&cli.BoolFlag{
+				Name:  "lsh",
+				Usage: "search the database with a locality-sensitive hashing prefilter (faster on large databases, may miss weak matches)",
+			},
+			&cli.DurationFlag{
+				Name:  "shutdown-timeout",
+				Value: maxShutdownTimeout,
+				Usage: "time given to requests in flight on shutdown",
+			},
+		},
+		Action: runDaemon,
+	}
+}
+
+func runDaemon(ctx context.Context, cliCom *cli.Command) error {
+	timeout, err := shutdownTimeout(cliCom)
+	if err != nil {
+		return err
+	}
+
+	ctx, done := untilShutdown(ctx)
+	defer done()
+
+	options := server.Options{Threshold: cliCom.Float("threshold"), Length: fingerprint.DefaultLength}
+
+	database, err := openServedDB(cliCom, options.Threshold)
+	if err != nil {
+		return err
+	}
+
+	if database != nil {
+		defer database.Close()
+
+		options.DB = database
+	}
+
+	listener, err := listenUnix(ctx, cliCom.String("socket"))
+	if err != nil {
+		return err
+	}
+
+	// Closing the listener on shutdown removes the socket.
+	return serveAndShutdown(ctx, listener, server.New(options), timeout)
+}
+
+// listenUnix listens on the Unix socket at path, replacing a socket left
+// behind by a daemon that is gone.
+func listenUnix(ctx context.Context, path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		checkCtx, cancel := context.WithTimeout(ctx, daemonTimeout)
+		defer cancel()
+
+		if _, err = server.DialUnix(path).Health(checkCtx); err == nil {
+			return nil, fmt.Errorf("%w: a daemon is already listening on %s", ErrServeFailure, path)
+		}
+
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrServeFailure, err)
+		}
+	}
+
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrServeFailure, err)
+	}
+
+	if err = os.Chmod(path, socketMode); err != nil {
+		_ = listener.Close()
+
+		return nil, fmt.Errorf("%w: %w", ErrServeFailure, err)
+	}
+
+	return listener, nil
+}
+
+// daemonClient returns a client of the daemon named by SPOREPRINT_SOCKET, or
+// nil to work in-process: when it is not set, or the daemon does not answer.
+func daemonClient(ctx context.Context) *server.Client {
+	client, _ := daemonHealth(ctx)
+
+	return client
+}
+
+// daemonHealth is like daemonClient, and also returns what the daemon serves.
+func daemonHealth(ctx context.Context) (*server.Client, server.HealthResponse) {
+	socket := os.Getenv(socketEnv)
+	if socket == "" {
+		return nil, server.HealthResponse{}
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, daemonTimeout)
+	defer cancel()
+
+	client := server.DialUnix(socket)
+
+	health, err := client.Health(checkCtx)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "warning: daemon unavailable, working in-process: %v\n", err)
+
+		return nil, server.HealthResponse{}
+	}
+
+	return client, health
+}
+
+// uploadLimit returns the number of bytes of stdin the daemon needs to
+// fingerprint with options, and false when they are not bounded (unlimited
+// length) or exceed its body size limit: stdin is then fingerprinted
+// in-process, as it cannot be read again once uploaded.
+func uploadLimit(options fingerprint.Options) (int64, bool) {
+	if options.Length <= 0 {
+		return 0, false
+	}
+
+	speed := options.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	// Resampling consumes input faster or slower than it feeds Chromaprint.
+	seconds := float64(options.Length) * max(speed, 1/speed)
+	limit := int64(math.Ceil(seconds*fingerprint.SampleRate*fingerprint.Channels))*bytesPerSample + wavAllowance
+
+	return limit, limit <= server.DefaultMaxAudioBytes
+}
+
+// daemonFingerprint fingerprints PCM from reader with the daemon.
+func daemonFingerprint(ctx context.Context, client *server.Client, reader io.Reader, options fingerprint.Options) (fingerprint.Result, error) {
+	response, err := client.Fingerprint(ctx, reader, options)
+	if err != nil {
+		return fingerprint.Result{}, daemonError(err)
+	}
+
+	return fingerprint.Result{Fingerprint: response.Fingerprint, Duration: response.Duration}, nil
+}
+
+// daemonError maps daemon failures to the errors of in-process work.
+func daemonError(err error) error {
+	apiErr := &server.Error{}
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("%w: %w", ErrServeFailure, err)
+	}
+
+	switch apiErr.Code {
+	case server.CodeInvalidArgs:
+		return fmt.Errorf("%w: %w", ErrInvalidArgs, err)
+	case server.CodeReadFailure, server.CodeTooLarge:
+		return fmt.Errorf("%w: %w", ErrReadFailure, err)
+	case server.CodeCompareFailure:
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	case server.CodeDatabaseFailure:
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	case server.CodeChromaprintFailure:
+		return fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrServeFailure, err)
+	}
+}
@@ -0,0 +1,557 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+const (
+	// defaultSearchLimit is the default maximum number of search results.
+	defaultSearchLimit = 10
+
+	conflictFail    = "fail"
+	conflictSkip    = "skip"
+	conflictReplace = "replace"
+)
+
+func dbCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "db",
+		Usage: "Manage a persistent fingerprint database",
+		Description: `A database is a directory, created by the first "db add". Every "db add"
+writes a new segment file; writes are atomic and may run from several
+processes at once.`,
+		Commands: []*cli.Command{
+			{
+				Name:      "add",
+				Usage:     "Add fingerprints to a database",
+				ArgsUsage: "DIR [PATH...]",
+				Description: `Fingerprints PCM from stdin (as "fingerprint") and stores it under --id,
+imports a list of already computed fingerprints with --list, one per line:
+
+  ID<TAB>FINGERPRINT[<TAB>DURATION]
+
+or fingerprints the audio files under PATH (as "dedupe"), stored under their
+path. With --trust-tags or --verify-tags, fingerprints written by "sporeprint
+tag" or MusicBrainz Picard are used instead of decoding files, when valid.
+With --cache, files unchanged since they were last fingerprinted are not
+decoded again.
+
+Examples:
+
+  ffmpeg -i track.flac ... | sporeprint db add --id track.flac library.db
+  sporeprint db add --trust-tags --cache default library.db ~/Music`,
+				Flags: append(append(tagPolicyFlags(), cacheFlags()...),
+					&cli.StringFlag{
+						Name:  "id",
+						Usage: "track ID of the PCM read from stdin",
+					},
+					&cli.StringFlag{
+						Name:  "list",
+						Usage: `file of "ID<TAB>FINGERPRINT[<TAB>DURATION]" lines to import ("-" for stdin)`,
+					},
+					&cli.IntFlag{
+						Name:    "length",
+						Aliases: []string{"l"},
+						Value:   fingerprint.DefaultLength,
+						Usage:   "max audio length in seconds (0 = unlimited)",
+					},
+					&cli.IntFlag{
+						Name:  "max-segments",
+						Value: db.DefaultMaxSegments,
+						Usage: "merge the smallest segments when there are more than this (-1 = never)",
+					},
+					&cli.IntFlag{
+						Name:    "jobs",
+						Aliases: []string{"j"},
+						Usage:   "files fingerprinted in parallel (0 = number of CPUs)",
+					},
+					&cli.StringFlag{
+						Name:  "ffmpeg",
+						Value: "ffmpeg",
+						Usage: "ffmpeg binary used to decode files",
+					},
+					&cli.StringFlag{
+						Name:  "ffprobe",
+						Value: "ffprobe",
+						Usage: "ffprobe binary used to verify tags",
+					},
+				),
+				Action: runDBAdd,
+			},
+			{
+				Name:      "search",
+				Usage:     "Search a database for a fingerprint",
+				ArgsUsage: "DIR [FINGERPRINT]",
+				Description: `Prints the matching tracks, best first. Without FINGERPRINT, fingerprints PCM
+from stdin (as "fingerprint").
+
+With SPOREPRINT_SOCKET set to a daemon serving DIR, searches through the
+daemon instead of loading the database, unless --threshold, --global or --lsh
+is set (see "sporeprint daemon --help").`,
+				Flags: []cli.Flag{
+					&cli.FloatFlag{
+						Name:    "threshold",
+						Aliases: []string{"t"},
+						Value:   defaultThreshold,
+						Usage:   "minimum similarity score to consider a match (0.0-1.0)",
+					},
+					&cli.BoolFlag{
+						Name:  "global",
+						Usage: "search all alignment offsets instead of ±15 seconds (excerpts)",
+					},
+					&cli.BoolFlag{
+						Name:  "lsh",
+						Usage: "select candidates with a locality-sensitive hashing prefilter (faster on large databases, may miss weak matches)",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Value: defaultSearchLimit,
+						Usage: "maximum number of results (0 = default)",
+					},
+					&cli.IntFlag{
+						Name:    "length",
+						Aliases: []string{"l"},
+						Value:   fingerprint.DefaultLength,
+						Usage:   "max audio length in seconds when reading stdin (0 = unlimited)",
+					},
+				},
+				Action: runDBSearch,
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove tracks from a database",
+				ArgsUsage: "DIR ID...",
+				Description: `Removed tracks are only marked as deleted. Their space is reclaimed by
+"db compact".`,
+				Action: runDBRemove,
+			},
+			{
+				Name:      "compact",
+				Usage:     "Merge all segments into one, dropping removed tracks",
+				ArgsUsage: "DIR",
+				Action:    runDBCompact,
+			},
+			{
+				Name:      "merge",
+				Usage:     "Copy the tracks of other databases into a database",
+				ArgsUsage: "DIR SOURCE...",
+				Description: `Combines databases built separately, such as ingestion shards. DIR is
+created if needed. Segment files are copied as they are.`,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "on-conflict",
+						Value: conflictFail,
+						Usage: "what to do with track IDs present in both databases (fail, skip, replace)",
+					},
+				},
+				Action: runDBMerge,
+			},
+			{
+				Name:      "stats",
+				Usage:     "Print database statistics",
+				ArgsUsage: "DIR",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "verify",
+						Usage: "also verify the checksum of every segment (reads the whole database)",
+					},
+				},
+				Action: runDBStats,
+			},
+		},
+	}
+}
+
+func runDBAdd(ctx context.Context, cliCom *cli.Command) error {
+	args := cliCom.Args()
+	if args.Len() == 0 {
+		return fmt.Errorf("%w: expected a database directory", ErrInvalidArgs)
+	}
+
+	id, list, paths := cliCom.String("id"), cliCom.String("list"), args.Tail()
+
+	sources := 0
+
+	for _, set := range []bool{id != "", list != "", len(paths) > 0} {
+		if set {
+			sources++
+		}
+	}
+
+	if sources != 1 {
+		return fmt.Errorf("%w: exactly one of --id, --list and PATH is required", ErrInvalidArgs)
+	}
+
+	var (
+		tracks []index.Track
+		err    error
+	)
+
+	switch {
+	case list != "":
+		tracks, err = readTrackList(list)
+	case id != "":
+		tracks, err = fingerprintTrack(ctx, id, cliCom.Int("length"))
+	default:
+		tracks, err = fingerprintLibrary(ctx, cliCom, paths)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	database, err := db.Open(args.Get(0), db.Options{Create: true, MaxSegments: cliCom.Int("max-segments")})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	defer database.Close()
+
+	if err = database.Add(tracks); err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "added=%d\n", len(tracks))
+
+	return nil
+}
+
+// searchOptions returns the index options selected by --global and --lsh.
+func searchOptions(cliCom *cli.Command, threshold float64) index.Options {
+	options := index.Options{Matcher: compare.Bounded{}, Threshold: threshold}
+	if cliCom.Bool("global") {
+		options.Matcher = compare.Global{}
+	}
+
+	if cliCom.Bool("lsh") {
+		options.LSH = &index.LSHOptions{}
+	}
+
+	return options
+}
+
+func runDBSearch(ctx context.Context, cliCom *cli.Command) error {
+	args := cliCom.Args()
+	if args.Len() < 1 || args.Len() > 2 { //nolint:mnd
+		return fmt.Errorf("%w: expected a database directory and an optional fingerprint", ErrInvalidArgs)
+	}
+
+	limit := cliCom.Int("limit")
+	if limit < 0 {
+		return fmt.Errorf("%w: --limit must not be negative", ErrInvalidArgs)
+	}
+
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+
+	encoded := args.Get(1)
+	if encoded == "" {
+		result, err := fingerprintStdin(ctx, fingerprint.Options{Length: cliCom.Int("length")})
+		if err != nil {
+			return err
+		}
+
+		encoded = result.Fingerprint
+	}
+
+	query, err := chromaprint.Decode(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	if client := daemonDB(ctx, cliCom, args.Get(0)); client != nil {
+		return daemonSearch(ctx, client, encoded, limit)
+	}
+
+	database, err := db.Open(args.Get(0), db.Options{Index: searchOptions(cliCom, cliCom.Float("threshold"))})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	defer database.Close()
+
+	matches := database.Search(query)
+	if len(matches) == 0 {
+		return ErrNoMatch
+	}
+
+	for _, match := range matches[:min(len(matches), limit)] {
+		_, _ = fmt.Fprintf(os.Stdout, "%s score=%.3f offset=%d\n", match.ID, match.Score, match.Offset)
+	}
+
+	return nil
+}
+
+// daemonDB returns a client of the daemon when it serves the database in dir,
+// which it has loaded already, or nil to open it. The daemon searches with
+// its own search options: setting them searches in-process.
+func daemonDB(ctx context.Context, cliCom *cli.Command, dir string) *server.Client {
+	if cliCom.IsSet("threshold") || cliCom.IsSet("global") || cliCom.IsSet("lsh") {
+		return nil
+	}
+
+	client, health := daemonHealth(ctx)
+	if client == nil || health.DB == "" {
+		return nil
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+
+	served, err := os.Stat(health.DB)
+	if err != nil || !os.SameFile(info, served) {
+		return nil
+	}
+
+	return client
+}
+
+// daemonSearch prints the matches of the daemon database for encoded.
+func daemonSearch(ctx context.Context, client *server.Client, encoded string, limit int) error {
+	response, err := client.Search(ctx, server.SearchRequest{Fingerprint: encoded, Limit: limit})
+	if err != nil {
+		return daemonError(err)
+	}
+
+	if len(response.Matches) == 0 {
+		return ErrNoMatch
+	}
+
+	for _, match := range response.Matches {
+		_, _ = fmt.Fprintf(os.Stdout, "%s score=%.3f offset=%d\n", match.ID, match.Score, match.Offset)
+	}
+
+	return nil
+}
+
+func runDBRemove(_ context.Context, cliCom *cli.Command) error {
+	args := cliCom.Args()
+	if args.Len() < 2 { //nolint:mnd
+		return fmt.Errorf("%w: expected a database directory and track IDs", ErrInvalidArgs)
+	}
+
+	database, err := db.Open(args.Get(0), db.Options{})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	defer database.Close()
+
+	removed, err := database.Remove(args.Tail()...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "removed=%d\n", removed)
+
+	return nil
+}
+
+func runDBStats(_ context.Context, cliCom *cli.Command) error {
+	args := cliCom.Args()
+	if args.Len() != 1 {
+		return fmt.Errorf("%w: expected a database directory, got %d arguments", ErrInvalidArgs, args.Len())
+	}
+
+	database, err := db.Open(args.Get(0), db.Options{})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	defer database.Close()
+
+	if cliCom.Bool("verify") {
+		if err = database.Verify(); err != nil {
+			return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+		}
+	}
+
+	printDBStats(database.Stats())
+
+	return nil
+}
+
+func runDBCompact(_ context.Context, cliCom *cli.Command) error {
+	args := cliCom.Args()
+	if args.Len() != 1 {
+		return fmt.Errorf("%w: expected a database directory, got %d arguments", ErrInvalidArgs, args.Len())
+	}
+
+	database, err := db.Open(args.Get(0), db.Options{})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	defer database.Close()
+
+	if err = database.Compact(); err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	printDBStats(database.Stats())
+
+	return nil
+}
+
+func runDBMerge(_ context.Context, cliCom *cli.Command) error {
+	args := cliCom.Args()
+	if args.Len() < 2 { //nolint:mnd
+		return fmt.Errorf("%w: expected a database directory and source databases", ErrInvalidArgs)
+	}
+
+	var conflict db.Conflict
+
+	switch cliCom.String("on-conflict") {
+	case conflictFail:
+		conflict = db.ConflictFail
+	case conflictSkip:
+		conflict = db.ConflictSkip
+	case conflictReplace:
+		conflict = db.ConflictReplace
+	default:
+		return fmt.Errorf("%w: unknown conflict policy %q", ErrInvalidArgs, cliCom.String("on-conflict"))
+	}
+
+	database, err := db.Open(args.Get(0), db.Options{Create: true})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	defer database.Close()
+
+	for _, dir := range args.Tail() {
+		if err = mergeDB(database, dir, conflict); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mergeDB(database *db.DB, dir string, conflict db.Conflict) error {
+	source, err := db.Open(dir, db.Options{})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	defer source.Close()
+
+	stats, err := database.Merge(source, conflict)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrDatabaseFailure, dir, err)
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "%s added=%d skipped=%d replaced=%d\n", dir, stats.Added, stats.Skipped, stats.Replaced)
+
+	return nil
+}
+
+func printDBStats(stats db.Stats) {
+	_, _ = fmt.Fprintf(os.Stdout, "tracks=%d deleted=%d segments=%d hashes=%d bytes=%d\n",
+		stats.Tracks, stats.Deleted, stats.Segments, stats.Hashes, stats.Bytes)
+}
+
+// fingerprintTrack fingerprints PCM from stdin into a single track.
+func fingerprintTrack(ctx context.Context, id string, length int) ([]index.Track, error) {
+	result, err := fingerprintStdin(ctx, fingerprint.Options{Length: length})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := chromaprint.Decode(result.Fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+	}
+
+	return []index.Track{{ID: id, Duration: result.Duration, Raw: raw}}, nil
+}
+
+// readTrackList parses "ID<TAB>FINGERPRINT[<TAB>DURATION]" lines. Empty lines
+// and lines starting with # are skipped.
+func readTrackList(path string) ([]index.Track, error) {
+	var reader io.Reader = os.Stdin
+
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadFailure, err)
+		}
+
+		defer file.Close()
+
+		reader = file
+	}
+
+	var tracks []index.Track
+
+	scanner := bufio.NewScanner(reader)
+	// Fingerprints of long recordings make long lines.
+	scanner.Buffer(nil, 1<<24) //nolint:mnd
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%w: %s:%d: expected 2 or 3 tab-separated fields", ErrInvalidArgs, path, lineNum)
+		}
+
+		raw, err := chromaprint.Decode(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s:%d: %w", ErrInvalidArgs, path, lineNum, err)
+		}
+
+		track := index.Track{ID: fields[0], Raw: raw}
+
+		if len(fields) == 3 { //nolint:mnd
+			if track.Duration, err = strconv.ParseFloat(fields[2], 64); err != nil {
+				return nil, fmt.Errorf("%w: %s:%d: %w", ErrInvalidArgs, path, lineNum, err)
+			}
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailure, err)
+	}
+
+	return tracks, nil
+}
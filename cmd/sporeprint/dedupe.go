@@ -0,0 +1,439 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/dedupe"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/library"
+)
+
+func dedupeCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "dedupe",
+		Usage:     "Find duplicate tracks in a music library",
+		ArgsUsage: "[PATH...]",
+		Description: `Fingerprints the audio files under PATH (decoded with ffmpeg, which must be
+in PATH), and/or loads the tracks of a database with --db, then prints the
+clusters of duplicates with the score and offset of every confirmed pair.
+Files under PATH also stored in --db (same ID) replace their stored track.
+With --trust-tags or --verify-tags, fingerprints written by "sporeprint tag"
+or MusicBrainz Picard are used instead of decoding files, when valid. With
+--cache, files unchanged since they were last fingerprinted are not decoded
+again.
+
+Offsets are in hashes (about 0.124 second each), of the first track of a pair
+relative to the second.
+
+With --action, the files of every cluster are probed with ffprobe and ranked
+to pick a keeper: lossless over lossy, then higher bit depth and sample rate
+(lossless) or bitrate (lossy), then longer duration, then paths matching an
+earlier --prefer pattern. The other files are then:
+
+  dry-run     only reported
+  quarantine  moved under --quarantine, keeping their absolute path
+  hardlink    replaced with hard links to the keeper, originals moved under
+              --quarantine if set
+  delete      removed
+
+Every change is recorded in --journal. "dedupe undo JOURNAL" reverts them,
+except deletions.`,
+		Commands: []*cli.Command{
+			{
+				Name:      "undo",
+				Usage:     "Revert the actions recorded in a dedupe journal",
+				ArgsUsage: "JOURNAL",
+				Action:    runDedupeUndo,
+			},
+		},
+		Flags: append(append(tagPolicyFlags(), cacheFlags()...),
+			&cli.StringFlag{
+				Name:  "db",
+				Usage: "also load the tracks of this database",
+			},
+			&cli.FloatFlag{
+				Name:    "threshold",
+				Aliases: []string{"t"},
+				Value:   defaultThreshold,
+				Usage:   "minimum similarity score to consider two tracks duplicates (0.0-1.0)",
+			},
+			&cli.BoolFlag{
+				Name:  "global",
+				Usage: "search all alignment offsets instead of ±15 seconds (edits, excerpts)",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: formatJSON,
+				Usage: "output format (json, csv)",
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "files fingerprinted in parallel (0 = number of CPUs)",
+			},
+			&cli.IntFlag{
+				Name:    "length",
+				Aliases: []string{"l"},
+				Value:   fingerprint.DefaultLength,
+				Usage:   "max audio length in seconds (0 = unlimited)",
+			},
+			&cli.StringFlag{
+				Name:  "ffmpeg",
+				Value: "ffmpeg",
+				Usage: "ffmpeg binary used to decode files",
+			},
+			&cli.StringFlag{
+				Name:  "action",
+				Usage: "resolve clusters: dry-run, quarantine, hardlink, delete",
+			},
+			&cli.StringSliceFlag{
+				Name:  "prefer",
+				Usage: "regular expression of preferred keeper paths (repeatable, by decreasing preference)",
+			},
+			&cli.StringFlag{
+				Name:  "quarantine",
+				Usage: "directory duplicates are moved to",
+			},
+			&cli.StringFlag{
+				Name:  "journal",
+				Value: "sporeprint-journal.jsonl",
+				Usage: "file actions are appended to, for undo",
+			},
+			&cli.StringFlag{
+				Name:  "ffprobe",
+				Value: "ffprobe",
+				Usage: "ffprobe binary used to rank files and verify tags",
+			},
+		),
+		Action: runDedupe,
+	}
+}
+
+type dedupePair struct {
+	Track1 string  `json:"track1"`
+	Track2 string  `json:"track2"`
+	Score  float64 `json:"score"`
+	Offset int     `json:"offset"`
+}
+
+type dedupeCluster struct {
+	Tracks []string     `json:"tracks"`
+	Pairs  []dedupePair `json:"pairs"`
+	Keeper string       `json:"keeper,omitempty"`
+}
+
+type dedupeOutput struct {
+	Clusters []dedupeCluster `json:"clusters"`
+}
+
+func runDedupe(ctx context.Context, cliCom *cli.Command) error {
+	format := cliCom.String("format")
+	if format != formatCSV && format != formatJSON {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidArgs, format)
+	}
+
+	if cliCom.Args().Len() == 0 && cliCom.String("db") == "" {
+		return fmt.Errorf("%w: expected library paths or --db", ErrInvalidArgs)
+	}
+
+	ranking, err := parseRanking(cliCom.StringSlice("prefer"))
+	if err != nil {
+		return err
+	}
+
+	action := dedupe.Action(cliCom.String("action"))
+
+	switch action {
+	case "", dedupe.ActionDryRun, dedupe.ActionHardlink, dedupe.ActionDelete:
+	case dedupe.ActionQuarantine:
+		if cliCom.String("quarantine") == "" {
+			return fmt.Errorf("%w: --action quarantine requires --quarantine", ErrInvalidArgs)
+		}
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidArgs, action)
+	}
+
+	tracks, err := loadLibrary(ctx, cliCom)
+	if err != nil {
+		return err
+	}
+
+	var matcher compare.Matcher = compare.Bounded{}
+	if cliCom.Bool("global") {
+		matcher = compare.Global{}
+	}
+
+	clusters, err := dedupe.Find(tracks, dedupe.Options{
+		Threshold: cliCom.Float("threshold"),
+		Matcher:   matcher,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	var keepers []string
+
+	if action != "" && len(clusters) > 0 {
+		keepers, err = resolveClusters(ctx, cliCom, clusters, ranking)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = printClusters(format, clusters, keepers); err != nil {
+		return err
+	}
+
+	if len(clusters) == 0 {
+		return ErrNoMatch
+	}
+
+	return nil
+}
+
+func runDedupeUndo(_ context.Context, cliCom *cli.Command) error {
+	if cliCom.Args().Len() != 1 {
+		return fmt.Errorf("%w: expected a journal file", ErrInvalidArgs)
+	}
+
+	journal, err := os.Open(cliCom.Args().First())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrReadFailure, err)
+	}
+
+	defer func() { _ = journal.Close() }()
+
+	restored, err := dedupe.Undo(journal)
+	_, _ = fmt.Fprintf(os.Stdout, "restored=%d\n", restored)
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrActionFailure, err)
+	}
+
+	return nil
+}
+
+func parseRanking(patterns []string) (dedupe.Ranking, error) {
+	var ranking dedupe.Ranking
+
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return ranking, fmt.Errorf("%w: --prefer: %w", ErrInvalidArgs, err)
+		}
+
+		ranking.Prefer = append(ranking.Prefer, compiled)
+	}
+
+	return ranking, nil
+}
+
+// resolveClusters picks the keeper of every cluster and applies the action to
+// the others. It returns the keepers, empty for clusters that could not be
+// ranked: those are reported and left alone.
+func resolveClusters(ctx context.Context, cliCom *cli.Command, clusters []dedupe.Cluster, ranking dedupe.Ranking) ([]string, error) {
+	options := dedupe.ApplyOptions{
+		Action:     dedupe.Action(cliCom.String("action")),
+		Quarantine: cliCom.String("quarantine"),
+	}
+
+	if options.Action != dedupe.ActionDryRun {
+		journal, err := os.OpenFile(cliCom.String("journal"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600) //nolint:mnd
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrActionFailure, err)
+		}
+
+		defer func() {
+			_ = journal.Sync()
+			_ = journal.Close()
+		}()
+
+		options.Journal = journal
+	}
+
+	keepers := make([]string, len(clusters))
+
+	for num, found := range clusters {
+		resolution, err := dedupe.Resolve(ctx, found, dedupe.ResolveOptions{
+			FFprobe: cliCom.String("ffprobe"),
+			Ranking: ranking,
+		})
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "warning: skipping cluster %d: %v\n", num+1, err)
+
+			continue
+		}
+
+		keepers[num] = resolution.Keeper
+
+		entries, err := dedupe.Apply(resolution, options)
+		for _, entry := range entries {
+			_, _ = fmt.Fprintf(os.Stderr, "%s: %s (keeping %s)\n", entry.Action, entry.Path, entry.Keeper)
+		}
+
+		if err != nil {
+			return keepers, fmt.Errorf("%w: %w", ErrActionFailure, err)
+		}
+	}
+
+	return keepers, nil
+}
+
+// loadLibrary fingerprints the files designated by the arguments, and loads
+// the tracks of --db.
+func loadLibrary(ctx context.Context, cliCom *cli.Command) ([]index.Track, error) {
+	var tracks []index.Track
+
+	if dir := cliCom.String("db"); dir != "" {
+		database, err := db.Open(dir, db.Options{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+		}
+
+		tracks = database.Tracks()
+		_ = database.Close()
+	}
+
+	if cliCom.Args().Len() == 0 {
+		return tracks, nil
+	}
+
+	found, err := fingerprintLibrary(ctx, cliCom, cliCom.Args().Slice())
+	if err != nil {
+		return nil, err
+	}
+
+	return library.Merge(tracks, found), nil
+}
+
+// fingerprintLibrary fingerprints the files designated by roots, per --jobs,
+// --length, --ffmpeg, --ffprobe, the tag policy and the cache flags. Files
+// that cannot be fingerprinted are reported and skipped.
+func fingerprintLibrary(ctx context.Context, cliCom *cli.Command, roots []string) ([]index.Track, error) {
+	policy, err := tagPolicy(cliCom)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprints, err := openCache(cliCom)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := library.Walk(roots)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadFailure, err)
+	}
+
+	tracks, failures := library.Fingerprint(ctx, paths, library.Options{
+		Jobs: cliCom.Int("jobs"),
+		Fingerprint: fingerprint.Options{
+			Length: cliCom.Int("length"),
+			FFmpeg: cliCom.String("ffmpeg"),
+		},
+		Tags:    policy,
+		FFprobe: cliCom.String("ffprobe"),
+		Cache:   fingerprints,
+	})
+
+	for _, failure := range failures {
+		_, _ = fmt.Fprintf(os.Stderr, "warning: %s: %v\n", failure.Path, failure.Err)
+	}
+
+	return tracks, nil
+}
+
+// printClusters prints the clusters, with their keeper when keepers is not
+// nil.
+func printClusters(format string, clusters []dedupe.Cluster, keepers []string) error {
+	output := dedupeOutput{Clusters: make([]dedupeCluster, len(clusters))}
+
+	for i, found := range clusters {
+		output.Clusters[i] = dedupeCluster{Tracks: found.IDs, Pairs: make([]dedupePair, len(found.Pairs))}
+
+		if keepers != nil {
+			output.Clusters[i].Keeper = keepers[i]
+		}
+
+		for j, pair := range found.Pairs {
+			output.Clusters[i].Pairs[j] = dedupePair{
+				Track1: pair.ID1,
+				Track2: pair.ID2,
+				Score:  pair.Score,
+				Offset: pair.Offset,
+			}
+		}
+	}
+
+	if format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(output); err != nil {
+			return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+		}
+
+		return nil
+	}
+
+	writer := csv.NewWriter(os.Stdout)
+
+	header := []string{"cluster", "track1", "track2", "score", "offset"}
+	if keepers != nil {
+		header = append(header, "keeper")
+	}
+
+	_ = writer.Write(header)
+
+	for i, found := range output.Clusters {
+		for _, pair := range found.Pairs {
+			record := []string{
+				strconv.Itoa(i + 1),
+				pair.Track1,
+				pair.Track2,
+				strconv.FormatFloat(pair.Score, 'f', 3, 64),
+				strconv.Itoa(pair.Offset),
+			}
+			if keepers != nil {
+				record = append(record, found.Keeper)
+			}
+
+			_ = writer.Write(record)
+		}
+	}
+
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	return nil
+}
@@ -18,11 +18,14 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"strconv"
 
 	"github.com/urfave/cli/v3"
 
@@ -30,19 +33,22 @@ import (
 
 	"github.com/mycophonic/sporeprint/chromaprint"
 	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/server"
 	"github.com/mycophonic/sporeprint/version"
 )
 
-// See README.
 const (
-	sampleRate      = 11025
-	channels        = 1
-	defaultDuration = 120
-	bufferSize      = 8192
+	// defaultTimelineWindow is the default timeline window size in seconds.
+	defaultTimelineWindow = 5.0
+
+	formatCSV  = "csv"
+	formatJSON = "json"
 
 	// defaultThreshold is the minimum similarity score to consider two
 	// fingerprints a match. Matches AcoustID's TRACK_GROUP_MERGE_THRESHOLD.
 	// Reference: https://github.com/acoustid/acoustid-server
+	// "sporeprint calibrate" measures a threshold suited to other material.
 	defaultThreshold = 0.4
 )
 
@@ -52,6 +58,12 @@ var (
 	ErrInvalidArgs        = errors.New("invalid arguments")
 	ErrReadFailure        = errors.New("read error")
 	ErrNoMatch            = errors.New("no match")
+	ErrDatabaseFailure    = errors.New("database error")
+	ErrActionFailure      = errors.New("action error")
+	ErrServeFailure       = errors.New("server error")
+	ErrServiceFailure     = errors.New("acoustid error")
+	ErrTagFailure         = errors.New("tag error")
+	ErrCacheFailure       = errors.New("cache error")
 )
 
 func main() {
@@ -77,9 +89,14 @@ The aresample filter parameters ensure identical output to fpcalc.`,
 					&cli.IntFlag{
 						Name:    "length",
 						Aliases: []string{"l"},
-						Value:   defaultDuration,
+						Value:   fingerprint.DefaultLength,
 						Usage:   "max audio length in seconds (0 = unlimited)",
 					},
+					&cli.FloatFlag{
+						Name:  "speed",
+						Value: 1,
+						Usage: "playback speed of the input relative to the original (1.04 = PAL speed-up), undone before fingerprinting",
+					},
 				},
 				Action: runFingerprint,
 			},
@@ -94,9 +111,67 @@ The aresample filter parameters ensure identical output to fpcalc.`,
 						Value:   defaultThreshold,
 						Usage:   "minimum similarity score to consider a match (0.0-1.0)",
 					},
+					&cli.BoolFlag{
+						Name:  "global",
+						Usage: "search all alignment offsets instead of ±15 seconds (live recordings, mixes)",
+					},
+					&cli.BoolFlag{
+						Name:  "tempo",
+						Usage: "search time-scale factors between 0.85 and 1.15 and report the best one",
+					},
+					&cli.BoolFlag{
+						Name:  "diff",
+						Usage: "also print the kept, deleted and inserted regions (radio edits, extended mixes), at the scale found with --tempo",
+					},
+					&cli.BoolFlag{
+						Name:  "timeline",
+						Usage: "print a sliding-window similarity timeline at the best offset instead of the score",
+					},
+					&cli.FloatFlag{
+						Name:  "window",
+						Value: defaultTimelineWindow,
+						Usage: "timeline window size in seconds",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: formatCSV,
+						Usage: "timeline output format (csv, json)",
+					},
 				},
 				Action: runCompare,
 			},
+			{
+				Name:      "locate",
+				Usage:     "Find where a track occurs inside a longer recording",
+				ArgsUsage: "QUERY HAYSTACK",
+				Description: `Reports every occurrence of the QUERY fingerprint (a track) inside the
+HAYSTACK fingerprint (a broadcast, a DJ set), one per line, with start and end
+times in seconds and a confidence score.
+
+The haystack must be fingerprinted without a length limit:
+
+  ffmpeg -i set.flac ... | sporeprint fingerprint --length 0`,
+				Flags: []cli.Flag{
+					&cli.FloatFlag{
+						Name:    "threshold",
+						Aliases: []string{"t"},
+						Value:   defaultThreshold,
+						Usage:   "minimum confidence to report an occurrence (0.0-1.0)",
+					},
+				},
+				Action: runLocate,
+			},
+			dbCommand(),
+			dedupeCommand(),
+			matrixCommand(),
+			calibrateCommand(),
+			robustnessCommand(),
+			serveCommand(),
+			lookupCommand(),
+			submitCommand(),
+			workerCommand(),
+			daemonCommand(),
+			tagCommand(),
 		},
 	}
 
@@ -109,7 +184,7 @@ The aresample filter parameters ensure identical output to fpcalc.`,
 	}
 }
 
-func runCompare(_ context.Context, cliCom *cli.Command) error {
+func runCompare(ctx context.Context, cliCom *cli.Command) error {
 	args := cliCom.Args()
 	if args.Len() != 2 { //nolint:mnd
 		return fmt.Errorf("%w: expected exactly 2 fingerprints, got %d", ErrInvalidArgs, args.Len())
@@ -119,91 +194,250 @@ func runCompare(_ context.Context, cliCom *cli.Command) error {
 	fp2 := args.Get(1)
 	threshold := cliCom.Float("threshold")
 
-	score, err := compare.Compare(fp1, fp2)
+	global := cliCom.Bool("global")
+	tempo := cliCom.Bool("tempo")
+
+	if cliCom.Bool("timeline") {
+		if cliCom.Bool("diff") {
+			return fmt.Errorf("%w: --timeline and --diff are mutually exclusive", ErrInvalidArgs)
+		}
+
+		return printTimeline(cliCom, fp1, fp2, newMatcher(global, tempo), threshold)
+	}
+
+	result, err := matchFingerprints(ctx, fp1, fp2, global, tempo)
 	if err != nil {
-		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+		return err
 	}
 
-	if score >= threshold {
-		_, _ = fmt.Fprintf(os.Stdout, "score=%.3f match (threshold=%.2f)\n", score, threshold)
+	// The offset is only informative when it is not bounded to a few seconds.
+	var detail string
+	if global {
+		detail += fmt.Sprintf(" offset=%d", result.Offset)
+	}
+
+	if tempo {
+		detail += fmt.Sprintf(" scale=%.3f", result.Scale)
+	}
 
-		return nil
+	verdict := "no match"
+	if result.Score >= threshold {
+		verdict = "match"
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "score=%.3f%s %s (threshold=%.2f)\n", result.Score, detail, verdict, threshold)
+
+	if cliCom.Bool("diff") {
+		if err = printDiff(fp1, fp2, result); err != nil {
+			return err
+		}
 	}
 
-	_, _ = fmt.Fprintf(os.Stdout, "score=%.3f no match (threshold=%.2f)\n", score, threshold)
+	if result.Score < threshold {
+		return ErrNoMatch
+	}
 
-	return ErrNoMatch
+	return nil
 }
 
-func runFingerprint(_ context.Context, cliCom *cli.Command) error {
-	length := cliCom.Int("length")
+// newMatcher returns the matcher selected by --global and --tempo.
+func newMatcher(global, tempo bool) compare.Matcher {
+	var matcher compare.Matcher = compare.Bounded{}
+	if global {
+		matcher = compare.Global{}
+	}
 
-	chroma := chromaprint.New()
-	defer chroma.Free()
+	if tempo {
+		matcher = compare.Tempo{Base: matcher}
+	}
 
-	if err := chroma.Start(sampleRate, channels); err != nil {
-		return fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+	return matcher
+}
+
+// matchFingerprints compares two fingerprints, with the daemon if there is one.
+func matchFingerprints(ctx context.Context, fp1, fp2 string, global, tempo bool) (compare.Result, error) {
+	if client := daemonClient(ctx); client != nil {
+		response, err := client.Compare(ctx, server.CompareRequest{
+			Fingerprint1: fp1,
+			Fingerprint2: fp2,
+			Global:       global,
+			Tempo:        tempo,
+		})
+		if err != nil {
+			return compare.Result{}, daemonError(err)
+		}
+
+		return compare.Result{Score: response.Score, Offset: response.Offset, Scale: response.Scale}, nil
 	}
 
-	// Calculate sample limit: rate × channels × seconds
-	var maxSamples int
-	if length > 0 {
-		maxSamples = sampleRate * channels * length
+	result, err := compare.Match(fp1, fp2, newMatcher(global, tempo))
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", ErrCompareFailure, err)
 	}
 
-	buf := make([]byte, bufferSize)
-	samples := make([]int16, bufferSize/2)
-	totalFed := 0
+	return result, nil
+}
 
-	for {
-		nread, err := io.ReadFull(os.Stdin, buf)
-		if nread == 0 {
-			if errors.Is(err, io.EOF) {
-				break
-			}
+type timelineWindow struct {
+	Start        float64 `json:"start"`
+	End          float64 `json:"end"`
+	Score        float64 `json:"score"`
+	BitErrorRate float64 `json:"ber"`
+}
 
-			if err != nil {
-				return fmt.Errorf("%w: %w", ErrReadFailure, err)
-			}
-		}
+type timelineOutput struct {
+	Score   float64          `json:"score"`
+	Offset  int              `json:"offset"`
+	Scale   float64          `json:"scale"`
+	Windows []timelineWindow `json:"windows"`
+}
 
-		// Convert bytes to int16 samples
-		numSamples := nread / 2
-		for i := 0; i+1 < nread; i += 2 {
-			//nolint:gosec // samples size = 1/2 buffer size
-			samples[i/2] = int16(binary.LittleEndian.Uint16(buf[i : i+2]))
-		}
+func printTimeline(cliCom *cli.Command, fp1, fp2 string, matcher compare.Matcher, threshold float64) error {
+	format := cliCom.String("format")
+	if format != formatCSV && format != formatJSON {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidArgs, format)
+	}
+
+	result, windows, err := compare.Timeline(fp1, fp2, compare.TimelineOptions{
+		Matcher: matcher,
+		Window:  int(math.Round(cliCom.Float("window") / compare.SecondsPerHash)),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	output := timelineOutput{
+		Score:   result.Score,
+		Offset:  result.Offset,
+		Scale:   result.Scale,
+		Windows: make([]timelineWindow, len(windows)),
+	}
 
-		// Apply length limit
-		toFeed := numSamples
-		if maxSamples > 0 && totalFed+toFeed > maxSamples {
-			toFeed = maxSamples - totalFed
-			if toFeed <= 0 {
-				break
-			}
+	for i, window := range windows {
+		output.Windows[i] = timelineWindow(window)
+	}
+
+	if format == formatJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err = encoder.Encode(output); err != nil {
+			return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+		}
+	} else {
+		writer := csv.NewWriter(os.Stdout)
+		_ = writer.Write([]string{"start", "end", "score", "ber"})
+
+		for _, window := range output.Windows {
+			_ = writer.Write([]string{
+				strconv.FormatFloat(window.Start, 'f', 3, 64),
+				strconv.FormatFloat(window.End, 'f', 3, 64),
+				strconv.FormatFloat(window.Score, 'f', 3, 64),
+				strconv.FormatFloat(window.BitErrorRate, 'f', 3, 64),
+			})
 		}
 
-		if err = chroma.Feed(samples[:toFeed]); err != nil {
-			return fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+		writer.Flush()
+
+		if err = writer.Error(); err != nil {
+			return fmt.Errorf("%w: %w", ErrCompareFailure, err)
 		}
+	}
+
+	if result.Score < threshold {
+		return ErrNoMatch
+	}
+
+	return nil
+}
+
+// printDiff prints the edits between fp1 and fp2, at the time scale of the
+// match found with --tempo. The diff itself aligns at any offset.
+func printDiff(fp1, fp2 string, result compare.Result) error {
+	edits, err := compare.ResultDiff(fp1, fp2, result)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	for _, edit := range edits {
+		_, _ = fmt.Fprintf(os.Stdout, "%-6s fp1=%.2f-%.2f fp2=%.2f-%.2f\n",
+			edit.Op, edit.Start1, edit.End1, edit.Start2, edit.End2)
+	}
+
+	return nil
+}
+
+func runLocate(_ context.Context, cliCom *cli.Command) error {
+	args := cliCom.Args()
+	if args.Len() != 2 { //nolint:mnd
+		return fmt.Errorf("%w: expected exactly 2 fingerprints, got %d", ErrInvalidArgs, args.Len())
+	}
+
+	threshold := cliCom.Float("threshold")
+
+	occurrences, err := compare.Locate(args.Get(0), args.Get(1))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
 
-		totalFed += toFeed
+	found := false
 
-		if maxSamples > 0 && totalFed >= maxSamples {
-			break
+	for _, occurrence := range occurrences {
+		if occurrence.Confidence < threshold {
+			continue
 		}
+
+		found = true
+
+		_, _ = fmt.Fprintf(os.Stdout, "start=%.2f end=%.2f confidence=%.3f\n",
+			occurrence.Start, occurrence.End, occurrence.Confidence)
+	}
+
+	if !found {
+		return ErrNoMatch
 	}
 
-	if err := chroma.Finish(); err != nil {
-		return fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+	return nil
+}
+
+func runFingerprint(ctx context.Context, cliCom *cli.Command) error {
+	if speed := cliCom.Float("speed"); !(speed > 0) {
+		return fmt.Errorf("%w: --speed must be positive, got %g", ErrInvalidArgs, speed)
 	}
 
-	fingerprint, err := chroma.Fingerprint()
+	result, err := fingerprintStdin(ctx, fingerprint.Options{
+		Length: cliCom.Int("length"),
+		Speed:  cliCom.Float("speed"),
+	})
 	if err != nil {
-		return fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+		return err
 	}
 
-	_, _ = fmt.Fprintln(os.Stdout, fingerprint)
+	_, _ = fmt.Fprintln(os.Stdout, result.Fingerprint)
 
 	return nil
 }
+
+// fingerprintStdin fingerprints PCM read from stdin, with the daemon if there
+// is one and it accepts the upload.
+func fingerprintStdin(ctx context.Context, options fingerprint.Options) (fingerprint.Result, error) {
+	if limit, ok := uploadLimit(options); ok {
+		if client := daemonClient(ctx); client != nil {
+			return daemonFingerprint(ctx, client, io.LimitReader(os.Stdin, limit), options)
+		}
+	}
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	result, err := fingerprint.Stream(chroma, os.Stdin, options)
+	if errors.Is(err, fingerprint.ErrRead) {
+		return result, fmt.Errorf("%w: %w", ErrReadFailure, err)
+	}
+
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+	}
+
+	return result, nil
+}
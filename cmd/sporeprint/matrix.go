@@ -0,0 +1,242 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/matrix"
+)
+
+const formatNPY = "npy"
+
+func matrixCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "matrix",
+		Usage:     "Compare every pair of a list of fingerprints",
+		ArgsUsage: "[LIST]",
+		Description: `Reads fingerprints from LIST (or stdin), one per line:
+
+  ID<TAB>FINGERPRINT[<TAB>DURATION]
+
+and prints the score, offset and time-scale factor (1 without --tempo) of
+every pair, in parallel. Each unordered pair is compared once.
+
+With --format npy, the full square score matrix is written to stdout as a
+NumPy .npy array (float64, rows and columns in LIST order), --offsets writes
+the offset matrix (int32, offset of the row relative to the column) to
+another .npy file, and --scales the scale matrix (float64, how many times
+faster the row plays than the column):
+
+  sporeprint matrix --format npy --offsets offsets.npy tracks.tsv > scores.npy`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Value: formatCSV,
+				Usage: "output format (csv, json, npy)",
+			},
+			&cli.StringFlag{
+				Name:  "offsets",
+				Usage: "with --format npy, also write the offset matrix to this file",
+			},
+			&cli.StringFlag{
+				Name:  "scales",
+				Usage: "with --format npy, also write the scale matrix to this file",
+			},
+			&cli.BoolFlag{
+				Name:  "global",
+				Usage: "search all alignment offsets instead of ±15 seconds (edits, excerpts)",
+			},
+			&cli.BoolFlag{
+				Name:  "tempo",
+				Usage: "also search time-scale factors (speed changes)",
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "rows compared in parallel (0 = number of CPUs)",
+			},
+		},
+		Action: runMatrix,
+	}
+}
+
+// matrixPair is the comparison of a pair of tracks. Offset and Scale are of
+// Track1 relative to Track2.
+type matrixPair struct {
+	Track1 string  `json:"track1"`
+	Track2 string  `json:"track2"`
+	Score  float64 `json:"score"`
+	Offset int     `json:"offset"`
+	Scale  float64 `json:"scale"`
+}
+
+type matrixOutput struct {
+	Tracks []string     `json:"tracks"`
+	Pairs  []matrixPair `json:"pairs"`
+}
+
+func runMatrix(_ context.Context, cliCom *cli.Command) error {
+	format := cliCom.String("format")
+	if format != formatCSV && format != formatJSON && format != formatNPY {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidArgs, format)
+	}
+
+	for _, flag := range []string{"offsets", "scales"} {
+		if cliCom.String(flag) != "" && format != formatNPY {
+			return fmt.Errorf("%w: --%s requires --format npy", ErrInvalidArgs, flag)
+		}
+	}
+
+	if cliCom.Args().Len() > 1 {
+		return fmt.Errorf("%w: expected at most one list", ErrInvalidArgs)
+	}
+
+	list := cliCom.Args().First()
+	if list == "" {
+		list = "-"
+	}
+
+	tracks, err := readTrackList(list)
+	if err != nil {
+		return err
+	}
+
+	var matcher compare.Matcher = compare.Bounded{}
+	if cliCom.Bool("global") {
+		matcher = compare.Global{}
+	}
+
+	if cliCom.Bool("tempo") {
+		matcher = compare.Tempo{Base: matcher}
+	}
+
+	raws := make([][]uint32, len(tracks))
+	for num, track := range tracks {
+		raws[num] = track.Raw
+	}
+
+	mat := matrix.Compute(raws, matrix.Options{Matcher: matcher, Jobs: cliCom.Int("jobs")})
+
+	switch format {
+	case formatNPY:
+		return writeMatrixNPY(mat, cliCom.String("offsets"), cliCom.String("scales"))
+	case formatJSON:
+		return writeMatrixJSON(mat, tracks)
+	default:
+		return writeMatrixCSV(mat, tracks)
+	}
+}
+
+func writeMatrixNPY(mat *matrix.Matrix, offsets, scales string) error {
+	if err := mat.WriteScoresNPY(os.Stdout); err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	if err := writeNPYFile(offsets, mat.WriteOffsetsNPY); err != nil {
+		return err
+	}
+
+	return writeNPYFile(scales, mat.WriteScalesNPY)
+}
+
+// writeNPYFile creates path, if not empty, and writes it with write.
+func writeNPYFile(path string, write func(io.Writer) error) error {
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	return nil
+}
+
+func writeMatrixJSON(mat *matrix.Matrix, tracks []index.Track) error {
+	output := matrixOutput{Tracks: make([]string, len(tracks))}
+
+	for i, track := range tracks {
+		output.Tracks[i] = track.ID
+
+		for j := i + 1; j < len(tracks); j++ {
+			result := mat.At(i, j)
+			output.Pairs = append(output.Pairs, matrixPair{
+				Track1: track.ID,
+				Track2: tracks[j].ID,
+				Score:  result.Score,
+				Offset: result.Offset,
+				Scale:  result.Scale,
+			})
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(output); err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	return nil
+}
+
+func writeMatrixCSV(mat *matrix.Matrix, tracks []index.Track) error {
+	writer := csv.NewWriter(os.Stdout)
+	_ = writer.Write([]string{"track1", "track2", "score", "offset", "scale"})
+
+	for i, track := range tracks {
+		for j := i + 1; j < len(tracks); j++ {
+			result := mat.At(i, j)
+			_ = writer.Write([]string{
+				track.ID,
+				tracks[j].ID,
+				strconv.FormatFloat(result.Score, 'f', 3, 64),
+				strconv.Itoa(result.Offset),
+				strconv.FormatFloat(result.Scale, 'f', 3, 64),
+			})
+		}
+	}
+
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+	}
+
+	return nil
+}
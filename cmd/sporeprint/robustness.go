@@ -0,0 +1,159 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/robustness"
+)
+
+func robustnessCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "robustness",
+		Usage: "Measure how fingerprints survive synthetic degradations",
+		Description: `Generates a test signal, applies each degradation at increasing strengths,
+and prints the score and bit error rate of each degraded fingerprint against
+the original. Everything runs offline and is deterministic.
+
+Degradations:
+  lossy  DCT codec dropping a fraction of the spectrum
+  eq     bass boost and treble cut, in dB
+  noise  white noise, at a signal-to-noise ratio in dB
+  gain   gain in dB, clipped to 16 bits
+  clip   hard clipping, at a fraction of the peak
+  crop   seconds removed from the start
+  speed  playback speed factor (tempo and pitch), see --tempo`,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:  "seconds",
+				Value: 30, //nolint:mnd
+				Usage: "duration of the test signal",
+			},
+			&cli.IntFlag{
+				Name:  "seed",
+				Value: 1,
+				Usage: "seed of the test signal",
+			},
+			&cli.StringSliceFlag{
+				Name:  "only",
+				Usage: "only run these degradations (repeatable)",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: formatText,
+				Usage: "output format (text, csv, json)",
+			},
+			&cli.BoolFlag{
+				Name:  "global",
+				Usage: "search all alignment offsets instead of ±15 seconds",
+			},
+			&cli.BoolFlag{
+				Name:  "tempo",
+				Usage: "also search time-scale factors (speed changes)",
+			},
+		},
+		Action: runRobustness,
+	}
+}
+
+func runRobustness(_ context.Context, cliCom *cli.Command) error {
+	format := cliCom.String("format")
+	if format != formatText && format != formatCSV && format != formatJSON {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidArgs, format)
+	}
+
+	if cliCom.Int("seconds") <= 0 {
+		return fmt.Errorf("%w: --seconds must be positive", ErrInvalidArgs)
+	}
+
+	degradations := robustness.Degradations()
+
+	if only := cliCom.StringSlice("only"); len(only) > 0 {
+		for _, name := range only {
+			if !slices.ContainsFunc(degradations, func(d robustness.Degradation) bool { return d.Name == name }) {
+				return fmt.Errorf("%w: unknown degradation %q", ErrInvalidArgs, name)
+			}
+		}
+
+		degradations = slices.DeleteFunc(degradations, func(d robustness.Degradation) bool {
+			return !slices.Contains(only, d.Name)
+		})
+	}
+
+	var matcher compare.Matcher = compare.Bounded{}
+	if cliCom.Bool("global") {
+		matcher = compare.Global{}
+	}
+
+	if cliCom.Bool("tempo") {
+		matcher = compare.Tempo{Base: matcher}
+	}
+
+	//nolint:gosec // the seed is a small positive number
+	signal := robustness.Generate(cliCom.Int("seconds"), uint64(cliCom.Int("seed")))
+
+	results, err := robustness.Run(signal, degradations, matcher)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+	}
+
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err = encoder.Encode(results); err != nil {
+			return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+		}
+	case formatCSV:
+		writer := csv.NewWriter(os.Stdout)
+		_ = writer.Write([]string{"degradation", "strength", "score", "offset", "ber"})
+
+		for _, result := range results {
+			_ = writer.Write([]string{
+				result.Degradation,
+				strconv.FormatFloat(result.Strength, 'g', -1, 64),
+				strconv.FormatFloat(result.Score, 'f', 3, 64),
+				strconv.Itoa(result.Offset),
+				strconv.FormatFloat(result.BitErrorRate, 'f', 3, 64),
+			})
+		}
+
+		writer.Flush()
+
+		if err = writer.Error(); err != nil {
+			return fmt.Errorf("%w: %w", ErrCompareFailure, err)
+		}
+	default:
+		for _, result := range results {
+			_, _ = fmt.Fprintf(os.Stdout, "%-6s %8g  score=%.3f offset=%d ber=%.3f\n",
+				result.Degradation, result.Strength, result.Score, result.Offset, result.BitErrorRate)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mycophonic/primordium/app/shutdown"
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+const (
+	defaultListen     = "localhost:8080"
+	readHeaderTimeout = 10 * time.Second
+
+	// shutdownDeadline is how long primordium runs the shutdown handlers after
+	// SIGINT or SIGTERM before exiting anyway.
+	shutdownDeadline = 10 * time.Second
+	// maxShutdownTimeout leaves a second of the deadline to close databases
+	// and sockets once requests are drained.
+	maxShutdownTimeout = shutdownDeadline - time.Second
+)
+
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Serve fingerprinting, comparison and search over HTTP",
+		Description: `Endpoints (JSON responses):
+
+  POST /v1/fingerprint[?length=N]  body: raw PCM or WAV (11025 Hz, mono, s16le)
+  POST /v1/compare                 {"fingerprint1", "fingerprint2", "global", "tempo"}
+  POST /v1/search                  {"fingerprint", "limit"}, requires --db
+  GET  /v2/lookup                  AcoustID lookup (also POST), requires --db
+  GET  /healthz
+  GET  /metrics                    Prometheus metrics
+
+/v1/fingerprint also takes ?speed=X, as "fingerprint --speed".
+
+Errors are {"error": {"code", "message"}}, with codes mirroring the exit
+errors: invalid_args, read_failure, chromaprint_failure, compare_failure,
+database_failure, plus too_large, not_found and method_not_allowed.
+
+/v2/lookup follows the AcoustID web service instead (fingerprint and duration
+parameters, {"status": "ok", "results": [{"id", "score"}]}), so AcoustID
+clients can be pointed at http://HOST/v2/lookup. Result IDs are database IDs.
+Tracks stored with a duration of --length or more (fingerprinted up to the
+limit) match queries of any longer duration: use the --length the database was
+built with.
+
+Requests are logged to stderr, one structured line each.
+
+On SIGINT or SIGTERM, the server stops accepting connections and waits up to
+--shutdown-timeout (at most 9s) for requests in flight.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Value: defaultListen,
+				Usage: "address to listen on",
+			},
+			&cli.StringFlag{
+				Name:  "db",
+				Usage: "database searched by /v1/search",
+			},
+			&cli.FloatFlag{
+				Name:    "threshold",
+				Aliases: []string{"t"},
+				Value:   defaultThreshold,
+				Usage:   "minimum similarity score for a match (0.0-1.0)",
+			},
+			&cli.BoolFlag{
+				Name:  "global",
+				Usage: "search the database at all alignment offsets instead of ±15 seconds",
+			},
+			&cli.BoolFlag{
+				Name:  "lsh",
+				Usage: "search the database with a locality-sensitive hashing prefilter (faster on large databases, may miss weak matches)",
+			},
+			&cli.IntFlag{
+				Name:    "length",
+				Aliases: []string{"l"},
+				Value:   fingerprint.DefaultLength,
+				Usage:   "default max audio length in seconds (0 = unlimited)",
+			},
+			&cli.Int64Flag{
+				Name:  "max-audio-bytes",
+				Value: server.DefaultMaxAudioBytes,
+				Usage: "size limit of audio bodies",
+			},
+			&cli.Int64Flag{
+				Name:  "max-json-bytes",
+				Value: server.DefaultMaxJSONBytes,
+				Usage: "size limit of JSON bodies",
+			},
+			&cli.DurationFlag{
+				Name:  "shutdown-timeout",
+				Value: maxShutdownTimeout,
+				Usage: "time given to requests in flight on shutdown",
+			},
+		},
+		Action: runServe,
+	}
+}
+
+func runServe(ctx context.Context, cliCom *cli.Command) error {
+	timeout, err := shutdownTimeout(cliCom)
+	if err != nil {
+		return err
+	}
+
+	ctx, done := untilShutdown(ctx)
+	defer done()
+
+	options := server.Options{
+		Threshold:     cliCom.Float("threshold"),
+		Length:        cliCom.Int("length"),
+		MaxAudioBytes: cliCom.Int64("max-audio-bytes"),
+		MaxJSONBytes:  cliCom.Int64("max-json-bytes"),
+	}
+
+	database, err := openServedDB(cliCom, options.Threshold)
+	if err != nil {
+		return err
+	}
+
+	if database != nil {
+		defer database.Close()
+
+		options.DB = database
+	}
+
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "tcp", cliCom.String("listen"))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrServeFailure, err)
+	}
+
+	return serveAndShutdown(ctx, listener, server.New(options), timeout)
+}
+
+// untilShutdown returns a context canceled on SIGINT or SIGTERM, and a
+// function to call once the command is done. primordium handles these signals
+// by running its shutdown handlers, then exiting the process: the handler
+// registered here cancels the context, and holds the exit until done is
+// called, so that servers drain and deferred cleanups run.
+func untilShutdown(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	finished := make(chan struct{})
+
+	shutdown.Register(func() {
+		cancel()
+		<-finished
+	})
+
+	return ctx, func() {
+		cancel()
+		close(finished)
+	}
+}
+
+// shutdownTimeout returns --shutdown-timeout, which must end before
+// primordium exits the process.
+func shutdownTimeout(cliCom *cli.Command) (time.Duration, error) {
+	timeout := cliCom.Duration("shutdown-timeout")
+	if timeout <= 0 || timeout > maxShutdownTimeout {
+		return 0, fmt.Errorf("%w: --shutdown-timeout must be positive and at most %s", ErrInvalidArgs, maxShutdownTimeout)
+	}
+
+	return timeout, nil
+}
+
+// openServedDB opens the --db database searched at --threshold, if any.
+func openServedDB(cliCom *cli.Command, threshold float64) (*db.DB, error) {
+	dir := cliCom.String("db")
+	if dir == "" {
+		return nil, nil //nolint:nilnil // No database is not an error.
+	}
+
+	database, err := db.Open(dir, db.Options{Index: searchOptions(cliCom, threshold)})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDatabaseFailure, err)
+	}
+
+	return database, nil
+}
+
+// serveAndShutdown serves handler on listener until ctx is done, then shuts
+// down gracefully.
+func serveAndShutdown(ctx context.Context, listener net.Listener, handler http.Handler, timeout time.Duration) error {
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	served := make(chan error, 1)
+
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+
+	_, _ = fmt.Fprintf(os.Stderr, "listening on %s\n", listener.Addr())
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("%w: %w", ErrServeFailure, err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("%w: %w", ErrServeFailure, err)
+	}
+
+	return nil
+}
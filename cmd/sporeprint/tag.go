@@ -0,0 +1,208 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/dedupe"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/library"
+	"github.com/mycophonic/sporeprint/tags"
+)
+
+// tagPolicyFlags are the flags of [tagPolicy].
+func tagPolicyFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "trust-tags",
+			Usage: "use the fingerprints found in file tags instead of decoding files, when valid",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-tags",
+			Usage: "as --trust-tags, checking them against the duration probed with ffprobe",
+		},
+	}
+}
+
+// tagPolicy returns the reuse policy of tagged fingerprints selected by
+// --trust-tags and --verify-tags.
+func tagPolicy(cliCom *cli.Command) (library.TagPolicy, error) {
+	switch trust, verify := cliCom.Bool("trust-tags"), cliCom.Bool("verify-tags"); {
+	case trust && verify:
+		return library.TagsIgnore, fmt.Errorf("%w: --trust-tags and --verify-tags are exclusive", ErrInvalidArgs)
+	case trust:
+		return library.TagsTrust, nil
+	case verify:
+		return library.TagsVerify, nil
+	default:
+		return library.TagsIgnore, nil
+	}
+}
+
+func tagCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "tag",
+		Usage:     "Write fingerprints into audio file tags",
+		ArgsUsage: "PATH...",
+		Description: `Fingerprints files (directories are walked recursively for audio files) and
+writes the fingerprint into their tags, where MusicBrainz Picard and media
+servers read it:
+
+  FLAC, Ogg Vorbis, Opus  ACOUSTID_FINGERPRINT comment
+  MP3                     ID3v2 TXXX "Acoustid Fingerprint" frame
+  MP4 (m4a)               ----:com.apple.iTunes:Acoustid Fingerprint atom
+
+With --duration, the track duration probed with ffprobe is written next to it
+(ACOUSTID_DURATION, "Acoustid Duration").
+
+Only the tags are rewritten, into a temporary file renamed over the original:
+audio data is copied unchanged, and hard links are broken.`,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "length",
+				Aliases: []string{"l"},
+				Value:   fingerprint.DefaultLength,
+				Usage:   "max audio length in seconds (0 = unlimited)",
+			},
+			&cli.BoolFlag{
+				Name:  "duration",
+				Usage: "also write the track duration",
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "files processed in parallel (0 = number of CPUs)",
+			},
+			&cli.StringFlag{
+				Name:  "ffmpeg",
+				Value: "ffmpeg",
+				Usage: "ffmpeg binary used to decode files",
+			},
+			&cli.StringFlag{
+				Name:  "ffprobe",
+				Value: "ffprobe",
+				Usage: "ffprobe binary used to probe durations",
+			},
+		},
+		Action: runTag,
+	}
+}
+
+func runTag(ctx context.Context, cliCom *cli.Command) error {
+	if cliCom.Args().Len() == 0 {
+		return fmt.Errorf("%w: expected at least one file or directory", ErrInvalidArgs)
+	}
+
+	paths, err := library.Walk(cliCom.Args().Slice())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrReadFailure, err)
+	}
+
+	jobs := cliCom.Int("jobs")
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	options := fingerprint.Options{Length: cliCom.Int("length"), FFmpeg: cliCom.String("ffmpeg")}
+	ffprobe := ""
+
+	if cliCom.Bool("duration") {
+		ffprobe = cliCom.String("ffprobe")
+	}
+
+	errs := make([]error, len(paths))
+	next := make(chan int)
+
+	var workers sync.WaitGroup
+
+	for range min(jobs, len(paths)) {
+		workers.Go(func() {
+			chroma := chromaprint.New()
+			defer chroma.Free()
+
+			for num := range next {
+				errs[num] = tagFile(ctx, chroma, paths[num], options, ffprobe)
+			}
+		})
+	}
+
+	for num := range paths {
+		if ctx.Err() != nil {
+			errs[num] = ctx.Err()
+
+			continue
+		}
+
+		next <- num
+	}
+
+	close(next)
+	workers.Wait()
+
+	failed := 0
+
+	for num, err := range errs {
+		if err != nil {
+			failed++
+
+			_, _ = fmt.Fprintf(os.Stderr, "warning: %s: %v\n", paths[num], err)
+		}
+	}
+
+	_, _ = fmt.Fprintf(os.Stdout, "tagged=%d failed=%d\n", len(paths)-failed, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d files failed", ErrTagFailure, failed, len(paths))
+	}
+
+	return nil
+}
+
+// tagFile fingerprints a file and writes the fingerprint into its tags, with
+// the duration probed by ffprobe unless empty.
+func tagFile(ctx context.Context, chroma *chromaprint.Context, path string, options fingerprint.Options, ffprobe string) error {
+	result, err := fingerprint.File(ctx, chroma, path, options)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrChromaprintFailure, err)
+	}
+
+	value := tags.Tags{Fingerprint: result.Fingerprint}
+
+	if ffprobe != "" {
+		quality, err := dedupe.Probe(ctx, ffprobe, path)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrReadFailure, err)
+		}
+
+		value.Duration = int(math.Round(quality.Duration))
+	}
+
+	if err = tags.Write(path, value); err != nil {
+		return fmt.Errorf("%w: %w", ErrTagFailure, err)
+	}
+
+	return nil
+}
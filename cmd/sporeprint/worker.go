@@ -0,0 +1,161 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/server"
+	"github.com/mycophonic/sporeprint/worker"
+)
+
+func workerCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "worker",
+		Usage: "Answer JSON requests read from stdin, one per line, until EOF",
+		Description: `Keeps running for a parent process, as exiftool -stay_open, reusing its
+Chromaprint contexts across requests. Each stdin line is a JSON request,
+answered by one JSON line on stdout carrying the same "id":
+
+  {"id": 1, "op": "fingerprint", "path": "track.flac"}
+  {"id": 2, "op": "fingerprint", "pcm": "<base64 PCM or WAV>", "length": 0}
+  {"id": 3, "op": "compare", "fingerprint1": "...", "fingerprint2": "...", "global": true, "tempo": false}
+  {"id": 4, "op": "search", "fingerprint": "...", "limit": 5}, requires --db
+  {"id": 5, "op": "ping"}
+
+Results are those of the "sporeprint serve" endpoints, and errors are
+{"id", "error": {"code", "message"}} with the same codes. Errors do not stop
+the worker. With --jobs above 1, responses may come out of order.
+
+On SIGINT or SIGTERM, the worker stops reading requests and answers those
+already read, giving them --shutdown-timeout to finish.`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "db",
+				Usage: "database searched by search requests",
+			},
+			&cli.FloatFlag{
+				Name:    "threshold",
+				Aliases: []string{"t"},
+				Value:   defaultThreshold,
+				Usage:   "minimum similarity score for a match (0.0-1.0)",
+			},
+			&cli.BoolFlag{
+				Name:  "global",
+				Usage: "search the database at all alignment offsets instead of ±15 seconds",
+			},
+			&cli.BoolFlag{
+				Name:  "lsh",
+				Usage: "search the database with a locality-sensitive hashing prefilter (faster on large databases, may miss weak matches)",
+			},
+			&cli.IntFlag{
+				Name:    "length",
+				Aliases: []string{"l"},
+				Value:   fingerprint.DefaultLength,
+				Usage:   "default max audio length in seconds (0 = unlimited)",
+			},
+			&cli.StringFlag{
+				Name:  "ffmpeg",
+				Value: "ffmpeg",
+				Usage: "ffmpeg binary used to decode files",
+			},
+			&cli.StringFlag{
+				Name:  "metrics",
+				Usage: "address serving Prometheus metrics on /metrics (disabled if empty)",
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Value:   1,
+				Usage:   "requests processed in parallel",
+			},
+			&cli.DurationFlag{
+				Name:  "shutdown-timeout",
+				Value: maxShutdownTimeout,
+				Usage: "time given to requests in flight on shutdown",
+			},
+		},
+		Action: runWorker,
+	}
+}
+
+func runWorker(ctx context.Context, cliCom *cli.Command) error {
+	if cliCom.Int("jobs") < 1 {
+		return fmt.Errorf("%w: --jobs must be at least 1", ErrInvalidArgs)
+	}
+
+	timeout, err := shutdownTimeout(cliCom)
+	if err != nil {
+		return err
+	}
+
+	ctx, done := untilShutdown(ctx)
+	defer done()
+
+	options := server.Options{Threshold: cliCom.Float("threshold")}
+
+	database, err := openServedDB(cliCom, options.Threshold)
+	if err != nil {
+		return err
+	}
+
+	if database != nil {
+		defer database.Close()
+
+		options.DB = database
+	}
+
+	api := server.New(options)
+
+	if addr := cliCom.String("metrics"); addr != "" {
+		var listener net.Listener
+
+		listener, err = (&net.ListenConfig{}).Listen(ctx, "tcp", addr)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrServeFailure, err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", api.Metrics())
+
+		metricsServer := &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+
+		go func() { _ = metricsServer.Serve(listener) }()
+
+		defer metricsServer.Close()
+	}
+
+	err = worker.Run(ctx, os.Stdin, os.Stdout, worker.Options{
+		Server:          api,
+		Length:          cliCom.Int("length"),
+		FFmpeg:          cliCom.String("ffmpeg"),
+		Jobs:            cliCom.Int("jobs"),
+		ShutdownTimeout: timeout,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrReadFailure, err)
+	}
+
+	return nil
+}
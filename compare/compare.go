@@ -28,6 +28,10 @@ const (
 	// At ~0.1238s per hash, 120 hashes ≈ 15 seconds of drift tolerance.
 	MaxAlignOffset = 120
 
+	// SecondsPerHash is the audio duration between two consecutive hashes:
+	// Chromaprint advances by 1365 samples per frame at 11025 Hz.
+	SecondsPerHash = 1365.0 / 11025.0
+
 	// MaxBitError is the maximum bit errors to consider two hashes as matching.
 	// With 32-bit hashes, 2 bits = 93.75% similarity threshold per hash.
 	MaxBitError = 2
@@ -40,8 +44,41 @@ const (
 
 	// bitsPerHash is the number of bits in each uint32 subfingerprint.
 	bitsPerHash = 32
+
+	// scaleUnchanged is the [Result] time scale of matchers that do not
+	// search time-scale factors.
+	scaleUnchanged = 1.0
 )
 
+// Result holds the outcome of comparing two fingerprints.
+type Result struct {
+	// Score is the similarity between 0.0 (completely different) and 1.0 (identical).
+	Score float64
+	// Offset is the best alignment offset in hashes. A positive offset means
+	// fp1 starts later than fp2, a negative one that it starts earlier.
+	Offset int
+	// Scale is how many times faster fp1 plays than fp2, as found by [Tempo].
+	// Matchers that do not search time-scale factors report 1.
+	Scale float64
+}
+
+// Matcher scores two raw subfingerprint arrays, as returned by
+// [chromaprint.Decode], against each other.
+type Matcher interface {
+	Match(raw1, raw2 []uint32) Result
+}
+
+// Bounded is the default [Matcher] used by [Compare] and [WithOffset]. It only
+// considers alignments within ±MaxAlignOffset hashes.
+type Bounded struct{}
+
+// Match implements [Matcher].
+func (Bounded) Match(raw1, raw2 []uint32) Result {
+	score, offset := compareRaw(raw1, raw2)
+
+	return Result{Score: score, Offset: offset, Scale: scaleUnchanged}
+}
+
 // Compare compares two encoded Chromaprint fingerprints and returns a
 // similarity score between 0.0 (completely different) and 1.0 (identical).
 //
@@ -75,6 +112,21 @@ func WithOffset(fp1, fp2 string) (score float64, offset int, err error) {
 	return score, offset, nil
 }
 
+// Match compares two encoded fingerprints using the given [Matcher]. A
+// [Tempo] matcher is validated first.
+func Match(fp1, fp2 string, matcher Matcher) (Result, error) {
+	if err := validate(matcher); err != nil {
+		return Result{Score: scoreNoMatch, Scale: scaleUnchanged}, err
+	}
+
+	raw1, raw2, err := decodePair(fp1, fp2)
+	if err != nil {
+		return Result{Score: scoreNoMatch, Scale: scaleUnchanged}, err
+	}
+
+	return matcher.Match(raw1, raw2), nil
+}
+
 // BitErrorRate computes the average bit error rate between two aligned
 // encoded fingerprints. Use this after aligning with [WithOffset].
 //
@@ -92,6 +144,23 @@ func BitErrorRate(fp1, fp2 string, offset int) (float64, error) {
 	return bitErrorRateRaw(raw1, raw2, offset), nil
 }
 
+// ResultBitErrorRate computes the bit error rate of two encoded fingerprints
+// at the alignment of a [Match] result. With a [Result.Scale] found by
+// [Tempo], fp1 is resampled first, so that the rate is measured on the
+// timeline the score and offset refer to.
+func ResultBitErrorRate(fp1, fp2 string, result Result) (float64, error) {
+	raw1, raw2, err := decodePair(fp1, fp2)
+	if err != nil {
+		return scoreMaxDissimilarity, err
+	}
+
+	if result.Scale > 0 {
+		raw1 = stretch(raw1, result.Scale)
+	}
+
+	return bitErrorRateRaw(raw1, raw2, result.Offset), nil
+}
+
 // IsSameTrack returns true if the encoded fingerprints likely represent the
 // same audio track. Threshold is the minimum similarity score (0.0-1.0).
 // Suggested: 0.5-0.7.
@@ -104,6 +173,16 @@ func IsSameTrack(fp1, fp2 string, threshold float64) (bool, error) {
 	return score >= threshold, nil
 }
 
+// validate rejects the invalid settings of a [Tempo] matcher, which Match
+// would otherwise replace by defaults.
+func validate(matcher Matcher) error {
+	if tempo, ok := matcher.(Tempo); ok {
+		return tempo.Validate()
+	}
+
+	return nil
+}
+
 // decodePair decodes two encoded fingerprints into raw uint32 arrays.
 func decodePair(fp1, fp2 string) (raw1, raw2 []uint32, err error) {
 	raw1, err = chromaprint.Decode(fp1)
@@ -17,6 +17,7 @@
 package compare_test
 
 import (
+	"math"
 	"testing"
 
 	"github.com/mycophonic/sporeprint/chromaprint"
@@ -94,6 +95,62 @@ func generateDistinctFingerprint(t *testing.T, multiplier, numSeconds int) strin
 	return fp
 }
 
+// hopSamples is the Chromaprint frame hop at 11025 Hz. Slicing melodies on
+// multiples of it keeps frames aligned, so the hashes of an excerpt are
+// identical to the corresponding hashes of the full signal.
+const hopSamples = 1365
+
+// melodySamples returns count samples, starting at absolute sample start, of
+// a deterministic melody defined by seed. The melody changes note every
+// quarter second, which gives every region of the signal its own hashes.
+// Because samples only depend on their absolute index, any slice is an exact
+// excerpt of the longer signal.
+func melodySamples(seed, start, count int) []int16 {
+	const noteSamples = 11025 / 4
+
+	samples := make([]int16, count)
+	for i := range samples {
+		n := start + i
+
+		// Cheap integer hash of (seed, note index) picks a semitone.
+		note := uint32(n/noteSamples)*2654435761 ^ uint32(seed)*40503
+		note ^= note >> 15
+		freq := 110 * math.Pow(2, float64(note%36)/12)
+
+		phase := 2 * math.Pi * freq * float64(n) / 11025
+		samples[i] = int16(8000*math.Sin(phase) + 4000*math.Sin(2*phase))
+	}
+
+	return samples
+}
+
+// fingerprintSamples fingerprints 11025 Hz mono PCM samples.
+func fingerprintSamples(t *testing.T, samples []int16) string {
+	t.Helper()
+
+	ctx := chromaprint.New()
+	defer ctx.Free()
+
+	if err := ctx.Start(11025, 1); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	if err := ctx.Feed(samples); err != nil {
+		t.Fatalf("Feed() failed: %v", err)
+	}
+
+	if err := ctx.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+
+	fp, err := ctx.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() failed: %v", err)
+	}
+
+	return fp
+}
+
 func TestCompareIdentical(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,273 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	// diffGapCost is the cost of leaving one hash unaligned. It sits between
+	// the bit error rate of matching audio (< 0.2) and of unrelated audio
+	// (~0.5): replacing two hashes by a gap on each side (0.4) is cheaper
+	// than aligning unrelated ones, and dearer than aligning matching ones.
+	diffGapCost = 0.2
+
+	// minEditHashes is the shortest insertion or deletion reported by [Diff],
+	// about one second. Shorter gaps are noise inside a kept region.
+	minEditHashes = 8
+
+	// maxDiffCells caps the size of the alignment matrix to 64 MiB (one byte
+	// per cell, see [Op]), which allows two fingerprints of about 17 minutes
+	// each.
+	maxDiffCells = 1 << 26
+)
+
+// ErrDiffTooLarge happens when fingerprints are too long to be diffed.
+var ErrDiffTooLarge = errors.New("compare: fingerprints too long to diff")
+
+// Op is the kind of an [Edit]. It is a byte, as it fills the alignment matrix.
+type Op uint8
+
+const (
+	// OpKeep marks a region present in both fingerprints.
+	OpKeep Op = iota
+	// OpDelete marks a region only present in fp1.
+	OpDelete
+	// OpInsert marks a region only present in fp2.
+	OpInsert
+)
+
+// String returns the lowercase name of the operation.
+func (o Op) String() string {
+	switch o {
+	case OpKeep:
+		return "keep"
+	case OpDelete:
+		return "delete"
+	case OpInsert:
+		return "insert"
+	default:
+		return fmt.Sprintf("Op(%d)", int(o))
+	}
+}
+
+// Edit is one step of the edit script turning fp1 into fp2. Times are in
+// seconds. The region of the side an edit does not touch is empty (start
+// equals end) and positioned where the edit happens. Regions shorter than
+// about a second are not reported, so consecutive edits may leave small holes.
+type Edit struct {
+	Op     Op
+	Start1 float64
+	End1   float64
+	Start2 float64
+	End2   float64
+}
+
+// Diff aligns two encoded fingerprints with dynamic programming and returns
+// the edit script turning fp1 into fp2: kept, deleted and inserted regions in
+// playback order. Unlike [WithOffset], the alignment may shift any number of
+// times, which describes radio edits, censored versions or extended mixes.
+//
+// The alignment minimizes the sum of per-hash bit error rates (the
+// [BitErrorRate] metric) over aligned pairs plus a fixed cost per unaligned
+// hash. Time and memory are proportional to len(fp1)×len(fp2).
+func Diff(fp1, fp2 string) ([]Edit, error) {
+	return ResultDiff(fp1, fp2, Result{Scale: scaleUnchanged})
+}
+
+// ResultDiff is like [Diff], at the time scale of a [Match] result. With a
+// [Result.Scale] found by [Tempo], fp1 is resampled first, and the times in
+// fp1 of the edits are converted back to its own timeline.
+func ResultDiff(fp1, fp2 string, result Result) ([]Edit, error) {
+	raw1, raw2, err := decodePair(fp1, fp2)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := scaleUnchanged
+	if result.Scale > 0 {
+		scale = result.Scale
+		raw1 = stretch(raw1, scale)
+	}
+
+	if len(raw1)*len(raw2) > maxDiffCells {
+		return nil, fmt.Errorf("%w: %d×%d hashes", ErrDiffTooLarge, len(raw1), len(raw2))
+	}
+
+	edits := diffRaw(raw1, raw2)
+	for i := range edits {
+		edits[i].Start1 /= scale
+		edits[i].End1 /= scale
+	}
+
+	return edits, nil
+}
+
+// diffRaw implements [Diff] on raw fingerprint arrays.
+func diffRaw(raw1, raw2 []uint32) []Edit {
+	return buildEdits(alignSteps(raw1, raw2))
+}
+
+// alignSteps computes the minimal cost alignment of raw1 and raw2 and returns
+// it as a sequence of operations, one per aligned pair or unaligned hash.
+func alignSteps(raw1, raw2 []uint32) []Op {
+	width := len(raw2) + 1
+	trace := make([]Op, (len(raw1)+1)*width)
+	prev := make([]float32, width)
+	cur := make([]float32, width)
+
+	for idx2 := 1; idx2 < width; idx2++ {
+		prev[idx2] = float32(idx2) * diffGapCost
+		trace[idx2] = OpInsert
+	}
+
+	for idx1 := 1; idx1 <= len(raw1); idx1++ {
+		cur[0] = float32(idx1) * diffGapCost
+		trace[idx1*width] = OpDelete
+
+		for idx2 := 1; idx2 < width; idx2++ {
+			bitError := bits.OnesCount32(raw1[idx1-1] ^ raw2[idx2-1])
+			best, op := prev[idx2-1]+float32(bitError)/bitsPerHash, OpKeep
+
+			if cost := prev[idx2] + diffGapCost; cost < best {
+				best, op = cost, OpDelete
+			}
+
+			if cost := cur[idx2-1] + diffGapCost; cost < best {
+				best, op = cost, OpInsert
+			}
+
+			cur[idx2] = best
+			trace[idx1*width+idx2] = op
+		}
+
+		prev, cur = cur, prev
+	}
+
+	var steps []Op
+
+	for idx1, idx2 := len(raw1), len(raw2); idx1 > 0 || idx2 > 0; {
+		op := trace[idx1*width+idx2]
+		steps = append(steps, op)
+
+		switch op {
+		case OpKeep:
+			idx1--
+			idx2--
+		case OpDelete:
+			idx1--
+		case OpInsert:
+			idx2--
+		}
+	}
+
+	for left, right := 0, len(steps)-1; left < right; left, right = left+1, right-1 {
+		steps[left], steps[right] = steps[right], steps[left]
+	}
+
+	return steps
+}
+
+// buildEdits groups alignment steps into an edit script.
+//
+// Kept runs of at least minEditHashes anchor the alignment. Everything
+// between two anchors (deletions, insertions and spurious short kept runs) is
+// a gap. Gaps where neither side reaches minEditHashes are absorbed into the
+// surrounding kept region. Otherwise the gap yields a deletion and an
+// insertion, each only reported if it reaches minEditHashes.
+func buildEdits(steps []Op) []Edit {
+	var edits []Edit
+
+	emit := func(op Op, start1, end1, start2, end2 int) {
+		if last := len(edits) - 1; op == OpKeep && last >= 0 && edits[last].Op == OpKeep {
+			edits[last].End1 = float64(end1) * SecondsPerHash
+			edits[last].End2 = float64(end2) * SecondsPerHash
+
+			return
+		}
+
+		edits = append(edits, Edit{
+			Op:     op,
+			Start1: float64(start1) * SecondsPerHash,
+			End1:   float64(end1) * SecondsPerHash,
+			Start2: float64(start2) * SecondsPerHash,
+			End2:   float64(end2) * SecondsPerHash,
+		})
+	}
+
+	// runLength returns the length of the run of identical steps at pos.
+	runLength := func(pos int) int {
+		end := pos
+		for end < len(steps) && steps[end] == steps[pos] {
+			end++
+		}
+
+		return end - pos
+	}
+
+	idx1, idx2 := 0, 0
+
+	for pos := 0; pos < len(steps); {
+		if length := runLength(pos); steps[pos] == OpKeep && length >= minEditHashes {
+			emit(OpKeep, idx1, idx1+length, idx2, idx2+length)
+			idx1 += length
+			idx2 += length
+			pos += length
+
+			continue
+		}
+
+		deleted, inserted := 0, 0
+
+		for pos < len(steps) {
+			length := runLength(pos)
+			if steps[pos] == OpKeep && length >= minEditHashes {
+				break
+			}
+
+			if steps[pos] != OpInsert {
+				deleted += length
+			}
+
+			if steps[pos] != OpDelete {
+				inserted += length
+			}
+
+			pos += length
+		}
+
+		if deleted < minEditHashes && inserted < minEditHashes {
+			emit(OpKeep, idx1, idx1+deleted, idx2, idx2+inserted)
+		}
+
+		if deleted >= minEditHashes {
+			emit(OpDelete, idx1, idx1+deleted, idx2, idx2)
+		}
+
+		if inserted >= minEditHashes {
+			emit(OpInsert, idx1+deleted, idx1+deleted, idx2, idx2+inserted)
+		}
+
+		idx1 += deleted
+		idx2 += inserted
+	}
+
+	return edits
+}
@@ -0,0 +1,173 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"unsafe"
+
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+func TestOpSize(t *testing.T) {
+	t.Parallel()
+
+	// The alignment matrix size cap assumes one byte per cell.
+	if size := unsafe.Sizeof(compare.OpKeep); size != 1 {
+		t.Errorf("Op is %d bytes, want 1", size)
+	}
+}
+
+func TestDiffIdentical(t *testing.T) {
+	t.Parallel()
+
+	fp := fingerprintSamples(t, melodySamples(11, 0, 11025*10))
+
+	edits, err := compare.Diff(fp, fp)
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+
+	if len(edits) != 1 || edits[0].Op != compare.OpKeep {
+		t.Fatalf("identical fingerprints should diff to a single keep, got %+v", edits)
+	}
+
+	if edits[0].Start1 != 0 || edits[0].Start2 != 0 || edits[0].End1 != edits[0].End2 {
+		t.Errorf("identical fingerprints should keep the same region on both sides, got %+v", edits[0])
+	}
+}
+
+// TestDiffRadioEdit removes a section from the middle of a track and expects
+// it to be reported as a deletion between two kept regions, and as an
+// insertion when the arguments are swapped.
+func TestDiffRadioEdit(t *testing.T) {
+	t.Parallel()
+
+	const (
+		introHops   = 100
+		sectionHops = 80
+		outroHops   = 100
+	)
+
+	intro := melodySamples(12, 0, introHops*hopSamples)
+	section := melodySamples(13, 0, sectionHops*hopSamples)
+	outro := melodySamples(14, 0, outroHops*hopSamples)
+
+	extended := fingerprintSamples(t, slices.Concat(intro, section, outro))
+	edit := fingerprintSamples(t, slices.Concat(intro, outro))
+
+	wantStart := introHops * compare.SecondsPerHash
+	wantEnd := (introHops + sectionHops) * compare.SecondsPerHash
+
+	cases := []struct {
+		name     string
+		fp1, fp2 string
+		op       compare.Op
+	}{
+		{"deletion", extended, edit, compare.OpDelete},
+		{"insertion", edit, extended, compare.OpInsert},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			edits, err := compare.Diff(tc.fp1, tc.fp2)
+			if err != nil {
+				t.Fatalf("Diff() failed: %v", err)
+			}
+
+			ops := make([]compare.Op, len(edits))
+			for i, e := range edits {
+				ops[i] = e.Op
+			}
+
+			if !slices.Equal(ops, []compare.Op{compare.OpKeep, tc.op, compare.OpKeep}) {
+				t.Fatalf("Diff() ops = %v, want [keep %s keep]: %+v", ops, tc.op, edits)
+			}
+
+			start, end := edits[1].Start1, edits[1].End1
+			if tc.op == compare.OpInsert {
+				start, end = edits[1].Start2, edits[1].End2
+			}
+
+			if math.Abs(start-wantStart) > 2 || math.Abs(end-wantEnd) > 2 {
+				t.Errorf("%s spans %.2f-%.2fs, want about %.2f-%.2fs", tc.op, start, end, wantStart, wantEnd)
+			}
+		})
+	}
+}
+
+// TestResultDiffTempo diffs a 5% faster version of a track at the scale found
+// by Tempo: the stretched hashes line up, and fp1 times stay within the 40
+// seconds of the faster fingerprint.
+func TestResultDiffTempo(t *testing.T) {
+	t.Parallel()
+
+	faster := fingerprintSamples(t, stretchedMelodySamples(4, 40, 1.05))
+	original := fingerprintSamples(t, stretchedMelodySamples(4, 40, 1))
+
+	result, err := compare.Match(faster, original, compare.Tempo{})
+	if err != nil {
+		t.Fatalf("Match() failed: %v", err)
+	}
+
+	unscaled, err := compare.Diff(faster, original)
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+
+	edits, err := compare.ResultDiff(faster, original, result)
+	if err != nil {
+		t.Fatalf("ResultDiff() failed: %v", err)
+	}
+
+	if len(edits) >= len(unscaled) {
+		t.Errorf("ResultDiff() at scale %f = %d edits, want fewer than the %d of Diff()", result.Scale, len(edits), len(unscaled))
+	}
+
+	for _, edit := range edits {
+		if edit.End1 > 40 {
+			t.Errorf("edit %+v ends beyond the 40s of fp1", edit)
+		}
+	}
+}
+
+func TestDiffInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := compare.Diff("invalid!!!", "also-invalid!!!")
+	if err == nil {
+		t.Error("Diff with invalid encoding should return error")
+	}
+}
+
+func TestOpString(t *testing.T) {
+	t.Parallel()
+
+	for op, want := range map[compare.Op]string{
+		compare.OpKeep:   "keep",
+		compare.OpDelete: "delete",
+		compare.OpInsert: "insert",
+	} {
+		if op.String() != want {
+			t.Errorf("%d.String() = %q, want %q", int(op), op.String(), want)
+		}
+	}
+}
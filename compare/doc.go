@@ -21,6 +21,9 @@
 // Decoding to raw uint32 arrays is handled internally via
 // [github.com/mycophonic/sporeprint/chromaprint.Decode].
 //
+// [Compare] and [WithOffset] use the [Bounded] matcher. Alternative alignment
+// strategies implement [Matcher] and are used through [Match].
+//
 // Based on the AcoustID PostgreSQL matching function.
 // Reference: https://oxygene.sk/2011/01/how-does-chromaprint-work/
 package compare
@@ -0,0 +1,137 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare
+
+import (
+	"math/bits"
+	"slices"
+)
+
+const (
+	// globalCandidates is the number of most voted offsets that get scored
+	// exactly by [Global].
+	globalCandidates = 16
+
+	// maxHashOccurrences caps how many positions of a single hash value are
+	// indexed. Hashes repeated more often than this (silence, sustained
+	// tones) carry no alignment information and would make voting quadratic.
+	maxHashOccurrences = 32
+)
+
+// Global is a [Matcher] whose alignment offsets are not bounded to the
+// ±MaxAlignOffset window used by [Compare]. Use it to find a track that starts
+// minutes into a live recording or a mix.
+//
+// Rather than scanning all offsets, fp2 is indexed by hash value and every
+// hash of fp1 votes for the offsets at which the same value occurs in fp2.
+// The most voted offsets are then scored with the same XOR/popcount rule as
+// [Compare], so both matchers agree whenever the best offset lies inside the
+// bounded window. Cost is linear in the fingerprint lengths.
+//
+// Only offsets nominated by exact hash collisions are considered, not every
+// possible one: heavily degraded excerpts, whose hashes are all a few bits
+// off, find no offset and score 0 where [Bounded] may still match them.
+type Global struct{}
+
+// Match implements [Matcher].
+func (Global) Match(raw1, raw2 []uint32) Result {
+	if len(raw1) == 0 || len(raw2) == 0 {
+		return Result{Score: scoreNoMatch, Scale: scaleUnchanged}
+	}
+
+	votes := offsetVotes(raw1, raw2)
+
+	best := Result{Score: scoreNoMatch, Scale: scaleUnchanged}
+	bestCount := 0
+
+	for _, offset := range topOffsets(votes, len(raw2), globalCandidates) {
+		count := matchCount(raw1, raw2, offset)
+		if count > bestCount || (count == bestCount && count > 0 && offset < best.Offset) {
+			bestCount = count
+			best.Offset = offset
+		}
+	}
+
+	best.Score = float64(bestCount) / float64(min(len(raw1), len(raw2)))
+
+	return best
+}
+
+// offsetVotes indexes raw2 by hash value and lets every hash of raw1 vote for
+// the offsets at which the same value occurs in raw2. The returned histogram
+// uses the compareRaw layout: index = offset + len(raw2).
+func offsetVotes(raw1, raw2 []uint32) []int {
+	positions := make(map[uint32][]int, len(raw2))
+
+	for idx2, hash := range raw2 {
+		if len(positions[hash]) < maxHashOccurrences {
+			positions[hash] = append(positions[hash], idx2)
+		}
+	}
+
+	votes := make([]int, len(raw1)+len(raw2)+1)
+
+	for idx1, hash := range raw1 {
+		for _, idx2 := range positions[hash] {
+			votes[idx1-idx2+len(raw2)]++
+		}
+	}
+
+	return votes
+}
+
+// topOffsets returns up to limit offsets with the most votes, highest first.
+// Offsets without any vote are never returned. Shift is the histogram index
+// of offset zero.
+func topOffsets(votes []int, shift, limit int) []int {
+	var candidates []int
+
+	for idx, count := range votes {
+		if count > 0 {
+			candidates = append(candidates, idx)
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a, b int) int {
+		return votes[b] - votes[a]
+	})
+
+	candidates = candidates[:min(limit, len(candidates))]
+	for i := range candidates {
+		candidates[i] -= shift
+	}
+
+	return candidates
+}
+
+// matchCount returns the number of hash pairs within MaxBitError of each
+// other when raw1 is aligned with raw2 at offset. This is the quantity
+// compareRaw accumulates per offset.
+func matchCount(raw1, raw2 []uint32, offset int) int {
+	start1 := max(0, offset)
+	end1 := min(len(raw1), len(raw2)+offset)
+
+	count := 0
+
+	for idx1 := start1; idx1 < end1; idx1++ {
+		if bits.OnesCount32(raw1[idx1]^raw2[idx1-offset]) <= MaxBitError {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,108 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare_test
+
+import (
+	"testing"
+
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+func TestGlobalIdentical(t *testing.T) {
+	t.Parallel()
+
+	fp := fingerprintSamples(t, melodySamples(1, 0, 11025*10))
+
+	result, err := compare.Match(fp, fp, compare.Global{})
+	if err != nil {
+		t.Fatalf("Match() failed: %v", err)
+	}
+
+	if result.Score != 1.0 {
+		t.Errorf("identical fingerprints should have score 1.0, got %f", result.Score)
+	}
+
+	if result.Offset != 0 {
+		t.Errorf("identical fingerprints should have offset 0, got %d", result.Offset)
+	}
+}
+
+// TestGlobalFindsDistantOffset verifies that an excerpt starting well past
+// MaxAlignOffset is found by Global while the bounded Compare misses it.
+func TestGlobalFindsDistantOffset(t *testing.T) {
+	t.Parallel()
+
+	const startHop = 300
+
+	full := fingerprintSamples(t, melodySamples(1, 0, 11025*60))
+	excerpt := fingerprintSamples(t, melodySamples(1, startHop*hopSamples, 11025*20))
+
+	bounded, err := compare.Compare(excerpt, full)
+	if err != nil {
+		t.Fatalf("Compare() failed: %v", err)
+	}
+
+	if bounded > 0.3 {
+		t.Errorf("bounded compare should miss an excerpt at offset %d, got score %f", startHop, bounded)
+	}
+
+	result, err := compare.Match(excerpt, full, compare.Global{})
+	if err != nil {
+		t.Fatalf("Match() failed: %v", err)
+	}
+
+	if result.Score < 0.7 {
+		t.Errorf("global compare should find the excerpt, got score %f", result.Score)
+	}
+
+	// The excerpt content occurs later in full, hence a negative offset.
+	if result.Offset != -startHop {
+		t.Errorf("global compare offset = %d, want %d", result.Offset, -startHop)
+	}
+}
+
+// TestGlobalAgreesWithBounded verifies that both matchers return the same
+// result when the best offset lies inside the bounded window.
+func TestGlobalAgreesWithBounded(t *testing.T) {
+	t.Parallel()
+
+	full := fingerprintSamples(t, melodySamples(2, 0, 11025*20))
+	excerpt := fingerprintSamples(t, melodySamples(2, 50*hopSamples, 11025*10))
+
+	bounded, err := compare.Match(excerpt, full, compare.Bounded{})
+	if err != nil {
+		t.Fatalf("Match(Bounded) failed: %v", err)
+	}
+
+	global, err := compare.Match(excerpt, full, compare.Global{})
+	if err != nil {
+		t.Fatalf("Match(Global) failed: %v", err)
+	}
+
+	if bounded != global {
+		t.Errorf("matchers disagree: bounded=%+v global=%+v", bounded, global)
+	}
+}
+
+func TestMatchInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := compare.Match("invalid!!!", "also-invalid!!!", compare.Global{})
+	if err == nil {
+		t.Error("Match with invalid encoding should return error")
+	}
+}
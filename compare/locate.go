@@ -0,0 +1,144 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+)
+
+const (
+	// locateCandidates is the number of most voted positions examined by
+	// [Locate]. Each occurrence of the query produces one strong peak.
+	locateCandidates = 64
+
+	// locateMinConfidence is the confidence below which a candidate position
+	// is discarded as noise.
+	locateMinConfidence = 0.1
+)
+
+// Occurrence is a place where a query fingerprint was found inside a longer
+// haystack fingerprint.
+type Occurrence struct {
+	// Start is the time in seconds, within the haystack, of the first
+	// matching hash.
+	Start float64
+	// End is the time in seconds, within the haystack, right after the last
+	// matching hash.
+	End float64
+	// Confidence is the fraction of query hashes matching the haystack at
+	// this position, between 0.0 and 1.0.
+	Confidence float64
+	// Position is the haystack hash index aligned with the first query hash.
+	// It is negative when the query begins before the haystack does.
+	Position int
+}
+
+// Locate finds every occurrence of an encoded query fingerprint (a track)
+// inside an encoded haystack fingerprint (a broadcast, a DJ set), sorted by
+// start time.
+//
+// Candidate positions are nominated by hash voting as in [Global], then each
+// is scored with the XOR/popcount rule of [Compare]. Unlike [Compare], the
+// score is normalized by the query length only, and matching hashes are
+// mapped back to haystack time using SecondsPerHash.
+func Locate(query, haystack string) ([]Occurrence, error) {
+	rawQuery, err := chromaprint.Decode(query)
+	if err != nil {
+		return nil, fmt.Errorf("decoding query: %w", err)
+	}
+
+	rawHaystack, err := chromaprint.Decode(haystack)
+	if err != nil {
+		return nil, fmt.Errorf("decoding haystack: %w", err)
+	}
+
+	return locateRaw(rawQuery, rawHaystack), nil
+}
+
+// locateRaw implements [Locate] on raw fingerprint arrays.
+func locateRaw(query, haystack []uint32) []Occurrence {
+	if len(query) == 0 || len(haystack) == 0 {
+		return nil
+	}
+
+	votes := offsetVotes(query, haystack)
+
+	var found []Occurrence
+
+	for _, offset := range topOffsets(votes, len(haystack), locateCandidates) {
+		occurrence, ok := occurrenceAt(query, haystack, -offset)
+		if !ok || occurrence.Confidence < locateMinConfidence {
+			continue
+		}
+
+		// Candidates come strongest first: drop weaker ones overlapping an
+		// accepted occurrence (repeated sections of the query itself).
+		if slices.ContainsFunc(found, occurrence.overlaps) {
+			continue
+		}
+
+		found = append(found, occurrence)
+	}
+
+	slices.SortFunc(found, func(a, b Occurrence) int {
+		return a.Position - b.Position
+	})
+
+	return found
+}
+
+// occurrenceAt scores the query aligned at the given haystack position. The
+// reported time span is trimmed to the first and last matching hashes.
+func occurrenceAt(query, haystack []uint32, position int) (Occurrence, bool) {
+	first, last, matched := -1, -1, 0
+
+	for idxQuery := max(0, -position); idxQuery < len(query) && position+idxQuery < len(haystack); idxQuery++ {
+		if bits.OnesCount32(query[idxQuery]^haystack[position+idxQuery]) > MaxBitError {
+			continue
+		}
+
+		if first < 0 {
+			first = position + idxQuery
+		}
+
+		last = position + idxQuery
+		matched++
+	}
+
+	if matched == 0 {
+		return Occurrence{}, false
+	}
+
+	return Occurrence{
+		Start:      float64(first) * SecondsPerHash,
+		End:        float64(last+1) * SecondsPerHash,
+		Confidence: float64(matched) / float64(len(query)),
+		Position:   position,
+	}, true
+}
+
+// overlaps reports whether two occurrences share more than half of the
+// shorter one's time span.
+func (o Occurrence) overlaps(other Occurrence) bool {
+	shared := min(o.End, other.End) - max(o.Start, other.Start)
+
+	return shared > min(o.End-o.Start, other.End-other.Start)/2
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+// TestLocateRepeatedTrack plays the same track twice inside a longer mix and
+// verifies that both occurrences are reported at the right times.
+func TestLocateRepeatedTrack(t *testing.T) {
+	t.Parallel()
+
+	const (
+		introHops = 200
+		trackHops = 240
+		breakHops = 150
+	)
+
+	track := melodySamples(7, 0, trackHops*hopSamples)
+
+	mix := slices.Concat(
+		melodySamples(5, 0, introHops*hopSamples),
+		track,
+		melodySamples(6, 0, breakHops*hopSamples),
+		track,
+		melodySamples(8, 0, introHops*hopSamples),
+	)
+
+	occurrences, err := compare.Locate(fingerprintSamples(t, track), fingerprintSamples(t, mix))
+	if err != nil {
+		t.Fatalf("Locate() failed: %v", err)
+	}
+
+	wantPositions := []int{introHops, introHops + trackHops + breakHops}
+	if len(occurrences) != len(wantPositions) {
+		t.Fatalf("Locate() found %d occurrences, want %d: %+v", len(occurrences), len(wantPositions), occurrences)
+	}
+
+	for i, occurrence := range occurrences {
+		if occurrence.Position != wantPositions[i] {
+			t.Errorf("occurrence %d position = %d, want %d", i, occurrence.Position, wantPositions[i])
+		}
+
+		wantStart := float64(wantPositions[i]) * compare.SecondsPerHash
+		if math.Abs(occurrence.Start-wantStart) > 2 {
+			t.Errorf("occurrence %d start = %.2fs, want about %.2fs", i, occurrence.Start, wantStart)
+		}
+
+		if occurrence.End <= occurrence.Start {
+			t.Errorf("occurrence %d ends (%.2fs) before it starts (%.2fs)", i, occurrence.End, occurrence.Start)
+		}
+
+		if occurrence.Confidence < 0.7 {
+			t.Errorf("occurrence %d confidence = %f, want at least 0.7", i, occurrence.Confidence)
+		}
+	}
+}
+
+func TestLocateAbsentTrack(t *testing.T) {
+	t.Parallel()
+
+	query := fingerprintSamples(t, melodySamples(9, 0, 11025*20))
+	haystack := fingerprintSamples(t, melodySamples(10, 0, 11025*60))
+
+	occurrences, err := compare.Locate(query, haystack)
+	if err != nil {
+		t.Fatalf("Locate() failed: %v", err)
+	}
+
+	for _, occurrence := range occurrences {
+		if occurrence.Confidence > 0.3 {
+			t.Errorf("absent track should not be located with confidence, got %+v", occurrence)
+		}
+	}
+}
+
+func TestLocateInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := compare.Locate("invalid!!!", "also-invalid!!!")
+	if err == nil {
+		t.Error("Locate with invalid encoding should return error")
+	}
+}
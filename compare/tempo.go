@@ -0,0 +1,147 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// DefaultTempoMin is the slowest time-scale factor searched by [Tempo].
+	DefaultTempoMin = 0.85
+
+	// DefaultTempoMax is the fastest time-scale factor searched by [Tempo].
+	// Covers PAL speed-up (25/24 ≈ 1.042) and most nightcore edits.
+	DefaultTempoMax = 1.15
+
+	// DefaultTempoStep is the distance between two searched time-scale
+	// factors. At 0.5%, a 120 seconds fingerprint drifts by less than
+	// 0.3 seconds (about 2 hashes) between two steps.
+	DefaultTempoStep = 0.005
+)
+
+// ErrTempo happens when the factors of a [Tempo] are negative, or Min
+// exceeds Max.
+var ErrTempo = errors.New("compare: invalid tempo range")
+
+// Tempo is a [Matcher] tolerant to time-scale changes. It resamples the hash
+// sequence of fp1 for every factor between Min and Max, scores each version
+// against fp2 with Base, and reports the best one along with its factor in
+// [Result.Scale].
+//
+// Resampling hashes corrects timing only. Speed changes that also shift pitch
+// (wrong-speed vinyl rips, PAL speed-up, nightcore) alter the hashes
+// themselves: for those, fingerprint the audio with its speed corrected at the
+// PCM level (see the fingerprint command --speed flag) and use Tempo to refine
+// the residual factor.
+type Tempo struct {
+	// Min is the slowest factor searched. Zero means DefaultTempoMin.
+	Min float64
+	// Max is the fastest factor searched. Zero means DefaultTempoMax.
+	Max float64
+	// Step is the distance between two factors. Zero means DefaultTempoStep.
+	Step float64
+	// Base scores each resampled version. Nil means [Bounded].
+	Base Matcher
+}
+
+// Validate checks the factors of t, which [Match] does before comparing:
+// [Tempo.Match] cannot report errors, and searches the default range instead
+// of invalid ones.
+func (t Tempo) Validate() error {
+	lowest, highest, step := t.factors()
+
+	if !(lowest > 0 && highest > 0 && step > 0) {
+		return fmt.Errorf("%w: factors must be positive, got min %g, max %g, step %g", ErrTempo, t.Min, t.Max, t.Step)
+	}
+
+	if lowest > highest {
+		return fmt.Errorf("%w: min %g exceeds max %g", ErrTempo, lowest, highest)
+	}
+
+	return nil
+}
+
+// Match implements [Matcher].
+func (t Tempo) Match(raw1, raw2 []uint32) Result {
+	if t.Validate() != nil {
+		t = Tempo{Base: t.Base}
+	}
+
+	lowest, highest, step := t.factors()
+
+	base := t.Base
+	if base == nil {
+		base = Bounded{}
+	}
+
+	best := Result{Score: scoreNoMatch, Scale: scaleUnchanged}
+
+	// Factors are generated as 1 + k×step so that 1 itself is always tried
+	// exactly, without accumulated floating point error.
+	kBegin := int(math.Ceil((lowest - scaleUnchanged) / step))
+	kEnd := int(math.Floor((highest - scaleUnchanged) / step))
+
+	for k := kBegin; k <= kEnd; k++ {
+		scale := scaleUnchanged + float64(k)*step
+
+		result := base.Match(stretch(raw1, scale), raw2)
+		result.Scale = scale
+
+		if result.Score > best.Score ||
+			(result.Score == best.Score && math.Abs(scale-scaleUnchanged) < math.Abs(best.Scale-scaleUnchanged)) {
+			best = result
+		}
+	}
+
+	return best
+}
+
+// factors returns Min, Max and Step, with defaults for zero values.
+func (t Tempo) factors() (lowest, highest, step float64) {
+	lowest, highest, step = t.Min, t.Max, t.Step
+	if lowest == 0 {
+		lowest = DefaultTempoMin
+	}
+
+	if highest == 0 {
+		highest = DefaultTempoMax
+	}
+
+	if step == 0 {
+		step = DefaultTempoStep
+	}
+
+	return lowest, highest, step
+}
+
+// stretch resamples a hash sequence played scale times faster than the
+// reference back to the reference timeline, using nearest neighbour lookup.
+func stretch(raw []uint32, scale float64) []uint32 {
+	if scale == scaleUnchanged || len(raw) == 0 {
+		return raw
+	}
+
+	stretched := make([]uint32, int(math.Round(float64(len(raw))*scale)))
+	for idx := range stretched {
+		stretched[idx] = raw[min(len(raw)-1, int(float64(idx)/scale))]
+	}
+
+	return stretched
+}
@@ -0,0 +1,152 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare_test
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+// stretchedMelodySamples returns numSeconds of a melody of half-second notes,
+// with its tempo multiplied by tempo. Pitches are unchanged, as with a
+// time-stretching effect.
+func stretchedMelodySamples(seed, numSeconds int, tempo float64) []int16 {
+	const noteSamples = 11025 / 2
+
+	samples := make([]int16, 11025*numSeconds)
+	for i := range samples {
+		note := uint32(float64(i)*tempo/noteSamples)*2654435761 ^ uint32(seed)*40503
+		note ^= note >> 15
+		freq := 110 * math.Pow(2, float64(note%36)/12)
+
+		phase := 2 * math.Pi * freq * float64(i) / 11025
+		samples[i] = int16(8000*math.Sin(phase) + 4000*math.Sin(2*phase))
+	}
+
+	return samples
+}
+
+func TestTempoIdentical(t *testing.T) {
+	t.Parallel()
+
+	fp := fingerprintSamples(t, melodySamples(3, 0, 11025*10))
+
+	result, err := compare.Match(fp, fp, compare.Tempo{})
+	if err != nil {
+		t.Fatalf("Match() failed: %v", err)
+	}
+
+	if result.Score != 1.0 || result.Scale != 1.0 {
+		t.Errorf("identical fingerprints should score 1.0 at scale 1.0, got %+v", result)
+	}
+}
+
+// TestTempoFindsFactor verifies that a 5% faster version of a track scores
+// better with Tempo than with the bounded matcher, at the right factor.
+func TestTempoFindsFactor(t *testing.T) {
+	t.Parallel()
+
+	original := fingerprintSamples(t, stretchedMelodySamples(4, 40, 1))
+	faster := fingerprintSamples(t, stretchedMelodySamples(4, 40, 1.05))
+
+	bounded, err := compare.Match(faster, original, compare.Bounded{})
+	if err != nil {
+		t.Fatalf("Match(Bounded) failed: %v", err)
+	}
+
+	result, err := compare.Match(faster, original, compare.Tempo{})
+	if err != nil {
+		t.Fatalf("Match(Tempo) failed: %v", err)
+	}
+
+	if result.Score <= bounded.Score {
+		t.Errorf("tempo score %f should beat bounded score %f", result.Score, bounded.Score)
+	}
+
+	if math.Abs(result.Scale-1.05) > 0.011 {
+		t.Errorf("tempo scale = %f, want about 1.05", result.Scale)
+	}
+}
+
+func TestTempoBitErrorRate(t *testing.T) {
+	t.Parallel()
+
+	original := fingerprintSamples(t, stretchedMelodySamples(4, 40, 1))
+	faster := fingerprintSamples(t, stretchedMelodySamples(4, 40, 1.05))
+
+	result, err := compare.Match(faster, original, compare.Tempo{})
+	if err != nil {
+		t.Fatalf("Match(Tempo) failed: %v", err)
+	}
+
+	unscaled, err := compare.BitErrorRate(faster, original, result.Offset)
+	if err != nil {
+		t.Fatalf("BitErrorRate() failed: %v", err)
+	}
+
+	scaled, err := compare.ResultBitErrorRate(faster, original, result)
+	if err != nil {
+		t.Fatalf("ResultBitErrorRate() failed: %v", err)
+	}
+
+	// Measured on the resampled timeline, the offset aligns the hashes.
+	if scaled >= unscaled {
+		t.Errorf("bit error rate at scale %f = %f, want below %f (unscaled)", result.Scale, scaled, unscaled)
+	}
+}
+
+func TestTempoRange(t *testing.T) {
+	t.Parallel()
+
+	fp := fingerprintSamples(t, melodySamples(3, 0, 11025*10))
+
+	// A range excluding 1 must never report 1.
+	result, err := compare.Match(fp, fp, compare.Tempo{Min: 1.1, Max: 1.2, Step: 0.05})
+	if err != nil {
+		t.Fatalf("Match() failed: %v", err)
+	}
+
+	if result.Scale < 1.1-1e-9 || result.Scale > 1.2+1e-9 {
+		t.Errorf("scale %f outside of searched range", result.Scale)
+	}
+}
+
+func TestTempoInvalid(t *testing.T) {
+	t.Parallel()
+
+	fp := fingerprintSamples(t, melodySamples(3, 0, 11025*10))
+
+	for _, tempo := range []compare.Tempo{
+		{Min: -1},
+		{Max: -1.1},
+		{Step: -0.01},
+		{Min: 1.2, Max: 1.1},
+	} {
+		if _, err := compare.Match(fp, fp, tempo); !errors.Is(err, compare.ErrTempo) {
+			t.Errorf("Match(%+v) error = %v, want ErrTempo", tempo, err)
+		}
+	}
+
+	// Reversed ranges are told apart from non-positive factors.
+	if err := (compare.Tempo{Min: 1.2, Max: 1.1}).Validate(); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Validate(reversed range) = %v, want min exceeding max", err)
+	}
+}
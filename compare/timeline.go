@@ -0,0 +1,116 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare
+
+// DefaultTimelineWindow is the default timeline window size in hashes,
+// about 5 seconds.
+const DefaultTimelineWindow = 40
+
+// TimelineOptions controls [Timeline].
+type TimelineOptions struct {
+	// Matcher finds the alignment offset. Nil means [Bounded].
+	Matcher Matcher
+	// Window is the window size in hashes. Zero means DefaultTimelineWindow.
+	Window int
+	// Step is the distance between two windows in hashes. Zero means half a
+	// window.
+	Step int
+}
+
+// Window is the similarity of one region of two aligned fingerprints.
+type Window struct {
+	// Start is the start time of the window in fp1, in seconds.
+	Start float64
+	// End is the end time of the window in fp1, in seconds.
+	End float64
+	// Score is the fraction of hashes within MaxBitError, as [Compare].
+	Score float64
+	// BitErrorRate is the average bit error rate, as [BitErrorRate].
+	BitErrorRate float64
+}
+
+// Timeline aligns two encoded fingerprints at their best offset, then slides
+// a window over the overlapping region and scores each position. It tells a
+// uniformly mediocre match apart from one that is perfect in places and
+// absent elsewhere. The returned [Result] is the alignment used. A [Tempo]
+// matcher is validated first, as by [Match].
+func Timeline(fp1, fp2 string, options TimelineOptions) (Result, []Window, error) {
+	matcher := options.Matcher
+	if matcher == nil {
+		matcher = Bounded{}
+	}
+
+	if err := validate(matcher); err != nil {
+		return Result{Score: scoreNoMatch, Scale: scaleUnchanged}, nil, err
+	}
+
+	raw1, raw2, err := decodePair(fp1, fp2)
+	if err != nil {
+		return Result{Score: scoreNoMatch, Scale: scaleUnchanged}, nil, err
+	}
+
+	result := matcher.Match(raw1, raw2)
+
+	// A time-scaled match is only aligned on the resampled sequence.
+	scale := scaleUnchanged
+	if result.Scale > 0 && result.Scale != scaleUnchanged {
+		scale = result.Scale
+		raw1 = stretch(raw1, scale)
+	}
+
+	return result, timelineRaw(raw1, raw2, result.Offset, scale, options.Window, options.Step), nil
+}
+
+// timelineRaw scores windows of raw1 against raw2 aligned at offset. raw1 is
+// resampled by scale, as by [stretch]: window times are converted back to the
+// timeline of the original fingerprint.
+func timelineRaw(raw1, raw2 []uint32, offset int, scale float64, window, step int) []Window {
+	if window <= 0 {
+		window = DefaultTimelineWindow
+	}
+
+	if step <= 0 {
+		step = max(1, window/2) //nolint:mnd
+	}
+
+	// Overlapping region, in raw1 indices.
+	start1 := max(0, offset)
+	end1 := min(len(raw1), len(raw2)+offset)
+
+	if end1 <= start1 {
+		return nil
+	}
+
+	// Always produce at least one window, even if the overlap is short.
+	window = min(window, end1-start1)
+
+	var windows []Window
+
+	for begin := start1; begin+window <= end1; begin += step {
+		part1 := raw1[begin : begin+window]
+		part2 := raw2[begin-offset : begin-offset+window]
+
+		windows = append(windows, Window{
+			Start:        float64(begin) * SecondsPerHash / scale,
+			End:          float64(begin+window) * SecondsPerHash / scale,
+			Score:        float64(matchCount(part1, part2, 0)) / float64(window),
+			BitErrorRate: bitErrorRateRaw(part1, part2, 0),
+		})
+	}
+
+	return windows
+}
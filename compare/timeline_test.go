@@ -0,0 +1,115 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package compare_test
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+func TestTimelineIdentical(t *testing.T) {
+	t.Parallel()
+
+	fp := fingerprintSamples(t, melodySamples(15, 0, 11025*20))
+
+	result, windows, err := compare.Timeline(fp, fp, compare.TimelineOptions{})
+	if err != nil {
+		t.Fatalf("Timeline() failed: %v", err)
+	}
+
+	if result.Score != 1.0 || result.Offset != 0 {
+		t.Errorf("identical fingerprints should align at offset 0 with score 1.0, got %+v", result)
+	}
+
+	if len(windows) == 0 {
+		t.Fatal("Timeline() returned no windows")
+	}
+
+	for _, window := range windows {
+		if window.Score != 1.0 || window.BitErrorRate != 0 {
+			t.Errorf("identical fingerprints should score every window perfectly, got %+v", window)
+		}
+	}
+}
+
+// TestTimelineHalfMatch shares the first half of two tracks only, and expects
+// the timeline to show a perfect first half and a poor second half.
+func TestTimelineHalfMatch(t *testing.T) {
+	t.Parallel()
+
+	const halfHops = 120
+
+	shared := melodySamples(16, 0, halfHops*hopSamples)
+	fp1 := fingerprintSamples(t, slices.Concat(shared, melodySamples(17, 0, halfHops*hopSamples)))
+	fp2 := fingerprintSamples(t, slices.Concat(shared, melodySamples(18, 0, halfHops*hopSamples)))
+
+	_, windows, err := compare.Timeline(fp1, fp2, compare.TimelineOptions{Window: 20, Step: 20})
+	if err != nil {
+		t.Fatalf("Timeline() failed: %v", err)
+	}
+
+	half := halfHops * compare.SecondsPerHash
+
+	for _, window := range windows {
+		switch {
+		case window.End < half-1 && window.Score < 0.8:
+			t.Errorf("shared window %.2f-%.2fs should score high, got %f", window.Start, window.End, window.Score)
+		case window.Start > half+1 && window.Score > 0.3:
+			t.Errorf("distinct window %.2f-%.2fs should score low, got %f", window.Start, window.End, window.Score)
+		}
+	}
+}
+
+func TestTimelineInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := compare.Timeline("invalid!!!", "also-invalid!!!", compare.TimelineOptions{})
+	if err == nil {
+		t.Error("Timeline with invalid encoding should return error")
+	}
+}
+
+// TestTimelineTempo checks that windows of a time-scaled match are timed in
+// the faster fingerprint: its overlap with the 40 seconds of the original ends
+// 40/Scale seconds in.
+func TestTimelineTempo(t *testing.T) {
+	t.Parallel()
+
+	faster := fingerprintSamples(t, stretchedMelodySamples(4, 40, 1.05))
+	original := fingerprintSamples(t, stretchedMelodySamples(4, 40, 1))
+
+	result, windows, err := compare.Timeline(faster, original, compare.TimelineOptions{Matcher: compare.Tempo{}})
+	if err != nil {
+		t.Fatalf("Timeline() failed: %v", err)
+	}
+
+	if result.Scale <= 1 || len(windows) == 0 {
+		t.Fatalf("Timeline() = %+v, %d windows, want a faster match", result, len(windows))
+	}
+
+	if last, want := windows[len(windows)-1], 40/result.Scale; math.Abs(last.End-want) > 1 {
+		t.Errorf("last window ends at %.2fs, want about %.2fs", last.End, want)
+	}
+
+	if _, _, err = compare.Timeline(faster, original, compare.TimelineOptions{Matcher: compare.Tempo{Min: 1.2, Max: 1.1}}); !errors.Is(err, compare.ErrTempo) {
+		t.Errorf("Timeline(invalid tempo) = %v, want ErrTempo", err)
+	}
+}
@@ -0,0 +1,747 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mycophonic/sporeprint/index"
+)
+
+var (
+	// ErrNotFound happens when opening a directory that holds no database
+	// without [Options.Create].
+	ErrNotFound = errors.New("db: database not found")
+	// ErrCorrupt happens when database files are malformed.
+	ErrCorrupt = errors.New("db: database corrupt")
+	// ErrVersion happens when the database was written by an incompatible
+	// version.
+	ErrVersion = errors.New("db: unsupported format version")
+	// ErrLocked happens when another process is writing to the database, on
+	// platforms without advisory locks.
+	ErrLocked = errors.New("db: database locked")
+	// ErrSelfMerge happens when merging a database into itself.
+	ErrSelfMerge = errors.New("db: cannot merge a database into itself")
+	// ErrClosed happens when using a closed database.
+	ErrClosed = errors.New("db: database closed")
+)
+
+// DefaultMaxSegments is the default segment count above which adds merge
+// the smallest segments. Every segment costs one lookup per query hash.
+const DefaultMaxSegments = 16
+
+// maxOpenAttempts bounds the manifests read by [Open] while other processes
+// write.
+const maxOpenAttempts = 10
+
+// Options controls how a database is opened.
+type Options struct {
+	// Create creates an empty database if the directory holds none.
+	Create bool
+	// MaxSegments is the number of segments above which adds merge the
+	// smallest ones. Zero means DefaultMaxSegments, negative means never.
+	MaxSegments int
+	// Index configures searches. Its mask also applies to the segments
+	// written by this process.
+	Index index.Options
+}
+
+// Conflict tells [DB.Merge] what to do with track IDs present in both
+// databases.
+type Conflict int
+
+const (
+	// ConflictFail aborts the merge with index.ErrDuplicateID.
+	ConflictFail Conflict = iota
+	// ConflictSkip keeps the existing track.
+	ConflictSkip
+	// ConflictReplace keeps the merged track.
+	ConflictReplace
+)
+
+// MergeStats counts the tracks merged by [DB.Merge].
+type MergeStats struct {
+	// Added is the number of tracks that were not stored yet.
+	Added int
+	// Skipped is the number of conflicting tracks left out.
+	Skipped int
+	// Replaced is the number of conflicting tracks that replaced stored ones.
+	Replaced int
+}
+
+// Stats describes the content of a database.
+type Stats struct {
+	// Tracks is the number of stored tracks.
+	Tracks int
+	// Segments is the number of segment files.
+	Segments int
+	// Deleted is the number of tombstoned tracks, whose space is reclaimed
+	// by compaction. They are not counted in Tracks.
+	Deleted int
+	// Hashes is the total number of stored hashes, including deleted tracks.
+	Hashes int
+	// Bytes is the total size of the segment files.
+	Bytes int64
+}
+
+// segmentFile is a mapped segment, unmapped when the last state using it is
+// released.
+type segmentFile struct {
+	name    string
+	data    []byte
+	segment *index.Segment
+	refs    atomic.Int64
+}
+
+// state is an immutable view of the database. It is reference counted: the
+// database holds a reference on its current state, and every read holds one
+// while it runs. States hold a reference on each of their files.
+type state struct {
+	manifest manifest
+	files    []*segmentFile
+	index    *index.Index
+	refs     atomic.Int64
+}
+
+// DB is an open database. It is safe for concurrent use: reads run on the
+// state current when they started and never wait for writers, which are
+// serialized. Several processes may also open the same database: writes are
+// serialized by a lock file, and each write first catches up with the writes
+// of other processes.
+type DB struct {
+	dir     string
+	options Options
+	// mu serializes the writers of this process.
+	mu      sync.Mutex
+	current atomic.Pointer[state]
+}
+
+// Open opens the database in dir.
+func Open(dir string, options Options) (*DB, error) {
+	if options.Index.Mask == 0 {
+		options.Index.Mask = index.FullMask
+	}
+
+	if options.MaxSegments == 0 {
+		options.MaxSegments = DefaultMaxSegments
+	}
+
+	database := &DB{dir: dir, options: options}
+
+	for attempt := 1; ; attempt++ {
+		man, err := readManifest(dir)
+		if errors.Is(err, ErrNotFound) && options.Create {
+			man, err = create(dir)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Reading happens without the lock: the write of another process may
+		// have removed segments listed by the manifest since, once a newer
+		// manifest replaced it.
+		st, err := database.load(nil, man)
+		if errors.Is(err, fs.ErrNotExist) && attempt < maxOpenAttempts {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		database.current.Store(st)
+
+		return database, nil
+	}
+}
+
+// Dir returns the directory of the database, as given to [Open].
+func (db *DB) Dir() string {
+	return db.dir
+}
+
+// Close releases the database. Segments are unmapped once running reads
+// complete. Matches returned by Search remain valid.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if st := db.current.Swap(nil); st != nil {
+		return st.release()
+	}
+
+	return nil
+}
+
+// Add stores tracks in a new segment. Their IDs must not be stored already.
+// If this leaves more than MaxSegments segments, the smallest are merged.
+func (db *DB) Add(tracks []index.Track) error {
+	for _, track := range tracks {
+		if len(track.Raw) == 0 {
+			return fmt.Errorf("%w: %q", index.ErrEmpty, track.ID)
+		}
+	}
+
+	err := db.write(func(st *state, man *manifest) (bool, error) {
+		if len(tracks) == 0 {
+			return false, nil
+		}
+
+		for _, track := range tracks {
+			if st.index.Contains(track.ID) {
+				return false, fmt.Errorf("%w: %q", index.ErrDuplicateID, track.ID)
+			}
+		}
+
+		name, err := db.writeSegment(man, tracks)
+		if err != nil {
+			return false, err
+		}
+
+		man.Segments = append(man.Segments, name)
+
+		return true, nil
+	})
+	if err != nil || db.options.MaxSegments < 0 {
+		return err
+	}
+
+	return db.compactSmallest()
+}
+
+// Remove deletes tracks by ID and returns how many were stored. Deleted
+// tracks are only tombstoned: their space is reclaimed by [DB.Compact].
+func (db *DB) Remove(ids ...string) (int, error) {
+	removed := 0
+
+	err := db.write(func(st *state, man *manifest) (bool, error) {
+		for _, id := range ids {
+			if name, found := st.locate(man, id); found {
+				man.bury(name, id)
+				removed++
+			}
+		}
+
+		st.dropDeleted(man)
+
+		return removed > 0, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// Compact merges all segments into one, dropping deleted tracks. It loads
+// every live track in memory.
+func (db *DB) Compact() error {
+	return db.write(func(st *state, man *manifest) (bool, error) {
+		if len(st.files) == 1 && len(man.Tombstones[st.files[0].name]) == 0 {
+			return false, nil
+		}
+
+		return len(st.files) > 0, db.mergeSegments(man, st.files)
+	})
+}
+
+// Merge copies the live tracks of another database into this one, typically
+// a shard built on another machine. Segment files are copied as they are, so
+// the source may use a different mask. Track IDs present in both databases
+// are handled according to conflict: segments whose tracks are all replaced
+// are dropped.
+func (db *DB) Merge(source *DB, conflict Conflict) (MergeStats, error) {
+	var stats MergeStats
+
+	if sameDir(db.dir, source.dir) {
+		return stats, ErrSelfMerge
+	}
+
+	src, err := source.acquire()
+	if err != nil {
+		return stats, err
+	}
+
+	defer func() { _ = src.release() }()
+
+	err = db.write(func(st *state, man *manifest) (bool, error) {
+		stats = MergeStats{}
+
+		for _, file := range src.files {
+			// Tombstones of the source segment carry over to its copy.
+			buried := slices.Clone(src.manifest.Tombstones[file.name])
+			dead := src.manifest.buried(file.name)
+			live := 0
+
+			for num := range file.segment.Len() {
+				id := file.segment.ID(num)
+				if _, found := dead[id]; found {
+					continue
+				}
+
+				existing, found := st.locate(man, id)
+
+				switch {
+				case !found:
+					stats.Added++
+					live++
+				case conflict == ConflictSkip:
+					buried = append(buried, id)
+					stats.Skipped++
+				case conflict == ConflictReplace:
+					man.bury(existing, id)
+					stats.Replaced++
+					live++
+				default:
+					return false, fmt.Errorf("%w: %q", index.ErrDuplicateID, id)
+				}
+			}
+
+			if live == 0 {
+				continue
+			}
+
+			name := man.newSegmentName()
+			if err := writeFileAtomic(db.dir, name, file.data); err != nil {
+				return false, err
+			}
+
+			man.Segments = append(man.Segments, name)
+
+			for _, id := range buried {
+				man.bury(name, id)
+			}
+		}
+
+		st.dropDeleted(man)
+
+		return stats != MergeStats{}, nil
+	})
+	if err != nil {
+		return MergeStats{}, err
+	}
+
+	return stats, nil
+}
+
+// Search returns the stored tracks matching a raw query fingerprint, as
+// [index.Index.Search]. A closed database matches nothing.
+func (db *DB) Search(query []uint32) []index.Match {
+	st, err := db.acquire()
+	if err != nil {
+		return nil
+	}
+
+	defer func() { _ = st.release() }()
+
+	return st.index.Search(query)
+}
+
+// Get returns a stored track by ID.
+func (db *DB) Get(id string) (index.Track, bool) {
+	st, err := db.acquire()
+	if err != nil {
+		return index.Track{}, false
+	}
+
+	defer func() { _ = st.release() }()
+
+	for _, file := range st.files {
+		if num, found := file.segment.Find(id); found && st.manifest.live(file.name, id) {
+			return file.segment.Track(num), true
+		}
+	}
+
+	return index.Track{}, false
+}
+
+// Tracks returns every stored track. It reads the whole database.
+func (db *DB) Tracks() []index.Track {
+	st, err := db.acquire()
+	if err != nil {
+		return nil
+	}
+
+	defer func() { _ = st.release() }()
+
+	var tracks []index.Track
+
+	for _, file := range st.files {
+		dead := st.manifest.buried(file.name)
+
+		for num := range file.segment.Len() {
+			if _, found := dead[file.segment.ID(num)]; !found {
+				tracks = append(tracks, file.segment.Track(num))
+			}
+		}
+	}
+
+	return tracks
+}
+
+// Stats describes the database content.
+func (db *DB) Stats() Stats {
+	st, err := db.acquire()
+	if err != nil {
+		return Stats{}
+	}
+
+	defer func() { _ = st.release() }()
+
+	stats := Stats{Segments: len(st.files)}
+
+	for _, file := range st.files {
+		deleted := len(st.manifest.Tombstones[file.name])
+		stats.Tracks += file.segment.Len() - deleted
+		stats.Deleted += deleted
+		stats.Hashes += file.segment.Hashes()
+		stats.Bytes += int64(len(file.data))
+	}
+
+	return stats
+}
+
+// Verify checks the checksum of every segment. It reads the whole database.
+func (db *DB) Verify() error {
+	st, err := db.acquire()
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = st.release() }()
+
+	for _, file := range st.files {
+		if err = file.segment.Verify(); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrCorrupt, file.name, err)
+		}
+	}
+
+	return nil
+}
+
+// acquire returns the current state with a reference held.
+func (db *DB) acquire() (*state, error) {
+	for {
+		st := db.current.Load()
+		if st == nil {
+			return nil, ErrClosed
+		}
+
+		// A state whose count dropped to zero was replaced (or closed) in
+		// the meantime: load again.
+		if refs := st.refs.Load(); refs > 0 && st.refs.CompareAndSwap(refs, refs+1) {
+			return st, nil
+		}
+	}
+}
+
+// write runs update on a copy of the latest manifest with the locks held. If
+// update reports a change, the copy becomes the new manifest and a new state
+// is published.
+func (db *DB) write(update func(st *state, man *manifest) (bool, error)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Writers hold mu, so the current state cannot change under them.
+	st := db.current.Load()
+	if st == nil {
+		return ErrClosed
+	}
+
+	lock, err := lockDir(db.dir)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		removeGarbage(db.dir, db.current.Load().manifest)
+
+		_ = lock.unlock()
+	}()
+
+	// Catch up with the writes of other processes.
+	latest, err := readManifest(db.dir)
+	if err != nil {
+		return err
+	}
+
+	if latest.Generation != st.manifest.Generation {
+		if st, err = db.publish(st, latest); err != nil {
+			return err
+		}
+	}
+
+	next := st.manifest.clone()
+
+	changed, err := update(st, &next)
+	if err != nil || !changed {
+		return err
+	}
+
+	for name := range next.Tombstones {
+		if !slices.Contains(next.Segments, name) {
+			delete(next.Tombstones, name)
+		}
+	}
+
+	next.Generation++
+
+	if err = writeManifest(db.dir, next); err != nil {
+		return err
+	}
+
+	_, err = db.publish(st, next)
+
+	return err
+}
+
+// publish loads man and makes it the current state, replacing st.
+func (db *DB) publish(st *state, man manifest) (*state, error) {
+	next, err := db.load(st, man)
+	if err != nil {
+		return nil, err
+	}
+
+	db.current.Store(next)
+
+	return next, st.release()
+}
+
+// writeSegment writes tracks to a new segment file and returns its name.
+func (db *DB) writeSegment(man *manifest, tracks []index.Track) (string, error) {
+	data, err := index.EncodeSegment(tracks, db.options.Index.Mask)
+	if err != nil {
+		return "", fmt.Errorf("encoding segment: %w", err)
+	}
+
+	name := man.newSegmentName()
+
+	return name, writeFileAtomic(db.dir, name, data)
+}
+
+// mergeSegments replaces files by a single segment holding their live tracks.
+func (db *DB) mergeSegments(man *manifest, files []*segmentFile) error {
+	var tracks []index.Track
+
+	merged := make(map[string]bool, len(files))
+
+	for _, file := range files {
+		merged[file.name] = true
+		dead := man.buried(file.name)
+
+		for num := range file.segment.Len() {
+			if _, found := dead[file.segment.ID(num)]; !found {
+				tracks = append(tracks, file.segment.Track(num))
+			}
+		}
+	}
+
+	segments := make([]string, 0, len(man.Segments))
+
+	for _, name := range man.Segments {
+		if !merged[name] {
+			segments = append(segments, name)
+		}
+	}
+
+	if len(tracks) > 0 {
+		name, err := db.writeSegment(man, tracks)
+		if err != nil {
+			return err
+		}
+
+		segments = append(segments, name)
+	}
+
+	man.Segments = segments
+
+	return nil
+}
+
+// compactSmallest merges the smallest segments until at most MaxSegments
+// remain.
+func (db *DB) compactSmallest() error {
+	return db.write(func(st *state, man *manifest) (bool, error) {
+		excess := len(st.files) - db.options.MaxSegments
+		if excess <= 0 {
+			return false, nil
+		}
+
+		files := slices.Clone(st.files)
+		slices.SortStableFunc(files, func(a, b *segmentFile) int {
+			return cmp.Compare(a.segment.Len(), b.segment.Len())
+		})
+
+		// Merging n segments into one removes n-1.
+		return true, db.mergeSegments(man, files[:excess+1])
+	})
+}
+
+// load builds the state of man, sharing the files already mapped by prev
+// (which may be nil). The returned state holds one reference.
+func (db *DB) load(prev *state, man manifest) (*state, error) {
+	mapped := make(map[string]*segmentFile)
+
+	if prev != nil {
+		for _, file := range prev.files {
+			mapped[file.name] = file
+		}
+	}
+
+	next := &state{
+		manifest: man,
+		files:    make([]*segmentFile, 0, len(man.Segments)),
+		index:    index.New(db.options.Index),
+	}
+
+	for _, name := range man.Segments {
+		file, found := mapped[name]
+		if !found {
+			var err error
+			if file, err = openSegmentFile(db.dir, name); err != nil {
+				// Drop the references taken so far.
+				next.refs.Store(1)
+				_ = next.release()
+
+				return nil, err
+			}
+		}
+
+		file.refs.Add(1)
+		next.files = append(next.files, file)
+		next.index.AddSegment(file.segment, man.Tombstones[name]...)
+	}
+
+	next.refs.Store(1)
+
+	return next, nil
+}
+
+// release drops a reference, and the references on the files of the state
+// when it was the last one.
+func (st *state) release() error {
+	if st.refs.Add(-1) > 0 {
+		return nil
+	}
+
+	var errs []error
+
+	for _, file := range st.files {
+		if file.refs.Add(-1) == 0 {
+			errs = append(errs, unmapFile(file.data))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// file returns a segment of the state by name.
+func (st *state) file(name string) *segmentFile {
+	for _, file := range st.files {
+		if file.name == name {
+			return file
+		}
+	}
+
+	panic("db: segment not mapped: " + name)
+}
+
+// dropDeleted drops from man the segments of the state whose tracks are all
+// deleted, right away rather than at compaction.
+func (st *state) dropDeleted(man *manifest) {
+	man.Segments = slices.DeleteFunc(man.Segments, func(name string) bool {
+		for _, file := range st.files {
+			if file.name == name {
+				return len(man.Tombstones[name]) == file.segment.Len()
+			}
+		}
+
+		// Written by this update.
+		return false
+	})
+}
+
+// locate returns the segment holding a live track, according to man.
+func (st *state) locate(man *manifest, id string) (string, bool) {
+	for _, file := range st.files {
+		if _, found := file.segment.Find(id); found && man.live(file.name, id) {
+			return file.name, true
+		}
+	}
+
+	return "", false
+}
+
+// create initializes an empty database in dir.
+func create(dir string) (manifest, error) {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return manifest{}, fmt.Errorf("creating database: %w", err)
+	}
+
+	lock, err := lockDir(dir)
+	if err != nil {
+		return manifest{}, err
+	}
+
+	defer func() { _ = lock.unlock() }()
+
+	// Another process may have created it in the meantime.
+	man, err := readManifest(dir)
+	if !errors.Is(err, ErrNotFound) {
+		return man, err
+	}
+
+	man = manifest{Version: FormatVersion, NextSegment: 1}
+
+	return man, writeManifest(dir, man)
+}
+
+func openSegmentFile(dir, name string) (*segmentFile, error) {
+	data, err := mapFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	segment, err := index.OpenSegment(data)
+	if err != nil {
+		_ = unmapFile(data)
+
+		return nil, fmt.Errorf("%w: %s: %w", ErrCorrupt, name, err)
+	}
+
+	return &segmentFile{name: name, data: data, segment: segment}, nil
+}
+
+func sameDir(dir1, dir2 string) bool {
+	info1, err1 := os.Stat(dir1)
+	info2, err2 := os.Stat(dir2)
+
+	return err1 == nil && err2 == nil && os.SameFile(info1, info2)
+}
+
+func lockPath(dir string) string {
+	return filepath.Join(dir, lockName)
+}
@@ -0,0 +1,475 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/index"
+)
+
+func syntheticTracks(first, count int) []index.Track {
+	tracks := make([]index.Track, count)
+
+	for i := range tracks {
+		rng := rand.New(rand.NewPCG(uint64(first+i), 0))
+
+		raw := make([]uint32, 400)
+		for pos := range raw {
+			raw[pos] = rng.Uint32()
+		}
+
+		tracks[i] = index.Track{ID: fmt.Sprintf("track-%d", first+i), Duration: 50, Raw: raw}
+	}
+
+	return tracks
+}
+
+func openDB(t *testing.T, dir string) *db.DB {
+	t.Helper()
+
+	database, err := db.Open(dir, db.Options{Create: true})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	t.Cleanup(func() { _ = database.Close() })
+
+	return database
+}
+
+func TestPersistence(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tracks := syntheticTracks(0, 30)
+
+	database := openDB(t, dir)
+	if err := database.Add(tracks[:20]); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if err := database.Add(tracks[20:]); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	reopened, err := db.Open(dir, db.Options{})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	defer reopened.Close()
+
+	stats := reopened.Stats()
+	if stats.Tracks != 30 || stats.Segments != 2 || stats.Hashes != 30*400 {
+		t.Errorf("Stats() = %+v, want 30 tracks in 2 segments", stats)
+	}
+
+	if err = reopened.Verify(); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+
+	matches := reopened.Search(tracks[25].Raw[100:300])
+	if len(matches) == 0 || matches[0].ID != "track-25" {
+		t.Errorf("Search() = %+v, want track-25", matches)
+	}
+
+	if all := reopened.Tracks(); len(all) != 30 {
+		t.Errorf("Tracks() returned %d tracks, want 30", len(all))
+	}
+
+	track, found := reopened.Get("track-3")
+	if !found || track.Duration != 50 || len(track.Raw) != 400 {
+		t.Errorf("Get(track-3) = %v, %+v", found, track)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	tracks := syntheticTracks(0, 10)
+	database := openDB(t, dir)
+
+	if err := database.Add(tracks[:5]); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if err := database.Add(tracks[5:]); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	removed, err := database.Remove("track-2", "track-missing")
+	if err != nil || removed != 1 {
+		t.Fatalf("Remove() = %d, %v; want 1", removed, err)
+	}
+
+	if matches := database.Search(tracks[2].Raw); len(matches) != 0 {
+		t.Errorf("removed track still found: %+v", matches)
+	}
+
+	if matches := database.Search(tracks[3].Raw); len(matches) == 0 || matches[0].ID != "track-3" {
+		t.Errorf("Search() = %+v, want track-3", matches)
+	}
+
+	if _, found := database.Get("track-2"); found {
+		t.Error("Get() found a removed track")
+	}
+
+	// Removing a whole segment drops it.
+	if removed, err = database.Remove("track-5", "track-6", "track-7", "track-8", "track-9"); err != nil || removed != 5 {
+		t.Fatalf("Remove() = %d, %v; want 5", removed, err)
+	}
+
+	if stats := database.Stats(); stats.Tracks != 4 || stats.Deleted != 1 || stats.Segments != 1 {
+		t.Errorf("Stats() = %+v, want 4 tracks and 1 deleted in 1 segment", stats)
+	}
+
+	if err = database.Compact(); err != nil {
+		t.Fatalf("Compact() failed: %v", err)
+	}
+
+	if stats := database.Stats(); stats.Tracks != 4 || stats.Deleted != 0 || stats.Hashes != 4*400 {
+		t.Errorf("Stats() after Compact() = %+v, want 4 tracks and no deleted", stats)
+	}
+
+	if matches := database.Search(tracks[3].Raw); len(matches) == 0 || matches[0].ID != "track-3" {
+		t.Errorf("Search() after Compact() = %+v, want track-3", matches)
+	}
+
+	// Replaced segment files are deleted.
+	files, _ := filepath.Glob(filepath.Join(dir, "seg-*"))
+	if len(files) != 1 {
+		t.Errorf("segment files = %v, want 1", files)
+	}
+
+	// A removed ID can be added again.
+	if err = database.Add(tracks[2:3]); err != nil {
+		t.Errorf("Add(removed ID) failed: %v", err)
+	}
+}
+
+func TestAutoCompaction(t *testing.T) {
+	t.Parallel()
+
+	database, err := db.Open(t.TempDir(), db.Options{Create: true, MaxSegments: 3})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	defer database.Close()
+
+	for i := range 10 {
+		if err = database.Add(syntheticTracks(i, 1)); err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+	}
+
+	if stats := database.Stats(); stats.Tracks != 10 || stats.Segments > 3 {
+		t.Errorf("Stats() = %+v, want 10 tracks in at most 3 segments", stats)
+	}
+
+	for i := range 10 {
+		if _, found := database.Get(fmt.Sprintf("track-%d", i)); !found {
+			t.Errorf("track-%d lost by compaction", i)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	shard1, shard2 := openDB(t, t.TempDir()), openDB(t, t.TempDir())
+	if err := shard1.Add(syntheticTracks(0, 5)); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	// Overlaps shard1 on track-3 and track-4, and has a deleted track.
+	if err := shard2.Add(syntheticTracks(3, 5)); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if _, err := shard2.Remove("track-7"); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		conflict db.Conflict
+		want     db.MergeStats
+	}{
+		{db.ConflictSkip, db.MergeStats{Added: 2, Skipped: 2}},
+		{db.ConflictReplace, db.MergeStats{Added: 2, Replaced: 2}},
+	} {
+		central := openDB(t, t.TempDir())
+
+		if _, err := central.Merge(shard1, db.ConflictFail); err != nil {
+			t.Fatalf("Merge() failed: %v", err)
+		}
+
+		if _, err := central.Merge(shard2, db.ConflictFail); !errors.Is(err, index.ErrDuplicateID) {
+			t.Errorf("Merge(ConflictFail) = %v, want ErrDuplicateID", err)
+		}
+
+		stats, err := central.Merge(shard2, test.conflict)
+		if err != nil || stats != test.want {
+			t.Errorf("Merge(%d) = %+v, %v; want %+v", test.conflict, stats, err, test.want)
+		}
+
+		if got := central.Stats().Tracks; got != 7 {
+			t.Errorf("Merge(%d) left %d tracks, want 7", test.conflict, got)
+		}
+
+		if _, found := central.Get("track-7"); found {
+			t.Errorf("Merge(%d) resurrected a deleted track", test.conflict)
+		}
+
+		if matches := central.Search(syntheticTracks(4, 1)[0].Raw); len(matches) != 1 || matches[0].ID != "track-4" {
+			t.Errorf("Merge(%d): Search() = %+v, want a single track-4", test.conflict, matches)
+		}
+	}
+
+	if _, err := shard1.Merge(shard1, db.ConflictSkip); !errors.Is(err, db.ErrSelfMerge) {
+		t.Errorf("Merge(self) = %v, want ErrSelfMerge", err)
+	}
+}
+
+// TestMergeReplaceAll replaces every track of a segment, which is dropped
+// without waiting for compaction.
+func TestMergeReplaceAll(t *testing.T) {
+	t.Parallel()
+
+	central, shard := openDB(t, t.TempDir()), openDB(t, t.TempDir())
+
+	for _, database := range []*db.DB{central, shard} {
+		if err := database.Add(syntheticTracks(0, 3)); err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+	}
+
+	stats, err := central.Merge(shard, db.ConflictReplace)
+	if err != nil || stats != (db.MergeStats{Replaced: 3}) {
+		t.Fatalf("Merge() = %+v, %v; want 3 replaced", stats, err)
+	}
+
+	if got := central.Stats(); got.Tracks != 3 || got.Segments != 1 || got.Deleted != 0 {
+		t.Errorf("Stats() = %+v, want 3 tracks in a single segment", got)
+	}
+}
+
+func TestConcurrentProcesses(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	first := openDB(t, dir)
+	second := openDB(t, dir)
+
+	if err := first.Add(syntheticTracks(0, 3)); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	// second catches up before writing, so nothing is lost.
+	if err := second.Add(syntheticTracks(3, 3)); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if err := second.Add(syntheticTracks(1, 1)); !errors.Is(err, index.ErrDuplicateID) {
+		t.Errorf("Add(duplicate) = %v, want ErrDuplicateID", err)
+	}
+
+	if stats := second.Stats(); stats.Tracks != 6 {
+		t.Errorf("Stats() = %+v, want 6 tracks", stats)
+	}
+}
+
+// TestOpenWhileWriting opens a database while another handle writes to it,
+// removing segments as it compacts.
+func TestOpenWhileWriting(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	writer, err := db.Open(dir, db.Options{Create: true, MaxSegments: 1})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	t.Cleanup(func() { _ = writer.Close() })
+
+	done := make(chan error, 1)
+
+	go func() {
+		for num := range 100 {
+			if err := writer.Add(syntheticTracks(num, 1)); err != nil {
+				done <- err
+
+				return
+			}
+		}
+
+		done <- nil
+	}()
+
+	for {
+		select {
+		case err = <-done:
+			if err != nil {
+				t.Fatalf("Add() failed: %v", err)
+			}
+
+			return
+		default:
+		}
+
+		reader, err := db.Open(dir, db.Options{})
+		if err != nil {
+			t.Fatalf("Open() while writing failed: %v", err)
+		}
+
+		_ = reader.Close()
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := db.Open(filepath.Join(t.TempDir(), "missing"), db.Options{}); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Open(missing) = %v, want ErrNotFound", err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "MANIFEST"), []byte(`{"version": 99}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Open(dir, db.Options{}); !errors.Is(err, db.ErrVersion) {
+		t.Errorf("Open(future version) = %v, want ErrVersion", err)
+	}
+}
+
+func TestInterruptedWrite(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	database := openDB(t, dir)
+
+	// Leftovers of a crash between writing a segment and the manifest.
+	orphan := filepath.Join(dir, "seg-000042.spx")
+	if err := os.WriteFile(orphan, []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.Add(syntheticTracks(0, 1)); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if _, err := os.Stat(orphan); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("orphan segment not removed: %v", err)
+	}
+
+	if stats := database.Stats(); stats.Tracks != 1 {
+		t.Errorf("Stats() = %+v, want 1 track", stats)
+	}
+}
+
+func TestConcurrentAddSearch(t *testing.T) {
+	t.Parallel()
+
+	database := openDB(t, t.TempDir())
+	initial := syntheticTracks(0, 10)
+
+	if err := database.Add(initial); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	var (
+		writing sync.WaitGroup
+		reading sync.WaitGroup
+		done    atomic.Bool
+	)
+
+	// Adds, removals and compactions replace segments, and unmap the old
+	// ones once no search uses them.
+	writing.Go(func() {
+		for i := range 40 {
+			if err := database.Add(syntheticTracks(100+i, 1)); err != nil {
+				t.Errorf("Add() failed: %v", err)
+			}
+
+			if i%10 == 9 {
+				if _, err := database.Remove(fmt.Sprintf("track-%d", 100+i)); err != nil {
+					t.Errorf("Remove() failed: %v", err)
+				}
+
+				if err := database.Compact(); err != nil {
+					t.Errorf("Compact() failed: %v", err)
+				}
+			}
+		}
+	})
+
+	for reader := range 8 {
+		reading.Go(func() {
+			for !done.Load() {
+				want := initial[reader]
+				if matches := database.Search(want.Raw); len(matches) == 0 || matches[0].ID != want.ID {
+					t.Errorf("Search() = %+v, want %s", matches, want.ID)
+				}
+
+				if _, found := database.Get(want.ID); !found {
+					t.Errorf("Get(%s) found nothing", want.ID)
+				}
+			}
+		})
+	}
+
+	writing.Wait()
+	done.Store(true)
+	reading.Wait()
+
+	if stats := database.Stats(); stats.Tracks != 46 {
+		t.Errorf("Stats() = %+v, want 46 tracks", stats)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	t.Parallel()
+
+	database := openDB(t, t.TempDir())
+	if err := database.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	if err := database.Add(syntheticTracks(0, 1)); !errors.Is(err, db.ErrClosed) {
+		t.Errorf("Add() after Close() = %v, want ErrClosed", err)
+	}
+
+	if matches := database.Search([]uint32{1, 2, 3}); matches != nil {
+		t.Errorf("Search() after Close() = %+v, want nothing", matches)
+	}
+}
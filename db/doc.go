@@ -0,0 +1,35 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package db provides a persistent fingerprint database.
+//
+// A database is a directory of immutable segment files, in the
+// [github.com/mycophonic/sporeprint/index.Segment] binary format, listed by a
+// MANIFEST file. Segments are memory-mapped where the platform allows it, so
+// opening a database costs the same whatever its size.
+//
+// Every write creates new files and then atomically replaces the manifest
+// (write to a temporary file, fsync, rename): a crash at any point leaves
+// either the previous or the next state, never a mix. Files the manifest does
+// not reference are leftovers of interrupted writes and are removed by the
+// next write. Writers from several processes are serialized by a lock file.
+//
+// Removing a track only records a tombstone in the manifest. Compaction
+// rewrites segments without their deleted tracks, and adds merge the smallest
+// segments when there are too many. Databases built separately, such as
+// ingestion shards, are combined with [DB.Merge], which copies segment files
+// without decoding them.
+package db
@@ -0,0 +1,79 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// mapFile reads a whole file: this platform has no memory-mapping support in
+// the standard library.
+func mapFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading segment: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty segment %s", ErrCorrupt, path)
+	}
+
+	return data, nil
+}
+
+func unmapFile([]byte) error {
+	return nil
+}
+
+// fileLock is an exclusive lock file. Unlike advisory locks, it survives the
+// process: after a crash, it must be removed by hand.
+type fileLock struct {
+	path string
+}
+
+func lockDir(dir string) (*fileLock, error) {
+	file, err := os.OpenFile(lockPath(dir), os.O_CREATE|os.O_EXCL|os.O_WRONLY, filePerm)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%w: remove %s if no other process is writing", ErrLocked, lockPath(dir))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("locking: %w", err)
+	}
+
+	_ = file.Close()
+
+	return &fileLock{path: lockPath(dir)}, nil
+}
+
+func (l *fileLock) unlock() error {
+	if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("unlocking: %w", err)
+	}
+
+	return nil
+}
+
+// syncDir is a no-op: directories cannot be synced on this platform.
+func syncDir(string) error {
+	return nil
+}
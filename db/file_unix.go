@@ -0,0 +1,104 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapFile memory-maps a file read-only.
+func mapFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening segment: %w", err)
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("opening segment: %w", err)
+	}
+
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("%w: empty segment %s", ErrCorrupt, path)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mapping segment: %w", err)
+	}
+
+	return data, nil
+}
+
+func unmapFile(data []byte) error {
+	if err := syscall.Munmap(data); err != nil {
+		return fmt.Errorf("unmapping segment: %w", err)
+	}
+
+	return nil
+}
+
+// fileLock is an exclusive advisory lock, released by the system if the
+// process dies.
+type fileLock struct {
+	file *os.File
+}
+
+func lockDir(dir string) (*fileLock, error) {
+	file, err := os.OpenFile(lockPath(dir), os.O_CREATE|os.O_RDWR, filePerm)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock: %w", err)
+	}
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		_ = file.Close()
+
+		return nil, fmt.Errorf("locking: %w", err)
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) unlock() error {
+	// Closing the descriptor releases the lock.
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("unlocking: %w", err)
+	}
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("syncing directory: %w", err)
+	}
+
+	defer file.Close()
+
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("syncing directory: %w", err)
+	}
+
+	return nil
+}
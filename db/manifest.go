@@ -0,0 +1,195 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// FormatVersion is the manifest format version written by this package.
+	// Version 2 added tombstones; version 1 manifests are still read.
+	FormatVersion = 2
+
+	minFormatVersion = 1
+
+	manifestName  = "MANIFEST"
+	lockName      = "LOCK"
+	segmentPrefix = "seg-"
+	segmentSuffix = ".spx"
+	tempSuffix    = ".tmp"
+	filePerm      = 0o644
+	dirPerm       = 0o755
+)
+
+// manifest lists the segments making up the database.
+type manifest struct {
+	Version int `json:"version"`
+	// Generation increases with every write, so readers can tell whether
+	// their view is current.
+	Generation uint64 `json:"generation"`
+	// NextSegment numbers the next segment file. Numbers are never reused.
+	NextSegment int      `json:"next_segment"`
+	Segments    []string `json:"segments"`
+	// Tombstones lists the deleted track IDs of each segment, until the
+	// segment is compacted.
+	Tombstones map[string][]string `json:"tombstones,omitempty"`
+}
+
+// live reports whether a segment track has no tombstone. Loops over the
+// tracks of a segment use [manifest.buried] instead.
+func (man *manifest) live(segment, id string) bool {
+	return !slices.Contains(man.Tombstones[segment], id)
+}
+
+// buried returns the set of the tombstones of a segment.
+func (man *manifest) buried(segment string) map[string]struct{} {
+	set := make(map[string]struct{}, len(man.Tombstones[segment]))
+	for _, id := range man.Tombstones[segment] {
+		set[id] = struct{}{}
+	}
+
+	return set
+}
+
+// bury adds a tombstone.
+func (man *manifest) bury(segment, id string) {
+	if man.Tombstones == nil {
+		man.Tombstones = make(map[string][]string)
+	}
+
+	man.Tombstones[segment] = append(man.Tombstones[segment], id)
+}
+
+// newSegmentName allocates a segment file name.
+func (man *manifest) newSegmentName() string {
+	name := fmt.Sprintf("%s%06d%s", segmentPrefix, man.NextSegment, segmentSuffix)
+	man.NextSegment++
+
+	return name
+}
+
+// clone returns a deep copy, for updates.
+func (man *manifest) clone() manifest {
+	next := *man
+	next.Segments = slices.Clone(man.Segments)
+	next.Tombstones = make(map[string][]string, len(man.Tombstones))
+
+	for segment, ids := range man.Tombstones {
+		next.Tombstones[segment] = slices.Clone(ids)
+	}
+
+	return next
+}
+
+func readManifest(dir string) (manifest, error) {
+	var man manifest
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return man, fmt.Errorf("%w: %s", ErrNotFound, dir)
+	}
+
+	if err != nil {
+		return man, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &man); err != nil {
+		return man, fmt.Errorf("%w: manifest: %w", ErrCorrupt, err)
+	}
+
+	if man.Version < minFormatVersion || man.Version > FormatVersion {
+		return man, fmt.Errorf("%w: manifest version %d", ErrVersion, man.Version)
+	}
+
+	return man, nil
+}
+
+func writeManifest(dir string, man manifest) error {
+	man.Version = FormatVersion
+
+	data, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	return writeFileAtomic(dir, manifestName, data)
+}
+
+// writeFileAtomic writes data to dir/name through a synced temporary file
+// renamed over the target, then syncs the directory so the rename is durable.
+func writeFileAtomic(dir, name string, data []byte) error {
+	file, err := os.CreateTemp(dir, name+".*"+tempSuffix)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", name, err)
+	}
+
+	temp := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(temp, filepath.Join(dir, name))
+	}
+
+	if err != nil {
+		_ = os.Remove(temp)
+
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+
+	return syncDir(dir)
+}
+
+// removeGarbage deletes the segment and temporary files that the manifest
+// does not reference. It must be called with the lock held.
+func removeGarbage(dir string, man manifest) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	live := make(map[string]bool, len(man.Segments))
+	for _, name := range man.Segments {
+		live[name] = true
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		isSegment := strings.HasPrefix(name, segmentPrefix) && strings.HasSuffix(name, segmentSuffix)
+
+		if strings.HasSuffix(name, tempSuffix) || (isSegment && !live[name]) {
+			// Best effort: a file still mapped elsewhere may not be removable
+			// on some platforms, and will be retried on the next write.
+			_ = os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
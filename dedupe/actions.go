@@ -0,0 +1,406 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dedupe
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Action is what [Apply] does to the duplicates of a cluster.
+type Action string
+
+const (
+	// ActionDryRun changes nothing and only reports what would be done.
+	ActionDryRun Action = "dry-run"
+	// ActionQuarantine moves duplicates to a quarantine directory.
+	ActionQuarantine Action = "quarantine"
+	// ActionHardlink replaces duplicates with hard links to the keeper. The
+	// originals are moved to the quarantine directory if one is set, and
+	// removed otherwise.
+	ActionHardlink Action = "hardlink"
+	// ActionDelete removes duplicates. It cannot be undone.
+	ActionDelete Action = "delete"
+)
+
+const (
+	dirMode = 0o755
+	tmpExt  = ".sporeprint-tmp"
+)
+
+var (
+	// ErrAction happens when an action is unknown or misconfigured.
+	ErrAction = errors.New("dedupe: invalid action")
+	// ErrExists happens when a file to create already exists.
+	ErrExists = errors.New("dedupe: file exists")
+	// ErrIrreversible happens when undoing an action whose originals are gone.
+	ErrIrreversible = errors.New("dedupe: action cannot be undone")
+	// ErrModified happens when undoing an action on a file changed since.
+	ErrModified = errors.New("dedupe: file modified since")
+)
+
+// Resolution is the keeper of a cluster and the duplicates to act on.
+type Resolution struct {
+	Keeper     string
+	Duplicates []string
+}
+
+// ResolveOptions controls [Resolve].
+type ResolveOptions struct {
+	// FFprobe is the ffprobe binary. Empty means "ffprobe" in PATH.
+	FFprobe string
+	// Ranking ranks the files of the cluster.
+	Ranking Ranking
+}
+
+// Entry is a journal record of an action applied to a duplicate.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Action Action    `json:"action"`
+	// Path is the duplicate acted on.
+	Path string `json:"path"`
+	// Keeper is the file kept in its cluster.
+	Keeper string `json:"keeper"`
+	// Backup is where the original of Path was moved, if anywhere.
+	Backup string `json:"backup,omitempty"`
+}
+
+// ApplyOptions controls [Apply].
+type ApplyOptions struct {
+	Action Action
+	// Quarantine is the directory originals are moved to. Paths are
+	// recreated under it. Required by [ActionQuarantine].
+	Quarantine string
+	// Journal receives an [Entry] per applied action, as JSON lines, for
+	// [Undo]. It may be nil.
+	Journal io.Writer
+}
+
+// Resolve probes the files of a cluster and picks the best one as keeper. The
+// cluster IDs must be file paths. It fails if any file cannot be probed: a
+// file is never removed without comparing it to all the others.
+func Resolve(ctx context.Context, cluster Cluster, options ResolveOptions) (Resolution, error) {
+	candidates := make([]Candidate, len(cluster.IDs))
+
+	for num, path := range cluster.IDs {
+		quality, err := Probe(ctx, options.FFprobe, path)
+		if err != nil {
+			return Resolution{}, err
+		}
+
+		candidates[num] = Candidate{Path: path, Quality: quality}
+	}
+
+	Rank(candidates, options.Ranking)
+
+	resolution := Resolution{Keeper: candidates[0].Path}
+	for _, candidate := range candidates[1:] {
+		resolution.Duplicates = append(resolution.Duplicates, candidate.Path)
+	}
+
+	return resolution, nil
+}
+
+// Apply acts on the duplicates of a resolution and returns an entry per
+// duplicate acted on. It stops at the first failure. With [ActionDryRun],
+// it returns the entries without acting nor journaling.
+func Apply(resolution Resolution, options ApplyOptions) ([]Entry, error) {
+	switch options.Action {
+	case ActionDryRun, ActionHardlink, ActionDelete:
+	case ActionQuarantine:
+		if options.Quarantine == "" {
+			return nil, fmt.Errorf("%w: %s requires a quarantine directory", ErrAction, options.Action)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrAction, options.Action)
+	}
+
+	if _, err := os.Stat(resolution.Keeper); err != nil {
+		return nil, fmt.Errorf("keeper: %w", err)
+	}
+
+	var (
+		journal *json.Encoder
+		entries []Entry
+	)
+
+	if options.Journal != nil {
+		journal = json.NewEncoder(options.Journal)
+	}
+
+	for _, path := range resolution.Duplicates {
+		entry := Entry{Time: time.Now().UTC(), Action: options.Action, Path: path, Keeper: resolution.Keeper}
+
+		if options.Action != ActionDryRun {
+			applied, err := apply(&entry, options.Quarantine)
+			if err != nil {
+				return entries, err
+			}
+
+			if !applied {
+				continue
+			}
+
+			if journal != nil {
+				if err = journal.Encode(entry); err != nil {
+					return entries, fmt.Errorf("writing journal: %w", err)
+				}
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Undo reverts the actions of a journal, latest first, and returns the
+// number of files restored. It goes on after failures and returns them
+// joined: deletions are reported as [ErrIrreversible].
+func Undo(journal io.Reader) (int, error) {
+	var entries []Entry
+
+	scanner := bufio.NewScanner(journal)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return 0, fmt.Errorf("reading journal: %w", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("reading journal: %w", err)
+	}
+
+	var (
+		restored int
+		errs     []error
+	)
+
+	for _, entry := range slices.Backward(entries) {
+		if err := undo(entry); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Path, err))
+
+			continue
+		}
+
+		restored++
+	}
+
+	return restored, errors.Join(errs...)
+}
+
+// apply acts on entry.Path and records its backup in entry. It returns false
+// if there was nothing to do.
+func apply(entry *Entry, quarantine string) (bool, error) {
+	if quarantine != "" && entry.Action != ActionDelete {
+		backup, err := quarantinePath(quarantine, entry.Path)
+		if err != nil {
+			return false, err
+		}
+
+		entry.Backup = backup
+	}
+
+	switch entry.Action {
+	case ActionQuarantine:
+		return true, moveFile(entry.Path, entry.Backup)
+	case ActionHardlink:
+		if same, err := sameFile(entry.Path, entry.Keeper); err != nil || same {
+			return false, err
+		}
+
+		return true, replaceWithLink(entry.Path, entry.Keeper, entry.Backup)
+	default:
+		if err := os.Remove(entry.Path); err != nil {
+			return false, fmt.Errorf("deleting: %w", err)
+		}
+
+		return true, nil
+	}
+}
+
+// replaceWithLink atomically replaces path with a hard link to keeper, after
+// moving the original to backup, if set.
+func replaceWithLink(path, keeper, backup string) error {
+	tmp := path + tmpExt
+
+	if err := os.Link(keeper, tmp); err != nil {
+		return fmt.Errorf("linking: %w", err)
+	}
+
+	if backup != "" {
+		if err := copyFile(path, backup); err != nil {
+			_ = os.Remove(tmp)
+
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+
+		if backup != "" {
+			_ = os.Remove(backup)
+		}
+
+		return fmt.Errorf("linking: %w", err)
+	}
+
+	return nil
+}
+
+func undo(entry Entry) error {
+	switch entry.Action {
+	case ActionQuarantine:
+		return moveFile(entry.Backup, entry.Path)
+	case ActionHardlink:
+		if entry.Backup == "" {
+			return ErrIrreversible
+		}
+
+		// Only remove the link if it still is one.
+		same, err := sameFile(entry.Path, entry.Keeper)
+		if err != nil {
+			return err
+		}
+
+		if !same {
+			return ErrModified
+		}
+
+		if err = os.Remove(entry.Path); err != nil {
+			return fmt.Errorf("removing link: %w", err)
+		}
+
+		return moveFile(entry.Backup, entry.Path)
+	case ActionDelete:
+		return ErrIrreversible
+	default:
+		return fmt.Errorf("%w: %q", ErrAction, entry.Action)
+	}
+}
+
+// quarantinePath returns where path is moved under the quarantine directory:
+// its absolute path is recreated there.
+func quarantinePath(quarantine, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("quarantine: %w", err)
+	}
+
+	return filepath.Join(quarantine, abs[len(filepath.VolumeName(abs)):]), nil
+}
+
+// moveFile renames src to dst, which must not exist, creating its directory.
+// Across devices, it copies then removes src.
+func moveFile(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%w: %s", ErrExists, dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), dirMode); err != nil {
+		return fmt.Errorf("moving: %w", err)
+	}
+
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("moving: %w", err)
+	}
+
+	return nil
+}
+
+// copyFile copies src to dst, which must not exist, creating its directory.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), dirMode); err != nil {
+		return fmt.Errorf("copying: %w", err)
+	}
+
+	input, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("copying: %w", err)
+	}
+
+	defer func() { _ = input.Close() }()
+
+	info, err := input.Stat()
+	if err != nil {
+		return fmt.Errorf("copying: %w", err)
+	}
+
+	output, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%w: %s", ErrExists, dst)
+		}
+
+		return fmt.Errorf("copying: %w", err)
+	}
+
+	_, err = io.Copy(output, input)
+	if err == nil {
+		err = output.Sync()
+	}
+
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(dst)
+
+		return fmt.Errorf("copying: %w", err)
+	}
+
+	return nil
+}
+
+func sameFile(path1, path2 string) (bool, error) {
+	info1, err := os.Stat(path1)
+	if err != nil {
+		return false, fmt.Errorf("stat: %w", err)
+	}
+
+	info2, err := os.Stat(path2)
+	if err != nil {
+		return false, fmt.Errorf("stat: %w", err)
+	}
+
+	return os.SameFile(info1, info2), nil
+}
@@ -0,0 +1,200 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dedupe_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/dedupe"
+)
+
+// library creates a keeper and two duplicates with distinct content.
+func newLibrary(t *testing.T) (string, dedupe.Resolution) {
+	t.Helper()
+
+	dir := t.TempDir()
+	resolution := dedupe.Resolution{
+		Keeper:     filepath.Join(dir, "music", "keeper.flac"),
+		Duplicates: []string{filepath.Join(dir, "music", "dup.mp3"), filepath.Join(dir, "downloads", "dup.ogg")},
+	}
+
+	for _, path := range append([]string{resolution.Keeper}, resolution.Duplicates...) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(filepath.Base(path)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir, resolution
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("reading %s: %v", path, err)
+
+		return
+	}
+
+	if string(got) != want {
+		t.Errorf("%s contains %q, want %q", path, got, want)
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	t.Parallel()
+
+	_, resolution := newLibrary(t)
+
+	var journal bytes.Buffer
+
+	entries, err := dedupe.Apply(resolution, dedupe.ApplyOptions{Action: dedupe.ActionDryRun, Journal: &journal})
+	if err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+
+	if len(entries) != 2 || journal.Len() != 0 {
+		t.Errorf("Apply() = %+v, journal %q, want 2 entries and no journal", entries, journal.String())
+	}
+
+	for _, path := range resolution.Duplicates {
+		assertContent(t, path, filepath.Base(path))
+	}
+}
+
+func TestApplyQuarantineUndo(t *testing.T) {
+	t.Parallel()
+
+	dir, resolution := newLibrary(t)
+	quarantine := filepath.Join(dir, "quarantine")
+
+	var journal bytes.Buffer
+
+	entries, err := dedupe.Apply(resolution, dedupe.ApplyOptions{
+		Action:     dedupe.ActionQuarantine,
+		Quarantine: quarantine,
+		Journal:    &journal,
+	})
+	if err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+
+	for num, path := range resolution.Duplicates {
+		if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s still exists after quarantine", path)
+		}
+
+		assertContent(t, entries[num].Backup, filepath.Base(path))
+	}
+
+	assertContent(t, resolution.Keeper, "keeper.flac")
+
+	restored, err := dedupe.Undo(&journal)
+	if err != nil || restored != 2 {
+		t.Fatalf("Undo() = %d, %v, want 2 restored", restored, err)
+	}
+
+	for _, path := range resolution.Duplicates {
+		assertContent(t, path, filepath.Base(path))
+	}
+}
+
+func TestApplyHardlinkUndo(t *testing.T) {
+	t.Parallel()
+
+	dir, resolution := newLibrary(t)
+
+	var journal bytes.Buffer
+
+	options := dedupe.ApplyOptions{
+		Action:     dedupe.ActionHardlink,
+		Quarantine: filepath.Join(dir, "quarantine"),
+		Journal:    &journal,
+	}
+
+	if _, err := dedupe.Apply(resolution, options); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+
+	for _, path := range resolution.Duplicates {
+		assertContent(t, path, "keeper.flac")
+	}
+
+	// Already linked: nothing to do.
+	entries, err := dedupe.Apply(resolution, options)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Apply() again = %+v, %v, want nothing done", entries, err)
+	}
+
+	restored, err := dedupe.Undo(&journal)
+	if err != nil || restored != 2 {
+		t.Fatalf("Undo() = %d, %v, want 2 restored", restored, err)
+	}
+
+	for _, path := range resolution.Duplicates {
+		assertContent(t, path, filepath.Base(path))
+	}
+
+	assertContent(t, resolution.Keeper, "keeper.flac")
+}
+
+func TestApplyDelete(t *testing.T) {
+	t.Parallel()
+
+	_, resolution := newLibrary(t)
+
+	var journal bytes.Buffer
+
+	if _, err := dedupe.Apply(resolution, dedupe.ApplyOptions{Action: dedupe.ActionDelete, Journal: &journal}); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+
+	for _, path := range resolution.Duplicates {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s still exists after delete", path)
+		}
+	}
+
+	restored, err := dedupe.Undo(&journal)
+	if restored != 0 || !errors.Is(err, dedupe.ErrIrreversible) {
+		t.Errorf("Undo() = %d, %v, want ErrIrreversible", restored, err)
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, resolution := newLibrary(t)
+
+	for _, options := range []dedupe.ApplyOptions{
+		{Action: "shred"},
+		{Action: dedupe.ActionQuarantine},
+	} {
+		if _, err := dedupe.Apply(resolution, options); !errors.Is(err, dedupe.ErrAction) {
+			t.Errorf("Apply(%+v) = %v, want ErrAction", options, err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dedupe
+
+import (
+	"cmp"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/index"
+)
+
+// Options controls [Find].
+type Options struct {
+	// Threshold is the minimum score for two tracks to be duplicates.
+	Threshold float64
+	// Matcher confirms candidate pairs. Nil means [compare.Bounded].
+	Matcher compare.Matcher
+	// Mask is applied to hashes when looking up candidates, as
+	// [index.Options.Mask].
+	Mask uint32
+	// Candidates is the number of candidates confirmed per track, as
+	// [index.Options.Candidates].
+	Candidates int
+	// Jobs is the number of tracks searched in parallel. Zero means the
+	// number of CPUs.
+	Jobs int
+}
+
+// Pair is two tracks confirmed as duplicates.
+type Pair struct {
+	// ID1 and ID2 are the track IDs, ID1 sorting first.
+	ID1 string
+	ID2 string
+	// Score is the similarity of the tracks.
+	Score float64
+	// Offset is the alignment offset of ID1 relative to ID2, in hashes, as
+	// [compare.WithOffset].
+	Offset int
+}
+
+// Cluster is a group of duplicate tracks.
+type Cluster struct {
+	// IDs are the tracks of the cluster, sorted.
+	IDs []string
+	// Pairs are the confirmed pairs linking them, sorted.
+	Pairs []Pair
+}
+
+// Find returns the clusters of duplicates among tracks, sorted by their first
+// ID. Track IDs must be unique.
+func Find(tracks []index.Track, options Options) ([]Cluster, error) {
+	data, err := index.EncodeSegment(tracks, cmp.Or(options.Mask, index.FullMask))
+	if err != nil {
+		return nil, fmt.Errorf("indexing: %w", err)
+	}
+
+	segment, err := index.OpenSegment(data)
+	if err != nil {
+		return nil, fmt.Errorf("indexing: %w", err)
+	}
+
+	idx := index.New(index.Options{
+		Mask:       options.Mask,
+		Candidates: options.Candidates,
+		Matcher:    options.Matcher,
+		Threshold:  options.Threshold,
+	})
+	idx.AddSegment(segment)
+
+	pairs := findPairs(idx, tracks, options.Jobs)
+
+	return cluster(tracks, pairs), nil
+}
+
+// findPairs searches every track and returns the confirmed pairs, keyed by
+// their IDs.
+func findPairs(idx *index.Index, tracks []index.Track, jobs int) map[[2]string]Pair {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	var (
+		mutex   sync.Mutex
+		workers sync.WaitGroup
+	)
+
+	pairs := make(map[[2]string]Pair)
+	next := make(chan index.Track)
+
+	for range jobs {
+		workers.Go(func() {
+			for track := range next {
+				for _, match := range idx.Search(track.Raw) {
+					if match.ID == track.ID {
+						continue
+					}
+
+					pair := Pair{ID1: track.ID, ID2: match.ID, Score: match.Score, Offset: match.Offset}
+					if pair.ID1 > pair.ID2 {
+						pair.ID1, pair.ID2, pair.Offset = pair.ID2, pair.ID1, -pair.Offset
+					}
+
+					key := [2]string{pair.ID1, pair.ID2}
+
+					mutex.Lock()
+					// Both tracks find each other: keep the best score.
+					if existing, found := pairs[key]; !found || pair.Score > existing.Score {
+						pairs[key] = pair
+					}
+					mutex.Unlock()
+				}
+			}
+		})
+	}
+
+	for _, track := range tracks {
+		next <- track
+	}
+
+	close(next)
+	workers.Wait()
+
+	return pairs
+}
+
+// cluster groups paired tracks with a union-find.
+func cluster(tracks []index.Track, pairs map[[2]string]Pair) []Cluster {
+	nums := make(map[string]int, len(tracks))
+	for num, track := range tracks {
+		nums[track.ID] = num
+	}
+
+	sets := newUnionFind(len(tracks))
+	for key := range pairs {
+		sets.union(nums[key[0]], nums[key[1]])
+	}
+
+	byRoot := make(map[int]*Cluster)
+
+	for key, pair := range pairs {
+		root := sets.find(nums[key[0]])
+		if byRoot[root] == nil {
+			byRoot[root] = &Cluster{}
+		}
+
+		byRoot[root].Pairs = append(byRoot[root].Pairs, pair)
+	}
+
+	for num, track := range tracks {
+		if found := byRoot[sets.find(num)]; found != nil {
+			found.IDs = append(found.IDs, track.ID)
+		}
+	}
+
+	clusters := make([]Cluster, 0, len(byRoot))
+
+	for _, found := range byRoot {
+		slices.Sort(found.IDs)
+		slices.SortFunc(found.Pairs, func(a, b Pair) int {
+			return cmp.Or(cmp.Compare(a.ID1, b.ID1), cmp.Compare(a.ID2, b.ID2))
+		})
+
+		clusters = append(clusters, *found)
+	}
+
+	slices.SortFunc(clusters, func(a, b Cluster) int {
+		return cmp.Compare(a.IDs[0], b.IDs[0])
+	})
+
+	return clusters
+}
+
+// unionFind is a disjoint-set forest with union by size and path halving.
+type unionFind struct {
+	parent []int
+	size   []int
+}
+
+func newUnionFind(count int) *unionFind {
+	sets := &unionFind{parent: make([]int, count), size: make([]int, count)}
+
+	for i := range sets.parent {
+		sets.parent[i] = i
+		sets.size[i] = 1
+	}
+
+	return sets
+}
+
+func (u *unionFind) find(elem int) int {
+	for u.parent[elem] != elem {
+		u.parent[elem] = u.parent[u.parent[elem]]
+		elem = u.parent[elem]
+	}
+
+	return elem
+}
+
+func (u *unionFind) union(elem1, elem2 int) {
+	root1, root2 := u.find(elem1), u.find(elem2)
+	if root1 == root2 {
+		return
+	}
+
+	if u.size[root1] < u.size[root2] {
+		root1, root2 = root2, root1
+	}
+
+	u.parent[root2] = root1
+	u.size[root1] += u.size[root2]
+}
@@ -0,0 +1,115 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dedupe_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/dedupe"
+	"github.com/mycophonic/sporeprint/index"
+)
+
+func syntheticFingerprint(seed uint64, length int) []uint32 {
+	rng := rand.New(rand.NewPCG(seed, 0))
+
+	raw := make([]uint32, length)
+	for i := range raw {
+		raw[i] = rng.Uint32()
+	}
+
+	return raw
+}
+
+// flipBits returns a copy of raw with one bit flipped in every third hash,
+// as lossy encoding would do.
+func flipBits(raw []uint32) []uint32 {
+	flipped := slices.Clone(raw)
+	for i := 0; i < len(flipped); i += 3 {
+		flipped[i] ^= 1 << (i % 32)
+	}
+
+	return flipped
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	long := syntheticFingerprint(1, 1000)
+	other := syntheticFingerprint(2, 500)
+
+	tracks := []index.Track{
+		// A chain: a1 and a3 only overlap on a third, but both match a2.
+		{ID: "a3", Raw: long[400:1000]},
+		{ID: "a1", Raw: long[0:600]},
+		{ID: "a2", Raw: long[200:800]},
+		{ID: "b1", Raw: other},
+		{ID: "b2", Raw: flipBits(other)},
+	}
+
+	for i := range 20 {
+		tracks = append(tracks, index.Track{ID: fmt.Sprintf("single-%d", i), Raw: syntheticFingerprint(uint64(100+i), 500)})
+	}
+
+	clusters, err := dedupe.Find(tracks, dedupe.Options{Threshold: 0.4, Matcher: compare.Global{}})
+	if err != nil {
+		t.Fatalf("Find() failed: %v", err)
+	}
+
+	if len(clusters) != 2 {
+		t.Fatalf("Find() = %+v, want 2 clusters", clusters)
+	}
+
+	if !slices.Equal(clusters[0].IDs, []string{"a1", "a2", "a3"}) || !slices.Equal(clusters[1].IDs, []string{"b1", "b2"}) {
+		t.Errorf("clusters = %v, %v; want [a1 a2 a3], [b1 b2]", clusters[0].IDs, clusters[1].IDs)
+	}
+
+	if len(clusters[0].Pairs) != 2 {
+		t.Fatalf("cluster a pairs = %+v, want a1-a2 and a2-a3", clusters[0].Pairs)
+	}
+
+	// a1 starts 200 hashes before a2.
+	if pair := clusters[0].Pairs[0]; pair.ID1 != "a1" || pair.ID2 != "a2" || pair.Offset != 200 || pair.Score < 0.6 {
+		t.Errorf("first pair = %+v, want a1-a2 at offset 200", pair)
+	}
+
+	if pair := clusters[1].Pairs[0]; pair.Offset != 0 || pair.Score < 0.99 {
+		t.Errorf("b pair = %+v, want a full match at offset 0", pair)
+	}
+}
+
+func TestFindNoDuplicates(t *testing.T) {
+	t.Parallel()
+
+	tracks := make([]index.Track, 30)
+	for i := range tracks {
+		tracks[i] = index.Track{ID: fmt.Sprintf("track-%d", i), Raw: syntheticFingerprint(uint64(i), 300)}
+	}
+
+	clusters, err := dedupe.Find(tracks, dedupe.Options{Threshold: 0.4})
+	if err != nil || len(clusters) != 0 {
+		t.Errorf("Find() = %+v, %v; want no clusters", clusters, err)
+	}
+
+	tracks = append(tracks, tracks[0])
+	if _, err = dedupe.Find(tracks, dedupe.Options{}); err == nil {
+		t.Error("Find() with duplicate IDs should fail")
+	}
+}
@@ -0,0 +1,27 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package dedupe finds duplicate tracks in a set of fingerprints.
+//
+// Every track is searched for in an [index.Index] of all the others, which
+// confirms candidates with a [compare.Matcher]. Confirmed pairs are then
+// grouped into clusters with a union-find: if a matches b and b matches c,
+// a, b and c are one cluster, even if a and c do not match directly.
+//
+// [Resolve] then probes the files of a cluster with ffprobe and [Rank]s them
+// to pick a keeper, and [Apply] acts on the other files, recording every
+// change in a journal that [Undo] reverts.
+package dedupe
@@ -0,0 +1,213 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dedupe
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultFFprobe is the ffprobe binary looked up in PATH.
+	defaultFFprobe = "ffprobe"
+
+	// durationTolerance is the width in seconds of the duration buckets
+	// ranked as equal: encoders pad differently. Buckets, unlike a maximum
+	// difference, keep the ranking order transitive.
+	durationTolerance = 1.0
+)
+
+// ErrProbe happens when ffprobe cannot read a file.
+var ErrProbe = errors.New("dedupe: probing failed")
+
+//nolint:gochecknoglobals // Immutable lookup table.
+var losslessCodecs = map[string]bool{
+	"alac":    true,
+	"ape":     true,
+	"flac":    true,
+	"mlp":     true,
+	"shorten": true,
+	"tak":     true,
+	"truehd":  true,
+	"tta":     true,
+	"wavpack": true,
+}
+
+// Quality describes the audio stream of a file, as reported by ffprobe.
+type Quality struct {
+	// Codec is the ffmpeg codec name (flac, mp3, pcm_s16le...).
+	Codec string
+	// Lossless reports whether the codec is lossless.
+	Lossless bool
+	// BitDepth is the sample size of lossless streams, zero if unknown.
+	BitDepth int
+	// SampleRate is in Hz.
+	SampleRate int
+	// BitRate is in bits per second, zero if unknown.
+	BitRate int
+	// Duration is in seconds.
+	Duration float64
+}
+
+// Candidate is a file of a cluster with its quality.
+type Candidate struct {
+	Path    string
+	Quality Quality
+}
+
+// Ranking controls how [Rank] picks keepers.
+type Ranking struct {
+	// Prefer lists path patterns by decreasing preference. A file matching
+	// an earlier pattern is preferred when the audio quality does not
+	// decide.
+	Prefer []*regexp.Regexp
+}
+
+// Rank sorts candidates best first. In order, it prefers lossless streams,
+// a higher bit depth and sample rate for lossless streams or a higher bitrate
+// for lossy ones, a longer duration in whole seconds (ignoring padding), a path
+// matching an earlier [Ranking.Prefer] pattern, and finally the path sorting
+// first.
+func Rank(candidates []Candidate, ranking Ranking) {
+	preference := func(path string) int {
+		for num, pattern := range ranking.Prefer {
+			if pattern.MatchString(path) {
+				return num
+			}
+		}
+
+		return len(ranking.Prefer)
+	}
+
+	slices.SortStableFunc(candidates, func(a, b Candidate) int {
+		qa, qb := a.Quality, b.Quality
+
+		if qa.Lossless != qb.Lossless {
+			if qa.Lossless {
+				return -1
+			}
+
+			return 1
+		}
+
+		if byQuality := compareStreams(qa, qb); byQuality != 0 {
+			return byQuality
+		}
+
+		return cmp.Or(
+			cmp.Compare(math.Floor(qb.Duration/durationTolerance), math.Floor(qa.Duration/durationTolerance)),
+			cmp.Compare(preference(a.Path), preference(b.Path)),
+			cmp.Compare(a.Path, b.Path),
+		)
+	})
+}
+
+// Probe reads the quality of the first audio stream of a file with ffprobe.
+// An empty ffprobe means "ffprobe" in PATH.
+func Probe(ctx context.Context, ffprobe, path string) (Quality, error) {
+	if ffprobe == "" {
+		ffprobe = defaultFFprobe
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	//nolint:gosec // running the configured ffprobe is the point
+	cmd := exec.CommandContext(ctx, ffprobe,
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,sample_rate,bits_per_sample,bits_per_raw_sample,bit_rate:format=bit_rate,duration",
+		"-of", "json",
+		path,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return Quality{}, fmt.Errorf("%w: %s: %w: %s", ErrProbe, path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var output struct {
+		Streams []struct {
+			CodecName        string `json:"codec_name"`
+			SampleRate       string `json:"sample_rate"`
+			BitsPerSample    int    `json:"bits_per_sample"`
+			BitsPerRawSample string `json:"bits_per_raw_sample"`
+			BitRate          string `json:"bit_rate"`
+		} `json:"streams"`
+		Format struct {
+			BitRate  string `json:"bit_rate"`
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return Quality{}, fmt.Errorf("%w: %s: %w", ErrProbe, path, err)
+	}
+
+	if len(output.Streams) == 0 {
+		return Quality{}, fmt.Errorf("%w: %s: no audio stream", ErrProbe, path)
+	}
+
+	stream := output.Streams[0]
+	quality := Quality{
+		Codec:      stream.CodecName,
+		Lossless:   losslessCodecs[stream.CodecName] || strings.HasPrefix(stream.CodecName, "pcm_"),
+		SampleRate: atoi(stream.SampleRate),
+		BitRate:    cmp.Or(atoi(stream.BitRate), atoi(output.Format.BitRate)),
+	}
+
+	quality.Duration, _ = strconv.ParseFloat(output.Format.Duration, 64)
+
+	if quality.Lossless {
+		quality.BitDepth = cmp.Or(atoi(stream.BitsPerRawSample), stream.BitsPerSample)
+	}
+
+	return quality, nil
+}
+
+// compareStreams compares qualities of the same kind, lossless or lossy,
+// best first.
+func compareStreams(qa, qb Quality) int {
+	if qa.Lossless {
+		return cmp.Or(
+			cmp.Compare(qb.BitDepth, qa.BitDepth),
+			cmp.Compare(qb.SampleRate, qa.SampleRate),
+		)
+	}
+
+	return cmp.Compare(qb.BitRate, qa.BitRate)
+}
+
+// atoi parses ffprobe numbers, which are strings and "N/A" when unknown.
+func atoi(value string) int {
+	num, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+
+	return num
+}
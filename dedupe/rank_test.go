@@ -0,0 +1,166 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package dedupe_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/dedupe"
+)
+
+func TestRank(t *testing.T) {
+	t.Parallel()
+
+	flac16 := dedupe.Quality{Codec: "flac", Lossless: true, BitDepth: 16, SampleRate: 44100, Duration: 200}
+	flac24 := dedupe.Quality{Codec: "flac", Lossless: true, BitDepth: 24, SampleRate: 96000, Duration: 200}
+	mp3High := dedupe.Quality{Codec: "mp3", BitRate: 320000, Duration: 200}
+	mp3Low := dedupe.Quality{Codec: "mp3", BitRate: 128000, Duration: 200}
+	mp3Cut := dedupe.Quality{Codec: "mp3", BitRate: 320000, Duration: 150}
+	mp3Padded := dedupe.Quality{Codec: "mp3", BitRate: 320000, Duration: 200.5}
+
+	candidates := []dedupe.Candidate{
+		{Path: "/downloads/e.mp3", Quality: mp3Cut},
+		{Path: "/downloads/d.mp3", Quality: mp3Low},
+		{Path: "/downloads/c.mp3", Quality: mp3High},
+		{Path: "/music/c.mp3", Quality: mp3Padded},
+		{Path: "/music/b.flac", Quality: flac16},
+		{Path: "/downloads/a.flac", Quality: flac24},
+	}
+
+	dedupe.Rank(candidates, dedupe.Ranking{Prefer: []*regexp.Regexp{regexp.MustCompile(`^/music/`)}})
+
+	want := []string{
+		"/downloads/a.flac", // lossless, 24 bits
+		"/music/b.flac",     // lossless, 16 bits
+		"/music/c.mp3",      // same as /downloads/c.mp3 to the second, preferred path
+		"/downloads/c.mp3",
+		"/downloads/e.mp3", // 320k but truncated
+		"/downloads/d.mp3", // 128k
+	}
+
+	for num, candidate := range candidates {
+		if candidate.Path != want[num] {
+			t.Errorf("Rank()[%d] = %s, want %s", num, candidate.Path, want[num])
+		}
+	}
+}
+
+// TestRankTransitive checks that the ranking does not depend on the input
+// order when durations are less than a second apart pairwise, but not all
+// together.
+func TestRankTransitive(t *testing.T) {
+	t.Parallel()
+
+	// Compared pairwise, /m.mp3 beats /z.mp3 and /a.mp3 beats /m.mp3 by path,
+	// but /z.mp3 beats /a.mp3 by duration.
+	durations := map[string]float64{"/z.mp3": 11.2, "/m.mp3": 10.6, "/a.mp3": 10}
+	want := []string{"/z.mp3", "/a.mp3", "/m.mp3"}
+
+	for _, order := range [][]string{
+		{"/a.mp3", "/m.mp3", "/z.mp3"},
+		{"/a.mp3", "/z.mp3", "/m.mp3"},
+		{"/m.mp3", "/a.mp3", "/z.mp3"},
+		{"/m.mp3", "/z.mp3", "/a.mp3"},
+		{"/z.mp3", "/a.mp3", "/m.mp3"},
+		{"/z.mp3", "/m.mp3", "/a.mp3"},
+	} {
+		candidates := make([]dedupe.Candidate, len(order))
+		for num, path := range order {
+			candidates[num] = dedupe.Candidate{Path: path, Quality: dedupe.Quality{Codec: "mp3", Duration: durations[path]}}
+		}
+
+		dedupe.Rank(candidates, dedupe.Ranking{})
+
+		for num, candidate := range candidates {
+			if candidate.Path != want[num] {
+				t.Errorf("Rank(%v)[%d] = %s, want %s", order, num, candidate.Path, want[num])
+			}
+		}
+	}
+}
+
+// fakeFFprobe writes a script printing the ffprobe JSON output of a 16 bits
+// FLAC file, and failing if the input file is named "broken".
+func fakeFFprobe(t *testing.T) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not executable on windows")
+	}
+
+	script := `#!/bin/sh
+case "$*" in *broken*) echo 'broken: Invalid data' >&2; exit 1;; esac
+cat <<'JSON'
+{
+    "programs": [],
+    "streams": [
+        {
+            "codec_name": "flac",
+            "sample_rate": "44100",
+            "bits_per_sample": 0,
+            "bits_per_raw_sample": "16"
+        }
+    ],
+    "format": {
+        "duration": "215.146667",
+        "bit_rate": "901236"
+    }
+}
+JSON
+`
+
+	ffprobe := filepath.Join(t.TempDir(), "ffprobe")
+	if err := os.WriteFile(ffprobe, []byte(script), 0o700); err != nil { //nolint:gosec // must be executable
+		t.Fatal(err)
+	}
+
+	return ffprobe
+}
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+
+	ffprobe := fakeFFprobe(t)
+
+	quality, err := dedupe.Probe(context.Background(), ffprobe, "track.flac")
+	if err != nil {
+		t.Fatalf("Probe() failed: %v", err)
+	}
+
+	want := dedupe.Quality{
+		Codec:      "flac",
+		Lossless:   true,
+		BitDepth:   16,
+		SampleRate: 44100,
+		BitRate:    901236,
+		Duration:   215.146667,
+	}
+	if quality != want {
+		t.Errorf("Probe() = %+v, want %+v", quality, want)
+	}
+
+	_, err = dedupe.Probe(context.Background(), ffprobe, "broken.flac")
+	if !errors.Is(err, dedupe.ErrProbe) {
+		t.Errorf("Probe(broken) = %v, want ErrProbe", err)
+	}
+}
@@ -0,0 +1,22 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package fingerprint streams raw PCM audio into a Chromaprint context.
+//
+// Input must be signed 16-bit little-endian PCM at [SampleRate] Hz with
+// [Channels] channel, as produced by the ffmpeg invocation documented in the
+// README. [File] runs that invocation itself on an audio file.
+package fingerprint
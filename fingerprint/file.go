@@ -0,0 +1,102 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fingerprint
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+)
+
+const (
+	// FFmpegFilter is the ffmpeg audio filter resampling like fpcalc, so that
+	// fingerprints of decoded files are identical to fpcalc's. See README.
+	FFmpegFilter = "aresample=resampler=swr:filter_size=16:phase_shift=8:cutoff=0.8:linear_interp=1"
+
+	// defaultFFmpeg is the ffmpeg binary looked up in PATH.
+	defaultFFmpeg = "ffmpeg"
+)
+
+// ErrDecode happens when ffmpeg cannot decode a file.
+var ErrDecode = errors.New("fingerprint: decoding failed")
+
+// File decodes an audio file with ffmpeg, as documented in the README, and
+// fingerprints it as [Stream]. Only the first options.Length seconds are
+// decoded.
+func File(ctx context.Context, chroma *chromaprint.Context, path string, options Options) (Result, error) {
+	if err := options.checkSpeed(); err != nil {
+		return Result{}, err
+	}
+
+	ffmpeg := options.FFmpeg
+	if ffmpeg == "" {
+		ffmpeg = defaultFFmpeg
+	}
+
+	args := []string{"-nostdin", "-v", "error", "-i", path}
+
+	if options.Length > 0 {
+		// The input plays Speed times faster: decode enough of it, plus a
+		// second of margin for the resampler.
+		speed := options.Speed
+		if speed == 0 {
+			speed = 1
+		}
+
+		args = append(args, "-t", strconv.FormatFloat(float64(options.Length)/speed+1, 'f', 3, 64))
+	}
+
+	args = append(args,
+		"-af", FFmpegFilter,
+		"-f", "s16le",
+		"-ac", strconv.Itoa(Channels),
+		"-ar", strconv.Itoa(SampleRate),
+		"pipe:1",
+	)
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, ffmpeg, args...)
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		return Result{}, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	result, streamErr := Stream(chroma, stdout, options)
+
+	// Stream stops at Length: let ffmpeg finish writing.
+	_, _ = io.Copy(io.Discard, stdout)
+
+	if err = cmd.Wait(); err != nil {
+		return Result{}, fmt.Errorf("%w: %s: %w: %s", ErrDecode, path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return result, streamErr
+}
@@ -0,0 +1,88 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fingerprint_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/fingerprint"
+)
+
+// fakeFFmpeg writes a script printing data whatever its arguments, and
+// failing if the input file is named "broken".
+func fakeFFmpeg(t *testing.T, data []byte) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not executable on windows")
+	}
+
+	dir := t.TempDir()
+	pcmPath := filepath.Join(dir, "pcm")
+
+	if err := os.WriteFile(pcmPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	script := "#!/bin/sh\n" +
+		"case \"$*\" in *broken*) echo 'broken: Invalid data' >&2; exit 1;; esac\n" +
+		"cat '" + pcmPath + "'\n"
+
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte(script), 0o700); err != nil { //nolint:gosec // must be executable
+		t.Fatal(err)
+	}
+
+	return ffmpeg
+}
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	data := pcm(10)
+	ffmpeg := fakeFFmpeg(t, data)
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	want, err := fingerprint.Stream(chroma, bytes.NewReader(data), fingerprint.Options{Length: 4})
+	if err != nil {
+		t.Fatalf("Stream() failed: %v", err)
+	}
+
+	// ffmpeg writes more than Length: the rest must be drained.
+	got, err := fingerprint.File(context.Background(), chroma, "track.flac", fingerprint.Options{Length: 4, FFmpeg: ffmpeg})
+	if err != nil {
+		t.Fatalf("File() failed: %v", err)
+	}
+
+	if got != want {
+		t.Errorf("File() = %+v, want %+v", got, want)
+	}
+
+	_, err = fingerprint.File(context.Background(), chroma, "broken.flac", fingerprint.Options{FFmpeg: ffmpeg})
+	if !errors.Is(err, fingerprint.ErrDecode) {
+		t.Errorf("File(broken) = %v, want ErrDecode", err)
+	}
+}
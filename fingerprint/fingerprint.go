@@ -0,0 +1,213 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fingerprint
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+)
+
+// See README.
+const (
+	// SampleRate is the sample rate Chromaprint works at, in Hz.
+	SampleRate = 11025
+	// Channels is the channel count Chromaprint works with.
+	Channels = 1
+	// DefaultLength is the default maximum audio length in seconds, as fpcalc.
+	DefaultLength = 120
+
+	bufferSize = 8192
+)
+
+var (
+	// ErrRead happens when reading PCM input fails.
+	ErrRead = errors.New("fingerprint: reading PCM failed")
+	// ErrSpeed happens when [Options.Speed] is negative or NaN.
+	ErrSpeed = errors.New("fingerprint: speed must be positive, or zero for the default")
+)
+
+// Options controls how PCM is fed to Chromaprint.
+type Options struct {
+	// Length is the maximum audio length in seconds. Zero means unlimited.
+	Length int
+	// Speed is how many times faster the input plays than the original
+	// recording (1.04 for a PAL speed-up). The input is resampled to undo it
+	// before fingerprinting, which corrects both tempo and pitch. Zero means 1,
+	// negative values are rejected with ErrSpeed.
+	Speed float64
+	// FFmpeg is the ffmpeg binary used by [File]. Empty means ffmpeg from PATH.
+	FFmpeg string
+}
+
+// Result is a computed fingerprint.
+type Result struct {
+	// Fingerprint is the encoded fingerprint, as [chromaprint.Context.Fingerprint].
+	Fingerprint string
+	// Duration is the length in seconds of the audio that was fingerprinted.
+	Duration float64
+}
+
+// Stream reads PCM from reader until EOF or options.Length is reached, and
+// returns its fingerprint. The context is started, fed and finished here, so
+// a single context can be reused across calls.
+func Stream(chroma *chromaprint.Context, reader io.Reader, options Options) (Result, error) {
+	if err := options.checkSpeed(); err != nil {
+		return Result{}, err
+	}
+
+	if err := chroma.Start(SampleRate, Channels); err != nil {
+		return Result{}, fmt.Errorf("starting: %w", err)
+	}
+
+	// Calculate sample limit: rate × channels × seconds
+	var maxSamples int
+	if options.Length > 0 {
+		maxSamples = SampleRate * Channels * options.Length
+	}
+
+	var resampled *resampler
+	if options.Speed > 0 && options.Speed != 1 {
+		resampled = &resampler{step: 1 / options.Speed}
+	}
+
+	buf := make([]byte, bufferSize)
+	samples := make([]int16, bufferSize/2)
+	totalFed := 0
+
+	for {
+		nread, err := io.ReadFull(reader, buf)
+		if nread == 0 {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			if err != nil {
+				return Result{}, fmt.Errorf("%w: %w", ErrRead, err)
+			}
+		}
+
+		// Convert bytes to int16 samples
+		numSamples := nread / 2
+		for i := 0; i+1 < nread; i += 2 {
+			//nolint:gosec // samples size = 1/2 buffer size
+			samples[i/2] = int16(binary.LittleEndian.Uint16(buf[i : i+2]))
+		}
+
+		chunk := samples[:numSamples]
+		if resampled != nil {
+			chunk = resampled.resample(chunk)
+		}
+
+		// Apply length limit
+		toFeed := len(chunk)
+		if maxSamples > 0 && totalFed+toFeed > maxSamples {
+			toFeed = maxSamples - totalFed
+			if toFeed <= 0 {
+				break
+			}
+		}
+
+		if err = chroma.Feed(chunk[:toFeed]); err != nil {
+			return Result{}, fmt.Errorf("feeding: %w", err)
+		}
+
+		totalFed += toFeed
+
+		if maxSamples > 0 && totalFed >= maxSamples {
+			break
+		}
+	}
+
+	if err := chroma.Finish(); err != nil {
+		return Result{}, fmt.Errorf("finishing: %w", err)
+	}
+
+	encoded, err := chroma.Fingerprint()
+	if err != nil {
+		return Result{}, fmt.Errorf("encoding: %w", err)
+	}
+
+	return Result{
+		Fingerprint: encoded,
+		Duration:    float64(totalFed) / float64(SampleRate*Channels),
+	}, nil
+}
+
+// checkSpeed rejects negative (or NaN) speeds, which would otherwise be
+// silently treated as 1.
+func (options Options) checkSpeed() error {
+	if !(options.Speed >= 0) {
+		return fmt.Errorf("%w: %g", ErrSpeed, options.Speed)
+	}
+
+	return nil
+}
+
+// resampler changes the playback speed of a mono sample stream by linear
+// interpolation, keeping state across chunks.
+type resampler struct {
+	// step is the number of input samples per output sample.
+	step float64
+	// pos is the input position of the next output sample, relative to the
+	// first sample of the next chunk. It is in [-1, 0) once started: -1
+	// designates prev.
+	pos  float64
+	prev int16
+	out  []int16
+}
+
+// resample returns the output samples computable from chunk. The returned
+// slice is only valid until the next call.
+func (r *resampler) resample(chunk []int16) []int16 {
+	r.out = r.out[:0]
+
+	if len(chunk) == 0 {
+		return r.out
+	}
+
+	at := func(idx int) float64 {
+		if idx < 0 {
+			return float64(r.prev)
+		}
+
+		return float64(chunk[idx])
+	}
+
+	last := float64(len(chunk) - 1)
+
+	for ; r.pos <= last; r.pos += r.step {
+		idx := int(math.Floor(r.pos))
+		frac := r.pos - float64(idx)
+
+		value := at(idx)
+		if frac > 0 {
+			value += (at(idx+1) - value) * frac
+		}
+
+		r.out = append(r.out, int16(math.Round(value)))
+	}
+
+	r.pos -= float64(len(chunk))
+	r.prev = chunk[len(chunk)-1]
+
+	return r.out
+}
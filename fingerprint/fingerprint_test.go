@@ -0,0 +1,163 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fingerprint_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"testing/iotest"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/fingerprint"
+)
+
+// pcm encodes numSeconds of a varying tone as s16le bytes.
+func pcm(numSeconds int) []byte {
+	buf := make([]byte, 0, 2*11025*numSeconds)
+
+	for i := range 11025 * numSeconds {
+		freq := 220 + float64(i/2756%12)*40
+		sample := int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/11025))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(sample))
+	}
+
+	return buf
+}
+
+func TestStreamMatchesDirectFeed(t *testing.T) {
+	t.Parallel()
+
+	data := pcm(5)
+
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+
+	direct := chromaprint.New()
+	defer direct.Free()
+
+	if err := direct.Start(fingerprint.SampleRate, fingerprint.Channels); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	if err := direct.Feed(samples); err != nil {
+		t.Fatalf("Feed() failed: %v", err)
+	}
+
+	if err := direct.Finish(); err != nil {
+		t.Fatalf("Finish() failed: %v", err)
+	}
+
+	want, err := direct.Fingerprint()
+	if err != nil {
+		t.Fatalf("Fingerprint() failed: %v", err)
+	}
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	// Reuse the same context twice: Stream must restart it.
+	for range 2 {
+		result, err := fingerprint.Stream(chroma, bytes.NewReader(data), fingerprint.Options{})
+		if err != nil {
+			t.Fatalf("Stream() failed: %v", err)
+		}
+
+		if result.Fingerprint != want {
+			t.Errorf("Stream() fingerprint differs from direct feed:\n  got:  %s\n  want: %s", result.Fingerprint, want)
+		}
+
+		if result.Duration != 5 {
+			t.Errorf("Stream() duration = %f, want 5", result.Duration)
+		}
+	}
+}
+
+func TestStreamLength(t *testing.T) {
+	t.Parallel()
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	result, err := fingerprint.Stream(chroma, bytes.NewReader(pcm(5)), fingerprint.Options{Length: 2})
+	if err != nil {
+		t.Fatalf("Stream() failed: %v", err)
+	}
+
+	if result.Duration != 2 {
+		t.Errorf("Stream() duration = %f, want 2", result.Duration)
+	}
+}
+
+func TestStreamSpeed(t *testing.T) {
+	t.Parallel()
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	// Input played twice as fast: undoing it doubles the duration.
+	result, err := fingerprint.Stream(chroma, bytes.NewReader(pcm(4)), fingerprint.Options{Speed: 2})
+	if err != nil {
+		t.Fatalf("Stream() failed: %v", err)
+	}
+
+	if math.Abs(result.Duration-8) > 0.01 {
+		t.Errorf("Stream() duration = %f, want 8", result.Duration)
+	}
+}
+
+func TestStreamInvalidSpeed(t *testing.T) {
+	t.Parallel()
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	for _, speed := range []float64{-1, math.NaN()} {
+		_, err := fingerprint.Stream(chroma, bytes.NewReader(pcm(1)), fingerprint.Options{Speed: speed})
+		if !errors.Is(err, fingerprint.ErrSpeed) {
+			t.Errorf("Stream(speed %g) error = %v, want ErrSpeed", speed, err)
+		}
+	}
+}
+
+func TestStreamReadError(t *testing.T) {
+	t.Parallel()
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	_, err := fingerprint.Stream(chroma, iotest.ErrReader(errors.New("boom")), fingerprint.Options{})
+	if !errors.Is(err, fingerprint.ErrRead) {
+		t.Errorf("Stream() error = %v, want ErrRead", err)
+	}
+}
+
+func TestStreamFreed(t *testing.T) {
+	t.Parallel()
+
+	chroma := chromaprint.New()
+	chroma.Free()
+
+	_, err := fingerprint.Stream(chroma, bytes.NewReader(pcm(1)), fingerprint.Options{})
+	if !errors.Is(err, chromaprint.ErrFreed) {
+		t.Errorf("Stream() error = %v, want ErrFreed", err)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fingerprint
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatExtensible = 0xFFFE
+	wavBitsPerSample    = 16
+	// wavFormatSize is the size of the fields read from a "fmt " chunk.
+	wavFormatSize = 16
+	// wavExtensibleSize is the size read from a WAVE_FORMAT_EXTENSIBLE "fmt "
+	// chunk, up to its sub-format tag.
+	wavExtensibleSize = 26
+	// wavUnknownSize is the data size written by streaming encoders.
+	wavUnknownSize = 0xFFFFFFFF
+	// wavHeaderSize is the size of the RIFF header and of a chunk header.
+	wavHeaderSize = 12
+	chunkHeader   = 8
+)
+
+// ErrWAV happens when WAV input is malformed or not in the format Chromaprint
+// works with.
+var ErrWAV = errors.New("fingerprint: invalid WAV")
+
+// ReadWAV parses the header of a WAV stream and returns a reader of its PCM
+// data. The audio must be 16-bit PCM at [SampleRate] Hz with [Channels]
+// channel: like [Stream], it does not convert anything (see README).
+func ReadWAV(reader io.Reader) (io.Reader, error) {
+	var header [wavHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWAV, err)
+	}
+
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return nil, fmt.Errorf("%w: not a RIFF WAVE stream", ErrWAV)
+	}
+
+	formatSeen := false
+
+	for {
+		var chunk [chunkHeader]byte
+		if _, err := io.ReadFull(reader, chunk[:]); err != nil {
+			return nil, fmt.Errorf("%w: no data chunk: %w", ErrWAV, err)
+		}
+
+		id, size := string(chunk[:4]), binary.LittleEndian.Uint32(chunk[4:])
+
+		switch {
+		case id == "fmt ":
+			if err := readWAVFormat(reader, size); err != nil {
+				return nil, err
+			}
+
+			formatSeen = true
+		case id == "data" && !formatSeen:
+			return nil, fmt.Errorf("%w: data before format", ErrWAV)
+		case id == "data":
+			if size == wavUnknownSize || size == 0 {
+				return reader, nil
+			}
+
+			return io.LimitReader(reader, int64(size)), nil
+		default:
+			// Chunks are padded to an even size.
+			if _, err := io.CopyN(io.Discard, reader, int64(size)+int64(size%2)); err != nil {
+				return nil, fmt.Errorf("%w: chunk %q: %w", ErrWAV, id, err)
+			}
+		}
+	}
+}
+
+func readWAVFormat(reader io.Reader, size uint32) error {
+	if size < wavFormatSize {
+		return fmt.Errorf("%w: format chunk too short", ErrWAV)
+	}
+
+	// The size comes from the input: read what is needed and skip the rest.
+	chunk := make([]byte, min(size, wavExtensibleSize))
+	if _, err := io.ReadFull(reader, chunk); err != nil {
+		return fmt.Errorf("%w: format chunk: %w", ErrWAV, err)
+	}
+
+	if _, err := io.CopyN(io.Discard, reader, int64(size)+int64(size%2)-int64(len(chunk))); err != nil {
+		return fmt.Errorf("%w: format chunk: %w", ErrWAV, err)
+	}
+
+	tag := binary.LittleEndian.Uint16(chunk)
+	channels := binary.LittleEndian.Uint16(chunk[2:])
+	rate := binary.LittleEndian.Uint32(chunk[4:])
+	bits := binary.LittleEndian.Uint16(chunk[14:])
+
+	// WAVE_FORMAT_EXTENSIBLE carries the actual format at the start of its
+	// sub-format GUID.
+	const subFormatOffset = 24
+	if tag == wavFormatExtensible && len(chunk) >= subFormatOffset+2 {
+		tag = binary.LittleEndian.Uint16(chunk[subFormatOffset:])
+	}
+
+	if tag != wavFormatPCM || bits != wavBitsPerSample || channels != Channels || rate != SampleRate {
+		return fmt.Errorf("%w: got format %d, %d bits, %d channels, %d Hz, want PCM, %d bits, %d channel, %d Hz",
+			ErrWAV, tag, bits, channels, rate, wavBitsPerSample, Channels, SampleRate)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fingerprint_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/fingerprint"
+)
+
+// wavExtraFormat is the size of a "fmt " chunk with extra bytes, odd to check
+// the padding.
+const wavExtraFormat = 41
+
+// wav wraps data in a WAV header with the given format, and an odd-sized
+// LIST chunk before the data.
+func wav(data []byte, channels, rate int) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(0))
+	buf.WriteString("WAVE")
+
+	buf.WriteString("fmt ")
+	for _, field := range []any{
+		uint32(16), uint16(1), uint16(channels), uint32(rate),
+		uint32(rate * channels * 2), uint16(channels * 2), uint16(16),
+	} {
+		_ = binary.Write(&buf, binary.LittleEndian, field)
+	}
+
+	buf.WriteString("LIST")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(3))
+	buf.WriteString("abc\x00")
+
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+
+	// Trailing chunks after the data are not audio.
+	buf.WriteString("id3 \x00\x00\x00\x00")
+
+	return buf.Bytes()
+}
+
+func TestReadWAV(t *testing.T) {
+	t.Parallel()
+
+	data := pcm(1)
+
+	reader, err := fingerprint.ReadWAV(bytes.NewReader(wav(data, 1, 11025)))
+	if err != nil {
+		t.Fatalf("ReadWAV() failed: %v", err)
+	}
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading data failed: %v", err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadWAV() data is %d bytes, want the %d PCM bytes", len(got), len(data))
+	}
+}
+
+// fmtChunk returns a WAV header up to a "fmt " chunk declaring size bytes, of
+// which only the 16 bytes of a valid format follow.
+func fmtChunk(size uint32) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+
+	for _, field := range []any{size, uint16(1), uint16(1), uint32(11025), uint32(22050), uint16(2), uint16(16)} {
+		_ = binary.Write(&buf, binary.LittleEndian, field)
+	}
+
+	return buf.Bytes()
+}
+
+func TestReadWAVFormatSize(t *testing.T) {
+	t.Parallel()
+
+	var before, after runtime.MemStats
+
+	runtime.ReadMemStats(&before)
+
+	// The declared size is skipped, not allocated.
+	if _, err := fingerprint.ReadWAV(bytes.NewReader(fmtChunk(0xFFFFFFF0))); !errors.Is(err, fingerprint.ErrWAV) {
+		t.Errorf("ReadWAV(huge format chunk) = %v, want ErrWAV", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("ReadWAV(huge format chunk) allocated %d bytes", allocated)
+	}
+
+	// Extra format bytes are skipped, with the padding byte.
+	input := fmtChunk(wavExtraFormat)
+	input = append(input, make([]byte, wavExtraFormat-16+1)...)
+	input = append(input, "data\x02\x00\x00\x00\x01\x02"...)
+
+	reader, err := fingerprint.ReadWAV(bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadWAV(long format chunk) failed: %v", err)
+	}
+
+	if data, _ := io.ReadAll(reader); !bytes.Equal(data, []byte{1, 2}) {
+		t.Errorf("ReadWAV(long format chunk) data = %v, want [1 2]", data)
+	}
+}
+
+func TestReadWAVInvalid(t *testing.T) {
+	t.Parallel()
+
+	for name, input := range map[string][]byte{
+		"stereo": wav(pcm(1), 2, 11025),
+		"44100":  wav(pcm(1), 1, 44100),
+		"raw":    pcm(1),
+		"empty":  nil,
+		"short":  fmtChunk(8),
+	} {
+		if _, err := fingerprint.ReadWAV(bytes.NewReader(input)); !errors.Is(err, fingerprint.ErrWAV) {
+			t.Errorf("ReadWAV(%s) = %v, want ErrWAV", name, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package index provides one-to-many fingerprint search.
+//
+// An [Index] maps hash values to the (track, position) pairs where they occur.
+// A query looks up each of its hashes, lets every hit vote for a track and an
+// alignment offset, then rescores the most voted tracks with a
+// [github.com/mycophonic/sporeprint/compare.Matcher].
+//
+// Postings live in immutable [Segment] values, either built in memory or read
+// in place from segment files. An index is a list of segments published as a
+// [Snapshot]: searches never block, and see a consistent set of tracks while
+// writers add more.
+//
+// For very large catalogs, [Options.LSH] replaces voting by a
+// locality-sensitive hashing prefilter over windows of the stored
+// fingerprints, trading a little recall for a lookup cost independent of
+// posting list lengths. The
+// BenchmarkIndexSearch and BenchmarkLSHSearch benchmarks report latency,
+// recall@10 and precision of both at several catalog sizes.
+//
+// All functions work on raw fingerprints, as returned by
+// [github.com/mycophonic/sporeprint/chromaprint.Decode].
+package index
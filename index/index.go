@@ -0,0 +1,393 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+const (
+	// FullMask indexes hashes with all their bits.
+	FullMask uint32 = 0xFFFFFFFF
+
+	// StableMask drops the 4 least significant bits (the last 2 of the 16
+	// Chromaprint classifiers), which makes lookups tolerant to their flips
+	// at the cost of longer posting lists.
+	StableMask uint32 = 0xFFFFFFF0
+
+	// DefaultCandidates is the default number of most voted tracks rescored
+	// by [Index.Search].
+	DefaultCandidates = 32
+
+	// DefaultMinVotes is the default minimum number of votes a track needs at
+	// a single offset to be rescored. One vote is often a chance collision.
+	DefaultMinVotes = 2
+)
+
+var (
+	// ErrDuplicateID happens when adding a track ID that is already indexed.
+	ErrDuplicateID = errors.New("index: duplicate track ID")
+	// ErrEmpty happens when adding an empty fingerprint.
+	ErrEmpty = errors.New("index: empty fingerprint")
+)
+
+// Options controls an [Index]. The zero value is usable.
+type Options struct {
+	// Mask is applied to hashes before indexing and lookup. Zero means FullMask.
+	Mask uint32
+	// Candidates is the number of most voted tracks rescored per query.
+	// Zero means DefaultCandidates.
+	Candidates int
+	// MinVotes is the minimum number of votes at a single offset for a track
+	// to be rescored. Zero means DefaultMinVotes.
+	MinVotes int
+	// Matcher rescores candidates. Nil means [compare.Bounded], which only
+	// finds queries aligned within MaxAlignOffset of the track start: use
+	// [compare.Global] for excerpts taken further into tracks.
+	Matcher compare.Matcher
+	// Threshold is the minimum rescored score for a track to be returned.
+	Threshold float64
+	// LSH, when set, selects the candidates rescored with a
+	// locality-sensitive hashing prefilter over windows of the fingerprints
+	// instead of voting over hash postings. Lookups no longer depend on
+	// posting list lengths, at the cost of a little recall (see
+	// [LSHOptions]), and of sketching every segment when attached. MinVotes
+	// does not apply.
+	LSH *LSHOptions
+}
+
+// Match is a search result.
+type Match struct {
+	// ID is the track ID given to [Index.Add].
+	ID string
+	// Score is the rescored similarity of the query with the track.
+	Score float64
+	// Offset is the alignment offset of the query (fp1) relative to the track
+	// (fp2), as [compare.WithOffset].
+	Offset int
+	// Votes is the number of query hashes found in the track at the best
+	// voted offset, or with [Options.LSH], the number of query band keys
+	// found in the track.
+	Votes int
+}
+
+// vote is a (track, offset) pair accumulating votes.
+type vote struct {
+	track  int32
+	offset int32
+}
+
+// Index is an inverted index of fingerprints, made of immutable segments:
+// segments attached with [Index.AddSegment], and segments built in memory by
+// [Index.Add].
+//
+// An Index is safe for concurrent use. Every search runs on the snapshot
+// current when it started, and never waits for writers. Writers are
+// serialized, and publish a new snapshot when done.
+type Index struct {
+	options Options
+	// mu serializes writers.
+	mu       sync.Mutex
+	current  atomic.Pointer[Snapshot]
+	sketcher *sketcher
+}
+
+// Snapshot is an immutable view of an [Index].
+type Snapshot struct {
+	options  *Options
+	sketcher *sketcher
+	segments []attached
+	// total is the number of live tracks.
+	total int
+}
+
+// attached is a segment of a snapshot.
+type attached struct {
+	segment *Segment
+	// deleted holds the numbers of tombstoned tracks.
+	deleted map[int]bool
+	// owned segments were built by Add, and are merged by later adds.
+	owned bool
+	// bands is the band table of the segment with [Options.LSH].
+	bands bandTable
+}
+
+// New creates an empty index.
+func New(options Options) *Index {
+	if options.Mask == 0 {
+		options.Mask = FullMask
+	}
+
+	if options.Candidates <= 0 {
+		options.Candidates = DefaultCandidates
+	}
+
+	if options.MinVotes <= 0 {
+		options.MinVotes = DefaultMinVotes
+	}
+
+	if options.Matcher == nil {
+		options.Matcher = compare.Bounded{}
+	}
+
+	idx := &Index{options: options}
+	if options.LSH != nil {
+		idx.sketcher = newSketcher(*options.LSH)
+	}
+
+	idx.current.Store(&Snapshot{options: &idx.options, sketcher: idx.sketcher})
+
+	return idx
+}
+
+// Snapshot returns the current snapshot. Later writes do not affect it.
+func (idx *Index) Snapshot() *Snapshot {
+	return idx.current.Load()
+}
+
+// Add indexes a raw fingerprint under a unique track ID.
+//
+// The fingerprint is encoded in a new in-memory segment. Consecutive
+// in-memory segments of similar sizes are then merged, as a binary counter
+// would carry, which keeps their count logarithmic in the number of adds.
+func (idx *Index) Add(id string, raw []uint32) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("%w: %q", ErrEmpty, id)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	snap := idx.current.Load()
+	if snap.Contains(id) {
+		return fmt.Errorf("%w: %q", ErrDuplicateID, id)
+	}
+
+	segments := slices.Clone(snap.segments)
+	tracks := []Track{{ID: id, Raw: raw}}
+
+	for {
+		last := len(segments) - 1
+		if last < 0 || !segments[last].owned || segments[last].segment.Len() > len(tracks) {
+			break
+		}
+
+		merged := segments[last].segment
+		for num := range merged.Len() {
+			tracks = append(tracks, merged.Track(num))
+		}
+
+		segments = segments[:last]
+	}
+
+	data, err := EncodeSegment(tracks, idx.options.Mask)
+	if err != nil {
+		return err
+	}
+
+	segment, err := OpenSegment(data)
+	if err != nil {
+		return err
+	}
+
+	idx.current.Store(&Snapshot{
+		options:  &idx.options,
+		sketcher: idx.sketcher,
+		segments: append(segments, idx.attach(segment, true)),
+		total:    snap.total + 1,
+	})
+
+	return nil
+}
+
+// AddSegment attaches a segment to the index. Tracks whose IDs are listed in
+// deleted (tombstones) are ignored. The other track IDs must not be indexed
+// already: checking it would read every ID, which defeats fast startup, so it
+// is left to the caller.
+func (idx *Index) AddSegment(segment *Segment, deleted ...string) {
+	seg := idx.attach(segment, false)
+
+	for _, id := range deleted {
+		if num, found := segment.Find(id); found {
+			if seg.deleted == nil {
+				seg.deleted = make(map[int]bool)
+			}
+
+			seg.deleted[num] = true
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	snap := idx.current.Load()
+	idx.current.Store(&Snapshot{
+		options:  &idx.options,
+		sketcher: idx.sketcher,
+		segments: append(slices.Clone(snap.segments), seg),
+		total:    snap.total + segment.Len() - len(seg.deleted),
+	})
+}
+
+// attach returns a segment ready to be added to a snapshot.
+func (idx *Index) attach(segment *Segment, owned bool) attached {
+	seg := attached{segment: segment, owned: owned}
+	if idx.sketcher != nil {
+		seg.bands = idx.sketcher.table(segment)
+	}
+
+	return seg
+}
+
+// Contains reports whether a track ID is indexed.
+func (idx *Index) Contains(id string) bool {
+	return idx.Snapshot().Contains(id)
+}
+
+// Len returns the number of indexed tracks.
+func (idx *Index) Len() int {
+	return idx.Snapshot().Len()
+}
+
+// Search searches the current snapshot, as [Snapshot.Search].
+func (idx *Index) Search(query []uint32) []Match {
+	return idx.Snapshot().Search(query)
+}
+
+// Contains reports whether a track ID is in the snapshot.
+func (snap *Snapshot) Contains(id string) bool {
+	for _, seg := range snap.segments {
+		if num, found := seg.segment.Find(id); found && !seg.deleted[num] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of tracks in the snapshot.
+func (snap *Snapshot) Len() int {
+	return snap.total
+}
+
+// Search returns the tracks of the snapshot matching a raw query fingerprint, best
+// first.
+//
+// Every query hash looks up its postings and votes for the (track, offset)
+// pairs it hits, or with [Options.LSH], the query band keys hit tracks. The
+// tracks with the most votes at a single offset (or hits) are then rescored
+// against the whole query with the configured matcher.
+func (snap *Snapshot) Search(query []uint32) []Match {
+	options := snap.options
+
+	var best map[int32]int
+	if snap.sketcher != nil {
+		best = snap.prefilter(query)
+	} else {
+		best = snap.vote(query)
+	}
+
+	candidates := make([]int32, 0, len(best))
+	for trackNum := range best {
+		candidates = append(candidates, trackNum)
+	}
+
+	slices.SortFunc(candidates, func(a, b int32) int {
+		return cmp.Or(cmp.Compare(best[b], best[a]), cmp.Compare(a, b))
+	})
+
+	candidates = candidates[:min(len(candidates), options.Candidates)]
+
+	matches := make([]Match, 0, len(candidates))
+
+	for _, trackNum := range candidates {
+		id, raw := snap.track(int(trackNum))
+
+		result := options.Matcher.Match(query, raw)
+		if result.Score < options.Threshold {
+			continue
+		}
+
+		matches = append(matches, Match{
+			ID:     id,
+			Score:  result.Score,
+			Offset: result.Offset,
+			Votes:  best[trackNum],
+		})
+	}
+
+	slices.SortStableFunc(matches, func(a, b Match) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return matches
+}
+
+// vote returns the best vote count at a single offset of every track of the
+// snapshot with at least MinVotes, numbered as in Search.
+func (snap *Snapshot) vote(query []uint32) map[int32]int {
+	votes := make(map[vote]int)
+
+	for queryPos, hash := range query {
+		// Tracks are numbered across segments, in order.
+		base := 0
+
+		for _, seg := range snap.segments {
+			seg.segment.lookup(hash&seg.segment.Mask(), func(num, position int) {
+				if !seg.deleted[num] {
+					//nolint:gosec // track count and fingerprint lengths stay far below 2^31
+					votes[vote{track: int32(base + num), offset: int32(queryPos - position)}]++
+				}
+			})
+
+			base += seg.segment.Len()
+		}
+	}
+
+	// Best vote count per track.
+	best := make(map[int32]int)
+
+	for key, count := range votes {
+		if count >= snap.options.MinVotes && count > best[key.track] {
+			best[key.track] = count
+		}
+	}
+
+	return best
+}
+
+// track returns the ID and raw fingerprint of a track, numbered as in Search.
+func (snap *Snapshot) track(num int) (string, []uint32) {
+	for _, seg := range snap.segments {
+		if num < seg.segment.Len() {
+			track := seg.segment.Track(num)
+
+			return track.ID, track.Raw
+		}
+
+		num -= seg.segment.Len()
+	}
+
+	panic("index: track number out of range")
+}
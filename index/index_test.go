@@ -0,0 +1,247 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/index"
+)
+
+// syntheticFingerprint returns a deterministic random raw fingerprint.
+// Random hashes are a pessimistic model of real ones: no two tracks share
+// anything by chance.
+func syntheticFingerprint(seed uint64, length int) []uint32 {
+	rng := rand.New(rand.NewPCG(seed, 0))
+
+	raw := make([]uint32, length)
+	for i := range raw {
+		raw[i] = rng.Uint32()
+	}
+
+	return raw
+}
+
+// degrade returns an excerpt of raw starting at start, with one random bit
+// flipped in one hash out of every flipEvery, as lossy encoding would do.
+func degrade(raw []uint32, start, length, flipEvery int) []uint32 {
+	rng := rand.New(rand.NewPCG(uint64(start), uint64(length)))
+
+	excerpt := make([]uint32, length)
+	copy(excerpt, raw[start:start+length])
+
+	for i := range excerpt {
+		if rng.IntN(flipEvery) == 0 {
+			excerpt[i] ^= 1 << rng.IntN(32)
+		}
+	}
+
+	return excerpt
+}
+
+func populate(t *testing.T, idx *index.Index, count, length int) [][]uint32 {
+	t.Helper()
+
+	raws := make([][]uint32, count)
+	for i := range raws {
+		raws[i] = syntheticFingerprint(uint64(i), length)
+
+		if err := idx.Add(fmt.Sprintf("track-%d", i), raws[i]); err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+	}
+
+	return raws
+}
+
+func TestSearchFindsTrack(t *testing.T) {
+	t.Parallel()
+
+	idx := index.New(index.Options{})
+	raws := populate(t, idx, 200, 1000)
+
+	if idx.Len() != 200 {
+		t.Fatalf("Len() = %d, want 200", idx.Len())
+	}
+
+	query := degrade(raws[42], 50, 300, 3)
+
+	matches := idx.Search(query)
+	if len(matches) == 0 {
+		t.Fatal("Search() found nothing")
+	}
+
+	if matches[0].ID != "track-42" {
+		t.Errorf("best match = %q, want track-42", matches[0].ID)
+	}
+
+	if matches[0].Score < 0.9 {
+		t.Errorf("best match score = %f, want at least 0.9", matches[0].Score)
+	}
+
+	// The query starts 50 hashes into the track.
+	if matches[0].Offset != -50 {
+		t.Errorf("best match offset = %d, want -50", matches[0].Offset)
+	}
+}
+
+func TestSearchRanksByScore(t *testing.T) {
+	t.Parallel()
+
+	idx := index.New(index.Options{})
+	raws := populate(t, idx, 10, 500)
+
+	// A second track sharing half of track-3 must rank below track-3 itself.
+	half := append(append([]uint32{}, raws[3][:250]...), syntheticFingerprint(1000, 250)...)
+	if err := idx.Add("half", half); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	matches := idx.Search(raws[3])
+	if len(matches) < 2 {
+		t.Fatalf("Search() found %d matches, want at least 2", len(matches))
+	}
+
+	if matches[0].ID != "track-3" || matches[1].ID != "half" {
+		t.Errorf("Search() ranking = %s, %s; want track-3, half", matches[0].ID, matches[1].ID)
+	}
+
+	if matches[0].Score < matches[1].Score {
+		t.Errorf("matches not sorted by score: %+v", matches)
+	}
+}
+
+func TestSearchUnknownTrack(t *testing.T) {
+	t.Parallel()
+
+	idx := index.New(index.Options{Threshold: 0.4})
+	populate(t, idx, 50, 500)
+
+	if matches := idx.Search(syntheticFingerprint(9999, 300)); len(matches) != 0 {
+		t.Errorf("unknown track should not match, got %+v", matches)
+	}
+}
+
+func TestSearchStableMask(t *testing.T) {
+	t.Parallel()
+
+	idx := index.New(index.Options{Mask: index.StableMask})
+	raws := populate(t, idx, 20, 500)
+
+	// Flip low bits only: exact lookups would miss most hashes.
+	query := make([]uint32, len(raws[7]))
+	for i, hash := range raws[7] {
+		query[i] = hash ^ uint32(i%4)
+	}
+
+	matches := idx.Search(query)
+	if len(matches) == 0 || matches[0].ID != "track-7" {
+		t.Errorf("Search() with stable mask should find track-7, got %+v", matches)
+	}
+}
+
+func TestAddErrors(t *testing.T) {
+	t.Parallel()
+
+	idx := index.New(index.Options{})
+
+	if err := idx.Add("empty", nil); !errors.Is(err, index.ErrEmpty) {
+		t.Errorf("Add(empty) = %v, want ErrEmpty", err)
+	}
+
+	if err := idx.Add("a", []uint32{1, 2, 3}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if err := idx.Add("a", []uint32{4, 5, 6}); !errors.Is(err, index.ErrDuplicateID) {
+		t.Errorf("Add(duplicate) = %v, want ErrDuplicateID", err)
+	}
+}
+
+func TestConcurrentAddSearch(t *testing.T) {
+	t.Parallel()
+
+	const (
+		writers         = 4
+		tracksPerWriter = 50
+		readers         = 8
+	)
+
+	idx := index.New(index.Options{})
+	raws := populate(t, idx, 20, 300)
+
+	var (
+		writing sync.WaitGroup
+		reading sync.WaitGroup
+		done    atomic.Bool
+	)
+
+	for writer := range writers {
+		writing.Go(func() {
+			for i := range tracksPerWriter {
+				seed := uint64(1000 + writer*tracksPerWriter + i)
+				if err := idx.Add(fmt.Sprintf("added-%d", seed), syntheticFingerprint(seed, 300)); err != nil {
+					t.Errorf("Add() failed: %v", err)
+				}
+			}
+		})
+	}
+
+	for reader := range readers {
+		reading.Go(func() {
+			for !done.Load() {
+				snap := idx.Snapshot()
+				size := snap.Len()
+
+				// Tracks present before the writers started are always found.
+				want := reader % len(raws)
+				if matches := snap.Search(raws[want]); len(matches) == 0 || matches[0].ID != fmt.Sprintf("track-%d", want) {
+					t.Errorf("Search() = %+v, want track-%d", matches, want)
+				}
+
+				// A snapshot never changes.
+				if snap.Len() != size {
+					t.Errorf("snapshot Len() changed from %d to %d", size, snap.Len())
+				}
+
+				if idx.Len() < size {
+					t.Errorf("Len() went back from %d to %d", size, idx.Len())
+				}
+			}
+		})
+	}
+
+	writing.Wait()
+	done.Store(true)
+	reading.Wait()
+
+	if want := 20 + writers*tracksPerWriter; idx.Len() != want {
+		t.Fatalf("Len() = %d, want %d", idx.Len(), want)
+	}
+
+	// Every concurrently added track is searchable.
+	for seed := uint64(1000); seed < 1000+writers*tracksPerWriter; seed += 7 {
+		if matches := idx.Search(syntheticFingerprint(seed, 300)); len(matches) == 0 || matches[0].ID != fmt.Sprintf("added-%d", seed) {
+			t.Errorf("Search(added-%d) = %+v", seed, matches)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Defaults of [LSHOptions], tuned with synthetic fingerprints so that
+// excerpts scoring at least 0.4 against their track (the match threshold of
+// the command line tools) are found, see TestLSHRecallAtThreshold.
+const (
+	// DefaultLSHWindow is the default window size in hashes, about 8 seconds.
+	DefaultLSHWindow = 64
+	// DefaultLSHBands is the default number of bands per window.
+	DefaultLSHBands = 16
+	// DefaultLSHRows is the default number of MinHash values per band.
+	DefaultLSHRows = 2
+	// DefaultLSHBandBits is the default number of hash bits a band looks at.
+	DefaultLSHBandBits = 24
+
+	bitsPerHash = 32
+)
+
+// LSHOptions controls the prefilter of an [Index], see [Options.LSH]. The
+// zero value is usable.
+type LSHOptions struct {
+	// Window is the number of consecutive hashes sketched together. Zero
+	// means DefaultLSHWindow.
+	Window int
+	// Step is the distance in hashes between two indexed windows. Zero
+	// means half a window.
+	Step int
+	// QueryStep is the distance in hashes between two query windows. Zero
+	// means an eighth of a window: the closer query windows are to indexed
+	// ones, the more they share.
+	QueryStep int
+	// Bands is the number of independent sketches per window. More bands
+	// raise recall and memory. Zero means DefaultLSHBands.
+	Bands int
+	// Rows is the number of MinHash values a band key combines. More rows
+	// raise precision and lower recall. Zero means DefaultLSHRows.
+	Rows int
+	// BandBits is the number of bits of each hash a band looks at, so that
+	// bit errors outside of them do not matter. Zero means
+	// DefaultLSHBandBits.
+	BandBits int
+	// Seed selects the band bits and MinHash functions. Sketches are only
+	// comparable with the same seed.
+	Seed uint64
+}
+
+// sketcher is a locality-sensitive hashing prefilter over raw fingerprints,
+// for catalogs where voting over every hash posting is too slow.
+//
+// Fingerprints are cut into overlapping windows. Each window is sketched into
+// band keys: every band keeps a random subset of the bits of each hash, takes
+// the MinHash of the resulting set with a few hash functions, and combines
+// them into a key. Windows sharing many hashes likely share a key. A query
+// looks up the keys of its own windows, and the tracks it hits most are
+// rescored with the matcher.
+type sketcher struct {
+	options   LSHOptions
+	bandMasks []uint32
+	seeds     []uint64
+}
+
+// bandTable maps the band keys of a segment to the numbers of the tracks
+// having them.
+type bandTable map[uint64][]int32
+
+func newSketcher(options LSHOptions) *sketcher {
+	if options.Window <= 0 {
+		options.Window = DefaultLSHWindow
+	}
+
+	if options.Step <= 0 {
+		options.Step = max(1, options.Window/2) //nolint:mnd
+	}
+
+	if options.QueryStep <= 0 {
+		options.QueryStep = max(1, options.Window/8) //nolint:mnd
+	}
+
+	if options.Bands <= 0 {
+		options.Bands = DefaultLSHBands
+	}
+
+	if options.Rows <= 0 {
+		options.Rows = DefaultLSHRows
+	}
+
+	if options.BandBits <= 0 || options.BandBits > bitsPerHash {
+		options.BandBits = DefaultLSHBandBits
+	}
+
+	sk := &sketcher{
+		options:   options,
+		bandMasks: make([]uint32, options.Bands),
+		seeds:     make([]uint64, options.Bands*options.Rows),
+	}
+
+	rng := rand.New(rand.NewPCG(options.Seed, 0)) //nolint:gosec // not security sensitive
+
+	for band := range sk.bandMasks {
+		for _, bit := range rng.Perm(bitsPerHash)[:options.BandBits] {
+			sk.bandMasks[band] |= 1 << bit
+		}
+	}
+
+	for i := range sk.seeds {
+		sk.seeds[i] = rng.Uint64()
+	}
+
+	return sk
+}
+
+// table returns the band table of a segment. It is computed once per segment
+// and options, so that reloading a database does not sketch its segments
+// again.
+func (sk *sketcher) table(segment *Segment) bandTable {
+	if table, found := segment.sketches.Load(sk.options); found {
+		return table.(bandTable) //nolint:forcetypeassert // Only band tables are stored.
+	}
+
+	table := make(bandTable)
+
+	for num := range segment.Len() {
+		//nolint:gosec // track count stays far below 2^31
+		trackNum := int32(num)
+
+		for _, key := range sk.sketch(segment.Track(num).Raw, sk.options.Step) {
+			// Tracks are sketched in order, so a repeated key is at the end.
+			if bucket := table[key]; len(bucket) == 0 || bucket[len(bucket)-1] != trackNum {
+				table[key] = append(bucket, trackNum)
+			}
+		}
+	}
+
+	stored, _ := segment.sketches.LoadOrStore(sk.options, table)
+
+	return stored.(bandTable) //nolint:forcetypeassert // Only band tables are stored.
+}
+
+// prefilter returns the number of query band keys hit by every track of the
+// snapshot, numbered as in [Snapshot.Search].
+func (snap *Snapshot) prefilter(query []uint32) map[int32]int {
+	keys := snap.sketcher.sketch(query, snap.sketcher.options.QueryStep)
+	hits := make(map[int32]int)
+	base := 0
+
+	for _, seg := range snap.segments {
+		for _, key := range keys {
+			for _, num := range seg.bands[key] {
+				if !seg.deleted[int(num)] {
+					//nolint:gosec // track count stays far below 2^31
+					hits[int32(base)+num]++
+				}
+			}
+		}
+
+		base += seg.segment.Len()
+	}
+
+	return hits
+}
+
+// sketch returns the band keys of the windows of raw, step hashes apart.
+// A fingerprint shorter than a window is sketched as a single window.
+func (sk *sketcher) sketch(raw []uint32, step int) []uint64 {
+	window := min(sk.options.Window, len(raw))
+	if window == 0 {
+		return nil
+	}
+
+	keys := make([]uint64, 0, ((len(raw)-window)/step+1)*sk.options.Bands)
+	minima := make([]uint64, sk.options.Rows)
+
+	for start := 0; start+window <= len(raw); start += step {
+		for band, mask := range sk.bandMasks {
+			seeds := sk.seeds[band*sk.options.Rows : (band+1)*sk.options.Rows]
+
+			for row := range minima {
+				minima[row] = math.MaxUint64
+			}
+
+			for _, hash := range raw[start : start+window] {
+				value := uint64(hash & mask)
+
+				for row, seed := range seeds {
+					minima[row] = min(minima[row], mix64(value^seed))
+				}
+			}
+
+			key := mix64(uint64(band))
+			for _, minimum := range minima {
+				key = mix64(key ^ minimum)
+			}
+
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// mix64 is the splitmix64 finalizer, a fast bijective 64-bit hash.
+func mix64(value uint64) uint64 {
+	value ^= value >> 30        //nolint:mnd
+	value *= 0xbf58476d1ce4e5b9 //nolint:mnd
+	value ^= value >> 27        //nolint:mnd
+	value *= 0x94d049bb133111eb //nolint:mnd
+	value ^= value >> 31        //nolint:mnd
+
+	return value
+}
@@ -0,0 +1,260 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/index"
+)
+
+// matchThreshold is the match threshold of the command line tools.
+const matchThreshold = 0.4
+
+// corrupt returns an excerpt of raw where a fraction of the hashes is
+// replaced by random values, and a quarter of the others has one bit
+// flipped. Replaced hashes no longer match, flipped ones still do, so the
+// excerpt scores about 1-replaced against raw.
+func corrupt(raw []uint32, start, length int, replaced float64, seed uint64) []uint32 {
+	rng := rand.New(rand.NewPCG(seed, 1))
+
+	excerpt := make([]uint32, length)
+	copy(excerpt, raw[start:start+length])
+
+	for i := range excerpt {
+		switch {
+		case rng.Float64() < replaced:
+			excerpt[i] = rng.Uint32()
+		case rng.IntN(4) == 0:
+			excerpt[i] ^= 1 << rng.IntN(32)
+		}
+	}
+
+	return excerpt
+}
+
+// lshIndex returns an index with the default LSH prefilter.
+func lshIndex() *index.Index {
+	return index.New(index.Options{Matcher: compare.Global{}, Threshold: matchThreshold, LSH: &index.LSHOptions{}})
+}
+
+// TestLSHRecallAtThreshold checks the defaults: excerpts scoring at least
+// matchThreshold against their track must be found.
+func TestLSHRecallAtThreshold(t *testing.T) {
+	t.Parallel()
+
+	lsh := lshIndex()
+	raws := populate(t, lsh, 300, 600)
+	rng := rand.New(rand.NewPCG(42, 0))
+
+	eligible, found := 0, 0
+
+	for _, replaced := range []float64{0, 0.2, 0.4, 0.5, 0.55, 0.6} {
+		for query := range 40 {
+			target := rng.IntN(len(raws))
+			excerpt := corrupt(raws[target], rng.IntN(350), 250, replaced, uint64(query))
+
+			if (compare.Global{}).Match(excerpt, raws[target]).Score < matchThreshold {
+				continue
+			}
+
+			eligible++
+
+			if matches := lsh.Search(excerpt); len(matches) > 0 && matches[0].ID == fmt.Sprintf("track-%d", target) {
+				found++
+			}
+		}
+	}
+
+	if eligible < 150 {
+		t.Fatalf("only %d excerpts above the threshold, the test is not meaningful", eligible)
+	}
+
+	if recall := float64(found) / float64(eligible); recall < 0.95 {
+		t.Errorf("recall = %.3f (%d/%d), want at least 0.95", recall, found, eligible)
+	}
+}
+
+func TestLSHPrecision(t *testing.T) {
+	t.Parallel()
+
+	lsh := lshIndex()
+	populate(t, lsh, 300, 600)
+
+	for query := range 50 {
+		if matches := lsh.Search(syntheticFingerprint(uint64(10000+query), 250)); len(matches) != 0 {
+			t.Errorf("unknown track should not match, got %+v", matches)
+		}
+	}
+}
+
+func TestLSHSegment(t *testing.T) {
+	t.Parallel()
+
+	segment, tracks := buildSegment(t, 50, 400, index.FullMask)
+
+	lsh := lshIndex()
+	lsh.AddSegment(segment, tracks[3].ID)
+
+	if lsh.Len() != 49 {
+		t.Errorf("Len() = %d, want 49", lsh.Len())
+	}
+
+	matches := lsh.Search(degrade(tracks[7].Raw, 120, 200, 3))
+	if len(matches) == 0 || matches[0].ID != tracks[7].ID || matches[0].Offset != -120 {
+		t.Errorf("Search() = %+v, want %s at offset -120", matches, tracks[7].ID)
+	}
+
+	if matches = lsh.Search(tracks[3].Raw); len(matches) != 0 && matches[0].ID == tracks[3].ID {
+		t.Errorf("Search() found a deleted track: %+v", matches)
+	}
+}
+
+// TestLSHPrefilter checks that searches select candidates with the prefilter:
+// no track of 400 hashes gets 1000 votes, so voting finds nothing.
+func TestLSHPrefilter(t *testing.T) {
+	t.Parallel()
+
+	segment, tracks := buildSegment(t, 50, 400, index.FullMask)
+	query := tracks[7].Raw[100:300]
+
+	for _, tc := range []struct {
+		lsh  *index.LSHOptions
+		want int
+	}{
+		{nil, 0},
+		{&index.LSHOptions{}, 1},
+	} {
+		idx := index.New(index.Options{Matcher: compare.Global{}, Threshold: matchThreshold, MinVotes: 1000, LSH: tc.lsh})
+		idx.AddSegment(segment)
+
+		matches := idx.Search(query)
+		if len(matches) != tc.want || (tc.want > 0 && matches[0].ID != tracks[7].ID) {
+			t.Errorf("LSH %v: Search() = %+v, want %d match(es) of %s", tc.lsh, matches, tc.want, tracks[7].ID)
+		}
+	}
+}
+
+// benchmarkSizes are the catalog sizes of the search benchmarks.
+//
+//nolint:gochecknoglobals // Test table.
+var benchmarkSizes = []int{1000, 10000, 50000}
+
+const (
+	benchmarkLength  = 400
+	benchmarkQueries = 64
+	recallAtK        = 10
+)
+
+// benchmarkCatalog returns synthetic tracks and corrupted excerpts of some of
+// them, scoring about 0.6 against their track.
+func benchmarkCatalog(size int) ([]index.Track, [][]uint32) {
+	tracks := make([]index.Track, size)
+	for i := range tracks {
+		tracks[i] = index.Track{ID: "track-" + strconv.Itoa(i), Raw: syntheticFingerprint(uint64(i), benchmarkLength)}
+	}
+
+	queries := make([][]uint32, benchmarkQueries)
+	for i := range queries {
+		queries[i] = corrupt(tracks[i*size/benchmarkQueries].Raw, 100, 200, 0.4, uint64(i))
+	}
+
+	return tracks, queries
+}
+
+// benchmarkSearch runs queries against search and reports latency (ns/op),
+// the fraction of queries finding their track in the top recallAtK, and the
+// fraction of returned matches that are that track.
+func benchmarkSearch(b *testing.B, size int, queries [][]uint32, search func([]uint32) []index.Match) {
+	b.Helper()
+
+	var queryCount, found, returned, correct int
+
+	for b.Loop() {
+		query := queryCount % len(queries)
+		want := "track-" + strconv.Itoa(query*size/benchmarkQueries)
+		matches := search(queries[query])
+
+		if slices.ContainsFunc(matches[:min(len(matches), recallAtK)], func(match index.Match) bool {
+			return match.ID == want
+		}) {
+			found++
+		}
+
+		returned += len(matches)
+
+		for _, match := range matches {
+			if match.ID == want {
+				correct++
+			}
+		}
+
+		queryCount++
+	}
+
+	b.ReportMetric(float64(found)/float64(queryCount), "recall@10")
+
+	if returned > 0 {
+		b.ReportMetric(float64(correct)/float64(returned), "precision")
+	}
+}
+
+// benchmarkIndex returns an index searching a segment of the tracks, with the
+// given prefilter options.
+func benchmarkIndex(b *testing.B, tracks []index.Track, lsh *index.LSHOptions) *index.Index {
+	b.Helper()
+
+	data, err := index.EncodeSegment(tracks, index.FullMask)
+	if err != nil {
+		b.Fatalf("EncodeSegment() failed: %v", err)
+	}
+
+	segment, err := index.OpenSegment(data)
+	if err != nil {
+		b.Fatalf("OpenSegment() failed: %v", err)
+	}
+
+	idx := index.New(index.Options{Matcher: compare.Global{}, Threshold: matchThreshold, LSH: lsh})
+	idx.AddSegment(segment)
+
+	return idx
+}
+
+// BenchmarkIndexSearch is the exhaustive voting baseline of BenchmarkLSHSearch.
+func BenchmarkIndexSearch(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			tracks, queries := benchmarkCatalog(size)
+			benchmarkSearch(b, size, queries, benchmarkIndex(b, tracks, nil).Search)
+		})
+	}
+}
+
+// BenchmarkLSHSearch searches through the prefilter of [index.Options.LSH].
+func BenchmarkLSHSearch(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			tracks, queries := benchmarkCatalog(size)
+			benchmarkSearch(b, size, queries, benchmarkIndex(b, tracks, &index.LSHOptions{}).Search)
+		})
+	}
+}
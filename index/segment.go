@@ -0,0 +1,356 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Segment binary format, version 1. All integers are little-endian uint32.
+//
+//	header     magic "SPIX", version, tracks, postings, mask, ids size, crc, 0
+//	tracks     per track: id offset, id length, raw start, raw length, duration (ms)
+//	order      track numbers sorted by ID
+//	ids        concatenated IDs, zero padded to 4 bytes
+//	raw        concatenated raw fingerprints
+//	keys       masked hash of every posting, sorted
+//	refs       raw index of every posting, in keys order
+//
+// The crc is CRC-32C over everything after the header. Every section is read
+// in place, so a memory-mapped segment is searchable without any decoding.
+const (
+	// SegmentVersion is the segment format version written by [EncodeSegment].
+	SegmentVersion = 1
+
+	segmentMagic    = "SPIX"
+	headerSize      = 32
+	trackEntrySize  = 20
+	wordSize        = 4
+	millisPerSecond = 1000
+)
+
+// ErrSegment happens when segment data is malformed or of an unknown version.
+var ErrSegment = errors.New("index: invalid segment")
+
+//nolint:gochecknoglobals // Immutable lookup table.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Track is a fingerprint with its metadata.
+type Track struct {
+	// ID uniquely identifies the track (a path, an MBID...).
+	ID string
+	// Duration is the length of the fingerprinted audio in seconds.
+	Duration float64
+	// Raw is the raw fingerprint, as returned by chromaprint.Decode.
+	Raw []uint32
+}
+
+// Segment is an immutable, searchable set of tracks backed by a byte slice in
+// the segment binary format, typically a memory-mapped file.
+type Segment struct {
+	data      []byte
+	tracks    int
+	postings  int
+	mask      uint32
+	orderOff  int
+	idsOff    int
+	rawOff    int
+	keysOff   int
+	refsOff   int
+	tracksOff int
+	// sketches caches the band tables of the LSH prefilter, by [LSHOptions].
+	sketches sync.Map
+}
+
+// EncodeSegment serializes tracks into the segment binary format. Hashes are
+// indexed with mask applied. IDs must be unique.
+func EncodeSegment(tracks []Track, mask uint32) ([]byte, error) {
+	order := make([]int, len(tracks))
+	for i := range order {
+		order[i] = i
+	}
+
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(tracks[a].ID, tracks[b].ID)
+	})
+
+	for i := 1; i < len(order); i++ {
+		if tracks[order[i]].ID == tracks[order[i-1]].ID {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateID, tracks[order[i]].ID)
+		}
+	}
+
+	idsSize, postings := 0, 0
+	for _, track := range tracks {
+		idsSize += len(track.ID)
+		postings += len(track.Raw)
+	}
+
+	idsSize = (idsSize + wordSize - 1) / wordSize * wordSize
+
+	layout := Segment{tracks: len(tracks), postings: postings}
+	layout.computeOffsets(idsSize)
+
+	size := layout.refsOff + postings*wordSize
+	if size > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d bytes exceed the format limit", ErrSegment, size)
+	}
+
+	data := make([]byte, size)
+	copy(data, segmentMagic)
+
+	put := func(off, value int) {
+		//nolint:gosec // size is checked above, so every value fits in uint32
+		binary.LittleEndian.PutUint32(data[off:], uint32(value))
+	}
+
+	put(4, SegmentVersion)                         //nolint:mnd
+	put(8, len(tracks))                            //nolint:mnd
+	put(12, postings)                              //nolint:mnd
+	binary.LittleEndian.PutUint32(data[16:], mask) //nolint:mnd
+	put(20, idsSize)                               //nolint:mnd
+
+	type entry struct {
+		key uint32
+		ref uint32
+	}
+
+	entries := make([]entry, 0, postings)
+	idOff, rawStart := 0, 0
+
+	for num, track := range tracks {
+		base := layout.tracksOff + num*trackEntrySize
+		put(base, idOff)
+		put(base+4, len(track.ID))                                    //nolint:mnd
+		put(base+8, rawStart)                                         //nolint:mnd
+		put(base+12, len(track.Raw))                                  //nolint:mnd
+		put(base+16, int(math.Round(track.Duration*millisPerSecond))) //nolint:mnd
+
+		copy(data[layout.idsOff+idOff:], track.ID)
+		idOff += len(track.ID)
+
+		for pos, hash := range track.Raw {
+			binary.LittleEndian.PutUint32(data[layout.rawOff+(rawStart+pos)*wordSize:], hash)
+			//nolint:gosec // see put
+			entries = append(entries, entry{key: hash & mask, ref: uint32(rawStart + pos)})
+		}
+
+		rawStart += len(track.Raw)
+	}
+
+	for i, num := range order {
+		put(layout.orderOff+i*wordSize, num)
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		return cmp.Or(cmp.Compare(a.key, b.key), cmp.Compare(a.ref, b.ref))
+	})
+
+	for i, e := range entries {
+		binary.LittleEndian.PutUint32(data[layout.keysOff+i*wordSize:], e.key)
+		binary.LittleEndian.PutUint32(data[layout.refsOff+i*wordSize:], e.ref)
+	}
+
+	binary.LittleEndian.PutUint32(data[24:], crc32.Checksum(data[headerSize:], castagnoli)) //nolint:mnd
+
+	return data, nil
+}
+
+// OpenSegment validates the header, the section sizes and the offsets of the
+// tracks of segment data, and returns a segment reading it in place. The data
+// must not be modified while the segment is in use. Postings are only checked
+// as they are read, so that opening does not read them all: use
+// [Segment.Verify] to also check the content.
+func OpenSegment(data []byte) (*Segment, error) {
+	if len(data) < headerSize || string(data[:4]) != segmentMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrSegment)
+	}
+
+	if version := binary.LittleEndian.Uint32(data[4:]); version != SegmentVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrSegment, version)
+	}
+
+	segment := &Segment{
+		data:     data,
+		tracks:   int(binary.LittleEndian.Uint32(data[8:])),  //nolint:mnd
+		postings: int(binary.LittleEndian.Uint32(data[12:])), //nolint:mnd
+		mask:     binary.LittleEndian.Uint32(data[16:]),      //nolint:mnd
+	}
+	segment.computeOffsets(int(binary.LittleEndian.Uint32(data[20:]))) //nolint:mnd
+
+	if want := segment.refsOff + segment.postings*wordSize; len(data) != want {
+		return nil, fmt.Errorf("%w: size %d, want %d", ErrSegment, len(data), want)
+	}
+
+	if err := segment.checkOffsets(); err != nil {
+		return nil, err
+	}
+
+	return segment, nil
+}
+
+// checkOffsets checks that the offsets of the tracks and order sections stay
+// within their sections, so that no read goes out of bounds. Fingerprints must
+// be contiguous in track order, as lookup relies on.
+func (s *Segment) checkOffsets() error {
+	idsSize, next := s.rawOff-s.idsOff, 0
+
+	for num := range s.tracks {
+		if off, length := int(s.word(s.entry(num))), int(s.word(s.entry(num)+4)); off+length > idsSize { //nolint:mnd
+			return fmt.Errorf("%w: track %d ID out of bounds", ErrSegment, num)
+		}
+
+		start, length := s.rawRange(num)
+		if start != next {
+			return fmt.Errorf("%w: track %d fingerprint at %d, want %d", ErrSegment, num, start, next)
+		}
+
+		next += length
+
+		if s.orderAt(num) >= s.tracks {
+			return fmt.Errorf("%w: order entry %d out of bounds", ErrSegment, num)
+		}
+	}
+
+	if next != s.postings {
+		return fmt.Errorf("%w: fingerprints hold %d hashes, want %d", ErrSegment, next, s.postings)
+	}
+
+	return nil
+}
+
+// Verify checks the segment checksum. It reads the whole segment.
+func (s *Segment) Verify() error {
+	want := binary.LittleEndian.Uint32(s.data[24:]) //nolint:mnd
+	if got := crc32.Checksum(s.data[headerSize:], castagnoli); got != want {
+		return fmt.Errorf("%w: checksum %08x, want %08x", ErrSegment, got, want)
+	}
+
+	return nil
+}
+
+// Len returns the number of tracks in the segment.
+func (s *Segment) Len() int {
+	return s.tracks
+}
+
+// Hashes returns the total number of hashes in the segment.
+func (s *Segment) Hashes() int {
+	return s.postings
+}
+
+// Mask returns the mask hashes were indexed with.
+func (s *Segment) Mask() uint32 {
+	return s.mask
+}
+
+// Track returns the track numbered num, in [0, Len()). Its raw fingerprint is
+// a copy.
+func (s *Segment) Track(num int) Track {
+	start, length := s.rawRange(num)
+
+	raw := make([]uint32, length)
+	for i := range raw {
+		raw[i] = s.word(s.rawOff + (start+i)*wordSize)
+	}
+
+	return Track{
+		ID:       s.ID(num),
+		Duration: float64(s.word(s.entry(num)+16)) / millisPerSecond, //nolint:mnd
+		Raw:      raw,
+	}
+}
+
+// ID returns the ID of the track numbered num, without reading its fingerprint.
+func (s *Segment) ID(num int) string {
+	off := int(s.word(s.entry(num)))
+	length := int(s.word(s.entry(num) + 4)) //nolint:mnd
+
+	return string(s.data[s.idsOff+off : s.idsOff+off+length])
+}
+
+// Find returns the number of the track with the given ID.
+func (s *Segment) Find(id string) (int, bool) {
+	pos := sort.Search(s.tracks, func(i int) bool {
+		return s.ID(s.orderAt(i)) >= id
+	})
+
+	if pos < s.tracks && s.ID(s.orderAt(pos)) == id {
+		return s.orderAt(pos), true
+	}
+
+	return 0, false
+}
+
+// lookup calls fn with the track number and position of every posting of a
+// masked hash. Postings out of bounds, which only corruption produces, are
+// skipped.
+func (s *Segment) lookup(key uint32, fn func(num, position int)) {
+	first := sort.Search(s.postings, func(i int) bool {
+		return s.word(s.keysOff+i*wordSize) >= key
+	})
+
+	for i := first; i < s.postings && s.word(s.keysOff+i*wordSize) == key; i++ {
+		ref := int(s.word(s.refsOff + i*wordSize))
+		if ref >= s.postings {
+			continue
+		}
+
+		// Last track starting at or before ref.
+		num := sort.Search(s.tracks, func(t int) bool {
+			start, _ := s.rawRange(t)
+
+			return start > ref
+		}) - 1
+
+		start, _ := s.rawRange(num)
+		fn(num, ref-start)
+	}
+}
+
+func (s *Segment) computeOffsets(idsSize int) {
+	s.tracksOff = headerSize
+	s.orderOff = s.tracksOff + s.tracks*trackEntrySize
+	s.idsOff = s.orderOff + s.tracks*wordSize
+	s.rawOff = s.idsOff + idsSize
+	s.keysOff = s.rawOff + s.postings*wordSize
+	s.refsOff = s.keysOff + s.postings*wordSize
+}
+
+func (s *Segment) word(off int) uint32 {
+	return binary.LittleEndian.Uint32(s.data[off:])
+}
+
+func (s *Segment) entry(num int) int {
+	return s.tracksOff + num*trackEntrySize
+}
+
+func (s *Segment) orderAt(i int) int {
+	return int(s.word(s.orderOff + i*wordSize))
+}
+
+func (s *Segment) rawRange(num int) (start, length int) {
+	return int(s.word(s.entry(num) + 8)), int(s.word(s.entry(num) + 12)) //nolint:mnd
+}
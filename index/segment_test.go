@@ -0,0 +1,226 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package index_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/index"
+)
+
+func buildSegment(t *testing.T, count, length int, mask uint32) (*index.Segment, []index.Track) {
+	t.Helper()
+
+	tracks := make([]index.Track, count)
+	for i := range tracks {
+		tracks[i] = index.Track{
+			// Not in ID order, to exercise the sorted ID table.
+			ID:       fmt.Sprintf("segment-%d", count-i),
+			Duration: float64(i) + 0.5,
+			Raw:      syntheticFingerprint(uint64(1000+i), length),
+		}
+	}
+
+	data, err := index.EncodeSegment(tracks, mask)
+	if err != nil {
+		t.Fatalf("EncodeSegment() failed: %v", err)
+	}
+
+	segment, err := index.OpenSegment(data)
+	if err != nil {
+		t.Fatalf("OpenSegment() failed: %v", err)
+	}
+
+	return segment, tracks
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	segment, tracks := buildSegment(t, 30, 200, index.FullMask)
+
+	if segment.Len() != 30 || segment.Hashes() != 30*200 {
+		t.Fatalf("Len(), Hashes() = %d, %d; want 30, 6000", segment.Len(), segment.Hashes())
+	}
+
+	if err := segment.Verify(); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+
+	for _, want := range tracks {
+		num, found := segment.Find(want.ID)
+		if !found {
+			t.Fatalf("Find(%q) found nothing", want.ID)
+		}
+
+		got := segment.Track(num)
+		if got.ID != want.ID || got.Duration != want.Duration || !slices.Equal(got.Raw, want.Raw) {
+			t.Errorf("Track(%d) = %s %f, want %s %f", num, got.ID, got.Duration, want.ID, want.Duration)
+		}
+	}
+
+	if _, found := segment.Find("segment-missing"); found {
+		t.Error("Find() found a missing ID")
+	}
+}
+
+func TestSegmentSearch(t *testing.T) {
+	t.Parallel()
+
+	segment, tracks := buildSegment(t, 100, 500, index.StableMask)
+
+	// Mix a segment with tracks added in memory.
+	idx := index.New(index.Options{})
+	idx.AddSegment(segment)
+	raws := populate(t, idx, 20, 500)
+
+	if idx.Len() != 120 {
+		t.Fatalf("Len() = %d, want 120", idx.Len())
+	}
+
+	matches := idx.Search(degrade(tracks[17].Raw, 100, 300, 3))
+	if len(matches) == 0 || matches[0].ID != tracks[17].ID || matches[0].Offset != -100 {
+		t.Errorf("Search() = %+v, want %s at offset -100", matches, tracks[17].ID)
+	}
+
+	matches = idx.Search(raws[5])
+	if len(matches) == 0 || matches[0].ID != "track-5" {
+		t.Errorf("Search() = %+v, want in-memory track-5", matches)
+	}
+
+	if err := idx.Add(tracks[0].ID, raws[0]); !errors.Is(err, index.ErrDuplicateID) {
+		t.Errorf("Add(segment ID) = %v, want ErrDuplicateID", err)
+	}
+}
+
+func TestSegmentOutOfBounds(t *testing.T) {
+	t.Parallel()
+
+	tracks := []index.Track{{ID: "a", Raw: []uint32{1, 2, 3}}, {ID: "b", Raw: []uint32{4}}}
+
+	// Offsets in a segment of these tracks: header, 2 track entries, order.
+	const (
+		idOffset  = 32
+		idLength  = 36
+		rawStart  = 40
+		rawLength = 44
+		order     = 72
+	)
+
+	for name, off := range map[string]int{
+		"id offset":  idOffset,
+		"id length":  idLength,
+		"raw start":  rawStart,
+		"raw length": rawLength,
+		"order":      order,
+	} {
+		data, err := index.EncodeSegment(tracks, index.FullMask)
+		if err != nil {
+			t.Fatalf("EncodeSegment() failed: %v", err)
+		}
+
+		data[off+2] = 0xff
+
+		if _, err = index.OpenSegment(data); !errors.Is(err, index.ErrSegment) {
+			t.Errorf("OpenSegment(corrupted %s) = %v, want ErrSegment", name, err)
+		}
+	}
+
+	// Postings are checked as they are read, and by Verify.
+	data, err := index.EncodeSegment(tracks, index.FullMask)
+	if err != nil {
+		t.Fatalf("EncodeSegment() failed: %v", err)
+	}
+
+	data[len(data)-2] = 0xff
+
+	segment, err := index.OpenSegment(data)
+	if err != nil {
+		t.Fatalf("OpenSegment(corrupted ref) failed: %v", err)
+	}
+
+	if err = segment.Verify(); !errors.Is(err, index.ErrSegment) {
+		t.Errorf("Verify(corrupted ref) = %v, want ErrSegment", err)
+	}
+
+	idx := index.New(index.Options{})
+	idx.AddSegment(segment)
+	idx.Search([]uint32{1, 2, 3, 4})
+}
+
+func TestSegmentErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := index.EncodeSegment([]index.Track{{ID: "a", Raw: []uint32{1}}, {ID: "a", Raw: []uint32{2}}}, index.FullMask)
+	if !errors.Is(err, index.ErrDuplicateID) {
+		t.Errorf("EncodeSegment(duplicates) = %v, want ErrDuplicateID", err)
+	}
+
+	data, err := index.EncodeSegment([]index.Track{{ID: "a", Raw: []uint32{1, 2, 3}}}, index.FullMask)
+	if err != nil {
+		t.Fatalf("EncodeSegment() failed: %v", err)
+	}
+
+	if _, err = index.OpenSegment(data[:len(data)-1]); !errors.Is(err, index.ErrSegment) {
+		t.Errorf("OpenSegment(truncated) = %v, want ErrSegment", err)
+	}
+
+	if _, err = index.OpenSegment([]byte("not a segment at all, really not")); !errors.Is(err, index.ErrSegment) {
+		t.Errorf("OpenSegment(garbage) = %v, want ErrSegment", err)
+	}
+
+	// The first raw hash: the raw, keys and refs sections hold 3 words each.
+	data[len(data)-9*4] ^= 1
+
+	segment, err := index.OpenSegment(data)
+	if err != nil {
+		t.Fatalf("OpenSegment() failed: %v", err)
+	}
+
+	if err = segment.Verify(); !errors.Is(err, index.ErrSegment) {
+		t.Errorf("Verify(corrupted) = %v, want ErrSegment", err)
+	}
+}
+
+func TestSegmentTombstones(t *testing.T) {
+	t.Parallel()
+
+	segment, tracks := buildSegment(t, 10, 300, index.FullMask)
+
+	idx := index.New(index.Options{})
+	idx.AddSegment(segment, tracks[4].ID, "segment-missing")
+
+	if idx.Len() != 9 {
+		t.Errorf("Len() = %d, want 9", idx.Len())
+	}
+
+	if idx.Contains(tracks[4].ID) {
+		t.Errorf("Contains(%q) = true for a deleted track", tracks[4].ID)
+	}
+
+	if matches := idx.Search(tracks[4].Raw); len(matches) != 0 {
+		t.Errorf("Search() found a deleted track: %+v", matches)
+	}
+
+	// A deleted ID can be indexed again.
+	if err := idx.Add(tracks[4].ID, tracks[4].Raw); err != nil {
+		t.Errorf("Add(deleted ID) failed: %v", err)
+	}
+}
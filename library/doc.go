@@ -0,0 +1,24 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package library finds and fingerprints the audio files of a music library.
+//
+// Files are decoded with ffmpeg by [fingerprint.File], in parallel, each
+// worker reusing its own Chromaprint context. Depending on [Options.Tags],
+// fingerprints already stored in the file tags (see package tags) are used
+// instead, when they are valid. An [Options.Cache] spares decoding unchanged
+// files again.
+package library
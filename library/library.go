@@ -0,0 +1,257 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package library
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mycophonic/sporeprint/cache"
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/index"
+)
+
+//nolint:gochecknoglobals // Immutable lookup table.
+var audioExtensions = map[string]bool{
+	".aac":  true,
+	".aif":  true,
+	".aiff": true,
+	".alac": true,
+	".ape":  true,
+	".dsf":  true,
+	".flac": true,
+	".m4a":  true,
+	".mka":  true,
+	".mp2":  true,
+	".mp3":  true,
+	".mpc":  true,
+	".oga":  true,
+	".ogg":  true,
+	".opus": true,
+	".wav":  true,
+	".wma":  true,
+	".wv":   true,
+}
+
+// Options controls [Fingerprint].
+type Options struct {
+	// Jobs is the number of files fingerprinted in parallel. Zero means the
+	// number of CPUs.
+	Jobs int
+	// Fingerprint controls decoding and fingerprinting of each file.
+	Fingerprint fingerprint.Options
+	// Tags controls the reuse of the fingerprints stored in file tags.
+	Tags TagPolicy
+	// FFprobe is the ffprobe binary used by [TagsVerify]. Empty means
+	// "ffprobe" in PATH.
+	FFprobe string
+	// Cache, if not nil, is looked up before anything else, and stores the
+	// tracks of decoded files.
+	Cache *cache.Cache
+}
+
+// Failure is a file that could not be fingerprinted.
+type Failure struct {
+	Path string
+	Err  error
+}
+
+// IsAudio reports whether a path has a known audio file extension.
+func IsAudio(path string) bool {
+	return audioExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// Walk returns the audio files designated by paths, sorted: files are kept
+// whatever their extension, directories are walked recursively for files
+// with a known audio extension.
+func Walk(paths []string) ([]string, error) {
+	var files []string
+
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("walking library: %w", err)
+		}
+
+		if !info.IsDir() {
+			files = append(files, root)
+
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if entry.Type().IsRegular() && IsAudio(path) {
+				files = append(files, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walking library: %w", err)
+		}
+	}
+
+	slices.Sort(files)
+
+	return slices.Compact(files), nil
+}
+
+// Merge returns stored followed by fresh, without the stored tracks whose IDs
+// are also in fresh: files fingerprinted again replace their earlier tracks,
+// typically loaded from a database.
+func Merge(stored, fresh []index.Track) []index.Track {
+	replaced := make(map[string]struct{}, len(fresh))
+	for _, track := range fresh {
+		replaced[track.ID] = struct{}{}
+	}
+
+	merged := make([]index.Track, 0, len(stored)+len(fresh))
+
+	for _, track := range stored {
+		if _, found := replaced[track.ID]; !found {
+			merged = append(merged, track)
+		}
+	}
+
+	return append(merged, fresh...)
+}
+
+// Fingerprint fingerprints files in parallel. It returns the tracks of the
+// files that succeeded, in the order of paths and with their path as ID, and
+// the files that failed. It stops early if ctx is canceled.
+func Fingerprint(ctx context.Context, paths []string, options Options) ([]index.Track, []Failure) {
+	jobs := options.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	tracks := make([]index.Track, len(paths))
+	errs := make([]error, len(paths))
+	next := make(chan int)
+
+	var workers sync.WaitGroup
+
+	for range min(jobs, len(paths)) {
+		workers.Go(func() {
+			chroma := chromaprint.New()
+			defer chroma.Free()
+
+			for num := range next {
+				tracks[num], errs[num] = fingerprintPath(ctx, chroma, paths[num], options)
+			}
+		})
+	}
+
+	for num := range paths {
+		if ctx.Err() != nil {
+			errs[num] = ctx.Err()
+
+			continue
+		}
+
+		next <- num
+	}
+
+	close(next)
+	workers.Wait()
+
+	var failures []Failure
+
+	succeeded := tracks[:0]
+
+	for num, track := range tracks {
+		if errs[num] != nil {
+			failures = append(failures, Failure{Path: paths[num], Err: errs[num]})
+
+			continue
+		}
+
+		succeeded = append(succeeded, track)
+	}
+
+	return succeeded, failures
+}
+
+// fingerprintPath returns the track of a file from options.Cache, from its tags
+// or by decoding it, in that order.
+func fingerprintPath(ctx context.Context, chroma *chromaprint.Context, path string, options Options) (index.Track, error) {
+	length := options.Fingerprint.Length
+
+	cached := options.Cache
+	if speed := options.Fingerprint.Speed; speed > 0 && speed != 1 {
+		cached = nil
+	}
+
+	var stamp cache.Stamp
+
+	if cached != nil {
+		var err error
+		if stamp, err = cached.Stamp(path); err != nil {
+			// Decoding reports the problem.
+			cached = nil
+		} else if track, ok := cached.Get(path, stamp, length); ok {
+			return track, nil
+		}
+	}
+
+	if track, ok := tagTrack(ctx, path, options); ok {
+		return track, nil
+	}
+
+	track, err := fingerprintFile(ctx, chroma, path, options.Fingerprint)
+	if err != nil {
+		return index.Track{}, err
+	}
+
+	if cached != nil {
+		// A cache entry that cannot be written only costs time on the next
+		// scan.
+		_ = cached.Put(path, stamp, length, track)
+	}
+
+	return track, nil
+}
+
+func fingerprintFile(ctx context.Context, chroma *chromaprint.Context, path string, options fingerprint.Options) (index.Track, error) {
+	result, err := fingerprint.File(ctx, chroma, path, options)
+	if err != nil {
+		return index.Track{}, err
+	}
+
+	raw, err := chromaprint.Decode(result.Fingerprint)
+	if err != nil {
+		return index.Track{}, fmt.Errorf("decoding fingerprint: %w", err)
+	}
+
+	if len(raw) == 0 {
+		return index.Track{}, fmt.Errorf("%w: %s", index.ErrEmpty, path)
+	}
+
+	return index.Track{ID: path, Duration: result.Duration, Raw: raw}, nil
+}
@@ -0,0 +1,273 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package library_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/cache"
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/dedupe"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/library"
+	"github.com/mycophonic/sporeprint/tags"
+)
+
+func TestWalk(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	for _, name := range []string{"a/1.flac", "a/2.MP3", "a/cover.jpg", "b/c/3.opus", "notes.txt"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Explicit files are kept whatever their extension, and only once.
+	files, err := library.Walk([]string{root, filepath.Join(root, "notes.txt"), filepath.Join(root, "a/1.flac")})
+	if err != nil {
+		t.Fatalf("Walk() failed: %v", err)
+	}
+
+	want := []string{
+		filepath.Join(root, "a/1.flac"),
+		filepath.Join(root, "a/2.MP3"),
+		filepath.Join(root, "b/c/3.opus"),
+		filepath.Join(root, "notes.txt"),
+	}
+
+	if !slices.Equal(files, want) {
+		t.Errorf("Walk() = %v, want %v", files, want)
+	}
+
+	if _, err = library.Walk([]string{filepath.Join(root, "missing")}); err == nil {
+		t.Error("Walk(missing) should fail")
+	}
+}
+
+// TestMerge checks that tracks loaded from a database and fingerprinted again
+// are only kept once, so that they can be indexed together.
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	stored := []index.Track{
+		{ID: "a.flac", Raw: []uint32{1}},
+		{ID: "b.flac", Raw: []uint32{2}},
+	}
+	fresh := []index.Track{
+		{ID: "b.flac", Raw: []uint32{3}},
+		{ID: "c.flac", Raw: []uint32{4}},
+	}
+
+	merged := library.Merge(stored, fresh)
+
+	want := []index.Track{stored[0], fresh[0], fresh[1]}
+	if !slices.EqualFunc(merged, want, func(a, b index.Track) bool {
+		return a.ID == b.ID && slices.Equal(a.Raw, b.Raw)
+	}) {
+		t.Errorf("Merge() = %+v, want %+v", merged, want)
+	}
+
+	if _, err := dedupe.Find(merged, dedupe.Options{}); err != nil {
+		t.Errorf("Find() failed: %v", err)
+	}
+}
+
+func TestFingerprintFailures(t *testing.T) {
+	t.Parallel()
+
+	paths := []string{"1.flac", "2.flac", "3.flac"}
+
+	tracks, failures := library.Fingerprint(context.Background(), paths, library.Options{
+		Jobs:        2,
+		Fingerprint: fingerprint.Options{FFmpeg: filepath.Join(t.TempDir(), "no-ffmpeg")},
+	})
+
+	if len(tracks) != 0 || len(failures) != 3 {
+		t.Fatalf("Fingerprint() = %d tracks, %d failures; want 0, 3", len(tracks), len(failures))
+	}
+
+	for i, failure := range failures {
+		if failure.Path != paths[i] || !errors.Is(failure.Err, fingerprint.ErrDecode) {
+			t.Errorf("failure %d = %s: %v, want %s: ErrDecode", i, failure.Path, failure.Err, paths[i])
+		}
+	}
+}
+
+// taggedFLAC writes a FLAC file whose tags hold the fingerprint of seconds of
+// audio and the given duration, and returns its path and the raw fingerprint.
+func taggedFLAC(t *testing.T, seconds, duration int) (string, []uint32) {
+	t.Helper()
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	samples := make([]int16, fingerprint.SampleRate*seconds)
+	for i := range samples {
+		samples[i] = int16((i*i/7 + i*13) % 20000)
+	}
+
+	if err := chroma.Start(fingerprint.SampleRate, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chroma.Feed(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chroma.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := chroma.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := chromaprint.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A STREAMINFO block, then a few bytes standing for audio frames.
+	flac := append([]byte("fLaC\x80\x00\x00\x22"), make([]byte, 34)...)
+	flac = append(flac, 0xff, 0xf8, 1, 2, 3)
+
+	path := filepath.Join(t.TempDir(), "track.flac")
+	if err = os.WriteFile(path, flac, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tags.Write(path, tags.Tags{Fingerprint: encoded, Duration: duration}); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, raw
+}
+
+func TestFingerprintTags(t *testing.T) {
+	t.Parallel()
+
+	path, raw := taggedFLAC(t, 30, 30)
+	implausible, _ := taggedFLAC(t, 30, 300)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name   string
+		path   string
+		length int
+		policy library.TagPolicy
+		// hashes is the number of hashes of the track, 0 if the file must
+		// be decoded.
+		hashes int
+	}{
+		{name: "trust", path: path, length: 120, policy: library.TagsTrust, hashes: len(raw)},
+		{name: "truncated", path: path, length: 10, policy: library.TagsTrust, hashes: 80},
+		{name: "ignore", path: path, length: 120, policy: library.TagsIgnore},
+		// ffprobe is missing: tags cannot be verified.
+		{name: "verify", path: path, length: 120, policy: library.TagsVerify},
+		// 30 seconds of fingerprint for a 300 seconds file.
+		{name: "implausible", path: implausible, length: 120, policy: library.TagsTrust},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			tracks, failures := library.Fingerprint(context.Background(), []string{test.path}, library.Options{
+				Fingerprint: fingerprint.Options{Length: test.length, FFmpeg: missing},
+				Tags:        test.policy,
+				FFprobe:     missing,
+			})
+
+			if test.hashes == 0 {
+				if len(tracks) != 0 || len(failures) != 1 || !errors.Is(failures[0].Err, fingerprint.ErrDecode) {
+					t.Fatalf("Fingerprint() = %d tracks, %v; want a decoding failure", len(tracks), failures)
+				}
+
+				return
+			}
+
+			if len(tracks) != 1 || len(failures) != 0 {
+				t.Fatalf("Fingerprint() = %d tracks, %v; want 1 track", len(tracks), failures)
+			}
+
+			track := tracks[0]
+			if track.ID != test.path || !slices.Equal(track.Raw, raw[:test.hashes]) {
+				t.Errorf("track = %s with %d hashes, want %s with %d hashes", track.ID, len(track.Raw), test.path, test.hashes)
+			}
+
+			if want := min(30, float64(test.length)); track.Duration != want {
+				t.Errorf("track duration = %v, want %v", track.Duration, want)
+			}
+		})
+	}
+}
+
+func TestFingerprintCache(t *testing.T) {
+	t.Parallel()
+
+	fingerprints, err := cache.Open(t.TempDir(), cache.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "track.flac")
+	if err = os.WriteFile(path, []byte("audio"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stamp, err := fingerprints.Stamp(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	track := index.Track{ID: path, Duration: 120, Raw: []uint32{1, 2, 3}}
+	if err = fingerprints.Put(path, stamp, 120, track); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "no-ffmpeg")
+
+	// Cached tracks are not decoded, unless fingerprinted at another speed.
+	for _, speed := range []float64{0, 1, 2} {
+		tracks, failures := library.Fingerprint(context.Background(), []string{path}, library.Options{
+			Fingerprint: fingerprint.Options{Length: 120, Speed: speed, FFmpeg: missing},
+			Cache:       fingerprints,
+		})
+
+		if hit := speed != 2; hit != (len(tracks) == 1 && len(failures) == 0) {
+			t.Errorf("speed %v: Fingerprint() = %d tracks, %v; want a cache hit: %v", speed, len(tracks), failures, hit)
+		}
+
+		if len(tracks) == 1 && !slices.Equal(tracks[0].Raw, track.Raw) {
+			t.Errorf("speed %v: track = %v, want %v", speed, tracks[0].Raw, track.Raw)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package library
+
+import (
+	"context"
+	"math"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/dedupe"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/tags"
+)
+
+// TagPolicy controls the reuse of fingerprints stored in file tags, as written
+// by "sporeprint tag" or MusicBrainz Picard, instead of decoding the files.
+type TagPolicy int
+
+const (
+	// TagsIgnore always decodes files.
+	TagsIgnore TagPolicy = iota
+	// TagsTrust uses tagged fingerprints computed with [chromaprint.Algorithm]
+	// whose length is plausible against the tagged duration, if any.
+	TagsTrust
+	// TagsVerify is TagsTrust, checking the length against the duration
+	// probed with ffprobe, and discarding tags whose duration disagrees.
+	TagsVerify
+)
+
+const (
+	// tagTolerance is the difference in seconds allowed between the audio a
+	// tagged fingerprint covers and the duration of the file: the first hashes
+	// need a few seconds of audio.
+	tagTolerance = 5
+)
+
+// tagTrack returns the track of the fingerprint tagged in path, truncated to
+// options.Fingerprint.Length. It returns false when the tag is missing,
+// unreadable or invalid per options.Tags, and the file must be decoded.
+func tagTrack(ctx context.Context, path string, options Options) (index.Track, bool) {
+	speed := options.Fingerprint.Speed
+	if options.Tags == TagsIgnore || (speed > 0 && speed != 1) {
+		return index.Track{}, false
+	}
+
+	tagged, err := tags.Read(path)
+	if err != nil || tagged.Fingerprint == "" {
+		return index.Track{}, false
+	}
+
+	raw, algorithm, err := chromaprint.DecodeAlgorithm(tagged.Fingerprint)
+	if err != nil || algorithm != chromaprint.Algorithm || len(raw) == 0 {
+		return index.Track{}, false
+	}
+
+	duration := float64(tagged.Duration)
+
+	if options.Tags == TagsVerify {
+		quality, err := dedupe.Probe(ctx, options.FFprobe, path)
+		if err != nil || (duration > 0 && math.Abs(duration-quality.Duration) > tagTolerance) {
+			return index.Track{}, false
+		}
+
+		duration = quality.Duration
+	}
+
+	length := float64(options.Fingerprint.Length)
+	covered := float64(len(raw)) * compare.SecondsPerHash
+
+	if duration > 0 {
+		// The tag may cover more than Length, but neither less nor more than
+		// the file.
+		expected := duration
+		if length > 0 {
+			expected = min(duration, length)
+		}
+
+		if covered > duration+tagTolerance || covered < expected-tagTolerance {
+			return index.Track{}, false
+		}
+	} else {
+		duration = covered
+	}
+
+	if length > 0 {
+		raw = raw[:min(len(raw), int(length/compare.SecondsPerHash))]
+		duration = min(duration, length)
+	}
+
+	return index.Track{ID: path, Duration: duration, Raw: raw}, true
+}
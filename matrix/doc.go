@@ -0,0 +1,24 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package matrix computes the similarity of every pair of a set of
+// fingerprints, for research and threshold tuning.
+//
+// Only the pairs i < j are compared: the score is symmetric and the offset
+// antisymmetric. [Matrix.At] returns any pair, and [Matrix.WriteScoresNPY]
+// and [Matrix.WriteOffsetsNPY] export the full square matrices in the NumPy
+// .npy format.
+package matrix
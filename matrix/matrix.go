@@ -0,0 +1,120 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package matrix
+
+import (
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+// Options controls [Compute].
+type Options struct {
+	// Matcher compares pairs. Nil means [compare.Bounded].
+	Matcher compare.Matcher
+	// Jobs is the number of rows compared in parallel. Zero means the
+	// number of CPUs.
+	Jobs int
+}
+
+// Matrix holds the comparison results of every pair of fingerprints, in
+// condensed form: only the pairs i < j, row by row.
+type Matrix struct {
+	size    int
+	results []compare.Result
+}
+
+// Compute compares every pair of raw fingerprints, as returned by
+// [chromaprint.Decode].
+func Compute(raws [][]uint32, options Options) *Matrix {
+	matcher := options.Matcher
+	if matcher == nil {
+		matcher = compare.Bounded{}
+	}
+
+	jobs := options.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	size := len(raws)
+	mat := &Matrix{size: size, results: make([]compare.Result, size*(size-1)/2)} //nolint:mnd
+
+	// Rows shrink: hand them out one at a time to balance the workers.
+	rows := make(chan int)
+
+	var workers sync.WaitGroup
+
+	for range min(jobs, size) {
+		workers.Go(func() {
+			for row := range rows {
+				base := mat.index(row, row+1)
+
+				for col := row + 1; col < size; col++ {
+					mat.results[base+col-row-1] = matcher.Match(raws[row], raws[col])
+				}
+			}
+		})
+	}
+
+	for row := range size {
+		rows <- row
+	}
+
+	close(rows)
+	workers.Wait()
+
+	return mat
+}
+
+// Len returns the number of fingerprints.
+func (m *Matrix) Len() int {
+	return m.size
+}
+
+// At returns the comparison of fingerprints i and j. The offset is of i
+// relative to j, in hashes of i resampled by the scale as [compare.Tempo]
+// does. A fingerprint compared to itself scores 1 at offset 0.
+func (m *Matrix) At(i, j int) compare.Result {
+	switch {
+	case i == j:
+		return compare.Result{Score: 1, Scale: 1}
+	case i < j:
+		return m.results[m.index(i, j)]
+	default:
+		result := m.results[m.index(j, i)]
+
+		// The offset of j was counted in hashes of j resampled by the scale,
+		// which the reversed pair measures in hashes of i resampled by its
+		// inverse instead.
+		if result.Scale != 0 {
+			result.Offset = int(math.Round(-float64(result.Offset) / result.Scale))
+			result.Scale = 1 / result.Scale
+		} else {
+			result.Offset = -result.Offset
+		}
+
+		return result
+	}
+}
+
+// index returns the condensed index of pair (i, j), i < j.
+func (m *Matrix) index(i, j int) int {
+	return i*m.size - i*(i+1)/2 + j - i - 1 //nolint:mnd
+}
@@ -0,0 +1,209 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package matrix_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/matrix"
+)
+
+// fingerprints returns count random fingerprints, every other one being an
+// excerpt of the previous.
+func fingerprints(count, length int) [][]uint32 {
+	rng := rand.New(rand.NewPCG(1, 2))
+	raws := make([][]uint32, count)
+
+	for num := range raws {
+		if num%2 == 1 {
+			raws[num] = slices.Clone(raws[num-1][10:])
+
+			continue
+		}
+
+		raws[num] = make([]uint32, length)
+		for i := range raws[num] {
+			raws[num][i] = rng.Uint32()
+		}
+	}
+
+	return raws
+}
+
+func TestCompute(t *testing.T) {
+	t.Parallel()
+
+	raws := fingerprints(9, 200)
+	mat := matrix.Compute(raws, matrix.Options{Matcher: compare.Global{}, Jobs: 3})
+
+	if mat.Len() != len(raws) {
+		t.Fatalf("Len() = %d, want %d", mat.Len(), len(raws))
+	}
+
+	for i := range raws {
+		for j := range raws {
+			got := mat.At(i, j)
+
+			want := compare.Global{}.Match(raws[i], raws[j])
+			if i == j {
+				want = compare.Result{Score: 1, Scale: 1}
+			}
+
+			if got != want {
+				t.Errorf("At(%d, %d) = %+v, want %+v", i, j, got, want)
+			}
+		}
+	}
+
+	// Excerpts start 10 hashes in: hash i+10 of a track is hash i of its
+	// excerpt.
+	if got := mat.At(0, 1); got.Score != 1 || got.Offset != 10 {
+		t.Errorf("At(0, 1) = %+v, want score 1 at offset 10", got)
+	}
+}
+
+// TestComputeTempo checks that reversed pairs convert offsets measured on a
+// resampled timeline.
+func TestComputeTempo(t *testing.T) {
+	t.Parallel()
+
+	// The second fingerprint plays the first 1.25 times faster, from hash 40.
+	rng := rand.New(rand.NewPCG(3, 4))
+	original := make([]uint32, 400)
+
+	for i := range original {
+		original[i] = rng.Uint32()
+	}
+
+	faster := make([]uint32, 280)
+	for i := range faster {
+		faster[i] = original[40+int(float64(i)*1.25)]
+	}
+
+	raws := [][]uint32{original, faster}
+	matcher := compare.Tempo{Min: 0.75, Max: 1.3, Step: 0.05, Base: compare.Global{}}
+	mat := matrix.Compute(raws, matrix.Options{Matcher: matcher})
+
+	got := mat.At(1, 0)
+	want := matcher.Match(raws[1], raws[0])
+
+	if math.Abs(got.Scale-want.Scale) > 1e-9 || got.Offset < want.Offset-1 || got.Offset > want.Offset+1 {
+		t.Errorf("At(1, 0) = %+v, want the scale and offset of %+v", got, want)
+	}
+}
+
+func TestComputeEmpty(t *testing.T) {
+	t.Parallel()
+
+	for _, raws := range [][][]uint32{nil, fingerprints(1, 10)} {
+		if mat := matrix.Compute(raws, matrix.Options{}); mat.Len() != len(raws) {
+			t.Errorf("Compute(%d) Len() = %d", len(raws), mat.Len())
+		}
+	}
+}
+
+func TestWriteNPY(t *testing.T) {
+	t.Parallel()
+
+	raws := fingerprints(5, 100)
+	mat := matrix.Compute(raws, matrix.Options{Matcher: compare.Tempo{Base: compare.Global{}}})
+
+	var scores, offsets, scales bytes.Buffer
+
+	if err := mat.WriteScoresNPY(&scores); err != nil {
+		t.Fatalf("WriteScoresNPY() failed: %v", err)
+	}
+
+	if err := mat.WriteOffsetsNPY(&offsets); err != nil {
+		t.Fatalf("WriteOffsetsNPY() failed: %v", err)
+	}
+
+	if err := mat.WriteScalesNPY(&scales); err != nil {
+		t.Fatalf("WriteScalesNPY() failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		data  []byte
+		descr string
+		size  int
+	}{
+		{scores.Bytes(), "'descr': '<f8'", 8},
+		{offsets.Bytes(), "'descr': '<i4'", 4},
+		{scales.Bytes(), "'descr': '<f8'", 8},
+	} {
+		if !bytes.HasPrefix(tc.data, []byte("\x93NUMPY\x01\x00")) {
+			t.Fatalf("bad magic: %q", tc.data[:8])
+		}
+
+		headerLen := int(binary.LittleEndian.Uint16(tc.data[8:]))
+		header := string(tc.data[10 : 10+headerLen])
+
+		if (10+headerLen)%64 != 0 || !strings.HasSuffix(header, "\n") {
+			t.Errorf("header %q is not aligned", header)
+		}
+
+		if !strings.Contains(header, tc.descr) || !strings.Contains(header, "'shape': (5, 5)") {
+			t.Errorf("header = %q, want %s and shape (5, 5)", header, tc.descr)
+		}
+
+		if len(tc.data) != 10+headerLen+5*5*tc.size {
+			t.Errorf("data is %d bytes, want %d", len(tc.data), 10+headerLen+5*5*tc.size)
+		}
+	}
+
+	data := scores.Bytes()[len(scores.Bytes())-25*8:]
+	offsetData := offsets.Bytes()[len(offsets.Bytes())-25*4:]
+	scaleData := scales.Bytes()[len(scales.Bytes())-25*8:]
+
+	for i := range 5 {
+		for j := range 5 {
+			want := mat.At(i, j)
+
+			if got := math.Float64frombits(binary.LittleEndian.Uint64(data[(i*5+j)*8:])); got != want.Score {
+				t.Errorf("scores[%d][%d] = %f, want %f", i, j, got, want.Score)
+			}
+
+			if got := int32(binary.LittleEndian.Uint32(offsetData[(i*5+j)*4:])); int(got) != want.Offset {
+				t.Errorf("offsets[%d][%d] = %d, want %d", i, j, got, want.Offset)
+			}
+
+			if got := math.Float64frombits(binary.LittleEndian.Uint64(scaleData[(i*5+j)*8:])); got != want.Scale || got == 0 {
+				t.Errorf("scales[%d][%d] = %f, want %f", i, j, got, want.Scale)
+			}
+		}
+	}
+}
+
+func BenchmarkCompute(b *testing.B) {
+	// About 10 seconds of audio each, as BenchmarkCompare.
+	raws := fingerprints(200, 80)
+
+	b.ResetTimer()
+
+	for b.Loop() {
+		matrix.Compute(raws, matrix.Options{})
+	}
+
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/float64(len(raws)*(len(raws)-1)/2), "ns/pair")
+}
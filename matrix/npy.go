@@ -0,0 +1,96 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package matrix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	npyMagic = "\x93NUMPY"
+	// npyAlign is the alignment of the data after the header.
+	npyAlign = 64
+	// npyPrelude is the size of the magic, version and header length.
+	npyPrelude = 10
+)
+
+// WriteScoresNPY writes the square matrix of scores as a NumPy .npy array of
+// little-endian float64.
+func (m *Matrix) WriteScoresNPY(writer io.Writer) error {
+	return m.writeNPY(writer, "<f8", func(buf []byte, i, j int) []byte {
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(m.At(i, j).Score))
+	})
+}
+
+// WriteOffsetsNPY writes the square matrix of offsets, in hashes, as a NumPy
+// .npy array of little-endian int32.
+func (m *Matrix) WriteOffsetsNPY(writer io.Writer) error {
+	return m.writeNPY(writer, "<i4", func(buf []byte, i, j int) []byte {
+		//nolint:gosec // offsets are bounded by fingerprint lengths
+		return binary.LittleEndian.AppendUint32(buf, uint32(int32(m.At(i, j).Offset)))
+	})
+}
+
+// WriteScalesNPY writes the square matrix of time-scale factors, as found by
+// [compare.Tempo], as a NumPy .npy array of little-endian float64.
+func (m *Matrix) WriteScalesNPY(writer io.Writer) error {
+	return m.writeNPY(writer, "<f8", func(buf []byte, i, j int) []byte {
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(m.At(i, j).Scale))
+	})
+}
+
+// writeNPY writes a version 1.0 .npy file of shape (Len, Len), in C order,
+// with each value appended by put.
+func (m *Matrix) writeNPY(writer io.Writer, descr string, put func(buf []byte, i, j int) []byte) error {
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%d, %d), }", descr, m.size, m.size)
+	// Pad with spaces and a newline so that the data is aligned.
+	padding := npyAlign - (npyPrelude+len(header)+1)%npyAlign
+	header += strings.Repeat(" ", padding%npyAlign) + "\n"
+
+	buffered := bufio.NewWriter(writer)
+
+	prelude := append([]byte(npyMagic), 1, 0)
+	//nolint:gosec // the header is short
+	prelude = binary.LittleEndian.AppendUint16(prelude, uint16(len(header)))
+
+	_, _ = buffered.Write(prelude)
+	_, _ = buffered.WriteString(header)
+
+	var row []byte
+
+	for i := range m.size {
+		row = row[:0]
+		for j := range m.size {
+			row = put(row, i, j)
+		}
+
+		if _, err := buffered.Write(row); err != nil {
+			return fmt.Errorf("writing npy: %w", err)
+		}
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("writing npy: %w", err)
+	}
+
+	return nil
+}
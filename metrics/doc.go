@@ -0,0 +1,24 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package metrics exposes counters, gauges and histograms in the Prometheus
+// text format, for scraping the long-running commands.
+//
+// It implements the subset of the format those commands need, to spare the
+// dependency on the Prometheus client library.
+//
+// Reference: https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
@@ -0,0 +1,293 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of [Registry.WriteTo] output.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelSeparator joins label values into keys. It cannot occur in UTF-8.
+const labelSeparator = "\xff"
+
+// DefaultBuckets are histogram bucket upper bounds suited to latencies in
+// seconds, as the Prometheus client libraries.
+//
+//nolint:gochecknoglobals // Immutable defaults.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metric writes its samples, after the HELP and TYPE lines.
+type metric interface {
+	write(writer *bufio.Writer, name string)
+}
+
+type family struct {
+	name, help, kind string
+	metric           metric
+}
+
+// Registry holds metrics, written in registration order. It is safe for
+// concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name, help, kind string, metric metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.families = append(r.families, family{name: name, help: help, kind: kind, metric: metric})
+}
+
+// Counter registers a counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	counter := &Counter{}
+	r.register(name, help, "counter", counter)
+
+	return counter
+}
+
+// CounterVec registers counters partitioned by labels.
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	vec := &CounterVec{labels: labels, counters: map[string]*Counter{}}
+	r.register(name, help, "counter", vec)
+
+	return vec
+}
+
+// GaugeFunc registers a gauge whose value is read from value at every write.
+func (r *Registry) GaugeFunc(name, help string, value func() float64) {
+	r.register(name, help, "gauge", gaugeFunc(value))
+}
+
+// Histogram registers a histogram with the given bucket upper bounds, in
+// increasing order. Nil means DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	histogram := &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	r.register(name, help, "histogram", histogram)
+
+	return histogram
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (r *Registry) WriteTo(writer io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	counter := &countingWriter{writer: writer}
+	buf := bufio.NewWriter(counter)
+
+	for _, fam := range families {
+		_, _ = fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", fam.name, escapeHelp(fam.help), fam.name, fam.kind)
+		fam.metric.write(buf, fam.name)
+	}
+
+	err := buf.Flush()
+
+	return counter.written, err
+}
+
+// ServeHTTP implements [http.Handler], serving the metrics to scrapers.
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(writer)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Inc adds 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds value, which must not be negative.
+func (c *Counter) Add(value float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+value)) {
+			return
+		}
+	}
+}
+
+// Value returns the current value.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+func (c *Counter) write(writer *bufio.Writer, name string) {
+	writeSample(writer, name, "", c.Value())
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	labels []string
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// With returns the counter of the given label values, in label order.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := strings.Join(values, labelSeparator)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	counter, found := v.counters[key]
+	if !found {
+		counter = &Counter{}
+		v.counters[key] = counter
+	}
+
+	return counter
+}
+
+func (v *CounterVec) write(writer *bufio.Writer, name string) {
+	v.mu.Lock()
+	counters := maps.Clone(v.counters)
+	v.mu.Unlock()
+
+	keys := slices.Sorted(maps.Keys(counters))
+
+	for _, key := range keys {
+		writeSample(writer, name, formatLabels(v.labels, strings.Split(key, labelSeparator)), counters[key].Value())
+	}
+}
+
+type gaugeFunc func() float64
+
+func (g gaugeFunc) write(writer *bufio.Writer, name string) {
+	writeSample(writer, name, "", g())
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records value.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if idx, _ := slices.BinarySearch(h.buckets, value); idx < len(h.buckets) {
+		h.counts[idx]++
+	}
+
+	h.count++
+	h.sum += value
+}
+
+func (h *Histogram) write(writer *bufio.Writer, name string) {
+	h.mu.Lock()
+	counts, count, sum := slices.Clone(h.counts), h.count, h.sum
+	h.mu.Unlock()
+
+	var cumulative uint64
+
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		writeSample(writer, name+"_bucket", formatLabels([]string{"le"}, []string{formatFloat(bound)}), float64(cumulative))
+	}
+
+	writeSample(writer, name+"_bucket", `{le="+Inf"}`, float64(count))
+	writeSample(writer, name+"_sum", "", sum)
+	writeSample(writer, name+"_count", "", float64(count))
+}
+
+func writeSample(writer *bufio.Writer, name, labels string, value float64) {
+	_, _ = writer.WriteString(name + labels + " " + formatFloat(value) + "\n")
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+//nolint:gochecknoglobals // Immutable replacers.
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	n, err := w.writer.Write(data)
+	w.written += int64(n)
+
+	return n, err //nolint:wrapcheck // Pass-through writer.
+}
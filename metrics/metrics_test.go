@@ -0,0 +1,130 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/metrics"
+)
+
+func TestWriteTo(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+
+	counter := registry.Counter("jobs_total", "Jobs done.")
+	counter.Add(2.5)
+	counter.Inc()
+
+	requests := registry.CounterVec("requests_total", "Requests by\nhandler.", "handler", "code")
+	requests.With("/b", "200").Inc()
+	requests.With(`/a"\`, "404").Add(2)
+	requests.With("/b", "200").Inc()
+
+	registry.GaugeFunc("tracks", "Stored tracks.", func() float64 { return 42 })
+
+	latency := registry.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	for _, value := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.Observe(value)
+	}
+
+	var out strings.Builder
+
+	written, err := registry.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("WriteTo() failed: %v", err)
+	}
+
+	want := `# HELP jobs_total Jobs done.
+# TYPE jobs_total counter
+jobs_total 3.5
+# HELP requests_total Requests by\nhandler.
+# TYPE requests_total counter
+requests_total{handler="/a\"\\",code="404"} 2
+requests_total{handler="/b",code="200"} 2
+# HELP tracks Stored tracks.
+# TYPE tracks gauge
+tracks 42
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 3.65
+latency_seconds_count 4
+`
+	if out.String() != want {
+		t.Errorf("WriteTo() =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if written != int64(len(want)) {
+		t.Errorf("WriteTo() = %d bytes, want %d", written, len(want))
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	counter := registry.Counter("total", "Total.")
+	vec := registry.CounterVec("by_worker", "By worker.", "worker")
+	histogram := registry.Histogram("values", "Values.", nil)
+
+	var workers sync.WaitGroup
+
+	for range 8 {
+		workers.Go(func() {
+			for range 1000 {
+				counter.Inc()
+				vec.With("w").Inc()
+				histogram.Observe(0.01)
+			}
+		})
+	}
+
+	// Scrapes run concurrently with updates.
+	_, _ = registry.WriteTo(&strings.Builder{})
+
+	workers.Wait()
+
+	if counter.Value() != 8000 || vec.With("w").Value() != 8000 {
+		t.Errorf("counters = %f, %f, want 8000", counter.Value(), vec.With("w").Value())
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	registry.Counter("total", "Total.").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := recorder.Header().Get("Content-Type"); got != metrics.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, metrics.ContentType)
+	}
+
+	if !strings.Contains(recorder.Body.String(), "\ntotal 1\n") {
+		t.Errorf("body = %q, want the counter", recorder.Body.String())
+	}
+}
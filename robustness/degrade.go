@@ -0,0 +1,325 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package robustness
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/mycophonic/sporeprint/fingerprint"
+)
+
+const (
+	// dctSize is the frame size of the lossy codec, in samples.
+	dctSize = 256
+	// maxQuantization is the quantization step of the lossy codec at
+	// strength 1, relative to a full-scale orthonormal coefficient.
+	maxQuantization = 400.0
+	// bassFrequency and trebleFrequency are the EQ shelf frequencies in Hz.
+	bassFrequency   = 250.0
+	trebleFrequency = 2500.0
+	// noiseSeed seeds the generator of the noise degradation.
+	noiseSeed = 0x5eed
+)
+
+// Degradation alters PCM audio with a given strength.
+type Degradation struct {
+	// Name identifies the degradation.
+	Name string
+	// Unit describes strengths.
+	Unit string
+	// Strengths are the strengths [Run] measures, from mildest to harshest.
+	Strengths []float64
+	// Apply returns a degraded copy of samples.
+	Apply func(samples []int16, strength float64) []int16
+}
+
+// Degradations returns the default degradations, with strengths from
+// inaudible to destructive.
+func Degradations() []Degradation {
+	return []Degradation{
+		{
+			Name:      "lossy",
+			Unit:      "fraction of spectrum dropped",
+			Strengths: []float64{0.25, 0.5, 0.75, 0.9}, //nolint:mnd
+			Apply:     Lossy,
+		},
+		{
+			Name:      "eq",
+			Unit:      "dB bass boost and treble cut",
+			Strengths: []float64{3, 6, 12, 24}, //nolint:mnd
+			Apply:     EQ,
+		},
+		{
+			Name:      "noise",
+			Unit:      "dB signal-to-noise ratio",
+			Strengths: []float64{30, 20, 10, 0}, //nolint:mnd
+			Apply:     Noise,
+		},
+		{
+			Name:      "gain",
+			Unit:      "dB",
+			Strengths: []float64{-30, -12, 6, 12}, //nolint:mnd
+			Apply:     Gain,
+		},
+		{
+			Name:      "clip",
+			Unit:      "fraction of peak kept",
+			Strengths: []float64{0.5, 0.25, 0.1, 0.02}, //nolint:mnd
+			Apply:     Clip,
+		},
+		{
+			Name:      "crop",
+			Unit:      "seconds removed from the start",
+			Strengths: []float64{1, 5, 10, 20}, //nolint:mnd
+			Apply:     Crop,
+		},
+		{
+			Name:      "speed",
+			Unit:      "playback speed factor",
+			Strengths: []float64{1.01, 1.03, 1.06, 1.12}, //nolint:mnd
+			Apply:     Speed,
+		},
+	}
+}
+
+// Generate returns a deterministic test signal of the given duration: a
+// melody of two-note chords changing every quarter of a second, over a soft
+// noise floor.
+func Generate(seconds int, seed uint64) []int16 {
+	const (
+		noteSamples = fingerprint.SampleRate / 4
+		amplitude   = 8000.0
+		noiseFloor  = 200.0
+	)
+
+	rng := rand.New(rand.NewPCG(seed, seed)) //nolint:gosec // deterministic on purpose
+	samples := make([]float64, fingerprint.SampleRate*seconds)
+
+	var freq1, freq2 float64
+
+	for i := range samples {
+		if i%noteSamples == 0 {
+			// Semitones from A3 over three octaves.
+			freq1 = 220 * math.Pow(2, float64(rng.IntN(36))/12) //nolint:mnd
+			freq2 = freq1 * 1.5                                 //nolint:mnd
+		}
+
+		t := float64(i) / fingerprint.SampleRate
+		samples[i] = amplitude*(math.Sin(2*math.Pi*freq1*t)+0.5*math.Sin(2*math.Pi*freq2*t)) + //nolint:mnd
+			noiseFloor*rng.NormFloat64()
+	}
+
+	return quantize(samples)
+}
+
+// Lossy simulates lossy encoding: each frame is transformed with a DCT, the
+// highest strength fraction of coefficients dropped and the others quantized
+// more coarsely as strength grows.
+func Lossy(samples []int16, strength float64) []int16 {
+	cosines := make([]float64, dctSize*dctSize)
+	for k := range dctSize {
+		for n := range dctSize {
+			cosines[k*dctSize+n] = math.Cos(math.Pi / dctSize * (float64(n) + 0.5) * float64(k)) //nolint:mnd
+		}
+	}
+
+	keep := int(float64(dctSize) * (1 - strength))
+	step := 1 + strength*maxQuantization
+	out := toFloat(samples)
+	coefs := make([]float64, dctSize)
+
+	for start := 0; start+dctSize <= len(out); start += dctSize {
+		frame := out[start : start+dctSize]
+
+		for k := range dctSize {
+			sum := 0.0
+			for n, value := range frame {
+				sum += value * cosines[k*dctSize+n]
+			}
+
+			scale := math.Sqrt(2.0 / dctSize) //nolint:mnd
+			if k == 0 {
+				scale = math.Sqrt(1.0 / dctSize)
+			}
+
+			coefs[k] = 0
+			if k < keep {
+				coefs[k] = math.Round(sum*scale/step) * step
+			}
+		}
+
+		for n := range frame {
+			sum := coefs[0] * math.Sqrt(1.0/dctSize)
+			for k := 1; k < keep; k++ {
+				sum += coefs[k] * math.Sqrt(2.0/dctSize) * cosines[k*dctSize+n] //nolint:mnd
+			}
+
+			frame[n] = sum
+		}
+	}
+
+	return quantize(out)
+}
+
+// EQ boosts frequencies under 250 Hz and cuts those above 2.5 kHz by
+// strength dB, with shelving biquads.
+func EQ(samples []int16, strength float64) []int16 {
+	out := toFloat(samples)
+	biquad(out, shelf(bassFrequency, strength, false))
+	biquad(out, shelf(trebleFrequency, -strength, true))
+
+	return quantize(out)
+}
+
+// Noise adds white gaussian noise at a signal-to-noise ratio of strength dB.
+func Noise(samples []int16, strength float64) []int16 {
+	out := toFloat(samples)
+
+	power := 0.0
+	for _, value := range out {
+		power += value * value
+	}
+
+	rms := math.Sqrt(power / float64(max(len(out), 1)))
+	deviation := rms / math.Pow(10, strength/20) //nolint:mnd
+
+	rng := rand.New(rand.NewPCG(noiseSeed, noiseSeed)) //nolint:gosec // deterministic on purpose
+	for i := range out {
+		out[i] += deviation * rng.NormFloat64()
+	}
+
+	return quantize(out)
+}
+
+// Gain amplifies by strength dB, clipping what exceeds 16 bits.
+func Gain(samples []int16, strength float64) []int16 {
+	out := toFloat(samples)
+	factor := math.Pow(10, strength/20) //nolint:mnd
+
+	for i := range out {
+		out[i] *= factor
+	}
+
+	return quantize(out)
+}
+
+// Clip hard-clips samples at strength times the peak amplitude.
+func Clip(samples []int16, strength float64) []int16 {
+	peak := 0.0
+	for _, sample := range samples {
+		peak = max(peak, math.Abs(float64(sample)))
+	}
+
+	limit := peak * strength
+	out := toFloat(samples)
+
+	for i := range out {
+		out[i] = min(max(out[i], -limit), limit)
+	}
+
+	return quantize(out)
+}
+
+// Crop removes the first strength seconds.
+func Crop(samples []int16, strength float64) []int16 {
+	start := min(int(strength*fingerprint.SampleRate), len(samples))
+
+	return append([]int16(nil), samples[start:]...)
+}
+
+// Speed plays samples strength times faster, changing both tempo and pitch
+// like a turntable, with linear interpolation.
+func Speed(samples []int16, strength float64) []int16 {
+	if len(samples) == 0 || strength <= 0 {
+		return nil
+	}
+
+	out := make([]float64, int(float64(len(samples)-1)/strength)+1)
+
+	for i := range out {
+		pos := float64(i) * strength
+		low := int(pos)
+		frac := pos - float64(low)
+
+		out[i] = float64(samples[low])
+		if low+1 < len(samples) {
+			out[i] += frac * (float64(samples[low+1]) - float64(samples[low]))
+		}
+	}
+
+	return quantize(out)
+}
+
+// coefficients are normalized biquad coefficients: b0, b1, b2, a1, a2.
+type coefficients [5]float64
+
+// shelf returns a shelving filter of gain dB at frequency, as in the Audio
+// EQ Cookbook with a slope of 1.
+func shelf(frequency, gain float64, high bool) coefficients {
+	amp := math.Pow(10, gain/40) //nolint:mnd
+	omega := 2 * math.Pi * frequency / fingerprint.SampleRate
+	cos := math.Cos(omega)
+	alpha := math.Sin(omega) / math.Sqrt2
+	root := 2 * math.Sqrt(amp) * alpha
+
+	sign := 1.0
+	if high {
+		sign = -1
+	}
+
+	// The cookbook low and high shelf formulas only differ by these signs.
+	b0 := amp * ((amp + 1) - sign*(amp-1)*cos + root)
+	b1 := sign * 2 * amp * ((amp - 1) - sign*(amp+1)*cos)
+	b2 := amp * ((amp + 1) - sign*(amp-1)*cos - root)
+	a0 := (amp + 1) + sign*(amp-1)*cos + root
+	a1 := -sign * 2 * ((amp - 1) + sign*(amp+1)*cos)
+	a2 := (amp + 1) + sign*(amp-1)*cos - root
+
+	return coefficients{b0 / a0, b1 / a0, b2 / a0, a1 / a0, a2 / a0}
+}
+
+// biquad filters samples in place.
+func biquad(samples []float64, coefs coefficients) {
+	var x1, x2, y1, y2 float64
+
+	for i, x0 := range samples {
+		y0 := coefs[0]*x0 + coefs[1]*x1 + coefs[2]*x2 - coefs[3]*y1 - coefs[4]*y2
+		x2, x1 = x1, x0
+		y2, y1 = y1, y0
+		samples[i] = y0
+	}
+}
+
+func toFloat(samples []int16) []float64 {
+	out := make([]float64, len(samples))
+	for i, sample := range samples {
+		out[i] = float64(sample)
+	}
+
+	return out
+}
+
+// quantize rounds samples to 16 bits, saturating.
+func quantize(samples []float64) []int16 {
+	out := make([]int16, len(samples))
+	for i, value := range samples {
+		out[i] = int16(min(max(math.Round(value), math.MinInt16), math.MaxInt16))
+	}
+
+	return out
+}
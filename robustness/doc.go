@@ -0,0 +1,25 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package robustness measures how fingerprints survive common degradations
+// of the audio: lossy encoding, EQ, noise, gain, clipping, cropping and speed
+// changes.
+//
+// Degradations are deterministic and pure Go, and apply to 16-bit PCM at
+// [fingerprint.SampleRate] Hz mono, so that [Run] works offline on signals
+// from [Generate]. The lossy codec is a crude stand-in for real encoders: a
+// DCT with high frequencies dropped and the rest quantized.
+package robustness
@@ -0,0 +1,105 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package robustness
+
+import (
+	"fmt"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/fingerprint"
+)
+
+// Result is the similarity of a degraded signal to the original.
+type Result struct {
+	Degradation string  `json:"degradation"`
+	Strength    float64 `json:"strength"`
+	Score       float64 `json:"score"`
+	// Offset is of the degraded fingerprint relative to the original, in
+	// hashes.
+	Offset int `json:"offset"`
+	// BitErrorRate is measured at Offset, as [compare.ResultBitErrorRate].
+	BitErrorRate float64 `json:"ber"`
+}
+
+// Run fingerprints signal and every degradation of it at every strength, and
+// compares each to the original with matcher (nil means [compare.Bounded]).
+func Run(signal []int16, degradations []Degradation, matcher compare.Matcher) ([]Result, error) {
+	if matcher == nil {
+		matcher = compare.Bounded{}
+	}
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	original, err := fingerprintSamples(chroma, signal)
+	if err != nil {
+		return nil, fmt.Errorf("original: %w", err)
+	}
+
+	var results []Result
+
+	for _, degradation := range degradations {
+		for _, strength := range degradation.Strengths {
+			degraded, err := fingerprintSamples(chroma, degradation.Apply(signal, strength))
+			if err != nil {
+				return nil, fmt.Errorf("%s %g: %w", degradation.Name, strength, err)
+			}
+
+			result, err := compare.Match(degraded, original, matcher)
+			if err != nil {
+				return nil, fmt.Errorf("%s %g: %w", degradation.Name, strength, err)
+			}
+
+			ber, err := compare.ResultBitErrorRate(degraded, original, result)
+			if err != nil {
+				return nil, fmt.Errorf("%s %g: %w", degradation.Name, strength, err)
+			}
+
+			results = append(results, Result{
+				Degradation:  degradation.Name,
+				Strength:     strength,
+				Score:        result.Score,
+				Offset:       result.Offset,
+				BitErrorRate: ber,
+			})
+		}
+	}
+
+	return results, nil
+}
+
+func fingerprintSamples(chroma *chromaprint.Context, samples []int16) (string, error) {
+	if err := chroma.Start(fingerprint.SampleRate, fingerprint.Channels); err != nil {
+		return "", fmt.Errorf("starting: %w", err)
+	}
+
+	if err := chroma.Feed(samples); err != nil {
+		return "", fmt.Errorf("feeding: %w", err)
+	}
+
+	if err := chroma.Finish(); err != nil {
+		return "", fmt.Errorf("finishing: %w", err)
+	}
+
+	fp, err := chroma.Fingerprint()
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting: %w", err)
+	}
+
+	return fp, nil
+}
@@ -0,0 +1,140 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package robustness_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/robustness"
+)
+
+func TestGenerateDeterministic(t *testing.T) {
+	t.Parallel()
+
+	signal := robustness.Generate(2, 1)
+	if len(signal) != 2*11025 {
+		t.Fatalf("Generate() returned %d samples, want %d", len(signal), 2*11025)
+	}
+
+	if !slices.Equal(signal, robustness.Generate(2, 1)) {
+		t.Error("Generate() is not deterministic")
+	}
+
+	if slices.Equal(signal, robustness.Generate(2, 2)) {
+		t.Error("Generate() ignores the seed")
+	}
+}
+
+func TestDegradations(t *testing.T) {
+	t.Parallel()
+
+	signal := robustness.Generate(3, 1)
+
+	for _, degradation := range robustness.Degradations() {
+		for _, strength := range degradation.Strengths {
+			degraded := degradation.Apply(signal, strength)
+
+			if !slices.Equal(degraded, degradation.Apply(signal, strength)) {
+				t.Errorf("%s %g is not deterministic", degradation.Name, strength)
+			}
+
+			if slices.Equal(degraded, signal) {
+				t.Errorf("%s %g does not change the signal", degradation.Name, strength)
+			}
+		}
+	}
+}
+
+func TestDegradationShapes(t *testing.T) {
+	t.Parallel()
+
+	signal := robustness.Generate(3, 1)
+
+	if got := len(robustness.Crop(signal, 1)); got != 2*11025 {
+		t.Errorf("Crop(1) returned %d samples, want %d", got, 2*11025)
+	}
+
+	if got, want := len(robustness.Speed(signal, 1.5)), 2*11025; math.Abs(float64(got-want)) > 1 {
+		t.Errorf("Speed(1.5) returned %d samples, want %d", got, want)
+	}
+
+	if !slices.Equal(robustness.Gain(signal, 0), signal) {
+		t.Error("Gain(0) changes the signal")
+	}
+
+	peak := func(samples []int16) int16 {
+		var found int16
+		for _, sample := range samples {
+			found = max(found, sample, -sample)
+		}
+
+		return found
+	}
+
+	if got, want := peak(robustness.Clip(signal, 0.5)), peak(signal)/2; math.Abs(float64(got-want)) > 1 {
+		t.Errorf("Clip(0.5) peak = %d, want %d", got, want)
+	}
+
+	// At 0 dB SNR, the noise is as loud as the signal.
+	noisy := robustness.Noise(signal, 0)
+
+	var signalPower, noisePower float64
+	for i := range signal {
+		signalPower += float64(signal[i]) * float64(signal[i])
+		diff := float64(noisy[i]) - float64(signal[i])
+		noisePower += diff * diff
+	}
+
+	if ratio := noisePower / signalPower; ratio < 0.9 || ratio > 1.1 {
+		t.Errorf("Noise(0) power ratio = %f, want 1", ratio)
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	signal := robustness.Generate(10, 1)
+	identity := robustness.Degradation{
+		Name:      "identity",
+		Strengths: []float64{0},
+		Apply: func(samples []int16, _ float64) []int16 {
+			return slices.Clone(samples)
+		},
+	}
+
+	results, err := robustness.Run(signal, append(robustness.Degradations(), identity), compare.Global{})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	count := 1
+	for _, degradation := range robustness.Degradations() {
+		count += len(degradation.Strengths)
+	}
+
+	if len(results) != count {
+		t.Fatalf("Run() returned %d results, want %d", len(results), count)
+	}
+
+	last := results[len(results)-1]
+	if last.Degradation != "identity" || last.Score != 1 || last.BitErrorRate != 0 || last.Offset != 0 {
+		t.Errorf("identity result = %+v, want a perfect match", last)
+	}
+}
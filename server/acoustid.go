@@ -0,0 +1,205 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/mycophonic/sporeprint/acoustid"
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/compare"
+)
+
+const (
+	// maxDurationDiff is the difference in seconds above which AcoustID
+	// discards a track whatever its score.
+	maxDurationDiff = 7
+	// maxBatch is the maximum number of fingerprints of a batch lookup.
+	maxBatch = 100
+)
+
+// LookupResult is a track found by /v2/lookup. Its ID is the database track
+// ID, where AcoustID returns its own track UUIDs.
+type LookupResult struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// LookupResponse is the response of a single /v2/lookup.
+type LookupResponse struct {
+	Status  string         `json:"status"`
+	Results []LookupResult `json:"results"`
+}
+
+// LookupFingerprint is the outcome of one fingerprint of a batch lookup.
+type LookupFingerprint struct {
+	Index   string         `json:"index"`
+	Results []LookupResult `json:"results"`
+}
+
+// BatchLookupResponse is the response of a batch /v2/lookup, with numbered
+// fingerprint.N and duration.N parameters.
+type BatchLookupResponse struct {
+	Status       string              `json:"status"`
+	Fingerprints []LookupFingerprint `json:"fingerprints"`
+}
+
+// lookup implements the AcoustID /v2/lookup request and response format over
+// the database: parameters come from the query or a form body, which may be
+// gzip-compressed as Picard sends it. The client key and meta are accepted
+// and ignored. Errors use the AcoustID format rather than [Error].
+func (s *Server) lookup(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodPost {
+		writer.Header().Set("Allow", "GET, POST")
+		writeLookupError(writer, http.StatusMethodNotAllowed, &acoustid.Error{Code: acoustid.CodeUnknownFormat, Message: "use GET or POST"})
+
+		return
+	}
+
+	response, err := s.lookupForm(writer, request)
+	if err != nil {
+		writeLookupError(writer, http.StatusBadRequest, err)
+
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, response)
+}
+
+func (s *Server) lookupForm(writer http.ResponseWriter, request *http.Request) (any, *acoustid.Error) {
+	request.Body = http.MaxBytesReader(writer, request.Body, s.options.MaxJSONBytes)
+
+	if request.Header.Get("Content-Encoding") == "gzip" {
+		unzipped, err := gzip.NewReader(request.Body)
+		if err != nil {
+			return nil, &acoustid.Error{Code: acoustid.CodeMissingParameter, Message: "invalid gzip body: " + err.Error()}
+		}
+
+		request.Body = http.MaxBytesReader(writer, readCloser{unzipped, request.Body}, s.options.MaxJSONBytes)
+	}
+
+	if err := request.ParseForm(); err != nil {
+		return nil, &acoustid.Error{Code: acoustid.CodeMissingParameter, Message: "invalid request: " + err.Error()}
+	}
+
+	if format := request.Form.Get("format"); format != "" && format != "json" {
+		return nil, &acoustid.Error{Code: acoustid.CodeUnknownFormat, Message: fmt.Sprintf("unknown format %q", format)}
+	}
+
+	if request.Form.Has("fingerprint") {
+		results, err := s.lookupOne(request.Form.Get("fingerprint"), request.Form.Get("duration"), "")
+		if err != nil {
+			return nil, err
+		}
+
+		return LookupResponse{Status: "ok", Results: results}, nil
+	}
+
+	batch := BatchLookupResponse{Status: "ok", Fingerprints: []LookupFingerprint{}}
+
+	for num := 0; num < maxBatch && request.Form.Has("fingerprint."+strconv.Itoa(num)); num++ {
+		suffix := "." + strconv.Itoa(num)
+
+		results, err := s.lookupOne(request.Form.Get("fingerprint"+suffix), request.Form.Get("duration"+suffix), suffix)
+		if err != nil {
+			return nil, err
+		}
+
+		batch.Fingerprints = append(batch.Fingerprints, LookupFingerprint{Index: strconv.Itoa(num), Results: results})
+	}
+
+	if len(batch.Fingerprints) == 0 {
+		return nil, &acoustid.Error{Code: acoustid.CodeMissingParameter, Message: `missing required parameter "fingerprint"`}
+	}
+
+	return batch, nil
+}
+
+// lookupOne searches a fingerprint, and drops tracks whose duration differs
+// from the given one by more than AcoustID allows.
+func (s *Server) lookupOne(encoded, durationParam, suffix string) ([]LookupResult, *acoustid.Error) {
+	if durationParam == "" {
+		return nil, &acoustid.Error{Code: acoustid.CodeMissingParameter, Message: fmt.Sprintf("missing required parameter %q", "duration"+suffix)}
+	}
+
+	duration, err := strconv.Atoi(durationParam)
+	if err != nil || duration <= 0 {
+		return nil, &acoustid.Error{Code: acoustid.CodeInvalidDuration, Message: fmt.Sprintf("invalid duration %q", durationParam)}
+	}
+
+	if encoded == "" {
+		return nil, &acoustid.Error{Code: acoustid.CodeMissingParameter, Message: fmt.Sprintf("missing required parameter %q", "fingerprint"+suffix)}
+	}
+
+	query, err := chromaprint.Decode(encoded)
+	if err != nil || len(query) == 0 {
+		return nil, &acoustid.Error{Code: acoustid.CodeInvalidFingerprint, Message: "invalid fingerprint"}
+	}
+
+	results := []LookupResult{}
+
+	for _, match := range s.searchDB(query) {
+		track, found := s.options.DB.Get(match.ID)
+		if !found {
+			// Removed since the search.
+			continue
+		}
+
+		if track.Duration > 0 && !durationMatches(track.Duration, float64(duration)) {
+			continue
+		}
+
+		results = append(results, LookupResult{ID: match.ID, Score: match.Score})
+	}
+
+	return results, nil
+}
+
+// durationMatches reports whether a database track whose fingerprinted audio
+// lasts stored seconds may be a track lasting duration seconds, as AcoustID
+// does within maxDurationDiff. Fingerprints are truncated to a whole number of
+// seconds, whatever length limit the database was built with: tracks that
+// last a whole number of seconds, to a hash, may have been truncated, and
+// last at least that long.
+func durationMatches(stored, duration float64) bool {
+	if math.Abs(stored-math.Round(stored)) < compare.SecondsPerHash {
+		return duration >= stored-maxDurationDiff
+	}
+
+	return math.Abs(stored-duration) <= maxDurationDiff
+}
+
+// readCloser reads a decompressed body and closes the original one.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func writeLookupError(writer http.ResponseWriter, status int, err *acoustid.Error) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(struct {
+		Status string          `json:"status"`
+		Error  *acoustid.Error `json:"error"`
+	}{Status: "error", Error: err})
+}
@@ -0,0 +1,237 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/acoustid"
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+// lookupServer serves a database holding "track-0" (20.5s), "track-1" (60.5s
+// declared, for the duration filter) and "track-2" (truncated to the default
+// length limit) with the returned fingerprints, fingerprinting with length.
+func lookupServer(t *testing.T, length int) (*httptest.Server, []string) {
+	t.Helper()
+
+	database, err := db.Open(t.TempDir(), db.Options{Create: true, Index: index.Options{Threshold: 0.4}})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	t.Cleanup(func() { _ = database.Close() })
+
+	fps := []string{encode(t, pcm(20, 0)), encode(t, pcm(20, 6)), encode(t, pcm(20, 3))}
+	durations := []float64{20.5, 60.5, fingerprint.DefaultLength}
+
+	for num, encoded := range fps {
+		raw, err := chromaprint.Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		track := index.Track{ID: "track-" + string(rune('0'+num)), Duration: durations[num], Raw: raw}
+		if err = database.Add([]index.Track{track}); err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+	}
+
+	srv := httptest.NewServer(server.New(server.Options{DB: database, Length: length}))
+	t.Cleanup(srv.Close)
+
+	return srv, fps
+}
+
+func decodeResponse(t *testing.T, response *http.Response, wantStatus int, value any) {
+	t.Helper()
+
+	defer response.Body.Close()
+
+	if response.StatusCode != wantStatus {
+		t.Errorf("status %d, want %d", response.StatusCode, wantStatus)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	srv, fps := lookupServer(t, fingerprint.DefaultLength)
+
+	// As AcoustID clients do: GET with the client key and meta.
+	response, err := http.Get(srv.URL + "/v2/lookup?" + url.Values{
+		"client":      {"key"},
+		"meta":        {"recordings"},
+		"duration":    {"20"},
+		"fingerprint": {fps[0]},
+	}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lookup server.LookupResponse
+	decodeResponse(t, response, http.StatusOK, &lookup)
+
+	if lookup.Status != "ok" || len(lookup.Results) != 1 || lookup.Results[0].ID != "track-0" || lookup.Results[0].Score != 1 {
+		t.Errorf("lookup = %+v, want track-0 with score 1", lookup)
+	}
+
+	// track-1 is declared 60.5s long: a 20s query is too far off.
+	response, err = http.PostForm(srv.URL+"/v2/lookup", url.Values{"duration": {"20"}, "fingerprint": {fps[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodeResponse(t, response, http.StatusOK, &lookup)
+
+	if lookup.Status != "ok" || lookup.Results == nil || len(lookup.Results) != 0 {
+		t.Errorf("lookup = %+v, want no results", lookup)
+	}
+}
+
+func TestLookupLongTrack(t *testing.T) {
+	t.Parallel()
+
+	// Whatever length the server fingerprints with, track-2 lasts at least
+	// the length limit it was truncated to: longer queries match, shorter ones
+	// do not.
+	for _, length := range []int{fingerprint.DefaultLength, 0, 30} {
+		srv, fps := lookupServer(t, length)
+
+		for duration, want := range map[string]int{"300": 1, "125": 1, "100": 0} {
+			response, err := http.PostForm(srv.URL+"/v2/lookup", url.Values{"duration": {duration}, "fingerprint": {fps[2]}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lookup server.LookupResponse
+			decodeResponse(t, response, http.StatusOK, &lookup)
+
+			if len(lookup.Results) != want || (want == 1 && lookup.Results[0].ID != "track-2") {
+				t.Errorf("--length %d: lookup of %ss = %+v, want %d result(s) of track-2", length, duration, lookup.Results, want)
+			}
+		}
+
+		// track-1 was not truncated: a longer query is too far off.
+		response, err := http.PostForm(srv.URL+"/v2/lookup", url.Values{"duration": {"300"}, "fingerprint": {fps[1]}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var lookup server.LookupResponse
+		decodeResponse(t, response, http.StatusOK, &lookup)
+
+		if len(lookup.Results) != 0 {
+			t.Errorf("--length %d: lookup of track-1 at 300s = %+v, want no results", length, lookup.Results)
+		}
+	}
+}
+
+func TestLookupBatchGzip(t *testing.T) {
+	t.Parallel()
+
+	srv, fps := lookupServer(t, fingerprint.DefaultLength)
+
+	form := url.Values{
+		"client":        {"key"},
+		"fingerprint.0": {fps[0]},
+		"duration.0":    {"21"},
+		"fingerprint.1": {fps[1]},
+		"duration.1":    {"58"},
+	}
+
+	var body bytes.Buffer
+
+	zipper := gzip.NewWriter(&body)
+	_, _ = zipper.Write([]byte(form.Encode()))
+	_ = zipper.Close()
+
+	request, err := http.NewRequest(http.MethodPost, srv.URL+"/v2/lookup", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Content-Encoding", "gzip")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var batch server.BatchLookupResponse
+	decodeResponse(t, response, http.StatusOK, &batch)
+
+	if len(batch.Fingerprints) != 2 {
+		t.Fatalf("batch = %+v, want 2 fingerprints", batch)
+	}
+
+	for num, found := range batch.Fingerprints {
+		want := "track-" + string(rune('0'+num))
+		if found.Index != string(rune('0'+num)) || len(found.Results) != 1 || found.Results[0].ID != want {
+			t.Errorf("fingerprint %d = %+v, want %s", num, found, want)
+		}
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	t.Parallel()
+
+	srv, fps := lookupServer(t, fingerprint.DefaultLength)
+
+	for _, tc := range []struct {
+		query string
+		code  acoustid.Code
+	}{
+		{"duration=20", acoustid.CodeMissingParameter},
+		{"fingerprint=" + fps[0], acoustid.CodeMissingParameter},
+		{"duration=x&fingerprint=" + fps[0], acoustid.CodeInvalidDuration},
+		{"duration=20&fingerprint=!!!", acoustid.CodeInvalidFingerprint},
+		{"format=xml&duration=20&fingerprint=" + fps[0], acoustid.CodeUnknownFormat},
+	} {
+		response, err := http.Post(srv.URL+"/v2/lookup", "application/x-www-form-urlencoded", strings.NewReader(tc.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var lookup struct {
+			Status string         `json:"status"`
+			Error  acoustid.Error `json:"error"`
+		}
+
+		decodeResponse(t, response, http.StatusBadRequest, &lookup)
+
+		if lookup.Status != "error" || lookup.Error.Code != tc.code {
+			t.Errorf("%s = %+v, want error %d", tc.query, lookup, tc.code)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/compare"
+	"github.com/mycophonic/sporeprint/fingerprint"
+)
+
+// wavMagic starts WAV streams.
+const wavMagic = "RIFF"
+
+// The operations behind the endpoints, for other transports. They return
+// [*Error] on failure.
+
+// Fingerprint reads raw PCM or WAV and fingerprints it with chroma, as
+// /v1/fingerprint.
+func (s *Server) Fingerprint(chroma *chromaprint.Context, reader io.Reader, options fingerprint.Options) (FingerprintResponse, error) {
+	body := bufio.NewReader(reader)
+	reader = body
+
+	// WAV is told apart from raw PCM by its header.
+	if magic, _ := body.Peek(len(wavMagic)); string(magic) == wavMagic {
+		var err error
+		if reader, err = fingerprint.ReadWAV(body); err != nil {
+			return FingerprintResponse{}, readError(err, CodeInvalidArgs)
+		}
+	}
+
+	result, err := fingerprint.Stream(chroma, reader, options)
+	if err != nil {
+		if errors.Is(err, fingerprint.ErrRead) {
+			return FingerprintResponse{}, readError(err, CodeReadFailure)
+		}
+
+		return FingerprintResponse{}, &Error{Code: CodeChromaprintFailure, Message: err.Error()}
+	}
+
+	return s.fingerprinted(result), nil
+}
+
+// FingerprintFile decodes an audio file with ffmpeg and fingerprints it with
+// chroma, as [fingerprint.File]. It is not exposed over HTTP.
+func (s *Server) FingerprintFile(ctx context.Context, chroma *chromaprint.Context, path string, options fingerprint.Options) (FingerprintResponse, error) {
+	result, err := fingerprint.File(ctx, chroma, path, options)
+	if errors.Is(err, fingerprint.ErrDecode) || errors.Is(err, fingerprint.ErrRead) {
+		return FingerprintResponse{}, &Error{Code: CodeReadFailure, Message: err.Error()}
+	}
+
+	if err != nil {
+		return FingerprintResponse{}, &Error{Code: CodeChromaprintFailure, Message: err.Error()}
+	}
+
+	return s.fingerprinted(result), nil
+}
+
+// fingerprinted counts a computed fingerprint.
+func (s *Server) fingerprinted(result fingerprint.Result) FingerprintResponse {
+	s.metrics.fingerprints.Inc()
+	s.metrics.audioSeconds.Add(result.Duration)
+
+	return FingerprintResponse{Fingerprint: result.Fingerprint, Duration: result.Duration}
+}
+
+// Compare compares two fingerprints, as /v1/compare.
+func (s *Server) Compare(req CompareRequest) (CompareResponse, error) {
+	var matcher compare.Matcher = compare.Bounded{}
+	if req.Global {
+		matcher = compare.Global{}
+	}
+
+	if req.Tempo {
+		matcher = compare.Tempo{Base: matcher}
+	}
+
+	start := time.Now()
+
+	result, err := compare.Match(req.Fingerprint1, req.Fingerprint2, matcher)
+
+	s.metrics.compares.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		return CompareResponse{}, &Error{Code: CodeCompareFailure, Message: err.Error()}
+	}
+
+	ber, err := compare.ResultBitErrorRate(req.Fingerprint1, req.Fingerprint2, result)
+	if err != nil {
+		return CompareResponse{}, &Error{Code: CodeCompareFailure, Message: err.Error()}
+	}
+
+	return CompareResponse{
+		Score:        result.Score,
+		Offset:       result.Offset,
+		Scale:        result.Scale,
+		BitErrorRate: ber,
+		Threshold:    s.options.Threshold,
+		Match:        result.Score >= s.options.Threshold,
+	}, nil
+}
+
+// Search searches the database, as /v1/search. It fails with CodeNotFound
+// without a database.
+func (s *Server) Search(req SearchRequest) (SearchResponse, error) {
+	if s.options.DB == nil {
+		return SearchResponse{}, &Error{Code: CodeNotFound, Message: "no database loaded"}
+	}
+
+	if req.Limit < 0 {
+		return SearchResponse{}, &Error{Code: CodeInvalidArgs, Message: "negative limit"}
+	}
+
+	query, err := chromaprint.Decode(req.Fingerprint)
+	if err != nil {
+		return SearchResponse{}, &Error{Code: CodeCompareFailure, Message: err.Error()}
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+
+	matches := s.searchDB(query)
+	response := SearchResponse{Matches: make([]SearchMatch, 0, min(len(matches), limit))}
+
+	for _, match := range matches[:min(len(matches), limit)] {
+		response.Matches = append(response.Matches, SearchMatch{ID: match.ID, Score: match.Score, Offset: match.Offset})
+	}
+
+	return response, nil
+}
@@ -0,0 +1,162 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mycophonic/sporeprint/fingerprint"
+)
+
+// unixBaseURL addresses the server behind a Unix socket: the host is ignored.
+const unixBaseURL = "http://unix"
+
+var (
+	// ErrUnavailable happens when the server cannot be reached.
+	ErrUnavailable = errors.New("server: unavailable")
+	// ErrResponse happens when the server answers something unexpected.
+	ErrResponse = errors.New("server: unexpected response")
+)
+
+// Client calls a [Server]. It is safe for concurrent use. Failures reported
+// by the server are [*Error].
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a client of the server at baseURL, without trailing
+// slash, sending requests with httpClient. Nil means [http.DefaultClient].
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{baseURL: baseURL, http: httpClient}
+}
+
+// DialUnix returns a client of the server listening on the Unix socket at
+// path. Connections are only made by requests.
+func DialUnix(path string) *Client {
+	var dialer net.Dialer
+
+	return NewClient(unixBaseURL, &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}})
+}
+
+// Health checks that the server answers, and returns what it serves.
+func (c *Client) Health(ctx context.Context) (HealthResponse, error) {
+	var response HealthResponse
+
+	err := c.do(ctx, http.MethodGet, "/healthz", "", nil, &response)
+
+	return response, err
+}
+
+// Fingerprint uploads raw PCM or WAV from reader, as [Server.Fingerprint].
+// options.FFmpeg is ignored.
+func (c *Client) Fingerprint(ctx context.Context, reader io.Reader, options fingerprint.Options) (FingerprintResponse, error) {
+	query := url.Values{"length": {strconv.Itoa(options.Length)}}
+	// Invalid speeds are sent too, for the server to reject them.
+	if options.Speed != 0 && options.Speed != 1 {
+		query.Set("speed", strconv.FormatFloat(options.Speed, 'g', -1, 64))
+	}
+
+	var response FingerprintResponse
+
+	err := c.do(ctx, http.MethodPost, "/v1/fingerprint?"+query.Encode(), "application/octet-stream", reader, &response)
+
+	return response, err
+}
+
+// Compare compares two fingerprints, as [Server.Compare].
+func (c *Client) Compare(ctx context.Context, req CompareRequest) (CompareResponse, error) {
+	var response CompareResponse
+
+	err := c.post(ctx, "/v1/compare", req, &response)
+
+	return response, err
+}
+
+// Search searches the server database, as [Server.Search].
+func (c *Client) Search(ctx context.Context, req SearchRequest) (SearchResponse, error) {
+	var response SearchResponse
+
+	err := c.post(ctx, "/v1/search", req, &response)
+
+	return response, err
+}
+
+func (c *Client) post(ctx context.Context, path string, req, response any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+
+	return c.do(ctx, http.MethodPost, path, "application/json", bytes.NewReader(body), response)
+}
+
+// do sends a request and decodes its JSON response, or error.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, response any) error {
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		var envelope struct {
+			Error *Error `json:"error"`
+		}
+
+		if err = decoder.Decode(&envelope); err != nil || envelope.Error == nil {
+			return fmt.Errorf("%w: HTTP %d", ErrResponse, resp.StatusCode)
+		}
+
+		return envelope.Error
+	}
+
+	if err = decoder.Decode(response); err != nil {
+		return fmt.Errorf("%w: %w", ErrResponse, err)
+	}
+
+	return nil
+}
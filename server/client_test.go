@@ -0,0 +1,166 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+// listenUnix serves handler on a Unix socket and returns its path.
+func listenUnix(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	// Socket paths are limited to about 100 bytes: t.TempDir() is too long.
+	dir, err := os.MkdirTemp("", "sporeprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := &http.Server{Handler: handler}
+
+	go func() { _ = httpServer.Serve(listener) }()
+
+	t.Cleanup(func() { _ = httpServer.Close() })
+
+	return socket
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := server.DialUnix(listenUnix(t, server.New(server.Options{Threshold: 0.4, Length: 120})))
+
+	if _, err := client.Health(ctx); err != nil {
+		t.Fatalf("Health() failed: %v", err)
+	}
+
+	data := pcm(5, 0)
+
+	fp, err := client.Fingerprint(ctx, bytes.NewReader(data), fingerprint.Options{Length: 3})
+	if err != nil {
+		t.Fatalf("Fingerprint() failed: %v", err)
+	}
+
+	if want := encode(t, data[:3*2*11025]); fp.Fingerprint != want || fp.Duration != 3 {
+		t.Errorf("Fingerprint() = %q (%fs), want %q (3s)", fp.Fingerprint, fp.Duration, want)
+	}
+
+	// The speed is undone on the server.
+	fast, err := client.Fingerprint(ctx, bytes.NewReader(data), fingerprint.Options{Speed: 2})
+	if err != nil || math.Abs(fast.Duration-10) > 0.01 {
+		t.Errorf("Fingerprint(speed 2) = %+v, %v, want 10s", fast, err)
+	}
+
+	// Invalid speeds are rejected rather than treated as 1.
+	apiErr := &server.Error{}
+
+	for _, speed := range []float64{-2, math.NaN()} {
+		_, err = client.Fingerprint(ctx, bytes.NewReader(data), fingerprint.Options{Speed: speed})
+		if !errors.As(err, &apiErr) || apiErr.Code != server.CodeInvalidArgs {
+			t.Errorf("Fingerprint(speed %g) error = %v, want %s", speed, err, server.CodeInvalidArgs)
+		}
+	}
+
+	cmp, err := client.Compare(ctx, server.CompareRequest{Fingerprint1: fp.Fingerprint, Fingerprint2: fp.Fingerprint})
+	if err != nil || cmp.Score != 1 || !cmp.Match {
+		t.Errorf("Compare() = %+v, %v, want a perfect match", cmp, err)
+	}
+
+	// No database loaded.
+	_, err = client.Search(ctx, server.SearchRequest{Fingerprint: fp.Fingerprint})
+	if !errors.As(err, &apiErr) || apiErr.Code != server.CodeNotFound {
+		t.Errorf("Search() error = %v, want %s", err, server.CodeNotFound)
+	}
+}
+
+func TestClientSearch(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	database, err := db.Open(dir, db.Options{Create: true, Index: index.Options{Threshold: 0.4}})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	t.Cleanup(func() { _ = database.Close() })
+
+	encoded := encode(t, pcm(20, 0))
+
+	raw, err := chromaprint.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = database.Add([]index.Track{{ID: "track", Raw: raw}}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	ctx := context.Background()
+	client := server.DialUnix(listenUnix(t, server.New(server.Options{DB: database})))
+
+	health, err := client.Health(ctx)
+	if err != nil || health.DB != dir {
+		t.Errorf("Health() = %+v, %v, want the database in %s", health, err, dir)
+	}
+
+	found, err := client.Search(ctx, server.SearchRequest{Fingerprint: encoded})
+	if err != nil || len(found.Matches) != 1 || found.Matches[0].ID != "track" {
+		t.Errorf("Search() = %+v, %v, want the track", found, err)
+	}
+}
+
+func TestClientUnavailable(t *testing.T) {
+	t.Parallel()
+
+	client := server.DialUnix(filepath.Join(t.TempDir(), "missing"))
+	if _, err := client.Health(context.Background()); !errors.Is(err, server.ErrUnavailable) {
+		t.Errorf("Health() error = %v, want ErrUnavailable", err)
+	}
+
+	unexpected := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(unexpected.Close)
+
+	if _, err := server.NewClient(unexpected.URL, nil).Health(context.Background()); !errors.Is(err, server.ErrResponse) {
+		t.Errorf("Health() error = %v, want ErrResponse", err)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package server exposes fingerprinting, comparison and database search over
+// HTTP, for services that cannot link the library.
+//
+// Endpoints:
+//
+//	POST /v1/fingerprint  raw PCM or WAV body, as "sporeprint fingerprint", with
+//	                      optional ?length= and ?speed=
+//	POST /v1/compare      {"fingerprint1", "fingerprint2", "global", "tempo"}
+//	POST /v1/search       {"fingerprint", "limit"}, with a database
+//	GET  /v2/lookup       AcoustID lookup, with a database
+//	GET  /healthz
+//	GET  /metrics         Prometheus text format
+//
+// Every response is JSON. Errors are {"error": {"code", "message"}}, with a
+// [Code] mirroring the exit errors of the command line.
+//
+// /v2/lookup (GET or POST) speaks the AcoustID web service format instead, so
+// that AcoustID clients such as MusicBrainz Picard can be pointed at a local
+// database: fingerprint and duration parameters in, {"status": "ok",
+// "results": [{"id", "score"}]} out, and AcoustID error codes. Tracks whose
+// duration differs from the query's are discarded as AcoustID does, except that
+// tracks fingerprinted up to [Options.Length] only need to last at least that.
+//
+// Requests are logged with [log/slog], and counted along with fingerprints,
+// comparison latencies and searches on /metrics.
+//
+// [Client] calls the endpoints, over TCP or a Unix socket.
+package server
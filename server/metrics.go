@@ -0,0 +1,93 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/metrics"
+)
+
+// serverMetrics are the metrics served on /metrics.
+type serverMetrics struct {
+	fingerprints *metrics.Counter
+	audioSeconds *metrics.Counter
+	compares     *metrics.Histogram
+	searches     *metrics.Counter
+	searchHits   *metrics.Counter
+	requests     *metrics.CounterVec
+}
+
+func newServerMetrics(registry *metrics.Registry, database *db.DB) *serverMetrics {
+	srvMetrics := &serverMetrics{
+		fingerprints: registry.Counter("sporeprint_fingerprints_total",
+			"Fingerprints computed."),
+		audioSeconds: registry.Counter("sporeprint_audio_seconds_total",
+			"Seconds of audio fingerprinted."),
+		compares: registry.Histogram("sporeprint_compare_duration_seconds",
+			"Time taken by fingerprint comparisons.", nil),
+		searches: registry.Counter("sporeprint_searches_total",
+			"Database searches, including AcoustID lookups."),
+		searchHits: registry.Counter("sporeprint_search_hits_total",
+			"Database searches that matched at least one track."),
+		requests: registry.CounterVec("sporeprint_http_requests_total",
+			"HTTP requests by handler pattern and status code.", "handler", "code"),
+	}
+
+	if database != nil {
+		registry.GaugeFunc("sporeprint_index_tracks", "Tracks in the database.", func() float64 {
+			return float64(database.Stats().Tracks)
+		})
+		registry.GaugeFunc("sporeprint_index_hashes", "Hashes in the database, including deleted tracks.", func() float64 {
+			return float64(database.Stats().Hashes)
+		})
+	}
+
+	return srvMetrics
+}
+
+// searchDB searches the database, counting searches and hits.
+func (s *Server) searchDB(query []uint32) []index.Match {
+	matches := s.options.DB.Search(query)
+
+	s.metrics.searches.Inc()
+
+	if len(matches) > 0 {
+		s.metrics.searchHits.Inc()
+	}
+
+	return matches
+}
+
+// statusRecorder records the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap supports [http.ResponseController].
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	database, err := db.Open(t.TempDir(), db.Options{Create: true})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	t.Cleanup(func() { _ = database.Close() })
+
+	encoded := encode(t, pcm(10, 0))
+
+	raw, err := chromaprint.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = database.Add([]index.Track{{ID: "stored", Raw: raw}}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	var logs bytes.Buffer
+
+	handler := server.New(server.Options{
+		DB:     database,
+		Length: 120,
+		Logger: slog.New(slog.NewJSONHandler(&logs, nil)),
+	})
+
+	var response any
+
+	call(t, handler, http.MethodPost, "/v1/fingerprint", pcm(4, 1), &response)
+	call(t, handler, http.MethodPost, "/v1/compare", marshal(t, server.CompareRequest{Fingerprint1: encoded, Fingerprint2: encoded}), &response)
+	call(t, handler, http.MethodPost, "/v1/search", marshal(t, server.SearchRequest{Fingerprint: encoded}), &response)
+	call(t, handler, http.MethodPost, "/v1/search", marshal(t, server.SearchRequest{Fingerprint: encode(t, pcm(10, 7))}), &response)
+	call(t, handler, http.MethodGet, "/nowhere", nil, &response)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := recorder.Body.String()
+
+	for _, want := range []string{
+		"sporeprint_fingerprints_total 1\n",
+		"sporeprint_audio_seconds_total 4\n",
+		"sporeprint_compare_duration_seconds_count 1\n",
+		"sporeprint_searches_total 2\n",
+		"sporeprint_search_hits_total 1\n",
+		"sporeprint_index_tracks 1\n",
+		`sporeprint_http_requests_total{handler="/v1/search",code="200"} 2` + "\n",
+		`sporeprint_http_requests_total{handler="/",code="404"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics lacks %q:\n%s", want, body)
+		}
+	}
+
+	// One structured line per request, /metrics included.
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("got %d log lines, want 6:\n%s", len(lines), logs.String())
+	}
+
+	var entry struct {
+		Msg    string `json:"msg"`
+		Method string `json:"method"`
+		Path   string `json:"path"`
+		Status int    `json:"status"`
+	}
+
+	if err = json.Unmarshal([]byte(lines[4]), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Msg != "request" || entry.Method != http.MethodGet || entry.Path != "/nowhere" || entry.Status != http.StatusNotFound {
+		t.Errorf("log entry = %+v, want the GET /nowhere request", entry)
+	}
+}
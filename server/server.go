@@ -0,0 +1,345 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/metrics"
+)
+
+const (
+	// DefaultMaxAudioBytes is the default size limit of audio bodies: about
+	// 50 minutes of PCM at 11025 Hz mono.
+	DefaultMaxAudioBytes = 64 << 20
+	// DefaultMaxJSONBytes is the default size limit of JSON bodies.
+	DefaultMaxJSONBytes = 4 << 20
+	// DefaultSearchLimit is the default number of search matches returned.
+	DefaultSearchLimit = 10
+)
+
+// Code identifies an error in responses.
+type Code string
+
+// Error codes, mirroring the exit errors of the command line.
+const (
+	CodeInvalidArgs        Code = "invalid_args"
+	CodeReadFailure        Code = "read_failure"
+	CodeChromaprintFailure Code = "chromaprint_failure"
+	CodeCompareFailure     Code = "compare_failure"
+	CodeDatabaseFailure    Code = "database_failure"
+	CodeTooLarge           Code = "too_large"
+	CodeNotFound           Code = "not_found"
+	CodeMethodNotAllowed   Code = "method_not_allowed"
+)
+
+//nolint:gochecknoglobals // Immutable lookup table.
+var codeStatus = map[Code]int{
+	CodeInvalidArgs:        http.StatusBadRequest,
+	CodeReadFailure:        http.StatusBadRequest,
+	CodeChromaprintFailure: http.StatusInternalServerError,
+	CodeCompareFailure:     http.StatusUnprocessableEntity,
+	CodeDatabaseFailure:    http.StatusInternalServerError,
+	CodeTooLarge:           http.StatusRequestEntityTooLarge,
+	CodeNotFound:           http.StatusNotFound,
+	CodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+}
+
+// Options configures a [Server].
+type Options struct {
+	// DB is searched by /v1/search. Nil disables the endpoint.
+	DB *db.DB
+	// Threshold is the score at or above which /v1/compare reports a match.
+	Threshold float64
+	// Length is the default maximum audio length fingerprinted, in seconds,
+	// as [fingerprint.Options]. Requests override it with ?length=, and may
+	// set the speed with ?speed=.
+	Length int
+	// MaxAudioBytes limits audio bodies. Zero means DefaultMaxAudioBytes.
+	MaxAudioBytes int64
+	// MaxJSONBytes limits JSON bodies. Zero means DefaultMaxJSONBytes.
+	MaxJSONBytes int64
+	// Logger logs requests. Nil means [slog.Default].
+	Logger *slog.Logger
+}
+
+// Server is an [http.Handler] serving the API.
+type Server struct {
+	options  Options
+	mux      *http.ServeMux
+	registry *metrics.Registry
+	metrics  *serverMetrics
+}
+
+// Error is an API error, as returned in responses.
+type Error struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// HealthResponse is the response of /healthz.
+type HealthResponse struct {
+	Status string `json:"status"`
+	// DB is the absolute directory of the database searched by /v1/search,
+	// if any.
+	DB string `json:"db,omitempty"`
+}
+
+// FingerprintResponse is the response of /v1/fingerprint.
+type FingerprintResponse struct {
+	Fingerprint string  `json:"fingerprint"`
+	Duration    float64 `json:"duration"`
+}
+
+// CompareRequest is the request of /v1/compare.
+type CompareRequest struct {
+	Fingerprint1 string `json:"fingerprint1"`
+	Fingerprint2 string `json:"fingerprint2"`
+	// Global searches all alignment offsets, as [compare.Global].
+	Global bool `json:"global"`
+	// Tempo also searches time-scale factors, as [compare.Tempo].
+	Tempo bool `json:"tempo"`
+}
+
+// CompareResponse is the response of /v1/compare.
+type CompareResponse struct {
+	Score        float64 `json:"score"`
+	Offset       int     `json:"offset"`
+	Scale        float64 `json:"scale"`
+	BitErrorRate float64 `json:"ber"`
+	Threshold    float64 `json:"threshold"`
+	Match        bool    `json:"match"`
+}
+
+// SearchRequest is the request of /v1/search.
+type SearchRequest struct {
+	Fingerprint string `json:"fingerprint"`
+	// Limit is the maximum number of matches. Zero means
+	// DefaultSearchLimit.
+	Limit int `json:"limit"`
+}
+
+// SearchMatch is a track found by /v1/search.
+type SearchMatch struct {
+	ID     string  `json:"id"`
+	Score  float64 `json:"score"`
+	Offset int     `json:"offset"`
+}
+
+// SearchResponse is the response of /v1/search.
+type SearchResponse struct {
+	Matches []SearchMatch `json:"matches"`
+}
+
+// New returns a server.
+func New(options Options) *Server {
+	if options.MaxAudioBytes <= 0 {
+		options.MaxAudioBytes = DefaultMaxAudioBytes
+	}
+
+	if options.MaxJSONBytes <= 0 {
+		options.MaxJSONBytes = DefaultMaxJSONBytes
+	}
+
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+
+	registry := metrics.NewRegistry()
+	srv := &Server{
+		options:  options,
+		mux:      http.NewServeMux(),
+		registry: registry,
+		metrics:  newServerMetrics(registry, options.DB),
+	}
+
+	srv.handle("/healthz", http.MethodGet, srv.health)
+	srv.mux.Handle("/metrics", registry)
+	srv.handle("/v1/fingerprint", http.MethodPost, srv.fingerprint)
+	srv.handle("/v1/compare", http.MethodPost, srv.compare)
+
+	if options.DB != nil {
+		srv.handle("/v1/search", http.MethodPost, srv.search)
+		srv.mux.HandleFunc("/v2/lookup", srv.lookup)
+	}
+
+	srv.mux.HandleFunc("/", func(writer http.ResponseWriter, _ *http.Request) {
+		writeError(writer, &Error{Code: CodeNotFound, Message: "no such endpoint"})
+	})
+
+	return srv
+}
+
+// ServeHTTP implements [http.Handler].
+func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+
+	s.mux.ServeHTTP(recorder, request)
+
+	// The mux sets the pattern, which keeps label values few.
+	s.metrics.requests.With(request.Pattern, strconv.Itoa(recorder.status)).Inc()
+
+	s.options.Logger.LogAttrs(request.Context(), slog.LevelInfo, "request",
+		slog.String("method", request.Method),
+		slog.String("path", request.URL.Path),
+		slog.Int("status", recorder.status),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("remote", request.RemoteAddr),
+	)
+}
+
+// Metrics returns the metrics served on /metrics, for other transports.
+func (s *Server) Metrics() *metrics.Registry {
+	return s.registry
+}
+
+// handle registers a handler returning its response, or an error.
+func (s *Server) handle(path, method string, handler func(http.ResponseWriter, *http.Request) (any, error)) {
+	s.mux.HandleFunc(path, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != method {
+			writer.Header().Set("Allow", method)
+			writeError(writer, &Error{Code: CodeMethodNotAllowed, Message: "use " + method})
+
+			return
+		}
+
+		response, err := handler(writer, request)
+		if err != nil {
+			if apiErr := (*Error)(nil); !errors.As(err, &apiErr) || codeStatus[apiErr.Code] >= http.StatusInternalServerError {
+				s.options.Logger.ErrorContext(request.Context(), "request failed", "path", path, "error", err)
+			}
+
+			writeError(writer, err)
+
+			return
+		}
+
+		writeJSON(writer, http.StatusOK, response)
+	})
+}
+
+func (s *Server) health(http.ResponseWriter, *http.Request) (any, error) {
+	response := HealthResponse{Status: "ok"}
+
+	if s.options.DB != nil {
+		dir, err := filepath.Abs(s.options.DB.Dir())
+		if err != nil {
+			return nil, &Error{Code: CodeDatabaseFailure, Message: err.Error()}
+		}
+
+		response.DB = dir
+	}
+
+	return response, nil
+}
+
+func (s *Server) fingerprint(writer http.ResponseWriter, request *http.Request) (any, error) {
+	options := fingerprint.Options{Length: s.options.Length}
+
+	if value := request.URL.Query().Get("length"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			return nil, &Error{Code: CodeInvalidArgs, Message: fmt.Sprintf("invalid length %q", value)}
+		}
+
+		options.Length = length
+	}
+
+	if value := request.URL.Query().Get("speed"); value != "" {
+		speed, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(speed > 0) {
+			return nil, &Error{Code: CodeInvalidArgs, Message: fmt.Sprintf("invalid speed %q", value)}
+		}
+
+		options.Speed = speed
+	}
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	return s.Fingerprint(chroma, http.MaxBytesReader(writer, request.Body, s.options.MaxAudioBytes), options)
+}
+
+func (s *Server) compare(writer http.ResponseWriter, request *http.Request) (any, error) {
+	var req CompareRequest
+	if err := s.decode(writer, request, &req); err != nil {
+		return nil, err
+	}
+
+	return s.Compare(req)
+}
+
+func (s *Server) search(writer http.ResponseWriter, request *http.Request) (any, error) {
+	var req SearchRequest
+	if err := s.decode(writer, request, &req); err != nil {
+		return nil, err
+	}
+
+	return s.Search(req)
+}
+
+// decode reads a JSON request body into value.
+func (s *Server) decode(writer http.ResponseWriter, request *http.Request, value any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, s.options.MaxJSONBytes))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(value); err != nil {
+		return readError(err, CodeInvalidArgs)
+	}
+
+	return nil
+}
+
+// readError reports body read failures as code, unless the body is too large.
+func readError(err error, code Code) *Error {
+	if maxBytes := (*http.MaxBytesError)(nil); errors.As(err, &maxBytes) {
+		return &Error{Code: CodeTooLarge, Message: fmt.Sprintf("body exceeds %d bytes", maxBytes.Limit)}
+	}
+
+	return &Error{Code: code, Message: err.Error()}
+}
+
+func writeError(writer http.ResponseWriter, err error) {
+	apiErr := &Error{}
+	if !errors.As(err, &apiErr) {
+		apiErr = &Error{Code: CodeChromaprintFailure, Message: err.Error()}
+	}
+
+	writeJSON(writer, codeStatus[apiErr.Code], map[string]*Error{"error": apiErr})
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(value)
+}
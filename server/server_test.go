@@ -0,0 +1,226 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+// pcm encodes numSeconds of a varying tone as s16le bytes. Seeds change both
+// the notes and their order.
+func pcm(numSeconds, seed int) []byte {
+	buf := make([]byte, 0, 2*11025*numSeconds)
+
+	for i := range 11025 * numSeconds {
+		freq := 220 + float64((i/2756+seed)%12)*float64(40+13*seed)
+		sample := int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/11025))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(sample))
+	}
+
+	return buf
+}
+
+func wav(data []byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+
+	for _, field := range []any{uint32(16), uint16(1), uint16(1), uint32(11025), uint32(22050), uint16(2), uint16(16)} {
+		_ = binary.Write(&buf, binary.LittleEndian, field)
+	}
+
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+
+	return buf.Bytes()
+}
+
+func encode(t *testing.T, data []byte) string {
+	t.Helper()
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	result, err := fingerprint.Stream(chroma, bytes.NewReader(data), fingerprint.Options{})
+	if err != nil {
+		t.Fatalf("Stream() failed: %v", err)
+	}
+
+	return result.Fingerprint
+}
+
+// call posts body to path and decodes the JSON response into response.
+func call(t *testing.T, handler http.Handler, method, path string, body []byte, response any) int {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, bytes.NewReader(body)))
+
+	if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("%s %s Content-Type = %q, want application/json", method, path, got)
+	}
+
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("%s %s returned invalid JSON %q: %v", method, path, recorder.Body.String(), err)
+	}
+
+	return recorder.Code
+}
+
+func marshal(t *testing.T, value any) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+type errorResponse struct {
+	Error server.Error `json:"error"`
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	handler := server.New(server.Options{Length: 3})
+	data := pcm(5, 0)
+	want := encode(t, data[:3*2*11025])
+
+	for name, body := range map[string][]byte{"pcm": data, "wav": wav(data)} {
+		var response server.FingerprintResponse
+		if code := call(t, handler, http.MethodPost, "/v1/fingerprint", body, &response); code != http.StatusOK {
+			t.Fatalf("%s: status %d", name, code)
+		}
+
+		if response.Fingerprint != want || response.Duration != 3 {
+			t.Errorf("%s: fingerprint %q (%fs), want %q (3s)", name, response.Fingerprint, response.Duration, want)
+		}
+	}
+
+	var response server.FingerprintResponse
+	if code := call(t, handler, http.MethodPost, "/v1/fingerprint?length=0", data, &response); code != http.StatusOK || response.Duration != 5 {
+		t.Errorf("length=0: status %d, duration %f, want the whole 5s", code, response.Duration)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	handler := server.New(server.Options{MaxAudioBytes: 1000, MaxJSONBytes: 100})
+	stereo := wav(pcm(1, 0))
+	binary.LittleEndian.PutUint16(stereo[22:], 2)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   []byte
+		status int
+		code   server.Code
+	}{
+		{http.MethodPost, "/v1/fingerprint?length=x", pcm(1, 0), http.StatusBadRequest, server.CodeInvalidArgs},
+		{http.MethodPost, "/v1/fingerprint", stereo, http.StatusBadRequest, server.CodeInvalidArgs},
+		{http.MethodPost, "/v1/fingerprint?length=0", pcm(1, 0), http.StatusRequestEntityTooLarge, server.CodeTooLarge},
+		{http.MethodGet, "/v1/fingerprint", nil, http.StatusMethodNotAllowed, server.CodeMethodNotAllowed},
+		{http.MethodPost, "/v1/compare", []byte(`{"fingerprint1": "x"`), http.StatusBadRequest, server.CodeInvalidArgs},
+		{http.MethodPost, "/v1/compare", []byte(`{"unknown": 1}`), http.StatusBadRequest, server.CodeInvalidArgs},
+		{http.MethodPost, "/v1/compare", []byte(`{"fingerprint1": "` + strings.Repeat("A", 100) + `"}`), http.StatusRequestEntityTooLarge, server.CodeTooLarge},
+		{http.MethodPost, "/v1/compare", []byte(`{"fingerprint1": "!!!", "fingerprint2": "!!!"}`), http.StatusUnprocessableEntity, server.CodeCompareFailure},
+		// No database loaded.
+		{http.MethodPost, "/v1/search", []byte(`{}`), http.StatusNotFound, server.CodeNotFound},
+	} {
+		var response errorResponse
+		if code := call(t, handler, tc.method, tc.path, tc.body, &response); code != tc.status || response.Error.Code != tc.code {
+			t.Errorf("%s %s = %d %+v, want %d %s", tc.method, tc.path, code, response.Error, tc.status, tc.code)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	handler := server.New(server.Options{Threshold: 0.4})
+	fp1, fp2 := encode(t, pcm(10, 0)), encode(t, pcm(10, 5))
+
+	var same server.CompareResponse
+	if code := call(t, handler, http.MethodPost, "/v1/compare",
+		marshal(t, server.CompareRequest{Fingerprint1: fp1, Fingerprint2: fp1}), &same); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+
+	if same.Score != 1 || same.BitErrorRate != 0 || !same.Match || same.Threshold != 0.4 || same.Scale != 1 {
+		t.Errorf("compare(fp, fp) = %+v, want a perfect match", same)
+	}
+
+	var different server.CompareResponse
+	call(t, handler, http.MethodPost, "/v1/compare",
+		marshal(t, server.CompareRequest{Fingerprint1: fp1, Fingerprint2: fp2, Global: true}), &different)
+
+	if different.Score >= same.Score || different.BitErrorRate <= 0 {
+		t.Errorf("compare(fp1, fp2) = %+v, want a worse score than identical fingerprints", different)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	database, err := db.Open(t.TempDir(), db.Options{Create: true})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	t.Cleanup(func() { _ = database.Close() })
+
+	encoded := encode(t, pcm(20, 0))
+
+	raw, err := chromaprint.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = database.Add([]index.Track{{ID: "stored", Raw: raw}}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	handler := server.New(server.Options{DB: database})
+
+	var response server.SearchResponse
+	if code := call(t, handler, http.MethodPost, "/v1/search",
+		marshal(t, server.SearchRequest{Fingerprint: encoded}), &response); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+
+	if len(response.Matches) != 1 || response.Matches[0].ID != "stored" || response.Matches[0].Score != 1 {
+		t.Errorf("search = %+v, want the stored track", response.Matches)
+	}
+}
@@ -0,0 +1,31 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package tags reads and writes AcoustID fingerprints in audio file tags, as
+// MusicBrainz Picard does:
+//
+//   - FLAC: ACOUSTID_FINGERPRINT Vorbis comment
+//   - Ogg Vorbis and Opus: ACOUSTID_FINGERPRINT comment
+//   - MP3 (and other ID3v2 tagged MPEG streams): TXXX "Acoustid Fingerprint"
+//   - MP4 (m4a): ----:com.apple.iTunes:Acoustid Fingerprint
+//
+// The duration of the fingerprinted track, in whole seconds, is kept next to
+// it as ACOUSTID_DURATION ("Acoustid Duration").
+//
+// [Write] only rewrites the tag region: audio bytes are copied unchanged, to
+// a temporary file renamed over the original once complete. The rename
+// breaks hard links.
+package tags
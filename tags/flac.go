@@ -0,0 +1,159 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FLAC metadata, as https://xiph.org/flac/format.html#metadata_block.
+const (
+	flacMagic         = "fLaC"
+	flacLast          = 0x80
+	flacTypeMask      = 0x7f
+	flacStreamInfo    = 0
+	flacComment       = 4
+	flacHeaderSize    = 4
+	flacMaxBlockSize  = 1<<24 - 1
+	flacMaxBlockTypes = 127
+)
+
+type flacBlock struct {
+	kind byte
+	data []byte
+}
+
+// readFLACBlocks reads the metadata blocks, leaving reader at the first audio
+// frame.
+func readFLACBlocks(reader *bufio.Reader) ([]flacBlock, error) {
+	if _, err := reader.Discard(len(flacMagic)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+
+	var blocks []flacBlock
+
+	for {
+		header := make([]byte, flacHeaderSize)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil, fmt.Errorf("%w: FLAC metadata: %w", ErrMalformed, err)
+		}
+
+		block := flacBlock{kind: header[0] & flacTypeMask}
+		if block.kind == flacMaxBlockTypes {
+			return nil, fmt.Errorf("%w: invalid FLAC metadata block", ErrMalformed)
+		}
+
+		block.data = make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3]))
+		if _, err := io.ReadFull(reader, block.data); err != nil {
+			return nil, fmt.Errorf("%w: FLAC metadata: %w", ErrMalformed, err)
+		}
+
+		blocks = append(blocks, block)
+
+		if header[0]&flacLast != 0 {
+			break
+		}
+	}
+
+	if blocks[0].kind != flacStreamInfo {
+		return nil, fmt.Errorf("%w: FLAC without STREAMINFO", ErrMalformed)
+	}
+
+	return blocks, nil
+}
+
+func readFLAC(reader *bufio.Reader, _ *os.File) (Tags, error) {
+	blocks, err := readFLACBlocks(reader)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	for _, block := range blocks {
+		if block.kind != flacComment {
+			continue
+		}
+
+		comment, _, err := parseVorbisComment(block.data)
+		if err != nil {
+			return Tags{}, err
+		}
+
+		return comment.tags(), nil
+	}
+
+	return Tags{}, nil
+}
+
+func writeFLAC(dst io.Writer, reader *bufio.Reader, _ *os.File, tags Tags) error {
+	blocks, err := readFLACBlocks(reader)
+	if err != nil {
+		return err
+	}
+
+	idx := -1
+
+	for i, block := range blocks {
+		if block.kind == flacComment {
+			idx = i
+
+			break
+		}
+	}
+
+	comment := vorbisComment{vendor: "sporeprint"}
+
+	if idx >= 0 {
+		if comment, _, err = parseVorbisComment(blocks[idx].data); err != nil {
+			return err
+		}
+	} else {
+		// The comment block goes right after STREAMINFO, as the reference
+		// encoder puts it.
+		idx = 1
+		blocks = append(blocks[:idx], append([]flacBlock{{kind: flacComment}}, blocks[idx:]...)...)
+	}
+
+	comment.set(tags)
+
+	if blocks[idx].data, err = comment.bytes(); err != nil {
+		return err
+	}
+
+	if _, err = io.WriteString(dst, flacMagic); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	for i, block := range blocks {
+		if len(block.data) > flacMaxBlockSize {
+			return fmt.Errorf("%w: FLAC metadata block too large", ErrUnsupported)
+		}
+
+		header := []byte{block.kind, byte(len(block.data) >> 16), byte(len(block.data) >> 8), byte(len(block.data))}
+		if i == len(blocks)-1 {
+			header[0] |= flacLast
+		}
+
+		if _, err = dst.Write(append(header, block.data...)); err != nil {
+			return fmt.Errorf("tags: %w", err)
+		}
+	}
+
+	return copyRest(dst, reader)
+}
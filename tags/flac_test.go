@@ -0,0 +1,85 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/tags"
+)
+
+func vorbisComment(comments ...string) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, 6)
+	data = append(data, "vendor"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(comments)))
+
+	for _, comment := range comments {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(comment)))
+		data = append(data, comment...)
+	}
+
+	return data
+}
+
+func flacBlock(kind byte, last bool, data []byte) []byte {
+	if last {
+		kind |= 0x80
+	}
+
+	return append([]byte{kind, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// flac returns a FLAC file with an optional comment block.
+func flac(comment, frames []byte) []byte {
+	data := append([]byte("fLaC"), flacBlock(0, false, make([]byte, 34))...)
+
+	if comment != nil {
+		data = append(data, flacBlock(4, false, comment)...)
+	}
+
+	data = append(data, flacBlock(1, true, make([]byte, 100))...)
+
+	return append(data, frames...)
+}
+
+func TestFLAC(t *testing.T) {
+	t.Parallel()
+
+	frames := audio(5000)
+
+	// Without comment block.
+	data := roundTrip(t, writeFile(t, "new.flac", flac(nil, frames)), tags.Tags{Fingerprint: "AQAAnew"})
+	if !bytes.HasSuffix(data, frames) {
+		t.Error("audio frames changed")
+	}
+
+	// Existing tags are replaced, whatever their case, and others kept.
+	path := writeFile(t, "old.flac", flac(vorbisComment("TITLE=Song", "acoustid_fingerprint=AQAAold", "ACOUSTID_DURATION=10"), frames))
+	data = roundTrip(t, path, tags.Tags{Fingerprint: "AQAAnew"})
+
+	if !bytes.HasSuffix(data, frames) || !bytes.Contains(data, []byte("TITLE=Song")) || bytes.Contains(data, []byte("AQAAold")) {
+		t.Error("rewritten comment block lost a tag, kept the old fingerprint, or changed audio")
+	}
+
+	if bytes.Contains(data, []byte("ACOUSTID_DURATION")) {
+		t.Error("zero Duration kept the duration tag")
+	}
+
+	roundTrip(t, path, tags.Tags{Fingerprint: "AQAAnewer", Duration: 99})
+}
@@ -0,0 +1,320 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ID3v2.3 and ID3v2.4, as https://id3.org/id3v2.4.0-structure.
+const (
+	id3Magic      = "ID3"
+	id3HeaderSize = 10
+	id3FooterSize = 10
+	id3Unsync     = 0x80
+	id3Extended   = 0x40
+	id3Footer     = 0x10
+	id3MaxSize    = 1<<28 - 1
+	id3TXXX       = "TXXX"
+	id3v3         = 3
+	id3v4         = 4
+
+	// Frame format flags (second flag byte) that alter frame content.
+	id3v3FormatFlags = 0xe0
+	id3v4FormatFlags = 0x4f
+
+	// Text encodings.
+	id3Latin1  = 0
+	id3UTF16   = 1
+	id3UTF16BE = 2
+	id3UTF8    = 3
+)
+
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+type id3Tag struct {
+	major  byte
+	flags  byte
+	frames []id3Frame
+}
+
+func isMPEGSync(head []byte) bool {
+	return len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0
+}
+
+func syncsafe(data []byte) int {
+	return int(data[0]&0x7f)<<21 | int(data[1]&0x7f)<<14 | int(data[2]&0x7f)<<7 | int(data[3]&0x7f)
+}
+
+func appendSyncsafe(data []byte, value int) []byte {
+	return append(data, byte(value>>21)&0x7f, byte(value>>14)&0x7f, byte(value>>7)&0x7f, byte(value)&0x7f)
+}
+
+// readID3Tag reads the ID3v2 tag at the start of reader, if any, leaving
+// reader at the audio.
+func readID3Tag(reader *bufio.Reader) (*id3Tag, error) {
+	if head, _ := reader.Peek(len(id3Magic)); string(head) != id3Magic {
+		return nil, nil //nolint:nilnil // No tag is not an error.
+	}
+
+	header := make([]byte, id3HeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("%w: ID3v2 header: %w", ErrMalformed, err)
+	}
+
+	tag := &id3Tag{major: header[3], flags: header[5]}
+
+	if tag.major != id3v3 && tag.major != id3v4 {
+		return nil, fmt.Errorf("%w: ID3v2.%d", ErrUnsupported, tag.major)
+	}
+
+	if tag.flags&id3Unsync != 0 {
+		return nil, fmt.Errorf("%w: unsynchronised ID3v2 tag", ErrUnsupported)
+	}
+
+	size := syncsafe(header[6:])
+	if tag.flags&id3Footer != 0 {
+		size += id3FooterSize
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, fmt.Errorf("%w: ID3v2 tag: %w", ErrMalformed, err)
+	}
+
+	if tag.flags&id3Footer != 0 {
+		body = body[:len(body)-id3FooterSize]
+	}
+
+	if tag.flags&id3Extended != 0 {
+		// The extended header is dropped on write: it only holds hints.
+		skip, err := id3ExtendedSize(tag.major, body)
+		if err != nil {
+			return nil, err
+		}
+
+		body = body[skip:]
+	}
+
+	for len(body) >= id3HeaderSize && body[0] != 0 {
+		frame := id3Frame{id: string(body[:4]), flags: [2]byte{body[8], body[9]}}
+
+		frameSize := int(binary.BigEndian.Uint32(body[4:]))
+		if tag.major == id3v4 {
+			frameSize = syncsafe(body[4:])
+		}
+
+		if frameSize > len(body)-id3HeaderSize {
+			return nil, fmt.Errorf("%w: truncated ID3v2 frame %q", ErrMalformed, frame.id)
+		}
+
+		frame.data = body[id3HeaderSize : id3HeaderSize+frameSize]
+		tag.frames = append(tag.frames, frame)
+		body = body[id3HeaderSize+frameSize:]
+	}
+
+	return tag, nil
+}
+
+func id3ExtendedSize(major byte, body []byte) (int, error) {
+	if len(body) < 4 { //nolint:mnd
+		return 0, fmt.Errorf("%w: truncated ID3v2 extended header", ErrMalformed)
+	}
+
+	// ID3v2.3 does not count the size field, ID3v2.4 does.
+	skip := int(binary.BigEndian.Uint32(body)) + 4 //nolint:mnd
+	if major == id3v4 {
+		skip = syncsafe(body)
+	}
+
+	if skip > len(body) {
+		return 0, fmt.Errorf("%w: truncated ID3v2 extended header", ErrMalformed)
+	}
+
+	return skip, nil
+}
+
+// userText returns the description and value of a TXXX frame.
+func (f *id3Frame) userText(major byte) (string, string, bool) {
+	formatFlags := byte(id3v3FormatFlags)
+	if major == id3v4 {
+		formatFlags = id3v4FormatFlags
+	}
+
+	if f.id != id3TXXX || f.flags[1]&formatFlags != 0 || len(f.data) == 0 {
+		return "", "", false
+	}
+
+	encoding, data := f.data[0], f.data[1:]
+
+	switch encoding {
+	case id3Latin1, id3UTF8:
+		desc, value, found := bytes.Cut(data, []byte{0})
+		if !found {
+			return "", "", false
+		}
+
+		if encoding == id3Latin1 {
+			return latin1(desc), latin1(bytes.TrimRight(value, "\x00")), true
+		}
+
+		return string(desc), string(bytes.TrimRight(value, "\x00")), true
+	case id3UTF16, id3UTF16BE:
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return decodeUTF16(data[:i], encoding == id3UTF16BE), decodeUTF16(data[i+2:], encoding == id3UTF16BE), true
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+
+	return string(runes)
+}
+
+// decodeUTF16 decodes UTF-16 text, with a byte order mark unless bigEndian.
+func decodeUTF16(data []byte, bigEndian bool) string {
+	var order binary.ByteOrder = binary.LittleEndian
+
+	switch {
+	case bigEndian:
+		order = binary.BigEndian
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		order, data = binary.BigEndian, data[2:]
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		data = data[2:]
+	}
+
+	units := make([]uint16, 0, len(data)/2) //nolint:mnd
+
+	for i := 0; i+1 < len(data); i += 2 {
+		if unit := order.Uint16(data[i:]); unit != 0 {
+			units = append(units, unit)
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// isFingerprintFrame reports whether a frame is one written by [Write].
+func (f *id3Frame) isFingerprintFrame(major byte) bool {
+	desc, _, ok := f.userText(major)
+
+	return ok && (strings.EqualFold(desc, NameFingerprint) || strings.EqualFold(desc, NameDuration))
+}
+
+func readID3(reader *bufio.Reader, _ *os.File) (Tags, error) {
+	tag, err := readID3Tag(reader)
+	if err != nil || tag == nil {
+		return Tags{}, err
+	}
+
+	var tags Tags
+
+	for _, frame := range tag.frames {
+		desc, value, ok := frame.userText(tag.major)
+
+		switch {
+		case !ok:
+		case strings.EqualFold(desc, NameFingerprint) && tags.Fingerprint == "":
+			tags.Fingerprint = value
+		case strings.EqualFold(desc, NameDuration) && tags.Duration == 0:
+			tags.Duration, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+
+	return tags, nil
+}
+
+func writeID3(dst io.Writer, reader *bufio.Reader, _ *os.File, tags Tags) error {
+	tag, err := readID3Tag(reader)
+	if err != nil {
+		return err
+	}
+
+	if tag == nil {
+		tag = &id3Tag{major: id3v4}
+	}
+
+	frames := tag.frames[:0]
+
+	for _, frame := range tag.frames {
+		if !frame.isFingerprintFrame(tag.major) {
+			frames = append(frames, frame)
+		}
+	}
+
+	frames = append(frames, newTXXX(NameFingerprint, tags.Fingerprint))
+	if tags.Duration > 0 {
+		frames = append(frames, newTXXX(NameDuration, strconv.Itoa(tags.Duration)))
+	}
+
+	var body []byte
+
+	for _, frame := range frames {
+		body = append(body, frame.id...)
+
+		if tag.major == id3v4 {
+			body = appendSyncsafe(body, len(frame.data))
+		} else {
+			body = binary.BigEndian.AppendUint32(body, uint32(len(frame.data))) //nolint:gosec // Bounded below.
+		}
+
+		body = append(body, frame.flags[:]...)
+		body = append(body, frame.data...)
+	}
+
+	if len(body) > id3MaxSize {
+		return fmt.Errorf("%w: ID3v2 tag too large", ErrUnsupported)
+	}
+
+	header := append([]byte(id3Magic), tag.major, 0, tag.flags&^(id3Extended|id3Footer))
+	header = appendSyncsafe(header, len(body))
+
+	if _, err = dst.Write(append(header, body...)); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	return copyRest(dst, reader)
+}
+
+// newTXXX returns a TXXX frame of ASCII text.
+func newTXXX(desc, value string) id3Frame {
+	data := append([]byte{id3Latin1}, desc...)
+	data = append(data, 0)
+
+	return id3Frame{id: id3TXXX, data: append(data, value...)}
+}
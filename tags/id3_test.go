@@ -0,0 +1,84 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/mycophonic/sporeprint/tags"
+)
+
+// id3v23 returns an ID3v2.3 tag of frames.
+func id3v23(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	size := len(body)
+
+	return append([]byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}, body...)
+}
+
+func id3v23Frame(id string, data []byte) []byte {
+	frame := binary.BigEndian.AppendUint32([]byte(id), uint32(len(data)))
+
+	return append(append(frame, 0, 0), data...)
+}
+
+// utf16TXXX returns a TXXX frame in UTF-16 with byte order marks, as written
+// by Picard for ID3v2.3.
+func utf16TXXX(desc, value string) []byte {
+	data := []byte{1}
+
+	for _, text := range []string{desc, value} {
+		data = append(data, 0xff, 0xfe)
+
+		for _, unit := range utf16.Encode([]rune(text)) {
+			data = binary.LittleEndian.AppendUint16(data, unit)
+		}
+
+		data = append(data, 0, 0)
+	}
+
+	return id3v23Frame("TXXX", data)
+}
+
+func TestID3(t *testing.T) {
+	t.Parallel()
+
+	frames := append([]byte{0xff, 0xfb, 0x90, 0x64}, audio(3000)...)
+
+	// Untagged MPEG stream.
+	data := roundTrip(t, writeFile(t, "new.mp3", frames), tags.Tags{Fingerprint: "AQAAnew", Duration: 42})
+	if !bytes.HasSuffix(data, frames) || data[3] != 4 {
+		t.Error("new tag is not ID3v2.4, or audio changed")
+	}
+
+	title := id3v23Frame("TIT2", []byte("\x00Song"))
+	path := writeFile(t, "old.mp3", append(id3v23(title, utf16TXXX("Acoustid Fingerprint", "AQAAold"), make([]byte, 64)), frames...))
+
+	old, err := tags.Read(path)
+	if err != nil || old.Fingerprint != "AQAAold" {
+		t.Fatalf("Read() = %+v, %v, want the UTF-16 fingerprint", old, err)
+	}
+
+	data = roundTrip(t, path, tags.Tags{Fingerprint: "AQAAnew"})
+
+	if !bytes.HasSuffix(data, frames) || !bytes.Contains(data, title) || data[3] != 3 {
+		t.Error("rewritten tag lost a frame, changed version, or changed audio")
+	}
+}
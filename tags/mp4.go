@@ -0,0 +1,508 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MP4 atoms (ISO/IEC 14496-12 boxes) holding iTunes-style metadata.
+const (
+	mp4HeaderSize   = 8
+	mp4LargeSize    = 16
+	mp4FullBoxSize  = 4
+	mp4DataHeader   = 8
+	mp4TypeUTF8     = 1
+	mp4FreeformMean = "com.apple.iTunes"
+	mp4Freeform     = "----"
+)
+
+type mp4Atom struct {
+	kind         string
+	offset, size int64
+}
+
+// readMP4Atoms lists the top-level atoms of a file.
+func readMP4Atoms(file *os.File) ([]mp4Atom, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("tags: %w", err)
+	}
+
+	var atoms []mp4Atom
+
+	header := make([]byte, mp4LargeSize)
+
+	for offset := int64(0); offset < info.Size(); {
+		count, err := file.ReadAt(header, offset)
+		if count < mp4HeaderSize {
+			return nil, fmt.Errorf("%w: truncated MP4 atom: %w", ErrMalformed, err)
+		}
+
+		atom := mp4Atom{kind: string(header[4:8]), offset: offset, size: int64(binary.BigEndian.Uint32(header))}
+
+		switch atom.size {
+		case 0:
+			atom.size = info.Size() - offset
+		case 1:
+			if count < mp4LargeSize {
+				return nil, fmt.Errorf("%w: truncated MP4 atom", ErrMalformed)
+			}
+
+			atom.size = int64(binary.BigEndian.Uint64(header[8:])) //nolint:gosec // Checked below.
+		}
+
+		if atom.size < mp4HeaderSize || atom.size > info.Size()-offset {
+			return nil, fmt.Errorf("%w: invalid MP4 atom %q size", ErrMalformed, atom.kind)
+		}
+
+		atoms = append(atoms, atom)
+		offset += atom.size
+	}
+
+	return atoms, nil
+}
+
+// readMoov returns the moov atom and its payload.
+func readMoov(file *os.File) (mp4Atom, []byte, []mp4Atom, error) {
+	atoms, err := readMP4Atoms(file)
+	if err != nil {
+		return mp4Atom{}, nil, nil, err
+	}
+
+	var moov *mp4Atom
+
+	for i, atom := range atoms {
+		switch atom.kind {
+		case "moof":
+			return mp4Atom{}, nil, nil, fmt.Errorf("%w: fragmented MP4", ErrUnsupported)
+		case "moov":
+			if moov != nil {
+				return mp4Atom{}, nil, nil, fmt.Errorf("%w: several moov atoms", ErrMalformed)
+			}
+
+			moov = &atoms[i]
+		}
+	}
+
+	if moov == nil {
+		return mp4Atom{}, nil, nil, fmt.Errorf("%w: MP4 without moov atom", ErrMalformed)
+	}
+
+	data := make([]byte, moov.size)
+	if _, err = file.ReadAt(data, moov.offset); err != nil {
+		return mp4Atom{}, nil, nil, fmt.Errorf("%w: moov atom: %w", ErrMalformed, err)
+	}
+
+	children, err := parseMP4Children(data)
+	if err != nil || len(children) != 1 {
+		return mp4Atom{}, nil, nil, fmt.Errorf("%w: invalid moov atom", ErrMalformed)
+	}
+
+	return *moov, children[0].payload, atoms, nil
+}
+
+type mp4Child struct {
+	kind    string
+	payload []byte
+	raw     []byte
+}
+
+// parseMP4Children splits the payload of a container atom into atoms.
+// Payloads share data.
+func parseMP4Children(data []byte) ([]mp4Child, error) {
+	var children []mp4Child
+
+	for len(data) > 0 {
+		if len(data) < mp4HeaderSize {
+			return nil, fmt.Errorf("%w: truncated MP4 atom", ErrMalformed)
+		}
+
+		size, header := uint64(binary.BigEndian.Uint32(data)), uint64(mp4HeaderSize)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < mp4LargeSize {
+				return nil, fmt.Errorf("%w: truncated MP4 atom", ErrMalformed)
+			}
+
+			size, header = binary.BigEndian.Uint64(data[8:]), mp4LargeSize
+		}
+
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: invalid MP4 atom %q size", ErrMalformed, data[4:8])
+		}
+
+		children = append(children, mp4Child{kind: string(data[4:8]), payload: data[header:size], raw: data[:size]})
+		data = data[size:]
+	}
+
+	return children, nil
+}
+
+// mp4Box encodes an atom.
+func mp4Box(kind string, payloads ...[]byte) []byte {
+	size := mp4HeaderSize
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	data := make([]byte, 0, size)
+	data = binary.BigEndian.AppendUint32(data, uint32(size)) //nolint:gosec // Metadata atoms are small.
+	data = append(data, kind...)
+
+	for _, payload := range payloads {
+		data = append(data, payload...)
+	}
+
+	return data
+}
+
+// findMP4Child returns the payload of the first child atom of kind.
+func findMP4Child(data []byte, kind string) ([]byte, bool, error) {
+	children, err := parseMP4Children(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, child := range children {
+		if child.kind == kind {
+			return child.payload, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// editMP4Child rewrites the payload of the first child atom of kind, which
+// is appended if missing.
+func editMP4Child(data []byte, kind string, edit func(payload []byte, found bool) ([]byte, error)) ([]byte, error) {
+	children, err := parseMP4Children(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+
+	found := false
+
+	for _, child := range children {
+		if child.kind != kind || found {
+			out = append(out, child.raw...)
+
+			continue
+		}
+
+		found = true
+
+		payload, err := edit(child.payload, true)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, mp4Box(kind, payload)...)
+	}
+
+	if !found {
+		payload, err := edit(nil, false)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, mp4Box(kind, payload)...)
+	}
+
+	return out, nil
+}
+
+// metaChildren returns where the children of a meta atom start: it is a full
+// atom in MP4 files, and a plain one in some QuickTime files.
+func metaChildren(meta []byte) int {
+	if len(meta) >= mp4HeaderSize && string(meta[4:8]) == "hdlr" {
+		return 0
+	}
+
+	return mp4FullBoxSize
+}
+
+// newMeta returns the payload of a meta atom with an iTunes handler.
+func newMeta() []byte {
+	hdlr := make([]byte, 0, 25) //nolint:mnd
+	hdlr = append(hdlr, 0, 0, 0, 0, 0, 0, 0, 0)
+	hdlr = append(hdlr, "mdirappl"...)
+	hdlr = append(hdlr, make([]byte, 9)...) //nolint:mnd // Reserved and empty name.
+
+	return append(make([]byte, mp4FullBoxSize), mp4Box("hdlr", hdlr)...)
+}
+
+// freeform returns the name and value of a ---- atom.
+func freeform(payload []byte) (string, string, bool) {
+	children, err := parseMP4Children(payload)
+	if err != nil {
+		return "", "", false
+	}
+
+	var name, value string
+
+	found := false
+
+	for _, child := range children {
+		switch {
+		case child.kind == "name" && len(child.payload) >= mp4FullBoxSize:
+			name = string(child.payload[mp4FullBoxSize:])
+		case child.kind == "data" && len(child.payload) >= mp4DataHeader && !found:
+			value, found = string(child.payload[mp4DataHeader:]), true
+		}
+	}
+
+	return name, value, found && name != ""
+}
+
+func newFreeform(name, value string) []byte {
+	mean := append(make([]byte, mp4FullBoxSize), mp4FreeformMean...)
+	nameData := append(make([]byte, mp4FullBoxSize), name...)
+	data := append([]byte{0, 0, 0, mp4TypeUTF8, 0, 0, 0, 0}, value...)
+
+	return mp4Box(mp4Freeform, mp4Box("mean", mean), mp4Box("name", nameData), mp4Box("data", data))
+}
+
+// ilst returns the payload of the moov/udta/meta/ilst atom, if any.
+func ilst(moov []byte) ([]byte, error) {
+	udta, found, err := findMP4Child(moov, "udta")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	meta, found, err := findMP4Child(udta, "meta")
+	if err != nil || !found {
+		return nil, err
+	}
+
+	list, _, err := findMP4Child(meta[min(metaChildren(meta), len(meta)):], "ilst")
+
+	return list, err
+}
+
+func readMP4(_ *bufio.Reader, file *os.File) (Tags, error) {
+	_, moov, _, err := readMoov(file)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	list, err := ilst(moov)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	children, err := parseMP4Children(list)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	var tags Tags
+
+	for _, child := range children {
+		if child.kind != mp4Freeform {
+			continue
+		}
+
+		name, value, ok := freeform(child.payload)
+
+		switch {
+		case !ok:
+		case strings.EqualFold(name, NameFingerprint) && tags.Fingerprint == "":
+			tags.Fingerprint = value
+		case strings.EqualFold(name, NameDuration) && tags.Duration == 0:
+			tags.Duration, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+
+	return tags, nil
+}
+
+func writeMP4(dst io.Writer, _ *bufio.Reader, file *os.File, tags Tags) error {
+	moov, payload, atoms, err := readMoov(file)
+	if err != nil {
+		return err
+	}
+
+	payload, err = editMP4Child(payload, "udta", func(udta []byte, _ bool) ([]byte, error) {
+		return editMP4Child(udta, "meta", func(meta []byte, found bool) ([]byte, error) {
+			if !found {
+				meta = newMeta()
+			}
+
+			start := min(metaChildren(meta), len(meta))
+
+			children, err := editMP4Child(meta[start:], "ilst", func(list []byte, _ bool) ([]byte, error) {
+				return editIlst(list, tags)
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			return append(meta[:start:start], children...), nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	newMoov := mp4Box("moov", payload)
+
+	// Chunk offsets pointing past the moov atom move with its end.
+	if delta := int64(len(newMoov)) - moov.size; delta != 0 {
+		if err = shiftChunkOffsets(newMoov[mp4HeaderSize:], moov.offset+moov.size, delta); err != nil {
+			return err
+		}
+	}
+
+	end := atoms[len(atoms)-1].offset + atoms[len(atoms)-1].size
+
+	if _, err = io.Copy(dst, io.NewSectionReader(file, 0, moov.offset)); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	if _, err = dst.Write(newMoov); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	return copyRest(dst, io.NewSectionReader(file, moov.offset+moov.size, end-moov.offset-moov.size))
+}
+
+// editIlst replaces the fingerprint atoms of an ilst payload.
+func editIlst(list []byte, tags Tags) ([]byte, error) {
+	children, err := parseMP4Children(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+
+	for _, child := range children {
+		if child.kind == mp4Freeform {
+			if name, _, ok := freeform(child.payload); ok &&
+				(strings.EqualFold(name, NameFingerprint) || strings.EqualFold(name, NameDuration)) {
+				continue
+			}
+		}
+
+		out = append(out, child.raw...)
+	}
+
+	out = append(out, newFreeform(NameFingerprint, tags.Fingerprint)...)
+
+	if tags.Duration > 0 {
+		out = append(out, newFreeform(NameDuration, strconv.Itoa(tags.Duration))...)
+	}
+
+	return out, nil
+}
+
+// shiftChunkOffsets adds delta to the stco and co64 chunk offsets of every
+// track that are at or past from, in place.
+func shiftChunkOffsets(moov []byte, from, delta int64) error {
+	tracks, err := parseMP4Children(moov)
+	if err != nil {
+		return err
+	}
+
+	for _, trak := range tracks {
+		if trak.kind != "trak" {
+			continue
+		}
+
+		stbl := trak.payload
+
+		for _, kind := range []string{"mdia", "minf", "stbl"} {
+			var found bool
+
+			if stbl, found, err = findMP4Child(stbl, kind); err != nil || !found {
+				return fmt.Errorf("%w: track without sample table", ErrMalformed)
+			}
+		}
+
+		tables, err := parseMP4Children(stbl)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			switch table.kind {
+			case "stco":
+				err = shiftOffsets(table.payload, 4, from, delta) //nolint:mnd
+			case "co64":
+				err = shiftOffsets(table.payload, 8, from, delta) //nolint:mnd
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// shiftOffsets shifts the entries of a chunk offset table of width bytes.
+func shiftOffsets(table []byte, width int, from, delta int64) error {
+	const tableHeader = 8
+
+	if len(table) < tableHeader {
+		return fmt.Errorf("%w: truncated chunk offset table", ErrMalformed)
+	}
+
+	count := int(binary.BigEndian.Uint32(table[4:]))
+	if count > (len(table)-tableHeader)/width {
+		return fmt.Errorf("%w: truncated chunk offset table", ErrMalformed)
+	}
+
+	for i := range count {
+		entry := table[tableHeader+i*width:]
+
+		if width == 4 { //nolint:mnd
+			offset := int64(binary.BigEndian.Uint32(entry))
+			if offset < from {
+				continue
+			}
+
+			if offset+delta > math.MaxUint32 {
+				return fmt.Errorf("%w: chunk offset overflow", ErrUnsupported)
+			}
+
+			binary.BigEndian.PutUint32(entry, uint32(offset+delta)) //nolint:gosec // Checked above.
+
+			continue
+		}
+
+		offset := int64(binary.BigEndian.Uint64(entry)) //nolint:gosec // File offsets fit.
+		if offset >= from {
+			binary.BigEndian.PutUint64(entry, uint64(offset+delta)) //nolint:gosec // Positive.
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/tags"
+)
+
+func box(kind string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+
+	return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(payload))), append([]byte(kind), payload...)...)
+}
+
+type atom struct {
+	kind    string
+	payload []byte
+}
+
+// children splits the payload of a container atom.
+func children(t *testing.T, data []byte) []atom {
+	t.Helper()
+
+	var atoms []atom
+
+	for len(data) > 0 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatalf("invalid atom size %d", size)
+		}
+
+		atoms = append(atoms, atom{kind: string(data[4:8]), payload: data[8:size]})
+		data = data[size:]
+	}
+
+	return atoms
+}
+
+// findBox returns the payload of the first atom at path.
+func findBox(t *testing.T, data []byte, path ...string) []byte {
+	t.Helper()
+
+	for _, child := range children(t, data) {
+		if child.kind != path[0] {
+			continue
+		}
+
+		if len(path) == 1 {
+			return child.payload
+		}
+
+		if child.kind == "meta" {
+			// Full atom.
+			return findBox(t, child.payload[4:], path[1:]...)
+		}
+
+		return findBox(t, child.payload, path[1:]...)
+	}
+
+	t.Fatalf("atom %q not found", path[0])
+
+	return nil
+}
+
+// chunks are the media data chunks.
+//
+//nolint:gochecknoglobals // Test data.
+var chunks = [][]byte{[]byte("first chunk"), []byte("second chunk")}
+
+func chunkTable(kind string, offsets []uint64) []byte {
+	table := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(offsets)))
+
+	for _, offset := range offsets {
+		if kind == "stco" {
+			table = binary.BigEndian.AppendUint32(table, uint32(offset))
+		} else {
+			table = binary.BigEndian.AppendUint64(table, offset)
+		}
+	}
+
+	return box(kind, table)
+}
+
+// moov returns a movie with a track per chunk table kind, and udta.
+func moov(offsets []uint64, udta []byte) []byte {
+	var traks []byte
+
+	for _, kind := range []string{"stco", "co64"} {
+		traks = append(traks, box("trak", box("mdia", box("minf", box("stbl", box("stsd", make([]byte, 8)), chunkTable(kind, offsets)))))...)
+	}
+
+	return box("moov", box("mvhd", make([]byte, 100)), traks, udta)
+}
+
+// mp4 returns an MP4 file with moov before or after mdat.
+func mp4(udta []byte, moovFirst bool) []byte {
+	ftyp := box("ftyp", []byte("M4A \x00\x00\x00\x00M4A isom"))
+	mdatPayload := bytes.Join(chunks, nil)
+
+	start := uint64(len(ftyp) + 8)
+	if moovFirst {
+		start += uint64(len(moov([]uint64{0, 0}, udta)))
+	}
+
+	offsets := []uint64{start, start + uint64(len(chunks[0]))}
+
+	if moovFirst {
+		return bytes.Join([][]byte{ftyp, moov(offsets, udta), box("mdat", mdatPayload)}, nil)
+	}
+
+	return bytes.Join([][]byte{ftyp, box("mdat", mdatPayload), moov(offsets, udta)}, nil)
+}
+
+// checkChunks checks that every chunk table points at the chunks.
+func checkChunks(t *testing.T, data []byte) {
+	t.Helper()
+
+	for _, trak := range children(t, findBox(t, data, "moov")) {
+		if trak.kind != "trak" {
+			continue
+		}
+
+		for _, table := range children(t, findBox(t, trak.payload, "mdia", "minf", "stbl")) {
+			for num, chunk := range chunks {
+				var offset uint64
+
+				switch table.kind {
+				case "stco":
+					offset = uint64(binary.BigEndian.Uint32(table.payload[8+4*num:]))
+				case "co64":
+					offset = binary.BigEndian.Uint64(table.payload[8+8*num:])
+				default:
+					continue
+				}
+
+				if !bytes.HasPrefix(data[offset:], chunk) {
+					t.Errorf("%s entry %d does not point at its chunk", table.kind, num)
+				}
+			}
+		}
+	}
+}
+
+func TestMP4(t *testing.T) {
+	t.Parallel()
+
+	for name, moovFirst := range map[string]bool{"moov first": true, "mdat first": false} {
+		path := writeFile(t, "track.m4a", mp4(nil, moovFirst))
+
+		checkChunks(t, roundTrip(t, path, tags.Tags{Fingerprint: "AQAAnew", Duration: 60}))
+
+		// Shrinking moov moves the chunks back.
+		checkChunks(t, roundTrip(t, path, tags.Tags{Fingerprint: "AQAA"}))
+
+		if t.Failed() {
+			t.Fatalf("%s: chunk offsets broken", name)
+		}
+	}
+}
+
+func TestMP4Existing(t *testing.T) {
+	t.Parallel()
+
+	title := box("\xa9nam", box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte("Song")))
+	old := box("----",
+		box("mean", make([]byte, 4), []byte("com.apple.iTunes")),
+		box("name", make([]byte, 4), []byte("Acoustid Fingerprint")),
+		box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte("AQAAold")))
+	hdlr := box("hdlr", make([]byte, 8), []byte("mdirappl"), make([]byte, 9))
+	udta := box("udta", box("meta", make([]byte, 4), hdlr, box("ilst", title, old)))
+
+	path := writeFile(t, "track.m4a", mp4(udta, true))
+
+	if got, err := tags.Read(path); err != nil || got.Fingerprint != "AQAAold" {
+		t.Fatalf("Read() = %+v, %v, want the existing fingerprint", got, err)
+	}
+
+	data := roundTrip(t, path, tags.Tags{Fingerprint: "AQAAnew"})
+	checkChunks(t, data)
+
+	list := findBox(t, data, "moov", "udta", "meta", "ilst")
+	if !bytes.HasPrefix(list, title) || bytes.Contains(list, []byte("AQAAold")) {
+		t.Error("rewritten ilst lost the title or kept the old fingerprint")
+	}
+}
@@ -0,0 +1,341 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// Ogg framing, as https://xiph.org/ogg/doc/framing.html.
+const (
+	oggMagic       = "OggS"
+	oggHeaderSize  = 27
+	oggContinued   = 0x01
+	oggCRCOffset   = 22
+	oggMaxSegments = 255
+	oggMaxLacing   = 255
+	oggCRCPoly     = 0x04c11db7
+	// oggNoGranule marks pages on which no packet ends.
+	oggNoGranule = ^uint64(0)
+
+	vorbisCommentMagic = "\x03vorbis"
+	opusCommentMagic   = "OpusTags"
+)
+
+//nolint:gochecknoglobals // Immutable lookup table.
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+
+	for i := range table {
+		crc := uint32(i) << 24 //nolint:gosec // i < 256.
+
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ oggCRCPoly
+			} else {
+				crc <<= 1
+			}
+		}
+
+		table[i] = crc
+	}
+
+	return table
+}()
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+
+	return crc
+}
+
+type oggPage struct {
+	flags   byte
+	granule uint64
+	serial  uint32
+	seq     uint32
+	lacing  []byte
+	data    []byte
+}
+
+// readOggPage reads a page, or returns io.EOF at the end of the stream.
+func readOggPage(reader io.Reader) (*oggPage, error) {
+	header := make([]byte, oggHeaderSize)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("%w: Ogg page: %w", ErrMalformed, err)
+	}
+
+	if string(header[:4]) != oggMagic || header[4] != 0 {
+		return nil, fmt.Errorf("%w: invalid Ogg page", ErrMalformed)
+	}
+
+	page := &oggPage{
+		flags:   header[5],
+		granule: binary.LittleEndian.Uint64(header[6:]),
+		serial:  binary.LittleEndian.Uint32(header[14:]),
+		seq:     binary.LittleEndian.Uint32(header[18:]),
+		lacing:  make([]byte, header[26]),
+	}
+
+	if _, err := io.ReadFull(reader, page.lacing); err != nil {
+		return nil, fmt.Errorf("%w: Ogg page: %w", ErrMalformed, err)
+	}
+
+	size := 0
+	for _, value := range page.lacing {
+		size += int(value)
+	}
+
+	page.data = make([]byte, size)
+	if _, err := io.ReadFull(reader, page.data); err != nil {
+		return nil, fmt.Errorf("%w: Ogg page: %w", ErrMalformed, err)
+	}
+
+	if crc := binary.LittleEndian.Uint32(header[oggCRCOffset:]); crc != oggCRC(page.bytes(0)) {
+		return nil, fmt.Errorf("%w: Ogg page checksum mismatch", ErrMalformed)
+	}
+
+	return page, nil
+}
+
+// bytes encodes the page with the given checksum.
+func (p *oggPage) bytes(crc uint32) []byte {
+	data := make([]byte, 0, oggHeaderSize+len(p.lacing)+len(p.data))
+	data = append(data, oggMagic...)
+	data = append(data, 0, p.flags)
+	data = binary.LittleEndian.AppendUint64(data, p.granule)
+	data = binary.LittleEndian.AppendUint32(data, p.serial)
+	data = binary.LittleEndian.AppendUint32(data, p.seq)
+	data = binary.LittleEndian.AppendUint32(data, crc)
+	data = append(data, byte(len(p.lacing)))
+	data = append(data, p.lacing...)
+
+	return append(data, p.data...)
+}
+
+// encode encodes the page with its checksum.
+func (p *oggPage) encode() []byte {
+	data := p.bytes(0)
+	binary.LittleEndian.PutUint32(data[oggCRCOffset:], oggCRC(data))
+
+	return data
+}
+
+// oggHeaders are the header packets of the first logical stream.
+type oggHeaders struct {
+	first   *oggPage
+	packets [][]byte
+	pages   int
+	// commentMagic starts the comment packet.
+	commentMagic string
+}
+
+// readOggHeaders reads the header packets of the first logical stream,
+// leaving reader at its first audio page.
+func readOggHeaders(reader io.Reader) (*oggHeaders, error) {
+	first, err := readOggPage(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := &oggHeaders{first: first, pages: 1}
+
+	// Identification headers are alone on the first page.
+	switch {
+	case bytes.HasPrefix(first.data, []byte("\x01vorbis")):
+		headers.commentMagic = vorbisCommentMagic
+		headers.packets = make([][]byte, 0, 3) //nolint:mnd // Identification, comment, setup.
+	case bytes.HasPrefix(first.data, []byte("OpusHead")):
+		headers.commentMagic = opusCommentMagic
+		headers.packets = make([][]byte, 0, 2) //nolint:mnd // Identification, comment.
+	default:
+		return nil, fmt.Errorf("%w: Ogg stream is neither Vorbis nor Opus", ErrUnsupported)
+	}
+
+	headers.packets = append(headers.packets, first.data)
+
+	var packet []byte
+
+	for len(headers.packets) < cap(headers.packets) {
+		page, err := readOggPage(reader)
+		if err != nil {
+			return nil, fmt.Errorf("%w: truncated Ogg headers", ErrMalformed)
+		}
+
+		if page.serial != first.serial {
+			return nil, fmt.Errorf("%w: multiplexed Ogg streams", ErrUnsupported)
+		}
+
+		headers.pages++
+
+		offset := 0
+
+		for _, value := range page.lacing {
+			if len(headers.packets) == cap(headers.packets) {
+				// Audio must start on a new page.
+				return nil, fmt.Errorf("%w: audio on an Ogg header page", ErrMalformed)
+			}
+
+			packet = append(packet, page.data[offset:offset+int(value)]...)
+			offset += int(value)
+
+			if value < oggMaxLacing {
+				headers.packets = append(headers.packets, packet)
+				packet = nil
+			}
+		}
+	}
+
+	return headers, nil
+}
+
+// comment returns the parsed comment packet and the bytes after it.
+func (h *oggHeaders) comment() (vorbisComment, []byte, error) {
+	packet := h.packets[1]
+	if !bytes.HasPrefix(packet, []byte(h.commentMagic)) {
+		return vorbisComment{}, nil, fmt.Errorf("%w: missing Ogg comment header", ErrMalformed)
+	}
+
+	return parseVorbisComment(packet[len(h.commentMagic):])
+}
+
+// paginate lays packets out on pages of a logical stream starting at seq.
+func paginate(packets [][]byte, serial, seq uint32) []*oggPage {
+	var pages []*oggPage
+
+	page := &oggPage{serial: serial, seq: seq, granule: oggNoGranule}
+
+	for _, packet := range packets {
+		for offset := 0; ; {
+			if len(page.lacing) == oggMaxSegments {
+				pages = append(pages, page)
+				seq++
+				page = &oggPage{serial: serial, seq: seq, granule: oggNoGranule}
+
+				if offset > 0 {
+					page.flags = oggContinued
+				}
+			}
+
+			size := min(len(packet)-offset, oggMaxLacing)
+			page.lacing = append(page.lacing, byte(size))
+			page.data = append(page.data, packet[offset:offset+size]...)
+			offset += size
+
+			if size < oggMaxLacing {
+				// Header packets have a granule position of zero.
+				page.granule = 0
+
+				break
+			}
+		}
+	}
+
+	return append(pages, page)
+}
+
+func readOgg(reader *bufio.Reader, _ *os.File) (Tags, error) {
+	headers, err := readOggHeaders(reader)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	comment, _, err := headers.comment()
+	if err != nil {
+		return Tags{}, err
+	}
+
+	return comment.tags(), nil
+}
+
+func writeOgg(dst io.Writer, reader *bufio.Reader, _ *os.File, tags Tags) error {
+	headers, err := readOggHeaders(reader)
+	if err != nil {
+		return err
+	}
+
+	comment, rest, err := headers.comment()
+	if err != nil {
+		return err
+	}
+
+	comment.set(tags)
+
+	data, err := comment.bytes()
+	if err != nil {
+		return err
+	}
+
+	// The rest is the Vorbis framing bit, or Opus padding.
+	packets := slices.Clone(headers.packets)
+	packets[1] = append(append([]byte(headers.commentMagic), data...), rest...)
+
+	serial := headers.first.serial
+	pages := paginate(packets[1:], serial, headers.first.seq+1)
+
+	if _, err = dst.Write(headers.first.encode()); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	for _, page := range pages {
+		if _, err = dst.Write(page.encode()); err != nil {
+			return fmt.Errorf("tags: %w", err)
+		}
+	}
+
+	// Later pages of the stream are renumbered if the header page count
+	// changed. Their content is untouched.
+	shift := uint32(len(pages) - (headers.pages - 1)) //nolint:gosec // Wraps around on purpose.
+	if shift == 0 {
+		return copyRest(dst, reader)
+	}
+
+	for {
+		page, err := readOggPage(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if page.serial == serial {
+			page.seq += shift
+		}
+
+		if _, err = dst.Write(page.encode()); err != nil {
+			return fmt.Errorf("tags: %w", err)
+		}
+	}
+}
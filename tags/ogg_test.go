@@ -0,0 +1,230 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/tags"
+)
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+type oggPage struct {
+	flags   byte
+	granule uint64
+	serial  uint32
+	seq     uint32
+	// packets are whole packets, each ending on the page.
+	packets [][]byte
+}
+
+func (p oggPage) bytes() []byte {
+	var lacing, body []byte
+
+	for _, packet := range p.packets {
+		for size := len(packet); ; size -= 255 {
+			lacing = append(lacing, byte(min(size, 255)))
+
+			if size < 255 {
+				break
+			}
+		}
+
+		body = append(body, packet...)
+	}
+
+	data := append([]byte("OggS"), 0, p.flags)
+	data = binary.LittleEndian.AppendUint64(data, p.granule)
+	data = binary.LittleEndian.AppendUint32(data, p.serial)
+	data = binary.LittleEndian.AppendUint32(data, p.seq)
+	data = append(data, 0, 0, 0, 0, byte(len(lacing)))
+	data = append(data, lacing...)
+	data = append(data, body...)
+
+	binary.LittleEndian.PutUint32(data[22:], oggCRC(data))
+
+	return data
+}
+
+// parsedPage is a page read back, with its payload.
+type parsedPage struct {
+	serial, seq uint32
+	payload     []byte
+}
+
+// parseOgg checks the checksums of every page and returns them.
+func parseOgg(t *testing.T, data []byte) []parsedPage {
+	t.Helper()
+
+	var pages []parsedPage
+
+	for len(data) > 0 {
+		if !bytes.HasPrefix(data, []byte("OggS")) {
+			t.Fatalf("page %d: no capture pattern", len(pages))
+		}
+
+		segments := int(data[26])
+		size := 27 + segments
+
+		for _, value := range data[27 : 27+segments] {
+			size += int(value)
+		}
+
+		page := bytes.Clone(data[:size])
+		crc := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+
+		if oggCRC(page) != crc {
+			t.Errorf("page %d: checksum mismatch", len(pages))
+		}
+
+		pages = append(pages, parsedPage{
+			serial:  binary.LittleEndian.Uint32(page[14:]),
+			seq:     binary.LittleEndian.Uint32(page[18:]),
+			payload: page[27+segments:],
+		})
+		data = data[size:]
+	}
+
+	return pages
+}
+
+// vorbisFile returns an Ogg Vorbis stream, interleaved with pages of another
+// logical stream after the headers, and its audio packets.
+func vorbisFile(comment []byte) ([]byte, [][]byte) {
+	id := append([]byte("\x01vorbis"), make([]byte, 23)...)
+	setup := append([]byte("\x05vorbis"), audio(300)...)
+
+	data := oggPage{flags: 2, serial: 7, packets: [][]byte{id}}.bytes()
+	data = append(data, oggPage{serial: 7, seq: 1, packets: [][]byte{append(append([]byte("\x03vorbis"), comment...), 1), setup}}.bytes()...)
+
+	var packets [][]byte
+
+	for seq := uint32(2); seq < 6; seq++ {
+		packet := audio(1000 + int(seq))
+		packets = append(packets, packet)
+
+		flags := byte(0)
+		if seq == 5 {
+			flags = 4
+		}
+
+		data = append(data, oggPage{flags: flags, granule: uint64(seq) * 1024, serial: 7, seq: seq, packets: [][]byte{packet}}.bytes()...)
+		data = append(data, oggPage{serial: 9, seq: seq, packets: [][]byte{[]byte("other")}}.bytes()...)
+	}
+
+	return data, packets
+}
+
+func TestOggVorbis(t *testing.T) {
+	t.Parallel()
+
+	original, packets := vorbisFile(vorbisComment("TITLE=Song", "ACOUSTID_FINGERPRINT=AQAAold"))
+
+	for name, fingerprint := range map[string]string{
+		"same page count": "AQAAnew",
+		// Spans several pages: later pages are renumbered.
+		"more pages": "AQAA" + strings.Repeat("x", 200000),
+	} {
+		path := writeFile(t, "track.ogg", original)
+		data := roundTrip(t, path, tags.Tags{Fingerprint: fingerprint, Duration: 180})
+
+		if !bytes.Contains(data, []byte("TITLE=Song")) {
+			t.Errorf("%s: lost a comment", name)
+		}
+
+		pages := parseOgg(t, data)
+
+		// The audio pages of the stream, numbered on from the headers.
+		var vorbis []parsedPage
+
+		for _, page := range pages {
+			if page.serial == 7 {
+				vorbis = append(vorbis, page)
+			}
+		}
+
+		for i, page := range vorbis {
+			if page.seq != uint32(i) {
+				t.Errorf("%s: page %d numbered %d", name, i, page.seq)
+			}
+		}
+
+		audioPages := vorbis[len(vorbis)-len(packets):]
+		for i, packet := range packets {
+			if !bytes.Equal(audioPages[i].payload, packet) {
+				t.Errorf("%s: audio packet %d changed", name, i)
+			}
+		}
+
+		// The other stream is untouched.
+		if other := pages[len(pages)-1]; other.serial != 9 || other.seq != 5 {
+			t.Errorf("%s: other stream page = %d/%d, want 9/5", name, other.serial, other.seq)
+		}
+	}
+}
+
+func TestOggOpus(t *testing.T) {
+	t.Parallel()
+
+	head := append([]byte("OpusHead"), make([]byte, 11)...)
+	// Opus comment headers may carry binary data after the comments.
+	comment := append(append([]byte("OpusTags"), vorbisComment()...), 0, 1, 2, 3)
+	packet := audio(500)
+
+	original := oggPage{flags: 2, serial: 3, packets: [][]byte{head}}.bytes()
+	original = append(original, oggPage{serial: 3, seq: 1, packets: [][]byte{comment}}.bytes()...)
+	original = append(original, oggPage{flags: 4, granule: 960, serial: 3, seq: 2, packets: [][]byte{packet}}.bytes()...)
+
+	data := roundTrip(t, writeFile(t, "track.opus", original), tags.Tags{Fingerprint: "AQAAopus"})
+
+	pages := parseOgg(t, data)
+	if len(pages) != 3 || !bytes.HasSuffix(pages[1].payload, []byte{0, 1, 2, 3}) || !bytes.Equal(pages[2].payload, packet) {
+		t.Error("rewritten stream lost the comment padding or changed audio")
+	}
+}
+
+func TestOggUnsupported(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, "track.ogg", oggPage{flags: 2, serial: 1, packets: [][]byte{[]byte("\x7fFLAC")}}.bytes())
+
+	if err := tags.Write(path, tags.Tags{Fingerprint: "AQAA"}); !errors.Is(err, tags.ErrUnsupported) {
+		t.Errorf("Write() error = %v, want ErrUnsupported", err)
+	}
+}
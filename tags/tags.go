@@ -0,0 +1,199 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Vorbis comment fields.
+const (
+	FieldFingerprint = "ACOUSTID_FINGERPRINT"
+	FieldDuration    = "ACOUSTID_DURATION"
+)
+
+// ID3v2 TXXX descriptions and MP4 freeform names.
+const (
+	NameFingerprint = "Acoustid Fingerprint"
+	NameDuration    = "Acoustid Duration"
+)
+
+// sniffSize is how much of a file is read to detect its format.
+const sniffSize = 12
+
+var (
+	// ErrUnsupported happens on files whose format or layout is not handled.
+	ErrUnsupported = errors.New("tags: unsupported file")
+	// ErrMalformed happens on files whose tags cannot be parsed.
+	ErrMalformed = errors.New("tags: malformed file")
+)
+
+// Tags are the fingerprint tags of a file.
+type Tags struct {
+	// Fingerprint is the encoded fingerprint. Empty when absent.
+	Fingerprint string
+	// Duration is the duration of the track in seconds. Zero when absent.
+	Duration int
+}
+
+// format reads and rewrites the tags of a container format.
+type format struct {
+	read func(reader *bufio.Reader, file *os.File) (Tags, error)
+	// write writes to dst a copy of src with tags set.
+	write func(dst io.Writer, reader *bufio.Reader, file *os.File, tags Tags) error
+}
+
+// detect returns the format of a file from its first bytes.
+func detect(head []byte) (format, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte(flacMagic)):
+		return format{read: readFLAC, write: writeFLAC}, nil
+	case bytes.HasPrefix(head, []byte(oggMagic)):
+		return format{read: readOgg, write: writeOgg}, nil
+	case bytes.HasPrefix(head, []byte(id3Magic)) || isMPEGSync(head):
+		return format{read: readID3, write: writeID3}, nil
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return format{read: readMP4, write: writeMP4}, nil
+	default:
+		return format{}, ErrUnsupported
+	}
+}
+
+// open opens a file and detects its format. The reader starts at the
+// beginning of the file.
+func open(path string) (*os.File, *bufio.Reader, format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, format{}, fmt.Errorf("tags: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+
+	head, err := reader.Peek(sniffSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		_ = file.Close()
+
+		return nil, nil, format{}, fmt.Errorf("tags: %w", err)
+	}
+
+	form, err := detect(head)
+	if err != nil {
+		_ = file.Close()
+
+		return nil, nil, format{}, fmt.Errorf("%w: %s", err, path)
+	}
+
+	return file, reader, form, nil
+}
+
+// Read returns the fingerprint tags of the file at path.
+func Read(path string) (Tags, error) {
+	file, reader, form, err := open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	defer file.Close()
+
+	tags, err := form.read(reader, file)
+	if err != nil {
+		return Tags{}, fmt.Errorf("%w: %s", err, path)
+	}
+
+	return tags, nil
+}
+
+// Write sets the fingerprint tags of the file at path, replacing existing
+// ones. A zero Duration removes the duration tag.
+func Write(path string, tags Tags) error {
+	if tags.Fingerprint == "" {
+		return fmt.Errorf("%w: empty fingerprint", ErrUnsupported)
+	}
+
+	file, reader, form, err := open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	if err = writeTemp(tmp, reader, file, form, tags, info.Mode().Perm()); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+
+		return fmt.Errorf("%w: %s", err, path)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	return nil
+}
+
+func writeTemp(tmp *os.File, reader *bufio.Reader, file *os.File, form format, tags Tags, mode os.FileMode) error {
+	buf := bufio.NewWriter(tmp)
+
+	if err := form.write(buf, reader, file, tags); err != nil {
+		return err
+	}
+
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	if err := tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	return nil
+}
+
+// copyRest copies the rest of the file after the tags.
+func copyRest(dst io.Writer, reader io.Reader) error {
+	if _, err := io.Copy(dst, reader); err != nil {
+		return fmt.Errorf("tags: copying audio: %w", err)
+	}
+
+	return nil
+}
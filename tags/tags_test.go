@@ -0,0 +1,134 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mycophonic/sporeprint/tags"
+)
+
+// audio returns size bytes standing for audio frames.
+func audio(size int) []byte {
+	data := make([]byte, size)
+	rng := rand.New(rand.NewPCG(1, 2)) //nolint:gosec // Test data.
+
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+
+	return data
+}
+
+// writeFile writes data to a file in a temporary directory.
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// roundTrip writes tags to path and checks that they read back.
+func roundTrip(t *testing.T, path string, want tags.Tags) []byte {
+	t.Helper()
+
+	if err := tags.Write(path, want); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+
+	got, err := tags.Read(path)
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+
+	if got != want {
+		t.Errorf("Read() = %+v, want %+v", got, want)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, "track.flac", flac(nil, audio(1000)))
+
+	roundTrip(t, path, tags.Tags{Fingerprint: "AQAAfirst", Duration: 215})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %v, want 0640 kept", info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %v, want the file alone", entries)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, "track.wav", append([]byte("RIFF\x00\x00\x00\x00WAVE"), audio(100)...))
+
+	if _, err := tags.Read(path); !errors.Is(err, tags.ErrUnsupported) {
+		t.Errorf("Read() error = %v, want ErrUnsupported", err)
+	}
+
+	if err := tags.Write(path, tags.Tags{Fingerprint: "AQAA"}); !errors.Is(err, tags.ErrUnsupported) {
+		t.Errorf("Write() error = %v, want ErrUnsupported", err)
+	}
+
+	flacPath := writeFile(t, "track.flac", flac(nil, audio(10)))
+	if err := tags.Write(flacPath, tags.Tags{}); !errors.Is(err, tags.ErrUnsupported) {
+		t.Errorf("Write(no fingerprint) error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	t.Parallel()
+
+	original := flac(nil, audio(10))[:20]
+	path := writeFile(t, "track.flac", original)
+
+	if err := tags.Write(path, tags.Tags{Fingerprint: "AQAA"}); !errors.Is(err, tags.ErrMalformed) {
+		t.Errorf("Write() error = %v, want ErrMalformed", err)
+	}
+
+	// The file is left alone.
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, original) {
+		t.Error("malformed file was modified")
+	}
+}
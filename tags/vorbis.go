@@ -0,0 +1,144 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// vorbisComment is a Vorbis comment block, as found in FLAC and Ogg streams.
+//
+// Reference: https://xiph.org/vorbis/doc/v-comment.html
+type vorbisComment struct {
+	vendor   string
+	comments []string
+}
+
+// parseVorbisComment parses a comment block, and returns the bytes after it.
+func parseVorbisComment(data []byte) (vorbisComment, []byte, error) {
+	var comment vorbisComment
+
+	vendor, data, err := readVorbisString(data)
+	if err != nil {
+		return comment, nil, err
+	}
+
+	comment.vendor = vendor
+
+	if len(data) < 4 { //nolint:mnd
+		return comment, nil, fmt.Errorf("%w: truncated Vorbis comment", ErrMalformed)
+	}
+
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+
+	// Every comment takes at least 4 bytes.
+	if uint64(count)*4 > uint64(len(data)) {
+		return comment, nil, fmt.Errorf("%w: Vorbis comment count %d", ErrMalformed, count)
+	}
+
+	comment.comments = make([]string, count)
+
+	for i := range comment.comments {
+		if comment.comments[i], data, err = readVorbisString(data); err != nil {
+			return comment, nil, err
+		}
+	}
+
+	return comment, data, nil
+}
+
+func readVorbisString(data []byte) (string, []byte, error) {
+	if len(data) < 4 { //nolint:mnd
+		return "", nil, fmt.Errorf("%w: truncated Vorbis comment", ErrMalformed)
+	}
+
+	size := binary.LittleEndian.Uint32(data)
+	if uint64(size) > uint64(len(data)-4) {
+		return "", nil, fmt.Errorf("%w: truncated Vorbis comment", ErrMalformed)
+	}
+
+	return string(data[4 : 4+size]), data[4+size:], nil
+}
+
+// bytes encodes the comment block.
+func (c *vorbisComment) bytes() ([]byte, error) {
+	if len(c.comments) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: too many comments", ErrUnsupported)
+	}
+
+	data := appendVorbisString(nil, c.vendor)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(c.comments)))
+
+	for _, comment := range c.comments {
+		data = appendVorbisString(data, comment)
+	}
+
+	return data, nil
+}
+
+func appendVorbisString(data []byte, value string) []byte {
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(value))) //nolint:gosec // Bounded by the container.
+
+	return append(data, value...)
+}
+
+// field returns the value of the first comment named name.
+func (c *vorbisComment) field(name string) (string, bool) {
+	for _, comment := range c.comments {
+		if key, value, found := strings.Cut(comment, "="); found && strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// tags returns the fingerprint tags.
+func (c *vorbisComment) tags() Tags {
+	var tags Tags
+
+	tags.Fingerprint, _ = c.field(FieldFingerprint)
+
+	if value, found := c.field(FieldDuration); found {
+		tags.Duration, _ = strconv.Atoi(strings.TrimSpace(value))
+	}
+
+	return tags
+}
+
+// set replaces the fingerprint tags.
+func (c *vorbisComment) set(tags Tags) {
+	kept := c.comments[:0]
+
+	for _, comment := range c.comments {
+		key, _, _ := strings.Cut(comment, "=")
+		if !strings.EqualFold(key, FieldFingerprint) && !strings.EqualFold(key, FieldDuration) {
+			kept = append(kept, comment)
+		}
+	}
+
+	c.comments = append(kept, FieldFingerprint+"="+tags.Fingerprint)
+
+	if tags.Duration > 0 {
+		c.comments = append(c.comments, FieldDuration+"="+strconv.Itoa(tags.Duration))
+	}
+}
@@ -0,0 +1,385 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tests_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/agar/pkg/agar"
+)
+
+// startSporeprint starts sporeprint with args, and returns it once it
+// listens, with the address it listens on.
+func startSporeprint(t *testing.T, args ...string) (*exec.Cmd, string) {
+	t.Helper()
+
+	bin, err := agar.LookFor("sporeprint")
+	if err != nil {
+		t.Fatalf("sporeprint: %v", err)
+	}
+
+	cmd := exec.Command(bin, args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = cmd.Process.Kill() })
+
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		if addr, ok := strings.CutPrefix(scanner.Text(), "listening on "); ok {
+			// Keep reading the request logs, so that the process never blocks.
+			go func() { _, _ = io.Copy(io.Discard, stderr) }()
+
+			return cmd, addr
+		}
+	}
+
+	t.Fatalf("sporeprint %s exited without listening", strings.Join(args, " "))
+
+	return nil, ""
+}
+
+// waitShutdown waits for cmd to exit after SIGTERM, and checks that it shut
+// down cleanly: either it returned, or primordium exited once done.
+func waitShutdown(t *testing.T, cmd *exec.Cmd) {
+	t.Helper()
+
+	exited := make(chan error, 1)
+
+	go func() { exited <- cmd.Wait() }()
+
+	select {
+	case err := <-exited:
+		exitErr := &exec.ExitError{}
+		if err != nil && (!errors.As(err, &exitErr) || exitErr.ExitCode() != 128+int(syscall.SIGTERM)) {
+			t.Errorf("sporeprint exited with %v, want a clean shutdown", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("sporeprint did not exit")
+	}
+}
+
+// tone returns seconds of a PCM sine at 11025 Hz, mono, s16le.
+func tone(seconds int) []byte {
+	pcm := make([]byte, 2*11025*seconds)
+	for i := range len(pcm) / 2 {
+		sample := 10000 * math.Sin(2*math.Pi*440*float64(i)/11025)
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(sample)))
+	}
+
+	return pcm
+}
+
+func TestServeDrainsOnSIGTERM(t *testing.T) {
+	t.Parallel()
+
+	cmd, addr := startSporeprint(t, "serve", "--listen", "127.0.0.1:0", "--shutdown-timeout", "5s")
+
+	body, upload := io.Pipe()
+
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://"+addr+"/v1/fingerprint", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type response struct {
+		status      int
+		fingerprint string
+		err         error
+	}
+
+	responses := make(chan response, 1)
+
+	go func() {
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			responses <- response{err: err}
+
+			return
+		}
+
+		defer resp.Body.Close()
+
+		var decoded struct {
+			Fingerprint string `json:"fingerprint"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&decoded)
+		responses <- response{status: resp.StatusCode, fingerprint: decoded.Fingerprint, err: err}
+	}()
+
+	// The request is in flight when SIGTERM arrives, and still uploading well
+	// after it.
+	pcm := tone(10)
+	if _, err = upload.Write(pcm[:len(pcm)/2]); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err = cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Second)
+
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		_ = conn.Close()
+
+		t.Error("sporeprint still accepts connections after SIGTERM")
+	}
+
+	if _, err = upload.Write(pcm[len(pcm)/2:]); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = upload.Close()
+
+	result := <-responses
+	if result.err != nil || result.status != http.StatusOK || result.fingerprint == "" {
+		t.Errorf("request in flight = %d, %q, %v; want a fingerprint", result.status, result.fingerprint, result.err)
+	}
+
+	waitShutdown(t, cmd)
+}
+
+// tempSocket returns a path for a Unix socket in a temporary directory.
+func tempSocket(t *testing.T) string {
+	t.Helper()
+
+	// Socket paths are limited to about 100 bytes: t.TempDir() is too long.
+	dir, err := os.MkdirTemp("", "sporeprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "sock")
+}
+
+func TestDaemonRemovesSocketOnSIGTERM(t *testing.T) {
+	t.Parallel()
+
+	socket := tempSocket(t)
+
+	// A socket left behind by a daemon that is gone.
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = listener.Close()
+
+	cmd, _ := startSporeprint(t, "daemon", "--socket", socket)
+
+	if err = cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	waitShutdown(t, cmd)
+
+	if _, err = os.Stat(socket); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket after SIGTERM: %v, want it removed", err)
+	}
+}
+
+// TestDaemonFingerprintUnlimited checks that stdin larger than the daemon
+// accepts is fingerprinted in-process.
+func TestDaemonFingerprintUnlimited(t *testing.T) {
+	t.Parallel()
+
+	socket := tempSocket(t)
+	startSporeprint(t, "daemon", "--socket", socket)
+
+	bin, err := agar.LookFor("sporeprint")
+	if err != nil {
+		t.Fatalf("sporeprint: %v", err)
+	}
+
+	// About 53 minutes, above the 64 MiB limit of the daemon.
+	cmd := exec.Command(bin, "fingerprint", "--length", "0")
+	cmd.Env = append(os.Environ(), "SPOREPRINT_SOCKET="+socket)
+	cmd.Stdin = bytes.NewReader(tone(3200))
+
+	output, err := cmd.Output()
+	if err != nil || len(bytes.TrimSpace(output)) == 0 {
+		t.Errorf("fingerprint --length 0 = %q, %v; want a fingerprint", output, err)
+	}
+}
+
+// TestDaemonSearch checks that db search queries the database loaded by the
+// daemon: its MANIFEST is gone, so that opening it again would fail.
+func TestDaemonSearch(t *testing.T) {
+	t.Parallel()
+
+	bin, err := agar.LookFor("sporeprint")
+	if err != nil {
+		t.Fatalf("sporeprint: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "library.db")
+
+	add := exec.Command(bin, "db", "add", dir, "--id", "track")
+	add.Stdin = bytes.NewReader(tone(20))
+
+	if output, err := add.CombinedOutput(); err != nil {
+		t.Fatalf("db add: %v: %s", err, output)
+	}
+
+	socket := tempSocket(t)
+	startSporeprint(t, "daemon", "--socket", socket, "--db", dir)
+
+	if err = os.Remove(filepath.Join(dir, "MANIFEST")); err != nil {
+		t.Fatal(err)
+	}
+
+	search := exec.Command(bin, "db", "search", dir)
+	search.Env = append(os.Environ(), "SPOREPRINT_SOCKET="+socket)
+	search.Stdin = bytes.NewReader(tone(20))
+
+	output, err := search.Output()
+	if err != nil || !strings.HasPrefix(string(output), "track ") {
+		t.Errorf("db search = %q, %v; want the track found by the daemon", output, err)
+	}
+
+	// Search options of its own cannot be served by the daemon.
+	search = exec.Command(bin, "db", "search", "--global", dir)
+	search.Env = append(os.Environ(), "SPOREPRINT_SOCKET="+socket)
+	search.Stdin = bytes.NewReader(tone(20))
+
+	if output, err = search.Output(); err == nil {
+		t.Errorf("db search --global = %q, want the database opened in-process", output)
+	}
+}
+
+// TestWorkerDrainsOnSIGTERM checks that the worker answers the requests it
+// read before SIGTERM, while stdin stays open.
+func TestWorkerDrainsOnSIGTERM(t *testing.T) {
+	t.Parallel()
+
+	bin, err := agar.LookFor("sporeprint")
+	if err != nil {
+		t.Fatalf("sporeprint: %v", err)
+	}
+
+	// An ffmpeg slow enough for the requests to be in flight at SIGTERM.
+	dir := t.TempDir()
+	pcmPath := filepath.Join(dir, "pcm")
+
+	if err = os.WriteFile(pcmPath, tone(10), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\nsleep 1\ncat '" + pcmPath + "'\n"
+
+	if err = os.WriteFile(ffmpeg, []byte(script), 0o700); err != nil { //nolint:gosec // must be executable
+		t.Fatal(err)
+	}
+
+	const numRequests = 4
+
+	cmd := exec.Command(bin, "worker", "--jobs", "4", "--ffmpeg", ffmpeg, "--shutdown-timeout", "5s")
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = stdin.Close()
+		_ = cmd.Process.Kill()
+	})
+
+	for id := range numRequests {
+		line := `{"id": ` + strconv.Itoa(id) + `, "op": "fingerprint", "path": "track.flac"}` + "\n"
+		if _, err = io.WriteString(stdin, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if err = cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	answered := 0
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var response struct {
+			Result *struct {
+				Fingerprint string `json:"fingerprint"`
+			} `json:"result"`
+			Error json.RawMessage `json:"error"`
+		}
+
+		if err = json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			t.Fatalf("response %q: %v", scanner.Text(), err)
+		}
+
+		if response.Result == nil || response.Result.Fingerprint == "" {
+			t.Errorf("response %q, want a fingerprint", scanner.Text())
+		}
+
+		answered++
+	}
+
+	if answered != numRequests {
+		t.Errorf("answered %d requests of %d", answered, numRequests)
+	}
+
+	waitShutdown(t, cmd)
+}
@@ -0,0 +1,33 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package worker serves fingerprinting, comparison and database search to a
+// long-running parent process over a pair of pipes, as exiftool -stay_open,
+// sparing it a process and a Chromaprint context per file.
+//
+// Each input line is a JSON [Request], answered by one output line holding
+// a JSON [Response] with the same "id":
+//
+//	{"id": 1, "op": "fingerprint", "path": "track.flac"}
+//	{"id": 2, "op": "fingerprint", "pcm": "<base64 PCM or WAV>", "length": 0}
+//	{"id": 3, "op": "compare", "fingerprint1": "...", "fingerprint2": "...", "global": true}
+//	{"id": 4, "op": "search", "fingerprint": "...", "limit": 5}
+//	{"id": 5, "op": "ping"}
+//
+// Results are those of the matching endpoints of package server. Errors are
+// {"id", "error": {"code", "message"}}, and do not stop the worker. With more
+// than one job, responses may come out of order.
+package worker
@@ -0,0 +1,277 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/server"
+)
+
+// Operations.
+const (
+	OpFingerprint = "fingerprint"
+	OpCompare     = "compare"
+	OpSearch      = "search"
+	OpPing        = "ping"
+)
+
+var (
+	// ErrRead happens when reading requests fails.
+	ErrRead = errors.New("worker: reading requests failed")
+	// ErrWrite happens when writing responses fails.
+	ErrWrite = errors.New("worker: writing responses failed")
+)
+
+// Options configures [Run].
+type Options struct {
+	// Server performs the operations.
+	Server *server.Server
+	// Length is the default maximum audio length fingerprinted, in seconds,
+	// as [fingerprint.Options]. Requests override it with "length".
+	Length int
+	// FFmpeg decodes "path" requests, as [fingerprint.Options].
+	FFmpeg string
+	// Jobs is the number of requests processed in parallel, each job owning
+	// a Chromaprint context. Zero means 1.
+	Jobs int
+	// ShutdownTimeout is how long requests in flight keep running once the
+	// context of [Run] is canceled, before they are canceled in turn. Zero
+	// cancels them at once.
+	ShutdownTimeout time.Duration
+}
+
+// Request is a request line.
+type Request struct {
+	// ID is echoed in the response. Any JSON value.
+	ID json.RawMessage `json:"id,omitempty"`
+	Op string          `json:"op"`
+	// Path is an audio file fingerprinted with ffmpeg.
+	Path string `json:"path,omitempty"`
+	// PCM is raw PCM or WAV fingerprinted directly, base64 in JSON.
+	PCM []byte `json:"pcm,omitempty"`
+	// Length overrides Options.Length.
+	Length *int `json:"length,omitempty"`
+
+	server.CompareRequest
+	server.SearchRequest
+}
+
+// Response is a response line.
+type Response struct {
+	ID     json.RawMessage `json:"id"`
+	Result any             `json:"result,omitempty"`
+	Error  *server.Error   `json:"error,omitempty"`
+}
+
+// Run answers requests read from input on output until input ends or ctx is
+// canceled. Canceling ctx stops reading, and every request read is still
+// answered: requests in flight get [Options.ShutdownTimeout] to finish.
+func Run(ctx context.Context, input io.Reader, output io.Writer, options Options) error {
+	jobs := max(options.Jobs, 1)
+	lines := make(chan []byte)
+	out := &writer{buf: bufio.NewWriter(output)}
+
+	requestCtx, cancelRequests := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRequests()
+
+	stopDrain := context.AfterFunc(ctx, func() {
+		time.AfterFunc(options.ShutdownTimeout, cancelRequests)
+	})
+	defer stopDrain()
+
+	var workers sync.WaitGroup
+
+	for range jobs {
+		workers.Go(func() {
+			chroma := chromaprint.New()
+			defer chroma.Free()
+
+			for line := range lines {
+				out.write(handle(requestCtx, chroma, line, options))
+			}
+		})
+	}
+
+	err := feed(ctx, bufio.NewReader(input), lines)
+
+	close(lines)
+	workers.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	return out.err
+}
+
+// feed sends the non-empty lines of reader to lines until reader ends or ctx
+// is canceled. Reads happen in their own goroutine, left blocked on reader
+// when ctx is canceled. Each line read before then is sent.
+func feed(ctx context.Context, reader *bufio.Reader, lines chan<- []byte) error {
+	var (
+		mu      sync.Mutex
+		stopped bool
+	)
+
+	done := make(chan error, 1)
+
+	go func() {
+		for {
+			line, err := reader.ReadBytes('\n')
+
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				mu.Lock()
+
+				if stopped {
+					mu.Unlock()
+
+					return
+				}
+
+				lines <- line
+
+				mu.Unlock()
+			}
+
+			if errors.Is(err, io.EOF) {
+				done <- nil
+
+				return
+			}
+
+			if err != nil {
+				done <- fmt.Errorf("%w: %w", ErrRead, err)
+
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+
+		return nil
+	}
+}
+
+// handle answers a request line.
+func handle(ctx context.Context, chroma *chromaprint.Context, line []byte, options Options) Response {
+	var req Request
+
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&req); err != nil {
+		// Salvage the ID, if any, so that the caller can match the error.
+		_ = json.Unmarshal(line, &struct {
+			ID *json.RawMessage `json:"id"`
+		}{&req.ID})
+
+		return Response{ID: req.ID, Error: &server.Error{Code: server.CodeInvalidArgs, Message: err.Error()}}
+	}
+
+	result, err := do(ctx, chroma, &req, options)
+	if err != nil {
+		apiErr := &server.Error{}
+		if !errors.As(err, &apiErr) {
+			apiErr = &server.Error{Code: server.CodeChromaprintFailure, Message: err.Error()}
+		}
+
+		return Response{ID: req.ID, Error: apiErr}
+	}
+
+	return Response{ID: req.ID, Result: result}
+}
+
+func do(ctx context.Context, chroma *chromaprint.Context, req *Request, options Options) (any, error) {
+	switch req.Op {
+	case OpFingerprint:
+		return fingerprintRequest(ctx, chroma, req, options)
+	case OpCompare:
+		return options.Server.Compare(req.CompareRequest)
+	case OpSearch:
+		return options.Server.Search(req.SearchRequest)
+	case OpPing:
+		return map[string]string{"status": "ok"}, nil
+	default:
+		return nil, &server.Error{Code: server.CodeInvalidArgs, Message: fmt.Sprintf("unknown op %q", req.Op)}
+	}
+}
+
+func fingerprintRequest(ctx context.Context, chroma *chromaprint.Context, req *Request, options Options) (any, error) {
+	fpOptions := fingerprint.Options{Length: options.Length, FFmpeg: options.FFmpeg}
+
+	if req.Length != nil {
+		if *req.Length < 0 {
+			return nil, &server.Error{Code: server.CodeInvalidArgs, Message: fmt.Sprintf("invalid length %d", *req.Length)}
+		}
+
+		fpOptions.Length = *req.Length
+	}
+
+	if (req.Path == "") == (req.PCM == nil) {
+		return nil, &server.Error{Code: server.CodeInvalidArgs, Message: "expected exactly one of path and pcm"}
+	}
+
+	if req.PCM != nil {
+		return options.Server.Fingerprint(chroma, bytes.NewReader(req.PCM), fpOptions)
+	}
+
+	return options.Server.FingerprintFile(ctx, chroma, req.Path, fpOptions)
+}
+
+// writer writes response lines from concurrent jobs, flushing each so that
+// the caller sees it at once, and keeps the first error.
+type writer struct {
+	mu  sync.Mutex
+	buf *bufio.Writer
+	err error
+}
+
+func (w *writer) write(response Response) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return
+	}
+
+	err := json.NewEncoder(w.buf).Encode(response)
+	if err == nil {
+		err = w.buf.Flush()
+	}
+
+	if err != nil {
+		w.err = fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+   Copyright Mycophonic.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package worker_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mycophonic/sporeprint/chromaprint"
+	"github.com/mycophonic/sporeprint/db"
+	"github.com/mycophonic/sporeprint/fingerprint"
+	"github.com/mycophonic/sporeprint/index"
+	"github.com/mycophonic/sporeprint/server"
+	"github.com/mycophonic/sporeprint/worker"
+)
+
+// pcm encodes numSeconds of a varying tone as s16le bytes.
+func pcm(numSeconds, seed int) []byte {
+	buf := make([]byte, 0, 2*11025*numSeconds)
+
+	for i := range 11025 * numSeconds {
+		freq := 220 + float64((i/2756+seed)%12)*float64(40+13*seed)
+		sample := int16(10000 * math.Sin(2*math.Pi*freq*float64(i)/11025))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(sample))
+	}
+
+	return buf
+}
+
+func encode(t *testing.T, data []byte) string {
+	t.Helper()
+
+	chroma := chromaprint.New()
+	defer chroma.Free()
+
+	result, err := fingerprint.Stream(chroma, bytes.NewReader(data), fingerprint.Options{})
+	if err != nil {
+		t.Fatalf("Stream() failed: %v", err)
+	}
+
+	return result.Fingerprint
+}
+
+type response struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *server.Error   `json:"error"`
+}
+
+// run runs a worker on input lines and returns its responses by ID.
+func run(t *testing.T, options worker.Options, lines ...string) map[string]response {
+	t.Helper()
+
+	var output bytes.Buffer
+	if err := worker.Run(context.Background(), strings.NewReader(strings.Join(lines, "\n")), &output, options); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	responses := map[string]response{}
+	scanner := bufio.NewScanner(&output)
+
+	for scanner.Scan() {
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response line %q: %v", scanner.Text(), err)
+		}
+
+		responses[string(resp.ID)] = resp
+	}
+
+	// Blank lines are skipped.
+	if want := len(lines) - strings.Count(strings.Join(lines, "\n"), "\n\n"); len(responses) != want {
+		t.Errorf("got %d responses to %d requests: %s", len(responses), want, output.String())
+	}
+
+	return responses
+}
+
+func marshal(t *testing.T, value any) string {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	database, err := db.Open(t.TempDir(), db.Options{Create: true})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	t.Cleanup(func() { _ = database.Close() })
+
+	data := pcm(5, 0)
+	stored := encode(t, data)
+
+	raw, err := chromaprint.Decode(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = database.Add([]index.Track{{ID: "stored", Raw: raw}}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	options := worker.Options{Server: server.New(server.Options{DB: database, Threshold: 0.4}), Length: 3}
+	zero := 0
+
+	responses := run(t, options,
+		marshal(t, worker.Request{ID: json.RawMessage(`1`), Op: worker.OpFingerprint, PCM: data}),
+		marshal(t, worker.Request{ID: json.RawMessage(`"whole"`), Op: worker.OpFingerprint, PCM: data, Length: &zero}),
+		marshal(t, worker.Request{
+			ID: json.RawMessage(`{"n":3}`), Op: worker.OpCompare,
+			CompareRequest: server.CompareRequest{Fingerprint1: stored, Fingerprint2: stored},
+		}),
+		marshal(t, worker.Request{
+			ID: json.RawMessage(`4`), Op: worker.OpSearch,
+			SearchRequest: server.SearchRequest{Fingerprint: stored},
+		}),
+		``,
+		`{"id": 5, "op": "ping"}`,
+	)
+
+	var fp server.FingerprintResponse
+	if err = json.Unmarshal(responses["1"].Result, &fp); err != nil || fp.Fingerprint != encode(t, data[:3*2*11025]) || fp.Duration != 3 {
+		t.Errorf("fingerprint = %+v (%v), want the first 3 seconds", fp, responses["1"].Error)
+	}
+
+	if err = json.Unmarshal(responses[`"whole"`].Result, &fp); err != nil || fp.Fingerprint != stored || fp.Duration != 5 {
+		t.Errorf("fingerprint length=0 = %+v (%v), want the whole 5s", fp, responses[`"whole"`].Error)
+	}
+
+	var cmp server.CompareResponse
+	if err = json.Unmarshal(responses[`{"n":3}`].Result, &cmp); err != nil || cmp.Score != 1 || !cmp.Match {
+		t.Errorf("compare = %+v (%v), want a perfect match", cmp, responses[`{"n":3}`].Error)
+	}
+
+	var search server.SearchResponse
+	if err = json.Unmarshal(responses["4"].Result, &search); err != nil || len(search.Matches) != 1 || search.Matches[0].ID != "stored" {
+		t.Errorf("search = %+v (%v), want the stored track", search, responses["4"].Error)
+	}
+
+	if got := string(responses["5"].Result); got != `{"status":"ok"}` {
+		t.Errorf("ping = %s", got)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	t.Parallel()
+
+	options := worker.Options{
+		Server: server.New(server.Options{}),
+		FFmpeg: filepath.Join(t.TempDir(), "missing-ffmpeg"),
+	}
+
+	responses := run(t, options,
+		`{"id": 1, "op": 1}`,
+		`{"op": "fingerprint"`,
+		`{"id": 2, "op": "fingerprint", "path": "a.flac", "pcm": "AAAA"}`,
+		`{"id": 3, "op": "fingerprint", "path": "a.flac"}`,
+		`{"id": 4, "op": "fingerprint", "pcm": "AAAA", "length": -1}`,
+		`{"id": 5, "op": "compare", "fingerprint1": "!!!", "fingerprint2": "!!!"}`,
+		`{"id": 6, "op": "search", "fingerprint": "x"}`,
+		`{"id": 7, "op": "transcode"}`,
+		`{"id": 8, "unknown": true}`,
+	)
+
+	for id, code := range map[string]server.Code{
+		"1":    server.CodeInvalidArgs,
+		"null": server.CodeInvalidArgs,
+		"2":    server.CodeInvalidArgs,
+		"3":    server.CodeReadFailure,
+		"4":    server.CodeInvalidArgs,
+		"5":    server.CodeCompareFailure,
+		"6":    server.CodeNotFound,
+		"7":    server.CodeInvalidArgs,
+		"8":    server.CodeInvalidArgs,
+	} {
+		if resp := responses[id]; resp.Error == nil || resp.Error.Code != code {
+			t.Errorf("request %s: error %+v, want %s", id, resp.Error, code)
+		}
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	t.Parallel()
+
+	data := pcm(2, 1)
+	want := encode(t, data)
+	lines := make([]string, 20)
+
+	for i := range lines {
+		lines[i] = marshal(t, worker.Request{ID: json.RawMessage(fmt.Sprint(i)), Op: worker.OpFingerprint, PCM: data})
+	}
+
+	responses := run(t, worker.Options{Server: server.New(server.Options{}), Jobs: 4}, lines...)
+
+	for id, resp := range responses {
+		var fp server.FingerprintResponse
+		if err := json.Unmarshal(resp.Result, &fp); err != nil || fp.Fingerprint != want {
+			t.Errorf("request %s: %+v (%v), want the same fingerprint from every context", id, fp, resp.Error)
+		}
+	}
+}
+
+// cancelingReader returns data, then cancels its context and blocks like an
+// open stdin.
+type cancelingReader struct {
+	data   io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if errors.Is(err, io.EOF) {
+		r.cancel()
+
+		select {}
+	}
+
+	return n, err
+}
+
+// TestRunCanceled checks that canceling Run stops it while input stays open,
+// after answering every request it read.
+func TestRunCanceled(t *testing.T) {
+	t.Parallel()
+
+	data := pcm(2, 1)
+	lines := make([]string, 8)
+
+	for i := range lines {
+		lines[i] = marshal(t, worker.Request{ID: json.RawMessage(fmt.Sprint(i)), Op: worker.OpFingerprint, PCM: data})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	input := &cancelingReader{data: strings.NewReader(strings.Join(lines, "\n") + "\n"), cancel: cancel}
+	options := worker.Options{Server: server.New(server.Options{}), Jobs: 2, ShutdownTimeout: time.Minute}
+
+	var output bytes.Buffer
+
+	done := make(chan error, 1)
+
+	go func() { done <- worker.Run(ctx, input, &output, options) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("Run() did not return once canceled")
+	}
+
+	if got := strings.Count(output.String(), `"fingerprint"`); got != len(lines) {
+		t.Errorf("got %d fingerprints for %d requests: %s", got, len(lines), output.String())
+	}
+}